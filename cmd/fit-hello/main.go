@@ -15,7 +15,11 @@ import (
 	"strings"
 	"time"
 
+	"fit/internal/audit"
 	"fit/internal/chal"
+	"fit/internal/clientpolicy"
+	"fit/internal/ctaperr"
+	"fit/internal/output"
 
 	webauthntypes "github.com/go-ctap/ctaphid/pkg/webauthntypes"
 	"github.com/go-ctap/winhello"
@@ -32,6 +36,19 @@ func main() {
 	}
 	cmd := os.Args[1]
 	args := os.Args[2:]
+	// Only the commands that draw challenges take a seed; FIT_SEED left in
+	// the environment does not reach the others.
+	seed := ""
+	if cmd == "auth" || cmd == "add-passkey" {
+		seed = getString(args, "--seed")
+		if seed == "" {
+			seed = os.Getenv("FIT_SEED")
+		}
+	}
+	if seed != "" {
+		chal.Seed(seed)
+		fmt.Fprintf(os.Stderr, "Warning: challenges are derived from seed %q and are predictable; use the output as test vectors only.\n", seed)
+	}
 	switch cmd {
 	case "list":
 		cmdList(args)
@@ -65,6 +82,10 @@ func printUsage() {
 	fmt.Println("  version                Print build version.")
 	fmt.Println("\nGlobal:")
 	fmt.Println("  --json                 Output JSON where applicable.")
+	fmt.Println("  --origin ORIGIN        clientData origin for auth/add-passkey (default https://RP).")
+	fmt.Println("  --allow-insecure-origin  Permit http:// and IP-address origins.")
+	fmt.Println("  --related-origins-url URL  Base URL for /.well-known/webauthn (default https://RP).")
+	fmt.Println("  --seed SEED            Derive auth/add-passkey challenges from SEED (or FIT_SEED) for test vectors. NOT SECURE.")
 }
 
 // Helpers
@@ -105,6 +126,27 @@ func writeJSON(v any) {
 	os.Stdout.WriteString("\n")
 }
 
+// recordAudit appends e to fit's audit log ($FIT_AUDIT_LOG or audit.jsonl in
+// the fit configuration directory) with the outcome from err. Like fit, a log
+// that cannot be written is reported on stderr without failing the command.
+func recordAudit(e audit.Entry, err error) {
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	e.Operator = audit.Operator()
+	e.Host, _ = os.Hostname()
+	e.Device = "Windows Hello"
+	e.Result = "ok"
+	if err != nil {
+		e.Result = string(ctaperr.Other)
+	}
+	path, perr := audit.Path()
+	if perr == nil {
+		_, perr = audit.Append(path, e)
+	}
+	if perr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write the audit log: %v\n", perr)
+	}
+}
+
 // Windows Hello window handle
 func getHelloWindow() (*hiddenwindow.HiddenWindow, func()) {
 	logger := slog.New(slog.DiscardHandler)
@@ -129,13 +171,27 @@ func helloCredentialHints(args []string) []webauthntypes.PublicKeyCredentialHint
 	return nil
 }
 
+// clientOrigin resolves --origin (default https://RP) and enforces the WebAuthn
+// client rules for it: secure context, RP ID registrable suffix, or a Related
+// Origin Requests entry.
+func clientOrigin(args []string, rpID string) string {
+	origin := getString(args, "--origin")
+	if origin == "" {
+		origin = clientpolicy.DefaultOrigin(rpID)
+	}
+	policy := clientpolicy.Policy{
+		AllowInsecureOrigin: has(args, "--allow-insecure-origin"),
+		RelatedOriginsURL:   getString(args, "--related-origins-url"),
+	}
+	if _, err := policy.Check(rpID, origin); err != nil {
+		log.Fatalf("%v", err)
+	}
+	return origin
+}
+
 // clientData factory
-func clientData(typ, rpID string, challenge []byte) []byte {
+func clientData(typ, origin string, challenge []byte) []byte {
 	b64url := base64.RawURLEncoding.EncodeToString(challenge)
-	origin := rpID
-	if !strings.Contains(origin, "://") {
-		origin = "https://" + rpID
-	}
 	cd := map[string]any{"type": typ, "challenge": b64url, "origin": origin, "crossOrigin": false}
 	bs, err := json.Marshal(cd)
 	if err != nil {
@@ -152,18 +208,19 @@ func cmdList(args []string) {
 		log.Fatalf("PlatformCredentialList: %v", err)
 	}
 	if has(args, "--json") {
-		items := make([]map[string]any, 0, len(creds))
+		out := output.HelloList{Header: output.NewHeader("list", output.BackendHello), RP: rp, Credentials: []output.HelloCredential{}}
 		for i, c := range creds {
-			items = append(items, map[string]any{
-				"index":     i,
-				"rp":        c.RP.ID,
-				"user":      c.User.Name,
-				"removable": c.Removable,
-				"backedUp":  c.BackedUp,
-				"credID":    base64.RawURLEncoding.EncodeToString(c.CredentialID),
+			out.Credentials = append(out.Credentials, output.HelloCredential{
+				Index:           i,
+				RP:              c.RP.ID,
+				User:            c.User.Name,
+				Removable:       c.Removable,
+				BackedUp:        c.BackedUp,
+				CredentialID:    output.B64(c.CredentialID),
+				CredentialIDHex: output.Hex(c.CredentialID),
 			})
 		}
-		writeJSON(map[string]any{"backend": "hello", "rp": rp, "credentials": items})
+		writeJSON(out)
 		return
 	}
 	if len(creds) == 0 {
@@ -187,20 +244,22 @@ func cmdTest(args []string) {
 		log.Fatalf("PlatformCredentialList: %v", err)
 	}
 	if has(args, "--json") {
-		items := make([]map[string]any, 0, len(creds))
+		out := output.HelloList{Header: output.NewHeader("test", output.BackendHello), RP: rp, APIVersion: int(winhello.APIVersionNumber()), Credentials: []output.HelloCredential{}}
 		for i, c := range creds {
 			if i >= 50 {
 				break
 			}
-			items = append(items, map[string]any{
-				"rp":        c.RP.ID,
-				"user":      c.User.Name,
-				"removable": c.Removable,
-				"backedUp":  c.BackedUp,
-				"credID":    base64.RawURLEncoding.EncodeToString(c.CredentialID),
+			out.Credentials = append(out.Credentials, output.HelloCredential{
+				Index:           i,
+				RP:              c.RP.ID,
+				User:            c.User.Name,
+				Removable:       c.Removable,
+				BackedUp:        c.BackedUp,
+				CredentialID:    output.B64(c.CredentialID),
+				CredentialIDHex: output.Hex(c.CredentialID),
 			})
 		}
-		writeJSON(map[string]any{"backend": "hello", "rp": rp, "credentials": items, "apiVersion": winhello.APIVersionNumber()})
+		writeJSON(out)
 		return
 	}
 	fmt.Println("Windows Hello diagnostic:")
@@ -232,6 +291,7 @@ func cmdAuth(args []string) {
 		return
 	}
 
+	origin := clientOrigin(args, rp)
 	wnd, closeWnd := getHelloWindow()
 	defer closeWnd()
 
@@ -274,7 +334,7 @@ func cmdAuth(args []string) {
 	}
 
 	challenge := chal.Bytes(32)
-	cd := clientData("webauthn.get", rp, challenge)
+	cd := clientData("webauthn.get", origin, challenge)
 	var allow []webauthntypes.PublicKeyCredentialDescriptor
 	if len(credID) > 0 {
 		allow = []webauthntypes.PublicKeyCredentialDescriptor{{Type: webauthntypes.PublicKeyCredentialTypePublicKey, ID: credID}}
@@ -290,9 +350,17 @@ func cmdAuth(args []string) {
 	}
 
 	if has(args, "--json") {
-		out := map[string]any{"backend": "hello", "rp": rp, "credentialID": base64.RawURLEncoding.EncodeToString(asrt.Credential.ID), "signature": base64.RawURLEncoding.EncodeToString(asrt.Signature), "challengeB64": base64.RawURLEncoding.EncodeToString(challenge), "challengeHex": hex.EncodeToString(challenge)}
+		out := output.Assertion{
+			Header:          output.NewHeader("auth", output.BackendHello),
+			RP:              rp,
+			CredentialID:    output.B64(asrt.Credential.ID),
+			CredentialIDHex: output.Hex(asrt.Credential.ID),
+			Signature:       output.B64(asrt.Signature),
+			ChallengeB64:    output.B64(challenge),
+			ChallengeHex:    output.Hex(challenge),
+		}
 		if asrt.ExtensionOutputs != nil && asrt.ExtensionOutputs.PRFOutputs != nil && asrt.ExtensionOutputs.PRFOutputs.PRF.Enabled {
-			out["prfFirst"] = base64.RawURLEncoding.EncodeToString(asrt.ExtensionOutputs.PRFOutputs.PRF.Results.First)
+			out.PRFFirst = output.B64(asrt.ExtensionOutputs.PRFOutputs.PRF.Results.First)
 		}
 		writeJSON(out)
 	} else {
@@ -323,10 +391,11 @@ func cmdAddPasskey(args []string) {
 		resident = false
 	}
 
+	origin := clientOrigin(args, rp)
 	wnd, closeWnd := getHelloWindow()
 	defer closeWnd()
 	challenge := chal.Bytes(32)
-	cd := clientData("webauthn.create", rp, challenge)
+	cd := clientData("webauthn.create", origin, challenge)
 	userID := []byte("user-id-012345678901234567890123")
 
 	att, err := winhello.MakeCredential(
@@ -348,7 +417,16 @@ func cmdAddPasskey(args []string) {
 	}
 
 	if has(args, "--json") {
-		writeJSON(map[string]any{"backend": "hello", "rp": rp, "user": user, "resident": att.ResidentKey, "credentialID": base64.RawURLEncoding.EncodeToString(att.CredentialID), "challengeB64": base64.RawURLEncoding.EncodeToString(challenge), "challengeHex": hex.EncodeToString(challenge)})
+		writeJSON(output.Passkey{
+			Header:          output.NewHeader("add-passkey", output.BackendHello),
+			RP:              rp,
+			User:            user,
+			Resident:        att.ResidentKey,
+			CredentialID:    output.B64(att.CredentialID),
+			CredentialIDHex: output.Hex(att.CredentialID),
+			ChallengeB64:    output.B64(challenge),
+			ChallengeHex:    output.Hex(challenge),
+		})
 	} else {
 		fmt.Println("Created passkey (Hello):")
 		fmt.Printf("  RP: %s\n", rp)
@@ -393,11 +471,18 @@ func cmdDeletePasskey(args []string) {
 		return
 	}
 
-	if err := winhello.DeletePlatformCredential(credID); err != nil {
+	err := winhello.DeletePlatformCredential(credID)
+	recordAudit(audit.Entry{Command: "delete-passkey", RP: rp, CredentialID: output.B64(credID)}, err)
+	if err != nil {
 		log.Fatalf("DeletePlatformCredential: %v", err)
 	}
 	if has(args, "--json") {
-		writeJSON(map[string]any{"backend": "hello", "deleted": true, "credID": base64.RawURLEncoding.EncodeToString(credID)})
+		writeJSON(output.HelloDelete{
+			Header:          output.NewHeader("delete-passkey", output.BackendHello),
+			Deleted:         true,
+			CredentialID:    output.B64(credID),
+			CredentialIDHex: output.Hex(credID),
+		})
 	} else {
 		fmt.Println("Credential deleted.")
 	}
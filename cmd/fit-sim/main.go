@@ -0,0 +1,174 @@
+//go:build linux
+// +build linux
+
+// fit-sim runs a simulated authenticator described by a scenario file. The
+// key appears as an ordinary /dev/hidrawN device, so fit (or any libfido2
+// client) talks to it as to a real one; see the README for the scenario
+// format.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"fit/internal/chal"
+	"fit/internal/cli"
+	"fit/internal/sim"
+	"fit/internal/vkey"
+)
+
+// Exit statuses of fit-sim's own failures, as env(1) and timeout(1) use
+// them; otherwise fit-sim exits with the command's status.
+const (
+	exitFailed      = 125
+	exitCannotExec  = 126
+	exitNotFound    = 127
+	exitSignalDelta = 128
+)
+
+var command = &cli.Command{
+	Name:     "fit-sim",
+	Summary:  "Run a simulated authenticator described by SCENARIO, optionally around a command.",
+	Operands: []string{"SCENARIO"},
+	Flags: []cli.Flag{
+		{Name: "seed", Arg: "SEED", Usage: "Derive keys, credential IDs and PIN tokens from SEED, and pass it to the command as FIT_SEED (default $FIT_SEED)."},
+		{Name: "verbose", Kind: cli.Bool, Usage: "Log each CTAP2 request and its status to stderr."},
+	},
+}
+
+func main() {
+	args, argv := os.Args[1:], []string(nil)
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, argv = args[:i], args[i+1:]
+	}
+	fl, err := command.Parse(args)
+	if err == cli.ErrHelp {
+		printUsage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printUsage(os.Stderr)
+		os.Exit(exitFailed)
+	}
+
+	sc, err := sim.Load(fl.Operands[0])
+	if err != nil {
+		fail(err)
+	}
+	seed := fl.String("seed")
+	if seed == "" {
+		seed = os.Getenv("FIT_SEED")
+	}
+	if seed != "" {
+		chal.Seed(seed)
+		fmt.Fprintf(os.Stderr, "Warning: keys are derived from seed %q and are predictable; use the output as test vectors only.\n", seed)
+	}
+
+	a := sim.New(sc)
+	if fl.Bool("verbose") {
+		a.Log = os.Stderr
+	}
+	key, err := vkey.Start(sc.VKeyInfo(), a)
+	if err != nil {
+		fail(fmt.Errorf("creating the virtual device: %v", err))
+	}
+
+	// Interrupts go to the command, which shares the terminal; fit-sim
+	// only stops once it has exited.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	code := 0
+	if len(argv) == 0 {
+		fmt.Println(key.Path)
+		fmt.Fprintf(os.Stderr, "fit-sim: %s is running at %s; interrupt to stop.\n", sc.Device.Name, key.Path)
+		<-sigs
+	} else {
+		code = run(argv, key.Path, seed)
+	}
+	key.Close()
+
+	if unused := a.Unused(); len(unused) > 0 {
+		fmt.Fprintf(os.Stderr, "fit-sim: %d script step(s) were never reached:\n", len(unused))
+		for _, st := range unused {
+			fmt.Fprintf(os.Stderr, "  %s\n", describe(st))
+		}
+		code = exitFailed
+	}
+	os.Exit(code)
+}
+
+// run runs the command with {} replaced by the device path and returns its
+// exit status.
+func run(argv []string, path, seed string) int {
+	for i, arg := range argv {
+		argv[i] = strings.ReplaceAll(arg, "{}", path)
+	}
+	c := exec.Command(argv[0], argv[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	c.Env = append(os.Environ(), "FIT_SIM_PATH="+path)
+	if seed != "" {
+		c.Env = append(c.Env, "FIT_SEED="+seed)
+	}
+	err := c.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return exitSignalDelta + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "fit-sim: %v\n", err)
+		return exitNotFound
+	}
+	fmt.Fprintf(os.Stderr, "fit-sim: %v\n", err)
+	return exitCannotExec
+}
+
+// describe is a script step as the scenario wrote it.
+func describe(st sim.Step) string {
+	s := st.Request
+	if st.Status != "" {
+		s += " status " + st.Status
+	}
+	if st.Delay > 0 {
+		s += " delay " + st.Delay.String()
+	}
+	if st.Keepalive != "" {
+		s += " keepalive " + st.Keepalive
+	}
+	if st.Times > 1 {
+		s += fmt.Sprintf(" times %d", st.Times)
+	}
+	return s
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "fit-sim: %v\n", err)
+	os.Exit(exitFailed)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: fit-sim [--seed SEED] [--verbose] SCENARIO [-- COMMAND [ARGS...]]")
+	fmt.Fprintln(w, "\nRuns the simulated authenticator SCENARIO describes as a virtual HID key.")
+	fmt.Fprintln(w, "Without a command it prints the key's /dev/hidrawN path and runs until")
+	fmt.Fprintln(w, "interrupted. With one, it runs COMMAND with {} in ARGS replaced by the path")
+	fmt.Fprintln(w, "(also in FIT_SIM_PATH), removes the key when it exits and exits with its status.")
+	fmt.Fprintln(w, "\nFlags:")
+	fmt.Fprintln(w, "  --seed SEED     Derive keys, credential IDs and PIN tokens from SEED and pass")
+	fmt.Fprintln(w, "                  it to COMMAND as FIT_SEED (default $FIT_SEED).")
+	fmt.Fprintln(w, "  --verbose       Log each CTAP2 request and its status to stderr.")
+	fmt.Fprintln(w, "\nExit status: COMMAND's; 125 if fit-sim fails or script steps were never")
+	fmt.Fprintln(w, "reached; 126 if COMMAND cannot run; 127 if it is not found.")
+}
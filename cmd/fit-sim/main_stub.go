@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// fit-sim creates its virtual key through Linux uhid; elsewhere this stub
+// keeps the module building.
+func main() {
+	fmt.Fprintln(os.Stderr, "fit-sim: Linux-only binary (needs /dev/uhid)")
+	os.Exit(125)
+}
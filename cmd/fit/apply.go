@@ -0,0 +1,340 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"fit/internal/audit"
+	"fit/internal/authnrcfg"
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/manifest"
+	"fit/internal/output"
+	"fit/internal/pinentry"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// cmdApply brings the selected key to the state declared in a manifest: a
+// PIN when the manifest requires one, its minPINLength and alwaysUv, and a
+// resident credential for every listed RP and user. It computes the plan
+// from getInfo and credential enumeration first; --dry-run prints it,
+// otherwise the missing pieces are created and the plan is printed with each
+// step's outcome. The settings are sent as authenticatorConfig over fit's
+// own channel (internal/authnrcfg); a key without that command gets manual
+// steps.
+func cmdApply(fl *cli.Values) {
+	path := fl.Operands[0]
+	m, err := manifest.Load(path)
+	if err != nil {
+		usageError(err, "apply")
+	}
+	dryRun := fl.Bool("dry-run")
+
+	loc, timeout := selectDevice(fl)
+	opTimeout = timeout
+	dev, err := openDevice(loc)
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.NoDevice, 0, "open", err))
+	}
+	info, err := deviceCall(dev, "info", dev.Info)
+	if err != nil {
+		fatal("info", err)
+	}
+	report := output.Apply{
+		Header:   output.NewHeader("apply", output.BackendLibfido2),
+		Manifest: path,
+		Device:   deviceEntry(loc),
+		DryRun:   dryRun,
+		// At most one step each for the PIN, minPINLength and alwaysUv
+		// plus one per credential, so the pointers add returns stay valid.
+		Steps: make([]output.ApplyStep, 0, 3+len(m.Credentials)),
+	}
+	add := func(s output.ApplyStep) *output.ApplyStep {
+		report.Steps = append(report.Steps, s)
+		return &report.Steps[len(report.Steps)-1]
+	}
+	status := "planned"
+	if !dryRun {
+		status = "done"
+	}
+
+	// PIN.
+	present, pinSet := clientPinStatus(info.Options)
+	minLen := max(m.PIN.MinLength, 4)
+	var setPIN *output.ApplyStep
+	switch {
+	case !m.PIN.Required:
+	case !present:
+		add(output.ApplyStep{Action: "set-pin", Target: "pin", Status: "manual", Detail: "the key does not support clientPin"})
+	case pinSet:
+		detail := "PIN is set"
+		if m.PIN.MinLength > 0 {
+			detail += "; the length of an existing PIN cannot be checked"
+		}
+		add(output.ApplyStep{Action: "set-pin", Target: "pin", Status: "ok", Detail: detail})
+	default:
+		setPIN = add(output.ApplyStep{Action: "set-pin", Target: "pin", Status: status, Detail: fmt.Sprintf("set a PIN (minimum length %d)", minLen)})
+	}
+
+	// minPINLength and alwaysUv: authenticatorConfig, sent after the PIN is
+	// set so that the PIN authorizes it.
+	authnrCfg := optionTrue(info.Options, "authnrCfg")
+	var setMinLen, toggleUV *output.ApplyStep
+	if m.PIN.MinLength > 0 {
+		s := output.ApplyStep{Action: "min-pin-length", Target: "minPINLength"}
+		ext, err := extendedInfo(dev)
+		switch {
+		case !present:
+			s.Status, s.Detail = "manual", "the key does not support clientPin"
+		case err != nil:
+			s.Status, s.Detail, s.Error = "failed", "could not read minPINLength", classify("info", err)
+		case ext.MinPINLength >= m.PIN.MinLength:
+			s.Status, s.Detail = "ok", fmt.Sprintf("minPINLength=%d", ext.MinPINLength)
+		case !authnrCfg || !optionTrue(info.Options, "setMinPINLength"):
+			s.Status = "manual"
+			s.Detail = fmt.Sprintf("key reports minPINLength=%d, manifest wants %d; the key does not support setMinPINLength", ext.MinPINLength, m.PIN.MinLength)
+		default:
+			s.Status, s.Detail = status, fmt.Sprintf("raise minPINLength from %d to %d", ext.MinPINLength, m.PIN.MinLength)
+		}
+		if p := add(s); p.Status == status {
+			setMinLen = p
+		}
+	}
+	if m.AlwaysUV != nil {
+		have := optionTrue(info.Options, "alwaysUv")
+		s := output.ApplyStep{Action: "always-uv", Target: "alwaysUv", Status: "ok", Detail: fmt.Sprintf("alwaysUv=%v", have)}
+		switch {
+		case have == *m.AlwaysUV:
+		case !authnrCfg || !hasOption(info.Options, "alwaysUv"):
+			s.Status = "manual"
+			s.Detail = fmt.Sprintf("key reports alwaysUv=%v, manifest wants %v; the key does not support toggleAlwaysUv", have, *m.AlwaysUV)
+		default:
+			s.Status, s.Detail = status, fmt.Sprintf("set alwaysUv=%v", *m.AlwaysUV)
+		}
+		if p := add(s); p.Status == status {
+			toggleUV = p
+		}
+	}
+
+	// Credentials: a PIN is needed both to enumerate and to create them,
+	// and authorizes the settings above.
+	var pin *pinentry.Secret
+	if pinSet && (len(m.Credentials) > 0 || !dryRun && (setMinLen != nil || toggleUV != nil)) {
+		pin = readPIN(fl, true)
+		defer pin.Zero()
+	}
+	existing := map[string]map[string]bool{} // rp -> user names present
+	type create struct {
+		step *output.ApplyStep
+		cred manifest.Credential
+	}
+	var creates []create
+	for _, c := range m.Credentials {
+		target := c.RP + "/" + c.User
+		if !pinSet && setPIN == nil {
+			reason := "the key has no PIN and the manifest does not require one"
+			if !present {
+				reason = "the key does not support clientPin"
+			}
+			add(output.ApplyStep{Action: "create-credential", Target: target, Status: "skipped", Detail: "resident credentials need a PIN; " + reason})
+			continue
+		}
+		if pinSet {
+			users, ok := existing[c.RP]
+			if !ok {
+				var err error
+				users, err = residentUsers(dev, c.RP, pin)
+				if err != nil {
+					add(output.ApplyStep{Action: "create-credential", Target: target, Status: "failed", Detail: "could not enumerate credentials", Error: classify("credentials", err)})
+					continue
+				}
+				existing[c.RP] = users
+			}
+			if users[c.User] {
+				add(output.ApplyStep{Action: "create-credential", Target: target, Status: "ok", Detail: "already present"})
+				continue
+			}
+		}
+		creates = append(creates, create{add(output.ApplyStep{Action: "create-credential", Target: target, Status: status}), c})
+	}
+
+	if !dryRun {
+		if setPIN != nil {
+			newPIN := readNewPIN(fl, minLen)
+			defer newPIN.Zero()
+			progressf("Setting PIN... You may need to touch your device.\n")
+			err := deviceOp(dev, "set-pin", func() error { return dev.SetPIN(newPIN.String(), "") })
+			recordAudit(openedDevice(dev), audit.Entry{Command: "set-pin"}, err)
+			if err != nil {
+				setPIN.Status, setPIN.Error = "failed", withRetries(dev, classify("set-pin", err))
+				for _, cr := range creates {
+					cr.step.Status, cr.step.Detail = "skipped", "the PIN could not be set"
+				}
+				creates = nil
+			} else {
+				setPIN.Detail = fmt.Sprintf("PIN set (minimum length %d)", minLen)
+				pin = newPIN
+			}
+		}
+		if setMinLen != nil || toggleUV != nil {
+			applyConfig(dev, info, pin, m, setMinLen, toggleUV)
+		}
+		for i, cr := range creates {
+			s, c := cr.step, cr.cred
+			progressf("Creating %s (%d of %d)... Touch your device.\n", s.Target, i+1, len(creates))
+			display := c.Display
+			if display == "" {
+				display = c.User
+			}
+			out, err := runAddPasskey(dev, passkeyParams{rpID: c.RP, userName: c.User, display: display, resident: true, pin: pin})
+			if err != nil {
+				s.Status, s.Error = "failed", classify("makeCredential", err)
+				continue
+			}
+			s.CredentialID = out.CredentialID
+			report.Created++
+		}
+	}
+
+	var firstErr *ctaperr.Error
+	for _, s := range report.Steps {
+		switch s.Status {
+		case "failed":
+			report.Failed++
+			if firstErr == nil {
+				firstErr = s.Error
+			}
+		case "manual":
+			report.Manual++
+		}
+	}
+	if outOpts.Structured() {
+		emit(report)
+	} else {
+		printApply(report)
+	}
+	switch {
+	case firstErr != nil:
+		exit(firstErr.ExitCode)
+	case report.Manual > 0 && !dryRun:
+		exit(ctaperr.ExitCode(ctaperr.UnsupportedOption))
+	}
+}
+
+// applyConfig sends the planned authenticatorConfig steps, authorized by a
+// pinUvAuthToken from pin when the key has one (pin is nil otherwise), and
+// records their outcome in the steps.
+func applyConfig(dev *libfido2.Device, info *libfido2.DeviceInfo, pin *pinentry.Secret, m *manifest.Manifest, setMinLen, toggleUV *output.ApplyStep) {
+	fail := func(e *ctaperr.Error) {
+		for _, s := range []*output.ApplyStep{setMinLen, toggleUV} {
+			if s != nil {
+				s.Status, s.Error = "failed", e
+			}
+		}
+	}
+	c, err := rawConn(dev)
+	if err != nil {
+		fail(ctaperr.New(ctaperr.Transport, 0, "authenticatorConfig", err))
+		return
+	}
+	defer c.Close()
+	var tok *authnrcfg.Token
+	if pin != nil {
+		proto := 1
+		if slices.Contains(info.Protocols, 2) {
+			proto = 2
+		}
+		if tok, err = authnrcfg.GetToken(c, proto, pin.Bytes(), rawTimeout()); err != nil {
+			fail(withRetries(dev, classify("clientPIN", err)))
+			return
+		}
+	}
+	d := openedDevice(dev)
+	if setMinLen != nil {
+		err := authnrcfg.SetMinPINLength(c, tok, m.PIN.MinLength, rawTimeout())
+		recordAudit(d, audit.Entry{Command: "set-min-pin-length"}, err)
+		if err != nil {
+			setMinLen.Status, setMinLen.Error = "failed", classify("authenticatorConfig", err)
+		} else {
+			setMinLen.Detail = fmt.Sprintf("minPINLength set to %d", m.PIN.MinLength)
+		}
+	}
+	if toggleUV != nil {
+		err := authnrcfg.ToggleAlwaysUv(c, tok, rawTimeout())
+		recordAudit(d, audit.Entry{Command: "toggle-always-uv"}, err)
+		if err != nil {
+			toggleUV.Status, toggleUV.Error = "failed", classify("authenticatorConfig", err)
+		} else {
+			toggleUV.Detail = fmt.Sprintf("alwaysUv set to %v", *m.AlwaysUV)
+		}
+	}
+}
+
+// residentUsers returns the user names of the resident credentials for rpID.
+func residentUsers(dev *libfido2.Device, rpID string, pin *pinentry.Secret) (map[string]bool, error) {
+	creds, err := deviceCall(dev, "credentials", func() ([]*libfido2.Credential, error) { return dev.Credentials(rpID, pin.String()) })
+	if errors.Is(err, libfido2.ErrNoCredentials) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]bool{}
+	for _, c := range creds {
+		users[c.User.Name] = true
+	}
+	return users, nil
+}
+
+// optionTrue reports whether getInfo lists option name as true.
+func optionTrue(opts []libfido2.Option, name string) bool {
+	for _, o := range opts {
+		if strings.EqualFold(o.Name, name) {
+			return o.Value == libfido2.True
+		}
+	}
+	return false
+}
+
+// hasOption reports whether getInfo lists option name at all.
+func hasOption(opts []libfido2.Option, name string) bool {
+	for _, o := range opts {
+		if strings.EqualFold(o.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// printApply is the text output of `fit apply`.
+func printApply(r output.Apply) {
+	verb := "Applied"
+	if r.DryRun {
+		verb = "Plan for"
+	}
+	fmt.Printf("%s %s on %s (%s):\n", verb, r.Manifest, r.Device.Label, r.Device.Path)
+	for _, s := range r.Steps {
+		line := fmt.Sprintf("  %-9s %-18s %s", "["+s.Status+"]", s.Action, s.Target)
+		if s.CredentialID != "" {
+			line += "  credentialID=" + s.CredentialID
+		}
+		if s.Detail != "" {
+			line += "  (" + s.Detail + ")"
+		}
+		if s.Error != nil {
+			line += "  error: " + s.Error.Error()
+		}
+		fmt.Println(line)
+	}
+	if r.DryRun {
+		fmt.Println("Dry run: nothing was changed.")
+		return
+	}
+	fmt.Printf("%d created, %d failed, %d need manual action.\n", r.Created, r.Failed, r.Manual)
+}
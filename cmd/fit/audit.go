@@ -0,0 +1,148 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"fit/internal/audit"
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/output"
+)
+
+// recordAudit appends e to the audit log, filling in who, when and which
+// key, and the outcome from err. The key has already been changed (or the
+// attempt failed) by the time this runs, so a log that cannot be written is
+// reported on stderr without changing the command's result.
+func recordAudit(d devsel.Device, e audit.Entry, err error) {
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	e.Operator = audit.Operator()
+	e.Host, _ = os.Hostname()
+	if e.Command == "" {
+		e.Command = commandName
+	}
+	e.Device, e.Path, e.Fingerprint = d.Label, d.Path, d.Fingerprint
+	e.Result = "ok"
+	if err != nil {
+		e.Result = string(classify(e.Command, err).Code)
+	}
+	path, perr := audit.Path()
+	if perr == nil {
+		_, perr = audit.Append(path, e)
+	}
+	if perr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write the audit log: %v\n", perr)
+	}
+}
+
+// cmdAudit shows or verifies the audit log.
+func cmdAudit(fl *cli.Values) {
+	path := fl.String("log")
+	if path == "" {
+		p, err := audit.Path()
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.Other, 0, "audit", err))
+		}
+		path = p
+	}
+	entries, problems, err := audit.Read(path)
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.Other, 0, "audit", err))
+	}
+
+	switch fl.Arg {
+	case "show":
+		if n, ok := fl.Int("last"); ok && n >= 0 && n < len(entries) {
+			entries = entries[len(entries)-n:]
+		}
+		if outOpts.Structured() {
+			emit(output.AuditLog{Header: output.NewHeader("audit", output.BackendLibfido2), Log: path, Entries: nonNil(entries)})
+		} else {
+			printAuditLog(path, entries)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: the audit log's hash chain is broken (%d problem(s)); run `fit audit verify`.\n", len(problems))
+		}
+	case "verify":
+		report := output.AuditVerify{
+			Header:   output.NewHeader("audit", output.BackendLibfido2),
+			Log:      path,
+			Entries:  len(entries),
+			Anchor:   strings.ToLower(fl.String("anchor")),
+			Problems: nonNil(problems),
+		}
+		if len(entries) > 0 {
+			report.Head = entries[len(entries)-1].Hash
+		}
+		if report.Anchor != "" && !hasHash(entries, report.Anchor) {
+			report.Problems = append(report.Problems, audit.Problem{Message: fmt.Sprintf("no entry has the anchor hash %s (log truncated or replaced)", report.Anchor)})
+		}
+		report.OK = len(report.Problems) == 0
+		if outOpts.Structured() {
+			emit(report)
+		} else {
+			printAuditVerify(report)
+		}
+		if !report.OK {
+			exit(ctaperr.ExitCode(ctaperr.AuditBroken))
+		}
+	}
+}
+
+func hasHash(entries []audit.Entry, hash string) bool {
+	for _, e := range entries {
+		if e.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// printAuditLog is the text output of `fit audit show`.
+func printAuditLog(path string, entries []audit.Entry) {
+	if len(entries) == 0 {
+		fmt.Printf("No entries in %s.\n", path)
+		return
+	}
+	for _, e := range entries {
+		fmt.Printf("#%d %s %s@%s %s %s", e.Seq, e.Time, e.Operator, e.Host, e.Command, e.Result)
+		if e.Device != "" {
+			fmt.Printf("  device=%q fingerprint=%s", e.Device, e.Fingerprint)
+		}
+		if e.RP != "" {
+			fmt.Printf("  rp=%s", e.RP)
+		}
+		if e.User != "" {
+			fmt.Printf("  user=%s", e.User)
+		}
+		if e.CredentialID != "" {
+			fmt.Printf("  credentialID=%s", e.CredentialID)
+		}
+		fmt.Println()
+	}
+}
+
+// printAuditVerify is the text output of `fit audit verify`.
+func printAuditVerify(r output.AuditVerify) {
+	for _, p := range r.Problems {
+		if p.Line > 0 {
+			fmt.Printf("line %d (seq %d): %s\n", p.Line, p.Seq, p.Message)
+		} else {
+			fmt.Println(p.Message)
+		}
+	}
+	if r.OK {
+		fmt.Printf("OK: %d entries in %s, chain intact.\n", r.Entries, r.Log)
+	} else {
+		fmt.Printf("FAILED: %d problem(s) in %d entries of %s.\n", len(r.Problems), r.Entries, r.Log)
+	}
+	if r.Head != "" {
+		fmt.Printf("Head: %s\n", r.Head)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"fit/internal/ctaperr"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// opTimeout bounds each device operation (--timeout); zero waits forever.
+var opTimeout time.Duration
+
+// cancelGrace is how long deviceOp keeps sending CTAPHID_CANCEL before giving
+// up on a device that does not answer it.
+const cancelGrace = 3 * time.Second
+
+// deviceOp runs fn, a blocking libfido2 call on dev, so that Ctrl-C (SIGINT or
+// SIGTERM) or --timeout cancels the pending request with CTAPHID_CANCEL
+// instead of killing the process mid-transaction. libfido2 opens and closes
+// the device around each call, so once fn returns the device is released.
+//
+// An interrupted call yields a CANCELLED error and an expired one TIMED_OUT;
+// a call that completes despite the cancel keeps its own result.
+func deviceOp(dev *libfido2.Device, op string, fn func() error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opTimeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Cancel is a no-op until libfido2 has the device open, so repeat it
+	// until the call returns.
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	grace := time.After(cancelGrace)
+	for {
+		dev.Cancel()
+		select {
+		case err := <-done:
+			if err == nil {
+				return nil
+			}
+			return stopped(ctx, op, err)
+		case <-grace:
+			return stopped(ctx, op, nil)
+		case <-tick.C:
+		}
+	}
+}
+
+// deviceCall is deviceOp for calls that return a value.
+func deviceCall[T any](dev *libfido2.Device, op string, fn func() (T, error)) (T, error) {
+	var v T
+	err := deviceOp(dev, op, func() error {
+		var err error
+		v, err = fn()
+		return err
+	})
+	return v, err
+}
+
+// stopped reports why ctx ended an operation.
+func stopped(ctx context.Context, op string, err error) *ctaperr.Error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		e := ctaperr.Newf(ctaperr.TimedOut, op, "timed out after %s", opTimeout)
+		e.Err = err
+		return e
+	}
+	e := ctaperr.Newf(ctaperr.Cancelled, op, "cancelled by user")
+	e.Err = err
+	return e
+}
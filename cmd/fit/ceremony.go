@@ -0,0 +1,332 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"fit/internal/audit"
+	"fit/internal/cli"
+	"fit/internal/clientpolicy"
+	"fit/internal/credlist"
+	"fit/internal/ctaperr"
+	"fit/internal/inventory"
+	"fit/internal/output"
+	"fit/internal/pinentry"
+	"fit/internal/rp"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// cmdCeremony drives a remote relying party's begin/finish endpoints with the selected authenticator.
+func cmdCeremony(fl *cli.Values) {
+	kind := fl.Arg
+
+	base := strings.TrimRight(fl.String("url"), "/")
+	beginURL := fl.String("begin-url")
+	finishURL := fl.String("finish-url")
+	if base == "" && (beginURL == "" || finishURL == "") {
+		usageError(&cli.UsageError{Command: "ceremony", Msg: "--url is required unless both --begin-url and --finish-url are given"}, "ceremony")
+	}
+	if beginURL == "" {
+		beginURL = base + "/" + kind + "/begin"
+	}
+	if finishURL == "" {
+		finishURL = base + "/" + kind + "/finish"
+	}
+	userName := fl.String("user")
+	if userName == "" && kind == "register" {
+		userName = "fit-user"
+	}
+	display := fl.String("display")
+	if display == "" {
+		display = userName
+	}
+	pin := readPIN(fl, false)
+	defer pin.Zero()
+
+	client, err := rp.NewClient(beginURL, fl.Strings("cookie"), 60*time.Second)
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.Usage, 0, "ceremony", err))
+	}
+	for _, h := range fl.Strings("header") {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			exitWith(ctaperr.Newf(ctaperr.Usage, "ceremony", "--header %q: want 'Name: value'", h))
+		}
+		client.Header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	var beginBody any = map[string]string{"username": userName, "displayName": display}
+	if raw := fl.String("begin-body"); raw != "" {
+		var v any
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			exitWith(ctaperr.Newf(ctaperr.Usage, "ceremony", "--begin-body: %v", err))
+		}
+		beginBody = v
+	}
+
+	report := &output.Ceremony{Header: output.NewHeader("ceremony", output.BackendLibfido2), Ceremony: kind, Steps: []*rp.Step{}}
+	asJSON := fl.Bool("json")
+	step := func(st *rp.Step) {
+		report.Steps = append(report.Steps, st)
+		if !asJSON {
+			fmt.Printf("  %-7s POST %s -> %d (%dms)\n", st.Name, st.URL, st.Status, st.Millis)
+		}
+	}
+	fail := func(e *ctaperr.Error) {
+		report.Error = e
+		if asJSON {
+			writeJSON(report)
+			exit(e.ExitCode)
+		}
+		exitWith(e)
+	}
+	rpFail := func(op string, err error) {
+		fail(ctaperr.New(ctaperr.RPError, 0, op, err))
+	}
+
+	if !asJSON {
+		fmt.Printf("Ceremony %s against %s\n", kind, beginURL)
+	}
+	begun := func(st *rp.Step, err error) {
+		if st != nil {
+			step(st)
+		}
+		if err != nil {
+			rpFail("begin", err)
+		}
+	}
+
+	var cred any
+	switch kind {
+	case "register":
+		st, opts, err := client.BeginRegistration(beginURL, beginBody)
+		begun(st, err)
+		report.RP = opts.RP.ID
+		if err := checkCeremonyOrigin(fl, report); err != nil {
+			fail(ctaperr.New(ctaperr.OriginRejected, 0, "client policy", err))
+		}
+		dev := getDeviceWithArgs(fl)
+		if dev == nil {
+			return
+		}
+		c, err := ceremonyCreate(dev, opts, report.Origin, pin, credListLimits(fl), asJSON)
+		if err != nil {
+			fail(withRetries(dev, classify("makeCredential", err)))
+		}
+		report.CredentialID = c.ID
+		cred = c
+	case "login":
+		st, opts, err := client.BeginLogin(beginURL, beginBody)
+		begun(st, err)
+		report.RP = opts.RPID
+		if err := checkCeremonyOrigin(fl, report); err != nil {
+			fail(ctaperr.New(ctaperr.OriginRejected, 0, "client policy", err))
+		}
+		dev := getDeviceWithArgs(fl)
+		if dev == nil {
+			return
+		}
+		c, err := ceremonyGet(dev, opts, report.Origin, pin, credListLimits(fl), asJSON)
+		if err != nil {
+			fail(withRetries(dev, classify("getAssertion", err)))
+		}
+		report.CredentialID = c.ID
+		cred = c
+	}
+
+	st, err := client.Post("finish", finishURL, cred)
+	if st != nil {
+		step(st)
+	}
+	if err != nil {
+		rpFail("finish", err)
+	}
+	report.OK = true
+	if asJSON {
+		writeJSON(report)
+		return
+	}
+	fmt.Printf("Ceremony %s succeeded.\n", kind)
+	fmt.Printf("  RP:            %s\n", report.RP)
+	fmt.Printf("  Origin:        %s\n", report.Origin)
+	fmt.Printf("  CredentialID:  %s\n", report.CredentialID)
+}
+
+// ceremonyCreate performs makeCredential for server-provided creation options.
+func ceremonyCreate(dev *libfido2.Device, opts *rp.CreationOptions, origin string, pin *pinentry.Secret, lim credlist.Limits, quiet bool) (*rp.RegistrationCredential, error) {
+	alg, err := pickCredentialType(opts.PubKeyCredParams)
+	if err != nil {
+		return nil, err
+	}
+	var exclude [][]byte
+	for _, c := range opts.ExcludeCredentials {
+		exclude = append(exclude, c.ID)
+	}
+	if err := checkExcluded(dev, opts.RP.ID, exclude, pin, lim); err != nil {
+		return nil, err
+	}
+	clientData := rp.ClientDataJSON("webauthn.create", opts.Challenge, origin)
+	cdh := sha256.Sum256(clientData)
+	rk := libfido2.False
+	if opts.AuthenticatorSelection.RequireResidentKey || opts.AuthenticatorSelection.ResidentKey == "required" ||
+		(opts.AuthenticatorSelection.ResidentKey == "preferred" && !pin.Empty()) {
+		rk = libfido2.True
+	}
+	uv := libfido2.Default
+	if opts.AuthenticatorSelection.UserVerification == "required" && pin.Empty() {
+		uv = libfido2.True
+	}
+	if !quiet {
+		fmt.Printf("  device  makeCredential rp=%s user=%s alg=%s rk=%s (touch your key)\n", opts.RP.ID, opts.User.Name, alg, rk)
+	}
+	att, err := deviceCall(dev, "makeCredential", func() (*libfido2.Attestation, error) {
+		return dev.MakeCredential(
+			cdh[:],
+			libfido2.RelyingParty{ID: opts.RP.ID, Name: opts.RP.Name},
+			libfido2.User{ID: opts.User.ID, Name: opts.User.Name, DisplayName: opts.User.DisplayName},
+			alg,
+			pin.String(),
+			&libfido2.MakeCredentialOpts{RK: rk, UV: uv},
+		)
+	})
+	e := audit.Entry{Command: "ceremony register", RP: opts.RP.ID, User: opts.User.Name}
+	if err == nil {
+		e.CredentialID = output.B64(att.CredentialID)
+	}
+	d := openedDevice(dev)
+	recordAudit(d, e, err)
+	if err != nil {
+		return nil, fmt.Errorf("MakeCredential failed: %w", err)
+	}
+	recordCreated(d, inventory.Credential{
+		RP:       opts.RP.ID,
+		User:     opts.User.Name,
+		UserID:   output.B64(opts.User.ID),
+		Resident: rk == libfido2.True,
+		Source:   "ceremony",
+	}, att)
+	authData := rp.UnwrapCBORBytes(att.AuthData)
+	var stmt map[string]any
+	if att.Format != "none" {
+		// libfido2 does not expose the attestation statement alg; batch
+		// certificates are ES256, self attestation uses the credential alg.
+		stmtAlg := int64(att.CredentialType)
+		if len(att.Cert) > 0 {
+			stmtAlg = rp.AlgES256
+		}
+		stmt = rp.PackedAttStmt(att.Format, stmtAlg, att.Sig, att.Cert)
+	}
+	attObj, err := rp.EncodeAttestationObject(att.Format, authData, stmt)
+	if err != nil {
+		return nil, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(att.CredentialID)
+	return &rp.RegistrationCredential{
+		ID:    id,
+		RawID: att.CredentialID,
+		Type:  "public-key",
+		Response: rp.AttestationResponse{
+			ClientDataJSON:    clientData,
+			AttestationObject: attObj,
+			Transports:        []string{"usb"},
+		},
+	}, nil
+}
+
+// ceremonyGet performs getAssertion for server-provided request options.
+func ceremonyGet(dev *libfido2.Device, opts *rp.RequestOptions, origin string, pin *pinentry.Secret, lim credlist.Limits, quiet bool) (*rp.AuthenticationCredential, error) {
+	clientData := rp.ClientDataJSON("webauthn.get", opts.Challenge, origin)
+	cdh := sha256.Sum256(clientData)
+	var allow [][]byte
+	for _, c := range opts.AllowCredentials {
+		allow = append(allow, c.ID)
+	}
+	if len(allow) > 1 {
+		// Narrow the allow list to the credential this key holds so the
+		// request fits the device's list limits.
+		found, err := probeCredentials(dev, opts.RPID, allow, pin, lim)
+		if err != nil {
+			return nil, fmt.Errorf("credential pre-flight failed: %w", err)
+		}
+		if found == nil {
+			return nil, ctaperr.Newf(ctaperr.NoCredentials, "getAssertion", "none of the %d allowed credentials are present on this device", len(allow))
+		}
+		allow = [][]byte{found}
+	}
+	uv := libfido2.Default
+	if opts.UserVerification == "required" && pin.Empty() {
+		uv = libfido2.True
+	}
+	if !quiet {
+		fmt.Printf("  device  getAssertion rp=%s allow=%d (touch your key)\n", opts.RPID, len(allow))
+	}
+	asrt, err := deviceCall(dev, "getAssertion", func() (*libfido2.Assertion, error) {
+		return dev.Assertion(opts.RPID, cdh[:], allow, pin.String(), &libfido2.AssertionOpts{UV: uv})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Assertion failed: %w", err)
+	}
+	credID := asrt.CredentialID
+	if len(credID) == 0 && len(allow) == 1 {
+		credID = allow[0]
+	}
+	return &rp.AuthenticationCredential{
+		ID:    base64.RawURLEncoding.EncodeToString(credID),
+		RawID: credID,
+		Type:  "public-key",
+		Response: rp.AssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: rp.UnwrapCBORBytes(asrt.AuthDataCBOR),
+			Signature:         asrt.Sig,
+			UserHandle:        asrt.User.ID,
+		},
+	}, nil
+}
+
+// pickCredentialType selects the first pubKeyCredParams entry libfido2 can create.
+func pickCredentialType(params []rp.CredentialParameter) (libfido2.CredentialType, error) {
+	if len(params) == 0 {
+		return libfido2.ES256, nil
+	}
+	for _, p := range params {
+		switch p.Alg {
+		case rp.AlgES256:
+			return libfido2.ES256, nil
+		case rp.AlgEdDSA:
+			return libfido2.EDDSA, nil
+		case rp.AlgRS256:
+			return libfido2.RS256, nil
+		}
+	}
+	return 0, ctaperr.Newf(ctaperr.UnsupportedAlgorithm, "makeCredential", "no supported algorithm in pubKeyCredParams")
+}
+
+// checkCeremonyOrigin resolves the clientData origin (--origin, else https://<rpId>)
+// and applies the WebAuthn client rules for it, as a browser would.
+func checkCeremonyOrigin(fl *cli.Values, report *output.Ceremony) error {
+	report.Origin = fl.String("origin")
+	if report.Origin == "" {
+		report.Origin = clientpolicy.DefaultOrigin(report.RP)
+	}
+	res, err := clientPolicyFromArgs(fl).Check(report.RP, report.Origin)
+	if err != nil {
+		return err
+	}
+	report.RelatedOrigin = res.RelatedOrigin
+	return nil
+}
+
+// clientPolicyFromArgs builds the origin policy from --allow-insecure-origin and --related-origins-url.
+func clientPolicyFromArgs(fl *cli.Values) clientpolicy.Policy {
+	return clientpolicy.Policy{
+		AllowInsecureOrigin: fl.Bool("allow-insecure-origin"),
+		RelatedOriginsURL:   fl.String("related-origins-url"),
+	}
+}
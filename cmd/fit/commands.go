@@ -0,0 +1,395 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fit/internal/cli"
+	"fit/internal/format"
+	"fit/internal/output"
+)
+
+// Flag groups shared by several commands.
+var (
+	jsonFlag = cli.Flag{Name: "json", Kind: cli.Bool, Usage: "Output machine-readable JSON."}
+
+	// outputFlags select a format.Options for commands that print a document;
+	// --json stays as shorthand for --output json.
+	outputFlags = []cli.Flag{
+		jsonFlag,
+		{Name: "output", Arg: "FORMAT", Usage: "Output format: " + strings.Join(format.Names, ", ") + ".", Default: "text"},
+		{Name: "template", Arg: "TEXT", Usage: "Go text/template for --output template, executed on the JSON document."},
+	}
+	outputExclusive = []string{"json", "output"}
+
+	deviceFlags = []cli.Flag{
+		{Name: "device", Kind: cli.Int, Usage: "Select the device by index (see `fit list`)."},
+		{Name: "path", Arg: "PATH", Usage: "Select the device by path."},
+		{Name: "vidpid", Arg: "VVVV:PPPP", Usage: "Select by USB vendor:product ID (hex, as in `fit list`)."},
+		{Name: "aaguid", Arg: "UUID", Usage: "Select by authenticator AAGUID."},
+		{Name: "product-match", Arg: "REGEXP", Usage: "Select by manufacturer/product string (case-insensitive)."},
+		{Name: "alias", Arg: "NAME", Usage: "Select the key named with `fit alias set`."},
+		{Name: "select", Arg: "touch|prompt", Usage: "With several keys attached: use the one you touch, or ask for an index (default on a terminal)."},
+		timeoutFlag,
+		traceFlag, traceFileFlag, traceSecretsFlag,
+		{Name: "record", Arg: "FILE", Usage: "Record the CTAP exchange with the key to a session FILE for --replay."},
+		{Name: "replay", Arg: "FILE", Usage: "Play a recorded session back through a virtual key instead of using a real one."},
+	}
+	deviceExclusive = []string{"device", "path", "select", "replay"}
+
+	timeoutFlag = cli.Flag{Name: "timeout", Kind: cli.Duration, Usage: "Cancel each device operation (e.g. waiting for a touch) after this long.", Default: "none"}
+
+	// The trace flags log the CTAP traffic of any command that talks to a key.
+	traceFlag        = cli.Flag{Name: "trace", Kind: cli.Bool, Usage: "Log every CTAP request and response (decoded CBOR, status, latency) to stderr."}
+	traceFileFlag    = cli.Flag{Name: "trace-file", Arg: "FILE", Usage: "Append the --trace log to FILE instead of stderr."}
+	traceSecretsFlag = cli.Flag{Name: "trace-secrets", Kind: cli.Bool, Usage: "Do not redact pinUvAuthParam, PIN tokens, hmac-secret data and large-blob keys in the trace."}
+
+	// seedFlag makes the random values a command draws reproducible.
+	seedFlag = cli.Flag{Name: "seed", Arg: "SEED", Usage: "Derive challenges and user IDs from SEED (or FIT_SEED) for test vectors. NOT SECURE."}
+
+	// fleetFlags run a command on every attached device at once.
+	fleetFlags = []cli.Flag{
+		{Name: "all-devices", Kind: cli.Bool, Usage: "Run on every attached device (narrowed by --vidpid etc.) and report per device."},
+		{Name: "parallel", Kind: cli.Int, Usage: "Devices handled at once with --all-devices.", Default: "4"},
+	}
+	fleetExclusive = append(append([]string{}, deviceExclusive...), "all-devices")
+
+	pinFlags = []cli.Flag{
+		{Name: "pin", Arg: "PIN", Usage: "Device PIN (visible in ps and shell history; prefer the options below).", Secret: true},
+		{Name: "pin-stdin", Kind: cli.Bool, Usage: "Read the PIN from the first line of stdin."},
+		{Name: "pin-file", Arg: "FILE", Usage: "Read the PIN from the first line of FILE."},
+		{Name: "pin-fd", Kind: cli.Int, Usage: "Read the PIN from inherited file descriptor N."},
+	}
+	pinExclusive = []string{"pin", "pin-stdin", "pin-file", "pin-fd"}
+
+	credListFlags = []cli.Flag{
+		{Name: "max-cred-count", Kind: cli.Int, Usage: "Override the device's maxCredentialCountInList.", Default: "from getInfo, else 1"},
+		{Name: "max-cred-id-len", Kind: cli.Int, Usage: "Override the device's maxCredentialIdLength (longer IDs are skipped).", Default: "from getInfo"},
+	}
+
+	originFlags = []cli.Flag{
+		{Name: "origin", Arg: "ORIGIN", Usage: "clientData origin.", Default: "https://<rp id>"},
+		{Name: "allow-insecure-origin", Kind: cli.Bool, Usage: "Accept non-https origins other than http://localhost."},
+		{Name: "related-origins-url", Arg: "URL", Usage: "Related origins document ('-' disables the lookup)."},
+	}
+)
+
+// commands is the subcommand table, in help order. It is filled in init
+// because cmdHelp refers back to it.
+var commands []*cli.Command
+
+func init() {
+	commands = []*cli.Command{
+		{
+			Name:      "list",
+			Summary:   "Lists attached FIDO2 devices.",
+			Flags:     flagSet(outputFlags, fleetFlags, []cli.Flag{timeoutFlag}),
+			Exclusive: [][]string{outputExclusive},
+			Run:       cmdList,
+		},
+		{
+			Name:    "auth",
+			Summary: "Performs a challenge/response (assertion), optionally creating a transient credential first.",
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "rp", Arg: "RP_ID", Usage: "Relying party ID.", Required: true},
+					{Name: "cred-id-hex", Arg: "HEX", Usage: "Assert with this credential ID."},
+					{Name: "cred-index", Kind: cli.Int, Usage: "Assert with the Nth resident credential for the RP.", Default: "0"},
+					{Name: "allow-cred", Kind: cli.List, Arg: "ID", Usage: "Allowed credential ID (hex, base64url or @FILE)."},
+					{Name: "create", Kind: cli.Bool, Usage: "Create a transient non-resident credential, then assert with it."},
+				},
+				outputFlags, pinFlags, credListFlags, deviceFlags, fleetFlags, []cli.Flag{seedFlag},
+			),
+			Exclusive: [][]string{{"cred-id-hex", "cred-index", "allow-cred", "create"}, outputExclusive, pinExclusive, fleetExclusive},
+			Run:       cmdAuth,
+		},
+		{
+			Name:    "add-passkey",
+			Summary: "Creates a new passkey (discoverable credential) on a FIDO2 security key.",
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "rp", Arg: "RP_ID", Usage: "Relying party ID.", Required: true},
+					{Name: "user", Arg: "USER", Usage: "User name.", Default: "fit-user"},
+					{Name: "display", Arg: "NAME", Usage: "User display name.", Default: "the user name"},
+					{Name: "resident", Kind: cli.Bool, Usage: "Create a discoverable credential (the default)."},
+					{Name: "no-resident", Kind: cli.Bool, Usage: "Create a non-resident credential."},
+					{Name: "exclude-cred", Kind: cli.List, Arg: "ID", Usage: "Refuse if the key holds this credential ID."},
+				},
+				outputFlags, pinFlags, credListFlags, deviceFlags, fleetFlags, []cli.Flag{seedFlag},
+			),
+			Exclusive: [][]string{{"resident", "no-resident"}, outputExclusive, pinExclusive, fleetExclusive},
+			Run:       cmdAddPasskey,
+		},
+		{
+			Name:    "set-pin",
+			Summary: "Sets or changes the device PIN (prompts for the new PIN, and the current PIN when one is set).",
+			Flags: flagSet(
+				pinFlags,
+				[]cli.Flag{
+					{Name: "old", Arg: "PIN", Usage: "Current PIN (visible in ps; alias of --pin).", Secret: true},
+					{Name: "new", Arg: "PIN", Usage: "New PIN (visible in ps; prefer the options below).", Secret: true},
+					{Name: "new-pin-file", Arg: "FILE", Usage: "Read the new PIN from the first line of FILE."},
+					{Name: "new-pin-fd", Kind: cli.Int, Usage: "Read the new PIN from inherited file descriptor N."},
+				},
+				deviceFlags,
+			),
+			Exclusive: [][]string{append(append([]string{}, pinExclusive...), "old"), {"new", "new-pin-file", "new-pin-fd"}, deviceExclusive},
+			Run:       cmdSetPIN,
+		},
+		{
+			Name:    "reset",
+			Summary: "Factory-resets a FIDO2 device after showing which key will be wiped and asking for its fingerprint.",
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "confirm", Arg: "FINGERPRINT", Usage: "Confirm without a prompt (full fingerprint or its first 8 characters)."},
+					{Name: "retries", Kind: cli.Int, Usage: "Replug-and-retry rounds when the reset window has passed.", Default: "2"},
+					{Name: "replug-wait", Kind: cli.Duration, Usage: "How long to wait for the key to be replugged.", Default: "60s"},
+				},
+				pinFlags, deviceFlags,
+			),
+			Exclusive: [][]string{pinExclusive, deviceExclusive},
+			Run:       cmdReset,
+		},
+		{
+			Name:      "info",
+			Summary:   "Displays device information / non-destructive diagnostics (a PIN adds resident key counts).",
+			Flags:     flagSet(outputFlags, pinFlags, deviceFlags, fleetFlags),
+			Exclusive: [][]string{outputExclusive, pinExclusive, fleetExclusive},
+			Run:       cmdInfo,
+		},
+		{
+			Name:    "watch",
+			Summary: "Prints an NDJSON event whenever a FIDO2 device is attached or removed.",
+			Flags: []cli.Flag{
+				{Name: "interval", Kind: cli.Duration, Usage: "Polling interval.", Default: "500ms"},
+				{Name: "info", Kind: cli.Bool, Usage: "Add the `fit info` summary to add events."},
+				{Name: "existing", Kind: cli.Bool, Usage: "Report devices attached at start as add events (initial=true)."},
+				{Name: "count", Kind: cli.Int, Usage: "Exit after N events."},
+				timeoutFlag, traceFlag, traceFileFlag, traceSecretsFlag,
+			},
+			Run: cmdWatch,
+		},
+		{
+			Name:    "alias",
+			Summary: "Names a key in the local config so --alias finds it after replugging (set|list|remove).",
+			Args:    []string{"set", "list", "remove"},
+			Flags: flagSet(
+				[]cli.Flag{{Name: "name", Arg: "NAME", Usage: "Alias to set or remove."}},
+				outputFlags, deviceFlags,
+			),
+			Exclusive: [][]string{outputExclusive, deviceExclusive},
+			Run:       cmdAlias,
+		},
+		{
+			Name:     "apply",
+			Summary:  "Provisions the key to match a manifest (PIN policy, resident credentials); --dry-run shows the plan.",
+			Operands: []string{"MANIFEST"},
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "dry-run", Kind: cli.Bool, Usage: "Print the plan without changing the key."},
+					{Name: "new-pin-file", Arg: "FILE", Usage: "Read the PIN to set from the first line of FILE."},
+					{Name: "new-pin-fd", Kind: cli.Int, Usage: "Read the PIN to set from inherited file descriptor N."},
+				},
+				outputFlags, pinFlags, deviceFlags, []cli.Flag{seedFlag},
+			),
+			Exclusive: [][]string{outputExclusive, pinExclusive, {"new-pin-file", "new-pin-fd"}, deviceExclusive},
+			Run:       cmdApply,
+		},
+		{
+			Name:    "lint",
+			Summary: "Checks the key against an organization policy (models, firmware, extensions, PIN, UV, certification).",
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "policy", Arg: "FILE", Usage: "Policy file (YAML).", Required: true},
+					{Name: "mds", Arg: "FILE", Usage: "FIDO Metadata Service BLOB (JWT or JSON) for the certification rule."},
+					{Name: "strict", Kind: cli.Bool, Usage: "Fail rules the key cannot be checked against."},
+				},
+				outputFlags, deviceFlags,
+			),
+			Exclusive: [][]string{outputExclusive, deviceExclusive},
+			Run:       cmdLint,
+		},
+		{
+			Name:    "snapshot",
+			Summary: "Records getInfo, options, retry counts and, with a PIN, every resident credential as JSON for `fit diff`.",
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "save", Arg: "FILE", Usage: "Write the snapshot to FILE (mode 0600) instead of stdout."},
+				},
+				outputFlags, pinFlags, deviceFlags,
+			),
+			Exclusive: [][]string{outputExclusive, pinExclusive, deviceExclusive},
+			Run:       cmdSnapshot,
+		},
+		{
+			Name:     "diff",
+			Summary:  "Shows what changed between two snapshots, or between a snapshot and the attached key.",
+			Operands: []string{"A", "[B]"},
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "exit-code", Kind: cli.Bool, Usage: "Exit with SNAPSHOT_DIFFERS (62) when anything changed."},
+				},
+				outputFlags, pinFlags, deviceFlags,
+			),
+			Exclusive: [][]string{outputExclusive, pinExclusive, deviceExclusive},
+			Run:       cmdDiff,
+		},
+		{
+			Name:    "audit",
+			Summary: "Shows or verifies the hash-chained log of set-pin, reset and add-passkey operations (show|verify).",
+			Args:    []string{"show", "verify"},
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "log", Arg: "FILE", Usage: "Audit log to read.", Default: "$FIT_AUDIT_LOG or ~/.config/fit/audit.jsonl"},
+					{Name: "last", Kind: cli.Int, Usage: "show: only the last N entries."},
+					{Name: "anchor", Arg: "HASH", Usage: "verify: fail unless an entry has this hash (a head recorded earlier), to detect truncation."},
+				},
+				outputFlags,
+			),
+			Exclusive: [][]string{outputExclusive},
+			Run:       cmdAudit,
+		},
+		{
+			Name:    "inventory",
+			Summary: "Lists, shows or prunes the local record of credentials created and used by fit, or scans keys into a fleet report (list|show|prune|scan).",
+			Args:    []string{"list", "show", "prune", "scan"},
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "db", Arg: "FILE", Usage: "Inventory file.", Default: "$FIT_INVENTORY or ~/.config/fit/inventory.json"},
+					{Name: "id", Arg: "ID", Usage: "Credential ID (base64url or hex)."},
+					{Name: "rp", Arg: "RP_ID", Usage: "Only credentials for this RP."},
+					{Name: "fingerprint", Arg: "FP", Usage: "Only credentials on the key with this fingerprint."},
+					{Name: "alias", Arg: "NAME", Usage: "Only credentials on the key named with `fit alias set`."},
+					{Name: "flagged", Kind: cli.Bool, Usage: "Only credentials with a signature counter regression."},
+					{Name: "unused-for", Kind: cli.Duration, Usage: "Only credentials not used (or created) for this long, e.g. 2160h."},
+					{Name: "dry-run", Kind: cli.Bool, Usage: "prune: show what would be removed."},
+					{Name: "existing", Kind: cli.Bool, Usage: "scan: include the keys attached at start."},
+					{Name: "count", Kind: cli.Int, Usage: "scan: stop after N distinct keys (default: at Ctrl-C)."},
+					{Name: "interval", Kind: cli.Duration, Usage: "scan: polling interval.", Default: "500ms"},
+					{Name: "policy", Arg: "FILE", Usage: "scan: check each key against this policy (as `fit lint`)."},
+					{Name: "mds", Arg: "FILE", Usage: "scan: FIDO Metadata Service BLOB for certification status."},
+					timeoutFlag, traceFlag, traceFileFlag, traceSecretsFlag,
+				},
+				outputFlags, pinFlags,
+			),
+			Exclusive: [][]string{outputExclusive, pinExclusive, {"fingerprint", "alias"}},
+			Run:       cmdInventory,
+		},
+		{
+			Name:    "rp",
+			Summary: "Runs a local WebAuthn relying party (register/login begin+finish endpoints).",
+			Args:    []string{"serve"},
+			Flags: []cli.Flag{
+				{Name: "addr", Arg: "HOST:PORT", Usage: "Listen address.", Default: "127.0.0.1:8787"},
+				{Name: "rp-id", Arg: "ID", Usage: "RP ID.", Default: "localhost"},
+				{Name: "rp-name", Arg: "NAME", Usage: "RP display name."},
+				{Name: "origin", Arg: "URL[,URL...]", Usage: "Accepted origins.", Default: "https://<rp id>"},
+				{Name: "store", Arg: "FILE", Usage: "Persist users and credentials to FILE (in memory when unset)."},
+				{Name: "uv", Arg: "required|preferred|discouraged", Usage: "User verification requirement."},
+				{Name: "resident", Arg: "required|preferred|discouraged", Usage: "Resident key requirement."},
+				{Name: "algs", Arg: "ES256,EdDSA,RS256", Usage: "Accepted algorithms, in preference order."},
+				{Name: "attestation", Arg: "none|indirect|direct|enterprise", Usage: "Attestation conveyance."},
+				{Name: "timeout", Kind: cli.Duration, Usage: "Ceremony timeout sent to clients."},
+				seedFlag,
+			},
+			Run: cmdRP,
+		},
+		{
+			Name:    "ceremony",
+			Summary: "Drives a remote relying party: fetch options from begin, run the ceremony, post to finish.",
+			Args:    []string{"register", "login"},
+			Flags: flagSet(
+				[]cli.Flag{
+					{Name: "url", Arg: "BASE_URL", Usage: "RP base URL; endpoints are BASE_URL/<register|login>/<begin|finish>."},
+					{Name: "begin-url", Arg: "URL", Usage: "Override the begin endpoint."},
+					{Name: "finish-url", Arg: "URL", Usage: "Override the finish endpoint."},
+					{Name: "user", Arg: "USER", Usage: "User name sent to begin.", Default: "fit-user for register"},
+					{Name: "display", Arg: "NAME", Usage: "Display name sent to begin."},
+					{Name: "header", Kind: cli.List, Arg: "'K: V'", Usage: "Extra request header."},
+					{Name: "cookie", Kind: cli.List, Arg: "NAME=VALUE", Usage: "Cookie to preload."},
+					{Name: "begin-body", Arg: "JSON", Usage: "Raw JSON body for begin (replaces username/displayName)."},
+					jsonFlag,
+				},
+				originFlags, pinFlags, credListFlags, deviceFlags,
+			),
+			Exclusive: [][]string{pinExclusive, deviceExclusive},
+			Run:       cmdCeremony,
+		},
+		{
+			Name:    "schema",
+			Summary: "Prints the JSON Schema of a command's --json output.",
+			Args:    output.Names(),
+			Flags: []cli.Flag{
+				{Name: "example", Kind: cli.Bool, Usage: "Print an example document instead of the schema."},
+			},
+			Run: cmdSchema,
+		},
+		{
+			Name:    "version",
+			Summary: "Prints build version (embedded via ldflags).",
+			Run:     func(*cli.Values) { fmt.Println(buildVersion) },
+		},
+	}
+}
+
+// cmdSchema prints the JSON Schema (or an example) for one output document.
+func cmdSchema(fl *cli.Values) {
+	get := output.Schema
+	if fl.Bool("example") {
+		get = output.Example
+	}
+	b, err := get(fl.Arg)
+	if err != nil {
+		usageError(err, "schema")
+	}
+	os.Stdout.Write(b)
+}
+
+// outputOptions resolves --output, --template and --json. Commands without
+// --output get Text, or JSON when they take --json.
+func outputOptions(fl *cli.Values) format.Options {
+	if fl.Bool("json") {
+		return format.Options{Format: format.JSON}
+	}
+	o, err := format.Parse(fl.String("output"), fl.String("template"))
+	if err != nil {
+		usageError(err, fl.Command.Name)
+	}
+	return o
+}
+
+// flagSet concatenates flag groups.
+func flagSet(groups ...[]cli.Flag) []cli.Flag {
+	var out []cli.Flag
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+func findCommand(name string) *cli.Command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// exeName is the program name for usage text.
+func exeName() string {
+	return filepath.Base(os.Args[0])
+}
@@ -0,0 +1,134 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"fit/internal/chal"
+	"fit/internal/cli"
+	"fit/internal/credlist"
+	"fit/internal/ctaperr"
+	"fit/internal/pinentry"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// credListLimits reads --max-cred-count and --max-cred-id-len, which override
+// the device's maxCredentialCountInList / maxCredentialIdLength. Limits left
+// zero are filled from getInfo by deviceLimits.
+func credListLimits(fl *cli.Values) credlist.Limits {
+	var lim credlist.Limits
+	if n, ok := fl.Int("max-cred-count"); ok {
+		lim.MaxCount = n
+	}
+	if n, ok := fl.Int("max-cred-id-len"); ok {
+		lim.MaxIDLen = n
+	}
+	return lim
+}
+
+// deviceLimits fills the limits lim leaves unknown from the key's getInfo
+// (0x07 maxCredentialCountInList, 0x08 maxCredentialIdLength), which fit reads
+// itself since go-libfido2 does not expose them. When getInfo cannot be read
+// the limits stay unknown and credlist's defaults apply.
+func deviceLimits(dev *libfido2.Device, lim credlist.Limits) credlist.Limits {
+	if lim.MaxCount > 0 && lim.MaxIDLen > 0 {
+		return lim
+	}
+	info, err := extendedInfo(dev)
+	if err != nil {
+		log.Printf("Reading credential list limits from getInfo: %v", err)
+		return lim
+	}
+	if lim.MaxCount == 0 && info.MaxCredentialCountInList != nil {
+		lim.MaxCount = *info.MaxCredentialCountInList
+	}
+	if lim.MaxIDLen == 0 && info.MaxCredentialIDLength != nil {
+		lim.MaxIDLen = *info.MaxCredentialIDLength
+	}
+	return lim
+}
+
+// parseCredFlags decodes every value of a repeatable credential-ID flag.
+func parseCredFlags(fl *cli.Values, name string) [][]byte {
+	ids, err := credlist.Parse(fl.Strings(name))
+	if err != nil {
+		usageError(fmt.Errorf("--%s: %w", name, err), fl.Command.Name)
+	}
+	return ids
+}
+
+// probeCredentials silently asks the device (up=false) which of ids it holds
+// for rpID, one batch at a time, the way browsers pre-flight allow and exclude
+// lists. It returns the first credential found, or nil when none are present.
+// lim holds the flag overrides; the rest comes from the key's getInfo.
+func probeCredentials(dev *libfido2.Device, rpID string, ids [][]byte, pin *pinentry.Secret, lim credlist.Limits) ([]byte, error) {
+	lim = deviceLimits(dev, lim)
+	batches, skipped := credlist.Batch(ids, lim)
+	if len(skipped) > 0 {
+		log.Printf("Skipping %d credential ID(s) longer than maxCredentialIdLength=%d", len(skipped), lim.MaxIDLen)
+	}
+	for _, batch := range batches {
+		if found, err := probeBatch(dev, rpID, batch, pin); err != nil || found != nil {
+			return found, err
+		}
+	}
+	return nil, nil
+}
+
+// probeBatch sends one silent getAssertion with batch as the allow list and
+// returns the credential the key used, or nil when it holds none of them. A
+// key may leave the credential out of its response only for a single-entry
+// allow list; when it does so for a longer one, the IDs are probed one at a
+// time, and a key that then matches none of them is answering wrongly.
+func probeBatch(dev *libfido2.Device, rpID string, batch [][]byte, pin *pinentry.Secret) ([]byte, error) {
+	asrt, err := deviceCall(dev, "getAssertion", func() (*libfido2.Assertion, error) {
+		return dev.Assertion(rpID, chal.Bytes(32), batch, pin.String(), &libfido2.AssertionOpts{UP: libfido2.False})
+	})
+	switch {
+	case errors.Is(err, libfido2.ErrNoCredentials):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case len(asrt.CredentialID) > 0:
+		return asrt.CredentialID, nil
+	case len(batch) == 1:
+		return batch[0], nil
+	}
+	for _, id := range batch {
+		if found, err := probeBatch(dev, rpID, [][]byte{id}, pin); err != nil || found != nil {
+			return found, err
+		}
+	}
+	return nil, ctaperr.Newf(ctaperr.Transport, "getAssertion", "the key matched an allow list of %d credentials without naming one, then matched none of them alone", len(batch))
+}
+
+// excludedCredentialError reports that creation was refused because the device
+// already holds a credential from the exclude list.
+type excludedCredentialError struct {
+	ID []byte
+}
+
+func (e *excludedCredentialError) Error() string {
+	return "creation refused: device already holds excluded credential " + hex.EncodeToString(e.ID)
+}
+
+// checkExcluded returns an excludedCredentialError if the device holds any of ids for rpID.
+func checkExcluded(dev *libfido2.Device, rpID string, ids [][]byte, pin *pinentry.Secret, lim credlist.Limits) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	found, err := probeCredentials(dev, rpID, ids, pin, lim)
+	if err != nil {
+		return err
+	}
+	if found != nil {
+		return &excludedCredentialError{ID: found}
+	}
+	return nil
+}
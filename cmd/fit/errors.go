@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"fit/internal/ctaperr"
+	"fit/internal/format"
+	"fit/internal/output"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// jsonMode is set from --json (or --output json|ndjson) before dispatch so
+// failures are reported as a JSON error object instead of text.
+var jsonMode bool
+
+// outOpts is the output format chosen for the running command.
+var outOpts format.Options
+
+// commandName is the running subcommand, for the error document header.
+var commandName string
+
+// sentinelStatus maps go-libfido2's sentinel errors to CTAP2 status bytes.
+// Negative libfido2 codes (transport, argument) have no status and are handled
+// in classify.
+var sentinelStatus = []struct {
+	err    error
+	status int
+}{
+	{libfido2.ErrInvalidCommand, 0x01},
+	{libfido2.ErrInvalidLength, 0x03},
+	{libfido2.ErrMissingParameter, 0x14},
+	{libfido2.ErrInvalidCredential, 0x22},
+	{libfido2.ErrOperationDenied, 0x27},
+	{libfido2.ErrUnsupportedOption, 0x2B},
+	{libfido2.ErrInvalidOption, 0x2C},
+	{libfido2.ErrKeepaliveCancel, 0x2D},
+	{libfido2.ErrNoCredentials, 0x2E},
+	{libfido2.ErrActionTimeout, 0x2F},
+	{libfido2.ErrNotAllowed, 0x30},
+	{libfido2.ErrPinInvalid, 0x31},
+	{libfido2.ErrPinAuthBlocked, 0x34},
+	{libfido2.ErrPinNotSet, 0x35},
+	{libfido2.ErrPinRequired, 0x36},
+	{libfido2.ErrPinPolicyViolation, 0x37},
+	{libfido2.ErrUPRequired, 0x3B},
+	{libfido2.ErrOther, 0x7F},
+}
+
+// classify maps an error from a device operation onto the taxonomy.
+func classify(op string, err error) *ctaperr.Error {
+	if e, ok := ctaperr.As(err); ok {
+		return e
+	}
+	var ex *excludedCredentialError
+	if errors.As(err, &ex) {
+		return ctaperr.New(ctaperr.CredentialExcluded, 0, op, err)
+	}
+	for _, s := range sentinelStatus {
+		if errors.Is(err, s.err) {
+			return ctaperr.FromStatus(s.status, op, err)
+		}
+	}
+	switch {
+	case errors.Is(err, libfido2.ErrTX), errors.Is(err, libfido2.ErrRX),
+		errors.Is(err, libfido2.ErrRXNotCBOR), errors.Is(err, libfido2.ErrRXInvalidCBOR):
+		return ctaperr.New(ctaperr.Transport, 0, op, err)
+	case errors.Is(err, libfido2.ErrUserPresenceRequired):
+		return ctaperr.New(ctaperr.UPRequired, 0, op, err)
+	case errors.Is(err, libfido2.ErrNotFIDO2):
+		return ctaperr.New(ctaperr.NotFIDO2, 0, op, err)
+	case errors.Is(err, libfido2.ErrInvalidArgument):
+		return ctaperr.New(ctaperr.InvalidParameter, 0, op, err)
+	}
+	// Codes go-libfido2 has no sentinel for (PIN_BLOCKED, UV_BLOCKED,
+	// CREDENTIAL_EXCLUDED, ...) arrive as libfido2.Error{Code}.
+	var le libfido2.Error
+	if errors.As(err, &le) && le.Code > 0 {
+		return ctaperr.FromStatus(le.Code, op, err)
+	}
+	return ctaperr.New(ctaperr.Other, 0, op, err)
+}
+
+// withRetries appends the remaining PIN retries to the hint of PIN failures.
+func withRetries(dev *libfido2.Device, e *ctaperr.Error) *ctaperr.Error {
+	if e.Code != ctaperr.PINInvalid || dev == nil {
+		return e
+	}
+	if n, err := dev.RetryCount(); err == nil {
+		e.Hint = fmt.Sprintf("%s %d retries left.", e.Hint, n)
+	}
+	return e
+}
+
+// fatal classifies err, reports it (JSON on stdout with --json, text on
+// stderr otherwise) and exits with the code's documented exit status.
+func fatal(op string, err error) {
+	exitWith(classify(op, err))
+}
+
+// exitWith reports an already classified error and exits.
+func exitWith(e *ctaperr.Error) {
+	if jsonMode {
+		writeJSON(output.Error{Header: output.NewHeader(commandName, output.BackendLibfido2), Error: e})
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+		if e.Hint != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", e.Hint)
+		}
+	}
+	exit(e.ExitCode)
+}
+
+// exit ends the trace and any --record or --replay session and exits with
+// code, or with REPLAY_MISMATCH when the replayed run strayed.
+func exit(code int) {
+	stopTrace()
+	os.Exit(stopSession(code))
+}
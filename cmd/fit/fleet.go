@@ -0,0 +1,142 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/output"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// defaultParallel is the --parallel default for --all-devices.
+const defaultParallel = 4
+
+// notef receives progress notes from a device operation.
+type notef func(format string, args ...any)
+
+// deviceFunc runs one command against dev and returns its document.
+type deviceFunc func(dev *libfido2.Device, note notef) (any, error)
+
+// runAll runs fn on every attached device (narrowed by any stable selectors)
+// with at most --parallel devices in flight, then prints one report and exits
+// with the status of the first failing device, or 0. Each device gets its own
+// --timeout, so a slow or failing key only holds up its own slot. show prints
+// one device's document as text.
+func runAll(fl *cli.Values, fn deviceFunc, show func(any)) {
+	opTimeout = fl.Duration("timeout", 0)
+	parallel := defaultParallel
+	if n, ok := fl.Int("parallel"); ok {
+		if n < 1 {
+			usageError(fmt.Errorf("--parallel must be at least 1"), fl.Command.Name)
+		}
+		parallel = n
+	}
+
+	all, err := libfido2.DeviceLocations()
+	if err != nil {
+		fatal("enumerate devices", err)
+	}
+	locs := all
+	if crit := parseCriteria(fl); !crit.Empty() {
+		locs = matchDevices(all, crit)
+	}
+
+	results := make([]output.DeviceResult, len(locs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for i, loc := range locs {
+		results[i].Device = listEntry(slices.Index(all, loc), loc)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			note := func(format string, args ...any) {
+				fmt.Fprintf(os.Stderr, "[%s] "+format, append([]any{loc.Path}, args...)...)
+			}
+			start := time.Now()
+			doc, err := openAndRun(loc, fn, note)
+			results[i].Millis = time.Since(start).Milliseconds()
+			if err != nil {
+				results[i].Error = classify(fl.Command.Name, err)
+				return
+			}
+			results[i].OK = true
+			results[i].Result = doc
+		}()
+	}
+	wg.Wait()
+
+	report := output.Fleet{
+		Header:   output.NewHeader(fl.Command.Name, output.BackendLibfido2),
+		Parallel: parallel,
+		Devices:  results,
+	}
+	var firstErr *ctaperr.Error
+	for _, r := range results {
+		if r.OK {
+			report.OK++
+			continue
+		}
+		report.Failed++
+		if firstErr == nil {
+			firstErr = r.Error
+		}
+	}
+
+	if outOpts.Structured() {
+		emit(report)
+	} else {
+		printFleet(report, show)
+	}
+	if firstErr != nil {
+		exit(firstErr.ExitCode)
+	}
+	if len(locs) == 0 {
+		exit(ctaperr.ExitCode(ctaperr.NoDevice))
+	}
+}
+
+func openAndRun(loc *libfido2.DeviceLocation, fn deviceFunc, note notef) (any, error) {
+	dev, err := openDevice(loc)
+	if err != nil {
+		return nil, ctaperr.New(ctaperr.NoDevice, 0, "open", err)
+	}
+	defer closeDevice(dev)
+	return fn(dev, note)
+}
+
+// printFleet is the text form of an --all-devices report.
+func printFleet(report output.Fleet, show func(any)) {
+	if len(report.Devices) == 0 {
+		fmt.Println("No FIDO2 devices found.")
+		return
+	}
+	for _, r := range report.Devices {
+		d := r.Device
+		status := "ok"
+		if !r.OK {
+			status = "FAILED"
+		}
+		fmt.Printf("== [%d] %s  VID:PID=%04x:%04x  Path=%s  %s (%dms)\n", d.Index, d.Label, d.VID, d.PID, d.Path, status, r.Millis)
+		if r.OK {
+			show(r.Result)
+		} else {
+			fmt.Printf("  Error: %s\n", r.Error.Error())
+			if r.Error.Hint != "" {
+				fmt.Printf("  Hint: %s\n", r.Error.Hint)
+			}
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d device(s): %d ok, %d failed (parallel %d)\n", len(report.Devices), report.OK, report.Failed, report.Parallel)
+}
@@ -0,0 +1,324 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/inventory"
+	"fit/internal/output"
+	"fit/internal/rp"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// updateInventory applies fn to the local inventory. Like the audit log, the
+// inventory is bookkeeping around an operation that already happened, so a
+// failure is reported on stderr and does not fail the command.
+func updateInventory(fn func(*inventory.DB) error) {
+	path, err := inventory.Path()
+	if err == nil {
+		err = inventory.Update(path, fn)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not update the credential inventory: %v\n", err)
+	}
+}
+
+// recordCreated adds a credential made on key d to the inventory. c carries
+// what the command knows (RP, user, residency, source); the ID, key and
+// signature counter come from att.
+func recordCreated(d devsel.Device, c inventory.Credential, att *libfido2.Attestation) {
+	c.ID = output.B64(att.CredentialID)
+	c.IDHex = output.Hex(att.CredentialID)
+	c.Device = d.Label
+	c.Fingerprint = d.Fingerprint
+	c.AAGUID = formatAAGUID(d.AAGUID)
+	c.Created = time.Now().UTC().Format(time.RFC3339)
+	if ad, err := rp.ParseAuthenticatorData(rp.UnwrapCBORBytes(att.AuthData)); err == nil {
+		c.SignCount = ad.SignCount
+		if ad.PublicKey != nil {
+			c.PublicKey = output.B64(ad.PublicKey)
+			if k, err := rp.ParseCOSEKey(ad.PublicKey); err == nil {
+				c.Alg = rp.AlgName(k.Alg)
+			}
+		}
+	}
+	updateInventory(func(db *inventory.DB) error {
+		db.Put(c)
+		return nil
+	})
+}
+
+// recordAssertion updates the inventory with an assertion's signature counter
+// and reports whether the counter went backwards. A credential fit has not
+// seen before is added; identify names its key and is only called then,
+// before the inventory is locked, since it talks to the key.
+func recordAssertion(rpID string, credID []byte, user string, count uint32, identify func() devsel.Device) bool {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var d devsel.Device
+	if path, err := inventory.Path(); err == nil {
+		if db, err := inventory.Load(path); err == nil && db.Find(output.B64(credID)) == nil {
+			d = identify()
+		}
+	}
+	var reg *inventory.Regression
+	updateInventory(func(db *inventory.DB) error {
+		c := db.Find(output.B64(credID))
+		if c == nil {
+			// Also when another fit removed it since the check above;
+			// the record then lacks the key's name.
+			c = db.Put(inventory.Credential{
+				ID:          output.B64(credID),
+				IDHex:       output.Hex(credID),
+				RP:          rpID,
+				User:        user,
+				Device:      d.Label,
+				Fingerprint: d.Fingerprint,
+				AAGUID:      formatAAGUID(d.AAGUID),
+				Source:      "auth",
+				Created:     now,
+			})
+		}
+		reg = c.Observe(count, now)
+		return nil
+	})
+	if reg != nil {
+		fmt.Fprintf(os.Stderr, "Warning: signature counter of credential %s went from %d to %d; the authenticator may have been cloned (see `fit inventory show --id %s`).\n",
+			output.Hex(credID), reg.Previous, reg.Reported, output.Hex(credID))
+	}
+	return reg != nil
+}
+
+// forgetDevice drops the credentials of a key that was just reset. A key
+// without a HID serial shares its fingerprint with every key of its model,
+// so the inventory cannot tell its credentials from theirs; fit asks before
+// removing them and keeps them when it cannot ask.
+func forgetDevice(d devsel.Device) {
+	match := func(c *inventory.Credential) bool { return c.Fingerprint == d.Fingerprint }
+	if d.Serial == "" {
+		path, err := inventory.Path()
+		if err != nil {
+			return
+		}
+		db, err := inventory.Load(path)
+		if err != nil {
+			return
+		}
+		n := 0
+		for i := range db.Credentials {
+			if match(&db.Credentials[i]) {
+				n++
+			}
+		}
+		if n == 0 {
+			return
+		}
+		fmt.Printf("The inventory has %d credential(s) recorded on keys of this model (fingerprint %s).\n", n, d.Fingerprint)
+		fmt.Println("The key has no serial number, so some of them may belong to other keys of the same model.")
+		answer, err := askTTY("Remove them all from the inventory? [y/N] ")
+		if err != nil || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Println("Kept them. Remove the ones from this key with `fit inventory prune --id ID`.")
+			return
+		}
+	}
+	var removed []inventory.Credential
+	updateInventory(func(db *inventory.DB) error {
+		removed = db.Remove(match)
+		return nil
+	})
+	if len(removed) > 0 {
+		fmt.Printf("Removed %d credential(s) of this key from the inventory.\n", len(removed))
+	}
+}
+
+func formatAAGUID(b []byte) string {
+	if b == nil {
+		return ""
+	}
+	return devsel.FormatAAGUID(b)
+}
+
+// cmdInventory lists, shows and prunes the local credential inventory, or
+// scans a batch of keys into a fleet report (see cmdInventoryScan).
+func cmdInventory(fl *cli.Values) {
+	if fl.Arg == "scan" {
+		cmdInventoryScan(fl)
+		return
+	}
+	path := fl.String("db")
+	if path == "" {
+		p, err := inventory.Path()
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.Other, 0, "inventory", err))
+		}
+		path = p
+	}
+	match := inventoryFilter(fl)
+	header := output.NewHeader("inventory", output.BackendLibfido2)
+
+	switch fl.Arg {
+	case "list", "show":
+		if fl.Arg == "show" && fl.String("id") == "" {
+			usageError(fmt.Errorf("inventory show needs --id"), "inventory")
+		}
+		db, err := inventory.Load(path)
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.Other, 0, "inventory", err))
+		}
+		creds := []inventory.Credential{}
+		for i := range db.Credentials {
+			if match(&db.Credentials[i]) {
+				creds = append(creds, db.Credentials[i])
+			}
+		}
+		if fl.Arg == "show" && len(creds) == 0 {
+			exitWith(ctaperr.Newf(ctaperr.NoCredentials, "inventory", "no credential %s in %s", fl.String("id"), path))
+		}
+		if outOpts.Structured() {
+			emit(output.Inventory{Header: header, DB: path, Credentials: creds})
+		} else if fl.Arg == "show" {
+			printInventoryCredential(creds[0])
+		} else {
+			printInventory(path, creds)
+		}
+	case "prune":
+		if !fl.Has("id") && !fl.Has("rp") && !fl.Has("fingerprint") && !fl.Has("unused-for") && !fl.Has("alias") {
+			usageError(fmt.Errorf("inventory prune needs --id, --rp, --fingerprint, --alias or --unused-for"), "inventory")
+		}
+		report := output.InventoryPrune{Header: header, DB: path, DryRun: fl.Bool("dry-run")}
+		apply := func(db *inventory.DB) error {
+			report.Removed = nonNil(db.Remove(match))
+			report.Remaining = len(db.Credentials)
+			return nil
+		}
+		var err error
+		if report.DryRun {
+			var db *inventory.DB
+			if db, err = inventory.Load(path); err == nil {
+				err = apply(db)
+			}
+		} else {
+			err = inventory.Update(path, apply)
+		}
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.Other, 0, "inventory", err))
+		}
+		if outOpts.Structured() {
+			emit(report)
+			return
+		}
+		verb := "Removed"
+		if report.DryRun {
+			verb = "Would remove"
+		}
+		for _, c := range report.Removed {
+			fmt.Printf("  %s  %s  %s  %s\n", c.IDHex, c.RP, c.User, c.Device)
+		}
+		fmt.Printf("%s %d credential(s); %d left in %s.\n", verb, len(report.Removed), report.Remaining, path)
+	}
+}
+
+// inventoryFilter builds the credential filter shared by list, show and
+// prune from --id, --rp, --fingerprint, --alias, --flagged and --unused-for.
+func inventoryFilter(fl *cli.Values) func(*inventory.Credential) bool {
+	id := fl.String("id")
+	rpID := fl.String("rp")
+	fp := strings.ToLower(fl.String("fingerprint"))
+	if name := fl.String("alias"); name != "" {
+		cfg, cfgPath := loadDeviceConfig()
+		a, ok := cfg.Lookup(name)
+		if !ok {
+			usageError(fmt.Errorf("no alias %q in %s (see `fit alias list`)", name, cfgPath), "inventory")
+		}
+		fp = a.Fingerprint
+	}
+	flagged := fl.Bool("flagged")
+	var before time.Time
+	if fl.Has("unused-for") {
+		before = time.Now().Add(-fl.Duration("unused-for", 0))
+	}
+	return func(c *inventory.Credential) bool {
+		if id != "" && c.ID != id && !strings.EqualFold(c.IDHex, id) {
+			return false
+		}
+		if rpID != "" && c.RP != rpID {
+			return false
+		}
+		if fp != "" && c.Fingerprint != fp {
+			return false
+		}
+		if flagged && !c.Flagged() {
+			return false
+		}
+		if !before.IsZero() {
+			last := c.LastUsed
+			if last == "" {
+				last = c.Created
+			}
+			// A time that does not parse says nothing about use.
+			if t, err := time.Parse(time.RFC3339, last); err != nil || !t.Before(before) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// printInventory is the text output of `fit inventory list`.
+func printInventory(path string, creds []inventory.Credential) {
+	if len(creds) == 0 {
+		fmt.Printf("No credentials in %s.\n", path)
+		return
+	}
+	flagged := 0
+	for _, c := range creds {
+		mark := ""
+		if c.Flagged() {
+			mark = "  COUNTER REGRESSION"
+			flagged++
+		}
+		last := c.LastUsed
+		if last == "" {
+			last = "never"
+		}
+		fmt.Printf("  %s  %-20s %-16s %s  count=%d last=%s%s\n", c.IDHex, c.RP, c.User, c.Device, c.SignCount, last, mark)
+	}
+	fmt.Printf("%d credential(s)", len(creds))
+	if flagged > 0 {
+		fmt.Printf(", %d with counter regressions (possible cloned authenticators)", flagged)
+	}
+	fmt.Println()
+}
+
+// printInventoryCredential is the text output of `fit inventory show`.
+func printInventoryCredential(c inventory.Credential) {
+	fmt.Printf("Credential %s\n", c.IDHex)
+	fmt.Printf("  ID (b64url):  %s\n", c.ID)
+	fmt.Printf("  RP:           %s\n", c.RP)
+	fmt.Printf("  User:         %s\n", c.User)
+	if c.UserID != "" {
+		fmt.Printf("  UserID:       %s\n", c.UserID)
+	}
+	fmt.Printf("  Resident:     %v\n", c.Resident)
+	if c.Alg != "" {
+		fmt.Printf("  Algorithm:    %s\n", c.Alg)
+	}
+	if c.PublicKey != "" {
+		fmt.Printf("  PublicKey:    %s\n", c.PublicKey)
+	}
+	fmt.Printf("  Device:       %s (fingerprint %s, AAGUID %s)\n", c.Device, c.Fingerprint, c.AAGUID)
+	fmt.Printf("  Recorded:     %s by %s\n", c.Created, c.Source)
+	fmt.Printf("  Uses:         %d (last %s)\n", c.Uses, c.LastUsed)
+	fmt.Printf("  SignCount:    %d\n", c.SignCount)
+	for _, r := range c.Regressions {
+		fmt.Printf("  REGRESSION:   %s counter %d after %d\n", r.Time, r.Reported, r.Previous)
+	}
+}
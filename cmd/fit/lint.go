@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/mds"
+	"fit/internal/output"
+	"fit/internal/policy"
+)
+
+// cmdLint checks the selected key against a policy file and exits with
+// POLICY_FAILED when a rule fails (or, with --strict, cannot be checked).
+func cmdLint(fl *cli.Values) {
+	p, err := policy.Load(fl.String("policy"))
+	if err != nil {
+		usageError(err, "lint")
+	}
+	blob := loadMDS(fl, p)
+
+	loc, timeout := selectDevice(fl)
+	opTimeout = timeout
+	dev, err := openDevice(loc)
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.NoDevice, 0, "open", err))
+	}
+	info, err := runInfo(dev, nil)
+	if err != nil {
+		exitWith(classify("info", err))
+	}
+	d := openedDevice(dev)
+	report := lintReport(p, blob, info, fl.Bool("strict"))
+	report.Policy = fl.String("policy")
+	report.Device = deviceEntry(loc)
+	report.Fingerprint = d.Fingerprint
+
+	if outOpts.Structured() {
+		emit(report)
+	} else {
+		printLint(report)
+	}
+	if !report.Pass {
+		exit(ctaperr.ExitCode(ctaperr.PolicyFailed))
+	}
+}
+
+// loadMDS reads --mds. Without it a certification rule is unknown, so a
+// warning is printed when the policy (which may be nil) has one.
+func loadMDS(fl *cli.Values, p *policy.Policy) *mds.BLOB {
+	path := fl.String("mds")
+	if path == "" {
+		if p != nil && p.NeedsMDS() {
+			fmt.Fprintln(os.Stderr, "Warning: the policy has a certification rule but no metadata BLOB was given (--mds); it cannot be checked.")
+		}
+		return nil
+	}
+	blob, err := mds.Load(path)
+	if err != nil {
+		usageError(err, fl.Command.Name)
+	}
+	return blob
+}
+
+// lintReport evaluates p against what `fit info` collected.
+func lintReport(p *policy.Policy, blob *mds.BLOB, info output.Info, strict bool) output.Lint {
+	f := policy.Facts{
+		AAGUID:       info.AAGUID,
+		Extensions:   info.Extensions,
+		Options:      info.Options,
+		PINRetries:   info.PINRetryCount,
+		MinPINLength: info.MinPINLength,
+		MDSLoaded:    blob != nil,
+	}
+	f.Firmware, f.FirmwareSource = lintFirmware(info)
+	if blob != nil {
+		if e, ok := blob.Lookup(info.AAGUID); ok {
+			f.MDS = &e
+		}
+	}
+	report := output.Lint{
+		Header:  output.NewHeader("lint", output.BackendLibfido2),
+		MDS:     f.MDS,
+		Results: nonNil(policy.Evaluate(p, f)),
+	}
+	for _, r := range report.Results {
+		switch r.Status {
+		case policy.Pass:
+			report.Passed++
+		case policy.Fail:
+			report.Failed++
+		default:
+			report.Unknown++
+		}
+	}
+	report.Pass = report.Failed == 0 && (!strict || report.Unknown == 0)
+	return report
+}
+
+// lintFirmware is the version the minFirmware rule checks and where it was
+// read: getInfo firmwareVersion, as info and scan report it, when the key
+// has one, and the CTAPHID device version otherwise.
+func lintFirmware(info output.Info) (string, string) {
+	if info.FirmwareVersion != nil {
+		return strconv.FormatInt(*info.FirmwareVersion, 10), policy.FirmwareGetInfo
+	}
+	if v := firmwareVersion(info); v != "" {
+		return v, policy.FirmwareCTAPHID
+	}
+	return "", ""
+}
+
+// firmwareVersion is the CTAPHID device version, which most keys set to
+// their firmware version, or "" when the transport did not report one.
+func firmwareVersion(info output.Info) string {
+	hid := info.CTAPHID
+	if hid == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d", hid.Major, hid.Minor, hid.Build)
+}
+
+// printLint is the text output of `fit lint`.
+func printLint(r output.Lint) {
+	fmt.Printf("Policy %s on %s (%s), fingerprint %s:\n", r.Policy, r.Device.Label, r.Device.Path, r.Fingerprint)
+	for _, res := range r.Results {
+		line := fmt.Sprintf("  %-7s %-24s want %s", map[string]string{policy.Pass: "PASS", policy.Fail: "FAIL", policy.Unknown: "UNKNOWN"}[res.Status], res.Rule, res.Want)
+		if res.Have != "" {
+			line += ", have " + res.Have
+		}
+		if res.Detail != "" {
+			line += " (" + res.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+	verdict := "PASS"
+	if !r.Pass {
+		verdict = "FAIL"
+	}
+	fmt.Printf("%s: %d passed, %d failed, %d unknown.\n", verdict, r.Passed, r.Failed, r.Unknown)
+}
@@ -4,16 +4,30 @@
 package main
 
 import (
-	"bufio"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 
+	"fit/internal/audit"
+	"fit/internal/chal"
+	"fit/internal/cli"
+	"fit/internal/credlist"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/format"
+	"fit/internal/inventory"
+	"fit/internal/output"
+	"fit/internal/pinentry"
+	"fit/internal/rp"
+
 	"github.com/keys-pub/go-libfido2"
 )
 
@@ -24,314 +38,508 @@ var buildVersion = "dev"
 // main is the entry point for the CLI application.
 func main() {
 	if len(os.Args) < 2 {
-		printUsage()
+		printUsage(os.Stderr)
+		exit(ctaperr.ExitCode(ctaperr.Usage))
+	}
+
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "--help":
+		cmdHelp(os.Args[2:])
+		return
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		msg := fmt.Sprintf("unknown command %q", name)
+		if s := cli.Suggest(name, commandNames()); s != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+		usageError(&cli.UsageError{Msg: msg}, "")
+	}
+	commandName = cmd.Name
+	fl, err := cmd.Parse(os.Args[2:])
+	if err == cli.ErrHelp {
+		cmd.Help(os.Stdout, exeName())
 		return
 	}
+	if err != nil {
+		jsonMode = slices.Contains(os.Args[2:], "--json") || slices.Contains(os.Args[2:], "--output=json")
+		usageError(err, cmd.Name)
+	}
+	outOpts = outputOptions(fl)
+	jsonMode = outOpts.Format == format.JSON || outOpts.Format == format.NDJSON
+	seedRandom(cmd, fl)
+	startTrace(fl)
+	startSession(fl)
+	cmd.Run(fl)
+	exit(0)
+}
 
-	command := os.Args[1]
-	args := os.Args[2:]
-
-	switch command {
-	case "list":
-		cmdList(args)
-	case "auth":
-		cmdAuth(args)
-	case "add-passkey":
-		cmdAddPasskey(args)
-	case "set-pin":
-		cmdSetPIN(args)
-	case "reset":
-		cmdReset(args)
-	case "info":
-		cmdInfo(args)
-	case "version":
-		fmt.Println(buildVersion)
-	default:
-		printUsage()
+// seedRandom applies --seed or FIT_SEED: the random values fit draws become
+// reproducible, and every document carries insecureSeed. Only commands that
+// declare seedFlag honour FIT_SEED, so a seed left in the environment cannot
+// weaken the others.
+func seedRandom(cmd *cli.Command, fl *cli.Values) {
+	if !slices.ContainsFunc(cmd.Flags, func(f cli.Flag) bool { return f.Name == seedFlag.Name }) {
+		return
+	}
+	seed := fl.String("seed")
+	if seed == "" {
+		seed = os.Getenv("FIT_SEED")
 	}
+	if seed == "" {
+		return
+	}
+	chal.Seed(seed)
+	fmt.Fprintf(os.Stderr, "Warning: random values are derived from seed %q and are predictable; use the output as test vectors only.\n", seed)
 }
 
-// printUsage displays the available commands and their usage.
-func printUsage() {
-	exe := os.Args[0]
-	fmt.Printf("Usage: %s <command> [arguments]\n", exe)
-	fmt.Println("\nCommands:")
-	fmt.Println("  list            Lists attached FIDO2 devices.")
-	fmt.Println("  auth --rp RP_ID [--pin PIN] [--cred-id-hex HEX|--cred-index N] [--create] [--device N|--path PATH]")
-	fmt.Println("                Performs a challenge/response (assertion). If --create is set, it will create a transient")
-	fmt.Println("                credential (non-resident) first, then assert using that credential.")
-	fmt.Println("  add-passkey --rp RP_ID [--user USER] [--display NAME] [--resident|--no-resident] [--pin PIN] [--device N|--path PATH]")
-	fmt.Println("                Creates a new passkey (discoverable credential) on a FIDO2 security key.")
-	fmt.Println("  set-pin --new NEW [--old OLD] [--device N|--path PATH]")
-	fmt.Println("                Sets the device PIN (initial if --old omitted, otherwise changes PIN).")
-	fmt.Println("  reset         Performs a factory reset on a FIDO2 device.")
-	fmt.Println("  info [--pin PIN] [--device N|--path PATH]")
-	fmt.Println("                Displays device information / non-destructive diagnostics.")
-	fmt.Println("  version        Prints build version (embedded via ldflags).")
-	fmt.Println("\nGlobal flags:")
-	fmt.Println("  --json          Output machine-readable JSON where applicable.")
+// usageError reports command-line misuse and exits with the usage status.
+func usageError(err error, cmd string) {
+	e := ctaperr.New(ctaperr.Usage, 0, "", err)
+	e.Hint = fmt.Sprintf("Run '%s help' for the list of commands.", exeName())
+	if cmd != "" {
+		e.Hint = fmt.Sprintf("Run '%s help %s' for usage.", exeName(), cmd)
+	}
+	exitWith(e)
 }
 
-// cmdSetPIN sets or changes the device PIN non-interactively.
-func cmdSetPIN(args []string) {
-	newPIN := getStringFlag(args, "--new")
-	oldPIN := getStringFlag(args, "--old")
-	if newPIN == "" {
-		fmt.Println("Usage: set-pin --new NEW [--old OLD] [--device N|--path PATH]")
+// cmdHelp prints the command list, or one command's help.
+func cmdHelp(args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
 		return
 	}
-	if len(newPIN) < 4 {
-		fmt.Println("PIN must be at least 4 characters.")
-		return
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		msg := fmt.Sprintf("no help for unknown command %q", args[0])
+		if s := cli.Suggest(args[0], commandNames()); s != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+		usageError(&cli.UsageError{Msg: msg}, "")
 	}
+	cmd.Help(os.Stdout, exeName())
+}
 
-	dev := getDeviceWithArgs(args)
+// printUsage displays the available commands and the shared flag notes.
+func printUsage(w io.Writer) {
+	exe := exeName()
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n", exe)
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for a command's flags. Flags accept --flag value or --flag=value.\n", exe)
+	fmt.Fprintln(w, "\nPIN sources (first match wins; prompts without echo when a PIN is required):")
+	fmt.Fprintln(w, "  --pin-stdin | --pin-file FILE | --pin-fd N | FIT_PIN env | FIT_ASKPASS/SSH_ASKPASS helper")
+	fmt.Fprintln(w, "  set-pin new PIN: --new-pin-file FILE | --new-pin-fd N | FIT_NEW_PIN env | prompt (confirmed)")
+	fmt.Fprintln(w, "  --pin/--old/--new PIN still work but expose the PIN in ps and shell history.")
+	fmt.Fprintln(w, "\nCredential lists (--allow-cred / --exclude-cred, repeatable):")
+	fmt.Fprintln(w, "  ID is hex, base64url, hex:ID, b64:ID or @FILE (one ID per line).")
+	fmt.Fprintln(w, "  --max-cred-count N   Override the device's maxCredentialCountInList (default from getInfo, else 1).")
+	fmt.Fprintln(w, "  --max-cred-id-len N  Override the device's maxCredentialIdLength (longer IDs are skipped).")
+}
+
+// cmdSetPIN sets or changes the device PIN.
+func cmdSetPIN(fl *cli.Values) {
+	dev := getDeviceWithArgs(fl)
 	if dev == nil {
 		return
 	}
 
-	info, err := dev.Info()
+	info, err := deviceCall(dev, "info", dev.Info)
 	if err != nil {
-		log.Fatalf("Failed to get device info: %v", err)
+		fatal("info", err)
 	}
 	present, pinSet := clientPinStatus(info.Options)
 	if !present {
 		fmt.Println("Device does not implement clientPin.")
 	} else if pinSet {
-		fmt.Println("Device reports PIN is set (clientPin=true). If changing, provide --old.")
+		fmt.Println("Device reports PIN is set (clientPin=true). The current PIN is required to change it.")
 	} else {
-		fmt.Println("Device reports PIN not set yet (clientPin=false). Set initial PIN with --new.")
+		fmt.Println("Device reports PIN not set yet (clientPin=false). Setting initial PIN.")
 	}
 
+	// The current PIN is only read for a key that has one, so a FIT_PIN left
+	// in the environment is not sent as the "current" PIN of a new key.
+	var oldPIN *pinentry.Secret
+	if pinSet {
+		oldOpts := pinOptions(fl)
+		if v := fl.String("old"); v != "" {
+			warnArgvSecret("--old")
+			oldOpts.Value = v
+		}
+		oldOpts.Prompt = "Enter current PIN: "
+		oldPIN, err = pinentry.Read(oldOpts)
+		if err != nil {
+			exitWith(pinError(err))
+		}
+		defer oldPIN.Zero()
+	} else if fl.Has("old") || fl.Has("pin") {
+		usageError(errors.New("the key has no PIN yet, so --old and --pin do not apply; give the new PIN with --new, --new-pin-file, --new-pin-fd or FIT_NEW_PIN"), "set-pin")
+	}
+
+	newPIN := readNewPIN(fl, 4)
+	defer newPIN.Zero()
+
 	action := "Setting initial PIN"
-	if oldPIN != "" {
+	if !oldPIN.Empty() {
 		action = "Changing PIN"
 	}
 	fmt.Println(action + "... You may need to touch your device.")
 
-	if err := dev.SetPIN(newPIN, oldPIN); err != nil {
-		msg := strings.ToLower(err.Error())
-		if oldPIN == "" && (strings.Contains(msg, "pin required") || strings.Contains(msg, "missing parameter")) {
-			fmt.Println("Device reports a PIN already exists. Provide --old to change it.")
-		}
-		if strings.Contains(msg, "mismatch") || strings.Contains(msg, "wrong") {
-			fmt.Println("Old PIN incorrect (remaining retries may decrease).")
-		}
-		if strings.Contains(msg, "policy") || strings.Contains(msg, "invalid") || strings.Contains(msg, "too short") || strings.Contains(msg, "length") {
-			fmt.Println("PIN rejected by policy (length/complexity). Try a longer PIN (>=4, preferably 6+ digits).")
+	err = deviceOp(dev, "set-pin", func() error { return dev.SetPIN(newPIN.String(), oldPIN.String()) })
+	recordAudit(openedDevice(dev), audit.Entry{Command: "set-pin"}, err)
+	if err != nil {
+		e := withRetries(dev, classify("set-pin", err))
+		if oldPIN.Empty() && (e.Code == ctaperr.PINRequired || e.CTAPStatus == 0x14) {
+			// Without a current PIN, changePIN is sent as setPIN and the
+			// device rejects it because a PIN already exists.
+			e.Code, e.ExitCode = ctaperr.PINRequired, ctaperr.ExitCode(ctaperr.PINRequired)
+			e.Hint = "Device reports a PIN already exists. Provide the current PIN (--pin-stdin, --pin-file, --pin-fd, FIT_PIN or --old)."
 		}
-		log.Fatalf("Failed to set PIN: %v", err)
+		exitWith(e)
 	}
 
 	fmt.Println("PIN updated successfully.")
 }
 
+// readNewPIN reads a new PIN, with confirmation when prompting, from --new,
+// --new-pin-file, --new-pin-fd, FIT_NEW_PIN or the terminal, and enforces a
+// minimum length.
+func readNewPIN(fl *cli.Values, minLen int) *pinentry.Secret {
+	newFD := -1
+	if n, ok := fl.Int("new-pin-fd"); ok {
+		newFD = n
+	}
+	newOpts := pinentry.Options{
+		Value:   fl.String("new"),
+		File:    fl.String("new-pin-file"),
+		FD:      newFD,
+		EnvVar:  "FIT_NEW_PIN",
+		Prompt:  "Enter new PIN: ",
+		Confirm: true,
+	}
+	if newOpts.Value != "" {
+		warnArgvSecret("--new")
+	}
+	newPIN, err := pinentry.Read(newOpts)
+	if err != nil {
+		exitWith(pinError(err))
+	}
+	if newPIN.Len() < minLen {
+		newPIN.Zero()
+		exitWith(ctaperr.Newf(ctaperr.PINPolicyViolation, commandName, "new PIN must be at least %d characters", minLen))
+	}
+	return newPIN
+}
+
+// authParams are the parsed flags of `fit auth`.
+type authParams struct {
+	rpID      string
+	create    bool
+	credID    []byte // --cred-id-hex
+	credIndex int
+	allowIDs  [][]byte
+	pin       *pinentry.Secret
+	limits    credlist.Limits
+}
+
 // cmdAuth performs a FIDO2 assertion (challenge/response).
-func cmdAuth(args []string) {
-	rpID := getStringFlag(args, "--rp")
-	if rpID == "" {
-		fmt.Println("Usage: auth --rp RP_ID [--pin PIN] [--cred-id-hex HEX|--cred-index N] [--create] [--device N|--path PATH]")
-		return
+func cmdAuth(fl *cli.Values) {
+	p := authParams{
+		rpID:     fl.String("rp"),
+		create:   fl.Bool("create"),
+		allowIDs: parseCredFlags(fl, "allow-cred"),
+		limits:   credListLimits(fl),
+	}
+	if credHex := fl.String("cred-id-hex"); credHex != "" {
+		b, err := hex.DecodeString(strings.TrimSpace(credHex))
+		if err != nil {
+			usageError(fmt.Errorf("invalid --cred-id-hex: %v", err), "auth")
+		}
+		p.credID = b
 	}
-	pin := getStringFlag(args, "--pin")
-	create := hasFlag(args, "--create")
-	credHex := getStringFlag(args, "--cred-id-hex")
-	credIndex, credIndexSet := getIntFlag(args, "--cred-index")
-	// libfido2 path only
+	p.credIndex, _ = fl.Int("cred-index")
+	// A PIN is needed to create credentials or enumerate resident ones.
+	p.pin = readPIN(fl, p.create || (p.credID == nil && len(p.allowIDs) == 0))
+	defer p.pin.Zero()
 
-	dev := getDeviceWithArgs(args)
-	if dev == nil {
+	if fl.Bool("all-devices") {
+		runAll(fl, func(dev *libfido2.Device, note notef) (any, error) { return runAuth(dev, p, note) }, printAssertion)
 		return
 	}
+	dev := getDeviceWithArgs(fl)
+	out, err := runAuth(dev, p, progressf)
+	if err != nil {
+		exitWith(classify("auth", err))
+	}
+	if outOpts.Structured() {
+		emit(out)
+	} else {
+		printAssertion(out)
+	}
+}
 
+// runAuth picks the credential on dev (given ID, allow list pre-flight,
+// transient creation or resident index) and asserts with it.
+func runAuth(dev *libfido2.Device, p authParams, note notef) (output.Assertion, error) {
 	// Step 1: determine credential ID(s)
-	var credID []byte
-	if credHex != "" {
-		b, err := hex.DecodeString(strings.TrimSpace(credHex))
+	credID := p.credID
+	var userName string // known for resident credentials
+	switch {
+	case credID != nil:
+		// Given by --cred-id-hex.
+	case len(p.allowIDs) > 0:
+		// Pre-flight the allow list in device-sized batches to find a credential this key holds
+		found, err := probeCredentials(dev, p.rpID, p.allowIDs, p.pin, p.limits)
 		if err != nil {
-			log.Fatalf("Invalid --cred-id-hex: %v", err)
+			return output.Assertion{}, withRetries(dev, classify("credential pre-flight", err))
 		}
-		credID = b
-	} else if create {
-		// Create a transient (non-resident) credential
-		if pin == "" {
-			fmt.Println("--create requires --pin to be provided.")
-			return
+		if found == nil {
+			return output.Assertion{}, ctaperr.Newf(ctaperr.NoCredentials, "auth", "none of the %d --allow-cred credential(s) are present on this device", len(p.allowIDs))
 		}
-		cdh := libfido2.RandBytes(32)
-		userID := libfido2.RandBytes(32)
-		attest, err := dev.MakeCredential(
-			cdh,
-			libfido2.RelyingParty{ID: rpID, Name: rpID},
-			libfido2.User{ID: userID, Name: "fit-user"},
-			libfido2.ES256,
-			pin,
-			&libfido2.MakeCredentialOpts{
-				// Explicitly avoid resident keys by setting RK to False
-				RK: libfido2.False,
-			},
-		)
+		credID = found
+		note("Using allowed credential %s\n", hex.EncodeToString(credID))
+	case p.create:
+		// Create a transient (non-resident) credential
+		if p.pin.Empty() {
+			return output.Assertion{}, ctaperr.Newf(ctaperr.PINRequired, "auth", "--create requires a PIN")
+		}
+		cdh := chal.Bytes(32)
+		userID := chal.Bytes(32)
+		attest, err := deviceCall(dev, "makeCredential", func() (*libfido2.Attestation, error) {
+			return dev.MakeCredential(
+				cdh,
+				libfido2.RelyingParty{ID: p.rpID, Name: p.rpID},
+				libfido2.User{ID: userID, Name: "fit-user"},
+				libfido2.ES256,
+				p.pin.String(),
+				&libfido2.MakeCredentialOpts{
+					// Explicitly avoid resident keys by setting RK to False
+					RK: libfido2.False,
+				},
+			)
+		})
 		if err != nil {
-			log.Fatalf("MakeCredential failed: %v", err)
+			return output.Assertion{}, withRetries(dev, classify("makeCredential", err))
 		}
 		credID = attest.CredentialID
-		fmt.Printf("Created transient credential: ID=%s Type=%s\n", hex.EncodeToString(attest.CredentialID), attest.CredentialType.String())
-	} else {
+		note("Created transient credential: ID=%s Type=%s\n", hex.EncodeToString(attest.CredentialID), attest.CredentialType.String())
+	default:
 		// Use an existing resident credential for this RP
-		creds, err := dev.Credentials(rpID, pin)
+		creds, err := deviceCall(dev, "credentials", func() ([]*libfido2.Credential, error) { return dev.Credentials(p.rpID, p.pin.String()) })
 		if err != nil {
-			log.Fatalf("Credentials(%s) failed: %v", rpID, err)
+			return output.Assertion{}, withRetries(dev, classify("credentials", err))
 		}
 		if len(creds) == 0 {
-			fmt.Println("No resident credentials found for RP.")
-			return
-		}
-		pick := 0
-		if credIndexSet {
-			pick = credIndex
+			return output.Assertion{}, ctaperr.Newf(ctaperr.NoCredentials, "auth", "no resident credentials found for %s", p.rpID)
 		}
-		if pick < 0 || pick >= len(creds) {
-			log.Fatalf("--cred-index out of range (have %d)", len(creds))
+		if p.credIndex < 0 || p.credIndex >= len(creds) {
+			return output.Assertion{}, ctaperr.Newf(ctaperr.Usage, "auth", "--cred-index out of range (have %d)", len(creds))
 		}
-		credID = creds[pick].ID
-		fmt.Printf("Using resident credential index %d (len=%d)\n", pick, len(credID))
+		credID = creds[p.credIndex].ID
+		userName = creds[p.credIndex].User.Name
+		note("Using resident credential index %d (len=%d)\n", p.credIndex, len(credID))
 	}
 
 	// Step 2: perform assertion using the determined credential ID
-	cdh := libfido2.RandBytes(32)
-	assertion, err := dev.Assertion(
-		rpID,
-		cdh,
-		[][]byte{credID},
-		pin,
-		&libfido2.AssertionOpts{},
-	)
+	cdh := chal.Bytes(32)
+	assertion, err := deviceCall(dev, "getAssertion", func() (*libfido2.Assertion, error) {
+		return dev.Assertion(p.rpID, cdh, [][]byte{credID}, p.pin.String(), &libfido2.AssertionOpts{})
+	})
 	if err != nil {
-		log.Fatalf("Assertion failed: %v", err)
-	}
+		return output.Assertion{}, withRetries(dev, classify("getAssertion", err))
+	}
+	authData := rp.UnwrapCBORBytes(assertion.AuthDataCBOR)
+	// A key may omit the credential ID when the allow list has one entry.
+	usedID := assertion.CredentialID
+	if len(usedID) == 0 {
+		usedID = credID
+	}
+	out := output.Assertion{
+		Header:          output.NewHeader("auth", output.BackendLibfido2),
+		RP:              p.rpID,
+		CredentialID:    output.B64(usedID),
+		CredentialIDHex: output.Hex(usedID),
+		Signature:       output.B64(assertion.Sig),
+		ChallengeB64:    output.B64(cdh),
+		ChallengeHex:    output.Hex(cdh),
+		HMACSecret:      output.B64(assertion.HMACSecret),
+		// libfido2 returns authenticator data CBOR-wrapped; emit the raw bytes.
+		AuthenticatorData: output.B64(authData),
+	}
+	if ad, err := rp.ParseAuthenticatorData(authData); err == nil {
+		out.SignCount = &ad.SignCount
+		// Transient --create credentials are not worth keeping.
+		if !p.create && len(usedID) > 0 {
+			out.CounterRegression = recordAssertion(p.rpID, usedID, userName, ad.SignCount, func() devsel.Device { return openedDevice(dev) })
+		}
+	}
+	return out, nil
+}
 
-	if hasFlag(args, "--json") {
-		out := map[string]any{
-			"backend":      "libfido2",
-			"rp":           rpID,
-			"credentialID": hex.EncodeToString(assertion.CredentialID),
-			"signature":    hex.EncodeToString(assertion.Sig),
-			"challengeHex": hex.EncodeToString(cdh),
-			"challengeB64": base64.RawURLEncoding.EncodeToString(cdh),
-		}
-		if len(assertion.HMACSecret) > 0 {
-			out["hmacSecret"] = hex.EncodeToString(assertion.HMACSecret)
-		}
-		if len(assertion.AuthDataCBOR) > 0 {
-			out["authDataCBOR"] = hex.EncodeToString(assertion.AuthDataCBOR)
-		}
-		writeJSON(out)
-	} else {
-		fmt.Println("Assertion result:")
-		fmt.Printf("  CredentialID: %s\n", hex.EncodeToString(assertion.CredentialID))
-		fmt.Printf("  Sig:          %s\n", hex.EncodeToString(assertion.Sig))
-		fmt.Printf("  Challenge(hex): %s\n", hex.EncodeToString(cdh))
-		fmt.Printf("  Challenge(b64): %s\n", base64.RawURLEncoding.EncodeToString(cdh))
-		if len(assertion.HMACSecret) > 0 {
-			fmt.Printf("  HMACSecret:   %s\n", hex.EncodeToString(assertion.HMACSecret))
-		}
-		if len(assertion.AuthDataCBOR) > 0 {
-			fmt.Printf("  AuthDataCBOR: %s\n", hex.EncodeToString(assertion.AuthDataCBOR))
-		}
+// printAssertion is the text output of `fit auth`.
+func printAssertion(doc any) {
+	out := doc.(output.Assertion)
+	fmt.Println("Assertion result:")
+	fmt.Printf("  CredentialID: %s\n", out.CredentialIDHex)
+	fmt.Printf("  Sig:          %s\n", b64Hex(out.Signature))
+	fmt.Printf("  Challenge(hex): %s\n", out.ChallengeHex)
+	fmt.Printf("  Challenge(b64): %s\n", out.ChallengeB64)
+	if out.HMACSecret != "" {
+		fmt.Printf("  HMACSecret:   %s\n", b64Hex(out.HMACSecret))
+	}
+	if out.AuthenticatorData != "" {
+		fmt.Printf("  AuthData:     %s\n", b64Hex(out.AuthenticatorData))
 	}
+	if out.SignCount != nil {
+		fmt.Printf("  SignCount:    %d\n", *out.SignCount)
+	}
+	if out.CounterRegression {
+		fmt.Println("  WARNING:      signature counter did not advance past the inventory's; possible cloned authenticator.")
+	}
+}
+
+// passkeyParams are the parsed flags of `fit add-passkey`.
+type passkeyParams struct {
+	rpID       string
+	userName   string
+	display    string
+	resident   bool
+	excludeIDs [][]byte
+	pin        *pinentry.Secret
+	limits     credlist.Limits
 }
 
 // cmdAddPasskey creates a new passkey for the given RP (resident credential by default).
-func cmdAddPasskey(args []string) {
-	rpID := getStringFlag(args, "--rp")
-	if rpID == "" {
-		fmt.Println("Usage: add-passkey --rp RP_ID [--user USER] [--display NAME] [--resident|--no-resident] [--pin PIN] [--device N|--path PATH]")
-		return
+func cmdAddPasskey(fl *cli.Values) {
+	p := passkeyParams{
+		rpID:       fl.String("rp"),
+		userName:   fl.String("user"),
+		resident:   !fl.Bool("no-resident"),
+		excludeIDs: parseCredFlags(fl, "exclude-cred"),
+		limits:     credListLimits(fl),
 	}
-	userName := getStringFlag(args, "--user")
-	if userName == "" {
-		userName = "fit-user"
+	if p.userName == "" {
+		p.userName = "fit-user"
 	}
-	userDisplay := getStringFlag(args, "--display")
-	if userDisplay == "" {
-		userDisplay = userName
+	if p.display = fl.String("display"); p.display == "" {
+		p.display = p.userName
 	}
-	resident := true
-	if hasFlag(args, "--no-resident") {
-		resident = false
+	p.pin = readPIN(fl, p.resident)
+	defer p.pin.Zero()
+	if p.resident && p.pin.Empty() {
+		exitWith(ctaperr.Newf(ctaperr.PINRequired, "add-passkey", "resident passkey creation requires a PIN"))
 	}
-	pin := getStringFlag(args, "--pin")
-
-	// libfido2 only
 
-	// libfido2: create resident or non-resident on a security key
-	dev := getDeviceWithArgs(args)
-	if dev == nil {
-		return
-	}
-	if resident && pin == "" {
-		fmt.Println("Resident passkey creation requires --pin for libfido2.")
+	if fl.Bool("all-devices") {
+		runAll(fl, func(dev *libfido2.Device, note notef) (any, error) { return runAddPasskey(dev, p) }, printPasskey)
 		return
 	}
-	cdh := libfido2.RandBytes(32)
-	userID := libfido2.RandBytes(32)
-	att, err := dev.MakeCredential(
-		cdh,
-		libfido2.RelyingParty{ID: rpID, Name: rpID},
-		libfido2.User{ID: userID, Name: userName},
-		libfido2.ES256,
-		pin,
-		&libfido2.MakeCredentialOpts{RK: func() libfido2.OptionValue {
-			if resident {
-				return libfido2.True
-			}
-			return libfido2.False
-		}()},
-	)
+	dev := getDeviceWithArgs(fl)
+	out, err := runAddPasskey(dev, p)
 	if err != nil {
-		log.Fatalf("MakeCredential failed: %v", err)
-	}
-	if hasFlag(args, "--json") {
-		writeJSON(map[string]any{
-			"backend":      "libfido2",
-			"rp":           rpID,
-			"user":         userName,
-			"resident":     resident,
-			"credentialID": hex.EncodeToString(att.CredentialID),
-			"challengeHex": hex.EncodeToString(cdh),
-			"challengeB64": base64.RawURLEncoding.EncodeToString(cdh),
-		})
+		exitWith(classify("makeCredential", err))
+	}
+	if outOpts.Structured() {
+		emit(out)
 	} else {
-		fmt.Println("Created passkey:")
-		fmt.Printf("  RP:            %s\n", rpID)
-		fmt.Printf("  User:          %s\n", userName)
-		fmt.Printf("  ResidentKey:   %v\n", resident)
-		fmt.Printf("  CredentialID:  %s\n", hex.EncodeToString(att.CredentialID))
-		fmt.Printf("  Challenge(hex): %s\n", hex.EncodeToString(cdh))
-		fmt.Printf("  Challenge(b64): %s\n", base64.RawURLEncoding.EncodeToString(cdh))
+		printPasskey(out)
 	}
 }
 
-// cmdInfo runs a non-destructive diagnostic against the authenticator.
-func cmdInfo(args []string) {
-	// Extract optional --pin from args (kept simple)
-	var pin string
-	for i := 0; i < len(args)-1; i++ {
-		if args[i] == "--pin" {
-			pin = args[i+1]
-			break
-		}
+// runAddPasskey checks the exclude list on dev and creates the credential.
+func runAddPasskey(dev *libfido2.Device, p passkeyParams) (output.Passkey, error) {
+	if err := checkExcluded(dev, p.rpID, p.excludeIDs, p.pin, p.limits); err != nil {
+		return output.Passkey{}, classify("makeCredential", err)
+	}
+	cdh := chal.Bytes(32)
+	userID := chal.Bytes(32)
+	rk := libfido2.False
+	if p.resident {
+		rk = libfido2.True
 	}
+	att, err := deviceCall(dev, "makeCredential", func() (*libfido2.Attestation, error) {
+		return dev.MakeCredential(
+			cdh,
+			libfido2.RelyingParty{ID: p.rpID, Name: p.rpID},
+			libfido2.User{ID: userID, Name: p.userName, DisplayName: p.display},
+			libfido2.ES256,
+			p.pin.String(),
+			&libfido2.MakeCredentialOpts{RK: rk},
+		)
+	})
+	e := audit.Entry{Command: "add-passkey", RP: p.rpID, User: p.userName}
+	if err == nil {
+		e.CredentialID = output.B64(att.CredentialID)
+	}
+	d := openedDevice(dev)
+	recordAudit(d, e, err)
+	if err != nil {
+		return output.Passkey{}, withRetries(dev, classify("makeCredential", err))
+	}
+	recordCreated(d, inventory.Credential{
+		RP:       p.rpID,
+		User:     p.userName,
+		UserID:   output.B64(userID),
+		Resident: p.resident,
+		Source:   "add-passkey",
+	}, att)
+	return output.Passkey{
+		Header:          output.NewHeader("add-passkey", output.BackendLibfido2),
+		RP:              p.rpID,
+		User:            p.userName,
+		Resident:        p.resident,
+		CredentialID:    output.B64(att.CredentialID),
+		CredentialIDHex: output.Hex(att.CredentialID),
+		ChallengeB64:    output.B64(cdh),
+		ChallengeHex:    output.Hex(cdh),
+	}, nil
+}
 
-	dev := getDeviceWithArgs(args)
-	if dev == nil {
+// printPasskey is the text output of `fit add-passkey`.
+func printPasskey(doc any) {
+	out := doc.(output.Passkey)
+	fmt.Println("Created passkey:")
+	fmt.Printf("  RP:            %s\n", out.RP)
+	fmt.Printf("  User:          %s\n", out.User)
+	fmt.Printf("  ResidentKey:   %v\n", out.Resident)
+	fmt.Printf("  CredentialID:  %s\n", out.CredentialIDHex)
+	fmt.Printf("  Challenge(hex): %s\n", out.ChallengeHex)
+	fmt.Printf("  Challenge(b64): %s\n", out.ChallengeB64)
+}
+
+// cmdInfo runs a non-destructive diagnostic against the authenticator.
+func cmdInfo(fl *cli.Values) {
+	// Optional PIN enables resident key statistics
+	pin := readPIN(fl, false)
+	defer pin.Zero()
+
+	if fl.Bool("all-devices") {
+		runAll(fl, func(dev *libfido2.Device, note notef) (any, error) { return runInfo(dev, pin) }, printInfo)
 		return
 	}
+	dev := getDeviceWithArgs(fl)
+	progressf("Fetching device information...\n")
+	out, err := runInfo(dev, pin)
+	if err != nil {
+		exitWith(classify("info", err))
+	}
+	if outOpts.Structured() {
+		emit(out)
+	} else {
+		printInfo(out)
+		fmt.Println("\nTest completed.")
+	}
+}
 
-	fmt.Println("Fetching device information...")
-	info, err := dev.Info()
+// runInfo collects getInfo, the CTAPHID version, the PIN retry counter and,
+// with a PIN, the resident key counts. Only getInfo failing is an error.
+func runInfo(dev *libfido2.Device, pin *pinentry.Secret) (output.Info, error) {
+	info, err := deviceCall(dev, "info", dev.Info)
 	if err != nil {
-		log.Fatalf("Info failed: %v", err)
+		return output.Info{}, classify("info", err)
 	}
 
 	typ, err := dev.Type()
@@ -348,87 +556,110 @@ func cmdInfo(args []string) {
 		log.Printf("CTAPHIDInfo() error: %v", err)
 	}
 
-	if hasFlag(args, "--json") {
-		out := map[string]any{
-			"backend":    "libfido2",
-			"type":       typ,
-			"isFIDO2":    isF2,
-			"versions":   info.Versions,
-			"extensions": info.Extensions,
-		}
-		if hid != nil {
-			out["ctapHID"] = map[string]any{"major": hid.Major, "minor": hid.Minor, "build": hid.Build, "flags": hid.Flags}
-		}
-		opts := map[string]string{}
-		for _, o := range info.Options {
-			opts[o.Name] = string(o.Value)
-		}
-		out["options"] = opts
-		if rc, err := dev.RetryCount(); err == nil {
-			out["pinRetryCount"] = rc
-		}
-		if pin != "" {
-			if ci, err := dev.CredentialsInfo(pin); err == nil && ci != nil {
-				out["residentKeys"] = map[string]int64{"existing": ci.RKExisting, "remaining": ci.RKRemaining}
-			}
+	out := output.Info{
+		Header:     output.NewHeader("info", output.BackendLibfido2),
+		Type:       string(typ),
+		IsFIDO2:    isF2,
+		Versions:   nonNil(info.Versions),
+		Extensions: nonNil(info.Extensions),
+		AAGUID:     formatAAGUID(info.AAGUID),
+		Options:    map[string]string{},
+	}
+	if hid != nil {
+		out.CTAPHID = &output.CTAPHID{Major: int(hid.Major), Minor: int(hid.Minor), Build: int(hid.Build), Flags: int(hid.Flags)}
+	}
+	for _, o := range info.Options {
+		out.Options[o.Name] = string(o.Value)
+	}
+	if ext, err := extendedInfo(dev); err == nil {
+		out.MaxCredentialCountInList = ext.MaxCredentialCountInList
+		out.MaxCredentialIDLength = ext.MaxCredentialIDLength
+		out.MinPINLength = &ext.MinPINLength
+		if v := ext.FirmwareVersion; v != nil {
+			fw := int64(*v)
+			out.FirmwareVersion = &fw
 		}
-		writeJSON(out)
 	} else {
-		fmt.Println("\nDevice summary:")
-		fmt.Printf("  Type: %s  IsFIDO2: %v\n", typ, isF2)
-		if hid != nil {
-			fmt.Printf("  CTAP HID: v%d.%d build %d flags=0x%02x\n", hid.Major, hid.Minor, hid.Build, hid.Flags)
-		}
-		if len(info.Versions) > 0 {
-			fmt.Printf("  Versions: %s\n", strings.Join(info.Versions, ", "))
-		}
-		if len(info.Extensions) > 0 {
-			fmt.Printf("  Extensions: %s\n", strings.Join(info.Extensions, ", "))
-		}
-		if len(info.Options) > 0 {
-			fmt.Printf("  Options:\n")
-			for _, o := range info.Options {
-				fmt.Printf("    - %s = %s\n", o.Name, o.Value)
-			}
-		}
-		if rc, err := dev.RetryCount(); err == nil {
-			fmt.Printf("  PIN Retry Count: %d\n", rc)
+		log.Printf("getInfo (extended fields) error: %v", err)
+	}
+	if rc, err := dev.RetryCount(); err == nil {
+		out.PINRetryCount = &rc
+	}
+	if !pin.Empty() {
+		ci, err := deviceCall(dev, "credentialsInfo", func() (*libfido2.CredentialsInfo, error) { return dev.CredentialsInfo(pin.String()) })
+		if err == nil && ci != nil {
+			out.ResidentKeys = &output.ResidentKeys{Existing: ci.RKExisting, Remaining: ci.RKRemaining}
+		} else if err != nil {
+			log.Printf("CredentialsInfo() error: %v", err)
 		}
-		if pin != "" {
-			if ci, err := dev.CredentialsInfo(pin); err == nil && ci != nil {
-				fmt.Printf("  Resident Keys: existing=%d remaining=%d\n", ci.RKExisting, ci.RKRemaining)
-			} else if err != nil {
-				log.Printf("CredentialsInfo() error: %v", err)
-			}
+	}
+	return out, nil
+}
+
+// printInfo is the text output of `fit info`.
+func printInfo(doc any) {
+	out := doc.(output.Info)
+	fmt.Println("\nDevice summary:")
+	fmt.Printf("  Type: %s  IsFIDO2: %v\n", out.Type, out.IsFIDO2)
+	if hid := out.CTAPHID; hid != nil {
+		fmt.Printf("  CTAP HID: v%d.%d build %d flags=0x%02x\n", hid.Major, hid.Minor, hid.Build, hid.Flags)
+	}
+	if len(out.Versions) > 0 {
+		fmt.Printf("  Versions: %s\n", strings.Join(out.Versions, ", "))
+	}
+	if out.AAGUID != "" {
+		fmt.Printf("  AAGUID: %s\n", out.AAGUID)
+	}
+	if len(out.Extensions) > 0 {
+		fmt.Printf("  Extensions: %s\n", strings.Join(out.Extensions, ", "))
+	}
+	if len(out.Options) > 0 {
+		fmt.Printf("  Options:\n")
+		for _, name := range slices.Sorted(maps.Keys(out.Options)) {
+			fmt.Printf("    - %s = %s\n", name, out.Options[name])
 		}
-		fmt.Println("\nTest completed.")
 	}
+	if out.FirmwareVersion != nil {
+		fmt.Printf("  Firmware Version: %d (0x%x)\n", *out.FirmwareVersion, *out.FirmwareVersion)
+	}
+	if out.MinPINLength != nil {
+		fmt.Printf("  Min PIN Length: %d\n", *out.MinPINLength)
+	}
+	if out.MaxCredentialCountInList != nil || out.MaxCredentialIDLength != nil {
+		fmt.Printf("  Credential lists: max %s IDs of up to %s bytes\n", intOr(out.MaxCredentialCountInList, "?"), intOr(out.MaxCredentialIDLength, "?"))
+	}
+	if out.PINRetryCount != nil {
+		fmt.Printf("  PIN Retry Count: %d\n", *out.PINRetryCount)
+	}
+	if rk := out.ResidentKeys; rk != nil {
+		fmt.Printf("  Resident Keys: existing=%d remaining=%d\n", rk.Existing, rk.Remaining)
+	}
+}
+
+// intOr formats *p, or def when p is nil.
+func intOr(p *int, def string) string {
+	if p == nil {
+		return def
+	}
+	return fmt.Sprint(*p)
 }
 
 // cmdList lists available FIDO devices.
-func cmdList(args []string) {
+func cmdList(fl *cli.Values) {
+	if fl.Bool("all-devices") {
+		runAll(fl, func(dev *libfido2.Device, note notef) (any, error) { return runInfo(dev, nil) }, printInfo)
+		return
+	}
 	locs, err := libfido2.DeviceLocations()
 	if err != nil {
-		log.Fatalf("Failed to get device locations: %v", err)
+		fatal("list", err)
 	}
-	if hasFlag(args, "--json") {
-		items := make([]map[string]any, 0, len(locs))
+	if outOpts.Structured() {
+		out := output.List{Header: output.NewHeader("list", output.BackendLibfido2), Devices: []output.Device{}}
 		for i, loc := range locs {
-			manu := strings.TrimSpace(loc.Manufacturer)
-			prod := strings.TrimSpace(loc.Product)
-			label := strings.TrimSpace(strings.Join([]string{manu, prod}, " "))
-			if label == "" {
-				label = "Unknown device"
-			}
-			items = append(items, map[string]any{
-				"index": i,
-				"label": label,
-				"vid":   uint16(loc.VendorID),
-				"pid":   uint16(loc.ProductID),
-				"path":  loc.Path,
-			})
+			out.Devices = append(out.Devices, listEntry(i, loc))
 		}
-		writeJSON(map[string]any{"backend": "libfido2", "devices": items})
+		emit(out)
 	} else {
 		if len(locs) == 0 {
 			fmt.Println("No FIDO2 devices found.")
@@ -436,189 +667,92 @@ func cmdList(args []string) {
 		}
 		fmt.Println("Detected FIDO devices:")
 		for i, loc := range locs {
-			manu := strings.TrimSpace(loc.Manufacturer)
-			prod := strings.TrimSpace(loc.Product)
-			label := strings.TrimSpace(strings.Join([]string{manu, prod}, " "))
-			if label == "" {
-				label = "Unknown device"
-			}
-			fmt.Printf("  [%d] %s  VID:PID=%04x:%04x  Path=%s\n", i, label, uint16(loc.VendorID), uint16(loc.ProductID), loc.Path)
+			fmt.Printf("  [%d] %s  VID:PID=%04x:%04x  Path=%s\n", i, deviceLabel(loc), uint16(loc.VendorID), uint16(loc.ProductID), loc.Path)
 		}
 	}
 }
 
-// cmdInit initializes the FIDO2 library.
-// cmdReset performs a factory reset on a FIDO2 device.
-func cmdReset(args []string) {
-	fmt.Println("WARNING: This will perform a factory reset on a FIDO2 device.")
-	fmt.Println("This is a destructive and irreversible action that will wipe all credentials.")
-	fmt.Print("Are you sure you want to proceed? (yes/no): ")
-
-	reader := bufio.NewReader(os.Stdin)
-	confirmation, _ := reader.ReadString('\n')
-	if strings.TrimSpace(strings.ToLower(confirmation)) != "yes" {
-		fmt.Println("Aborting reset.")
-		return
-	}
-
-	dev := getDeviceWithArgs(args)
-	if dev == nil {
-		return
-	}
-
-	fmt.Println("Performing device reset. You may need to touch your device now.")
-	if err := dev.Reset(); err != nil {
-		log.Fatalf("Failed to reset device: %v", err)
+// listEntry describes the i'th enumerated device.
+func listEntry(i int, loc *libfido2.DeviceLocation) output.Device {
+	return output.Device{
+		Index: i,
+		Label: deviceLabel(loc),
+		VID:   uint16(loc.VendorID),
+		PID:   uint16(loc.ProductID),
+		Path:  loc.Path,
 	}
+}
 
-	fmt.Println("Device has been successfully reset.")
+// deviceEntry describes loc with its index in the current enumeration.
+func deviceEntry(loc *libfido2.DeviceLocation) output.Device {
+	locs, _ := libfido2.DeviceLocations()
+	i := slices.IndexFunc(locs, func(l *libfido2.DeviceLocation) bool { return l.Path == loc.Path })
+	return listEntry(i, loc)
 }
 
 // cmdChangePIN changes the PIN for a FIDO2 device.
 // removed interactive init and change-pin; use set-pin instead
 
-// getDeviceWithArgs tries to select a device using optional args:
-//
-//	--device N  : select by index
-//	--path PATH : select by device path
-//
-// If not provided, auto-selects when only one device exists, otherwise prompts.
-func getDeviceWithArgs(args []string) *libfido2.Device {
-	locs, err := libfido2.DeviceLocations()
-	if err != nil {
-		log.Fatalf("Failed to get device locations: %v", err)
-	}
-	if len(locs) == 0 {
-		log.Fatalf("No FIDO2 devices found.")
-	}
-
-	// Attempt non-interactive selectors first.
-	idx, path, ok := parseDeviceSelectors(args)
-	if ok {
-		if path != "" {
-			dev, err := libfido2.NewDevice(path)
-			if err != nil {
-				log.Fatalf("Failed to open device: %v", err)
-			}
-			return dev
-		}
-		if idx != nil {
-			if *idx < 0 || *idx >= len(locs) {
-				log.Fatalf("Invalid device index: %d", *idx)
-			}
-			dev, err := libfido2.NewDevice(locs[*idx].Path)
-			if err != nil {
-				log.Fatalf("Failed to open device: %v", err)
-			}
-			return dev
-		}
-	}
-
-	// Auto-select if there is exactly one device.
-	if len(locs) == 1 {
-		dev, err := libfido2.NewDevice(locs[0].Path)
-		if err != nil {
-			log.Fatalf("Failed to open device: %v", err)
-		}
-		return dev
-	}
-
-	// Fallback to interactive selection.
-	fmt.Println("Found FIDO2 devices:")
-	for i, loc := range locs {
-		label := strings.TrimSpace(strings.Join([]string{loc.Manufacturer, loc.Product}, " "))
-		if label == "" {
-			label = "Unknown device"
-		}
-		fmt.Printf("  [%d] %s (Path: %s)\n", i, label, loc.Path)
-	}
-	fmt.Print("Select a device (enter number): ")
-	var index int
-	_, err = fmt.Scanln(&index)
-	if err != nil || index < 0 || index >= len(locs) {
-		log.Fatalf("Invalid selection: %v", err)
-	}
-	dev, err := libfido2.NewDevice(locs[index].Path)
+// getDeviceWithArgs opens the device chosen by the selector flags (see
+// selectDevice).
+func getDeviceWithArgs(fl *cli.Values) *libfido2.Device {
+	loc, timeout := selectDevice(fl)
+	opTimeout = timeout
+	dev, err := openDevice(loc)
 	if err != nil {
-		log.Fatalf("Failed to open device: %v", err)
+		exitWith(ctaperr.New(ctaperr.NoDevice, 0, "open", err))
 	}
 	return dev
 }
 
-// parseDeviceSelectors extracts --device N or --path PATH from args.
-func parseDeviceSelectors(args []string) (idx *int, path string, ok bool) {
-	for i := 0; i < len(args); i++ {
-		a := args[i]
-		switch a {
-		case "--device":
-			if i+1 < len(args) {
-				var v int
-				if _, err := fmt.Sscanf(args[i+1], "%d", &v); err == nil {
-					idx = &v
-					ok = true
-				}
-				i++
-			}
-		case "--path":
-			if i+1 < len(args) {
-				path = args[i+1]
-				ok = true
-				i++
-			}
-		default:
-			// ignore
+// clientPinStatus returns (present, set) for the clientPin option.
+// Presence means the authenticator uses a PIN; value=true means PIN set, false means not set.
+func clientPinStatus(opts []libfido2.Option) (bool, bool) {
+	for _, o := range opts {
+		if strings.EqualFold(o.Name, "clientPin") {
+			return true, o.Value == libfido2.True
 		}
 	}
-	return
+	return false, false
 }
 
-// getStringFlag returns the value following a named flag (e.g., --rp value).
-func getStringFlag(args []string, name string) string {
-	for i := 0; i < len(args)-1; i++ {
-		if args[i] == name {
-			return args[i+1]
-		}
+// getHelloWindow creates a hidden window required by Windows Hello APIs.
+// (Hello helpers removed in libfido2-only CLI)
+
+// nonNil returns s, or an empty slice so it encodes as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
 	}
-	return ""
+	return s
 }
 
-// hasFlag returns true if the flag name is present in args.
-func hasFlag(args []string, name string) bool {
-	for _, a := range args {
-		if a == name {
-			return true
-		}
+// emit writes a command's document to stdout in the --output format.
+func emit(doc any) {
+	if err := format.Write(os.Stdout, outOpts, doc); err != nil {
+		log.Fatalf("%s: %v", outOpts.Format, err)
 	}
-	return false
 }
 
-// getIntFlag parses an int following a named flag.
-func getIntFlag(args []string, name string) (int, bool) {
-	for i := 0; i < len(args)-1; i++ {
-		if args[i] == name {
-			if v, err := strconv.Atoi(args[i+1]); err == nil {
-				return v, true
-			}
-			return 0, false
-		}
+// progressf prints a progress note: to stdout with text output, to stderr
+// when stdout carries a document.
+func progressf(msg string, args ...any) {
+	w := os.Stdout
+	if outOpts.Structured() {
+		w = os.Stderr
 	}
-	return 0, false
+	fmt.Fprintf(w, msg, args...)
 }
 
-// clientPinStatus returns (present, set) for the clientPin option.
-// Presence means the authenticator uses a PIN; value=true means PIN set, false means not set.
-func clientPinStatus(opts []libfido2.Option) (bool, bool) {
-	for _, o := range opts {
-		if strings.EqualFold(o.Name, "clientPin") {
-			return true, o.Value == libfido2.True
-		}
+// b64Hex re-encodes a base64url document field as hex for text output.
+func b64Hex(s string) string {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return s
 	}
-	return false, false
+	return hex.EncodeToString(b)
 }
 
-// getHelloWindow creates a hidden window required by Windows Hello APIs.
-// (Hello helpers removed in libfido2-only CLI)
-
 // writeJSON pretty-prints JSON to stdout.
 func writeJSON(v any) {
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"log"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/pinentry"
+)
+
+// pinOptions collects the current-PIN sources shared by every command:
+// --pin, --pin-stdin, --pin-file, --pin-fd and FIT_PIN.
+func pinOptions(fl *cli.Values) pinentry.Options {
+	fd := -1
+	if n, ok := fl.Int("pin-fd"); ok {
+		fd = n
+	}
+	o := pinentry.Options{
+		Value:  fl.String("pin"),
+		Stdin:  fl.Bool("pin-stdin"),
+		File:   fl.String("pin-file"),
+		FD:     fd,
+		EnvVar: "FIT_PIN",
+	}
+	if o.Value != "" {
+		warnArgvSecret("--pin")
+	}
+	return o
+}
+
+// readPIN resolves the device PIN. When required and no source is given, it
+// prompts without echo (or runs FIT_ASKPASS/SSH_ASKPASS). Callers should Zero
+// the result once the device call returns.
+func readPIN(fl *cli.Values, required bool) *pinentry.Secret {
+	o := pinOptions(fl)
+	if required {
+		o.Prompt = "Enter device PIN: "
+	}
+	pin, err := pinentry.Read(o)
+	if err != nil {
+		exitWith(pinError(err))
+	}
+	return pin
+}
+
+// pinError classifies a failure to obtain a PIN from the user.
+func pinError(err error) *ctaperr.Error {
+	if errors.Is(err, pinentry.ErrUnavailable) {
+		return ctaperr.New(ctaperr.PINRequired, 0, "read PIN", err)
+	}
+	return ctaperr.New(ctaperr.Usage, 0, "read PIN", err)
+}
+
+// warnArgvSecret notes that a secret passed as an argument is visible to other users.
+func warnArgvSecret(flag string) {
+	log.Printf("warning: %s exposes the PIN in shell history and process listings; prefer --pin-stdin, --pin-file, --pin-fd, FIT_PIN or the prompt", flag)
+}
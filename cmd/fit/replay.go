@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/replay"
+	"fit/internal/vkey"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+var (
+	// recorder writes the --record session; nil otherwise.
+	recorder *replay.Recorder
+	// player and replayKey serve the --replay session; nil otherwise.
+	player    *replay.Player
+	replayKey *vkey.Key
+	// replayDevice is the recorded key the virtual one stands in for.
+	replayDevice replay.Device
+)
+
+// startSession starts --record (on the libfido2 log that startTrace turned
+// on) or --replay (a virtual key answering from the session file).
+func startSession(fl *cli.Values) {
+	rec, rep := fl.String("record"), fl.String("replay")
+	if rec != "" && rep != "" {
+		usageError(fmt.Errorf("--record and --replay cannot be combined"), fl.Command.Name)
+	}
+	if (rec != "" || rep != "") && fl.Bool("all-devices") {
+		usageError(fmt.Errorf("--record and --replay work with one key, not --all-devices"), fl.Command.Name)
+	}
+	if rec != "" {
+		r, err := replay.Create(rec, replay.Header{
+			Fit:  buildVersion,
+			Args: sessionArgs(fl),
+			Time: time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			usageError(fmt.Errorf("--record: %v", err), fl.Command.Name)
+		}
+		recorder = r
+		tracer.Observe = r.Observe
+	}
+	if rep != "" {
+		s, err := replay.Load(rep)
+		if err != nil {
+			usageError(fmt.Errorf("--replay: %v", err), fl.Command.Name)
+		}
+		player = replay.NewPlayer(s)
+		replayDevice = s.Device
+		name := strings.TrimSpace(s.Device.Manufacturer + " " + s.Device.Product)
+		key, err := vkey.Start(vkey.Info{Name: name, Serial: s.Device.Serial, VID: s.Device.VID, PID: s.Device.PID}, player)
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.NoDevice, 0, "replay", err))
+		}
+		replayKey = key
+	}
+}
+
+// sessionArgs is the command line as parsed, with the values of secret flags
+// (--pin, --old, --new) removed. It is rebuilt from fl rather than os.Args, so
+// no spelling of a secret flag reaches the session header.
+func sessionArgs(fl *cli.Values) []string {
+	return append([]string{fl.Command.Name}, fl.Args()...)
+}
+
+// recordDevice makes loc the --record session's key.
+func recordDevice(loc *libfido2.DeviceLocation) {
+	if recorder == nil {
+		return
+	}
+	recorder.Device(replay.Device{
+		Path:         loc.Path,
+		Manufacturer: loc.Manufacturer,
+		Product:      loc.Product,
+		Serial:       hidSerial(loc.Path),
+		VID:          uint16(loc.VendorID),
+		PID:          uint16(loc.ProductID),
+	})
+}
+
+// replayLoc is the virtual key, described as the recorded one.
+func replayLoc() *libfido2.DeviceLocation {
+	return &libfido2.DeviceLocation{
+		Path:         replayKey.Path,
+		Manufacturer: replayDevice.Manufacturer,
+		Product:      replayDevice.Product,
+		VendorID:     int16(replayDevice.VID),
+		ProductID:    int16(replayDevice.PID),
+	}
+}
+
+// stopSession closes the --record file, or removes the --replay key and
+// checks that the run sent exactly the recorded requests; a run that did
+// not exits with REPLAY_MISMATCH whatever its own status.
+func stopSession(code int) int {
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: --record: %v\n", err)
+		}
+		recorder = nil
+	}
+	if replayKey != nil {
+		replayKey.Close()
+		replayKey = nil
+		if err := player.Err(); err != nil {
+			e := ctaperr.New(ctaperr.ReplayMismatch, 0, "replay", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\nHint: %s\n", e, e.Hint)
+			return e.ExitCode
+		}
+	}
+	return code
+}
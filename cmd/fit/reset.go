@@ -0,0 +1,223 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"fit/internal/audit"
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/pinentry"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// resetTokenLen is how much of the fingerprint the user types to confirm.
+const resetTokenLen = 8
+
+// defaultReplugWait is the --replug-wait default.
+const defaultReplugWait = 60 * time.Second
+
+// cmdReset performs a factory reset on a FIDO2 device. It identifies the key
+// first and shows what will be wiped, then requires its fingerprint: typed at
+// the terminal, or given as --confirm for automation. CTAP2 only accepts
+// reset within about 10 seconds of power-up, so when the key answers
+// NOT_ALLOWED the user is asked to replug it and the reset is retried as soon
+// as the same key is back. The confirmation is not asked again: typing it
+// would use up most of the window.
+//
+// Without a HID serial number the fingerprint only identifies the model, so
+// reset refuses to run while another key with the same fingerprint is
+// attached: neither the typed token nor the replug could tell them apart.
+func cmdReset(fl *cli.Values) {
+	pinSecret := readPIN(fl, false)
+	defer pinSecret.Zero()
+
+	loc, timeout := selectDevice(fl)
+	opTimeout = timeout
+	d := describe(loc)
+	if err := identify(&d); err != nil {
+		fatal("info", err)
+	}
+	refuseTwins(d, nil)
+
+	fmt.Println("WARNING: factory reset deletes every credential on this key and clears its PIN. It cannot be undone.")
+	fmt.Printf("  Device:       %s\n", d.Label)
+	fmt.Printf("  Path:         %s  VID:PID=%04x:%04x\n", d.Path, d.VID, d.PID)
+	fmt.Printf("  AAGUID:       %s\n", devsel.FormatAAGUID(d.AAGUID))
+	fmt.Printf("  Fingerprint:  %s\n", d.Fingerprint)
+	fmt.Printf("  Resident keys: %s\n", residentKeyCount(loc, pinSecret))
+	confirmReset(fl, d)
+
+	fmt.Println("Keys accept reset only within about 10 seconds of being plugged in, and most require a touch.")
+	retries, _ := fl.Int("retries")
+	if !fl.Has("retries") {
+		retries = 2
+	}
+	wait := fl.Duration("replug-wait", defaultReplugWait)
+	for attempt := 0; ; attempt++ {
+		fmt.Println("Performing device reset. Touch the key if it blinks.")
+		dev, err := libfido2.NewDevice(loc.Path)
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.NoDevice, 0, "open", err))
+		}
+		err = deviceOp(dev, "reset", dev.Reset)
+		if err == nil {
+			recordAudit(d, audit.Entry{Command: "reset"}, nil)
+			break
+		}
+		e := classify("reset", err)
+		if e.Code != ctaperr.NotAllowed || attempt >= retries {
+			recordAudit(d, audit.Entry{Command: "reset"}, err)
+			if e.Code == ctaperr.NotAllowed {
+				e.Hint = "The reset window had passed. Unplug the key, plug it back in and run reset again within 10 seconds."
+			}
+			exitWith(e)
+		}
+		fmt.Printf("The key refused the reset because it was plugged in too long ago.\nUnplug it and plug it back in now (waiting up to %s)...\n", wait)
+		loc = waitReplug(d, wait)
+		d.Path = loc.Path
+		fmt.Printf("The same key (fingerprint %s) is back at %s.\n", d.Fingerprint, d.Path)
+	}
+
+	fmt.Println("Device has been successfully reset.")
+	forgetDevice(d)
+}
+
+// confirmReset asks for the first characters of d's fingerprint, or checks
+// --confirm, and exits unless they match.
+func confirmReset(fl *cli.Values, d devsel.Device) {
+	token := d.Fingerprint[:resetTokenLen]
+	if confirm := fl.String("confirm"); confirm != "" {
+		if len(confirm) < resetTokenLen || !strings.HasPrefix(d.Fingerprint, strings.ToLower(confirm)) {
+			exitWith(ctaperr.Newf(ctaperr.Usage, "reset", "--confirm %s does not match the selected key (fingerprint %s)", confirm, d.Fingerprint))
+		}
+		return
+	}
+	answer, err := askTTY(fmt.Sprintf("Type %s to reset this key: ", token))
+	if err != nil {
+		exitWith(ctaperr.Newf(ctaperr.Usage, "reset", "cannot ask for confirmation (%v); pass --confirm %s", err, d.Fingerprint))
+	}
+	if strings.TrimSpace(strings.ToLower(answer)) != token {
+		fmt.Println("Confirmation did not match; aborting reset.")
+		exit(ctaperr.ExitCode(ctaperr.OperationDenied))
+	}
+}
+
+// refuseTwins exits when a key other than d (at d.Path) and skip shares d's
+// fingerprint. A key with a HID serial number cannot have a twin, since the
+// serial is part of the fingerprint.
+func refuseTwins(d devsel.Device, locs []*libfido2.DeviceLocation) {
+	if d.Serial != "" {
+		return
+	}
+	twins := matchingKeys(d, locs)
+	twins = slices.DeleteFunc(twins, func(l *libfido2.DeviceLocation) bool { return l.Path == d.Path })
+	if len(twins) > 0 {
+		e := ctaperr.Newf(ctaperr.Usage, "reset", "%d other attached key(s) share fingerprint %s (same model, no serial number), so the confirmation cannot tell them apart", len(twins), d.Fingerprint)
+		e.Hint = "Unplug every other key of this model and run reset again."
+		exitWith(e)
+	}
+}
+
+// matchingKeys returns the attached keys (of locs, or all when locs is nil)
+// whose fingerprint is d's.
+func matchingKeys(d devsel.Device, locs []*libfido2.DeviceLocation) []*libfido2.DeviceLocation {
+	if locs == nil {
+		var err error
+		if locs, err = libfido2.DeviceLocations(); err != nil {
+			exitWith(ctaperr.New(ctaperr.Transport, 0, "list", err))
+		}
+	}
+	var out []*libfido2.DeviceLocation
+	for _, loc := range locs {
+		c := describe(loc)
+		if c.VID != d.VID || c.PID != d.PID || c.Serial != d.Serial {
+			continue
+		}
+		if identify(&c) == nil && c.Fingerprint == d.Fingerprint {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// residentKeyCount describes how many discoverable credentials the key holds,
+// which needs the PIN.
+func residentKeyCount(loc *libfido2.DeviceLocation, pin *pinentry.Secret) string {
+	if pin.Empty() {
+		return "unknown (give a PIN source to count them)"
+	}
+	dev, err := libfido2.NewDevice(loc.Path)
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+	ci, err := deviceCall(dev, "credentialsInfo", func() (*libfido2.CredentialsInfo, error) { return dev.CredentialsInfo(pin.String()) })
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", classify("credentialsInfo", err).Message)
+	}
+	return fmt.Sprintf("%d", ci.RKExisting)
+}
+
+// waitReplug waits for the key described by d to be removed and attached
+// again, and returns its new location. The key is recognised by fingerprint,
+// since its path may change; if more than one attached key then matches,
+// reset stops rather than guess.
+func waitReplug(d devsel.Device, wait time.Duration) *libfido2.DeviceLocation {
+	deadline := time.Now().Add(wait)
+	gone := false
+	for time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+		locs, err := libfido2.DeviceLocations()
+		if err != nil {
+			continue
+		}
+		present := false
+		for _, loc := range locs {
+			if loc.Path == d.Path {
+				present = true
+			}
+		}
+		if !gone {
+			gone = !present
+			continue
+		}
+		if m := matchingKeys(d, locs); len(m) > 0 {
+			d.Path = m[0].Path
+			refuseTwins(d, locs)
+			return m[0]
+		}
+	}
+	exitWith(ctaperr.Newf(ctaperr.NotAllowed, "reset", "the key was not replugged within %s", wait))
+	return nil
+}
+
+// askTTY prints prompt and reads a line from the terminal, even when stdin is
+// redirected (for example by --pin-stdin).
+func askTTY(prompt string) (string, error) {
+	var in io.Reader
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		in = tty
+	} else if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		in = os.Stdin
+	} else {
+		return "", errors.New("no terminal")
+	}
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return line, nil
+}
@@ -0,0 +1,102 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"fit/internal/cli"
+	"fit/internal/rp"
+)
+
+// cmdRP dispatches relying-party helper subcommands.
+func cmdRP(fl *cli.Values) {
+	switch fl.Arg {
+	case "serve":
+		cmdRPServe(fl)
+	}
+}
+
+// cmdRPServe runs a local WebAuthn relying party until interrupted.
+func cmdRPServe(fl *cli.Values) {
+	addr := fl.String("addr")
+	if addr == "" {
+		addr = "127.0.0.1:8787"
+	}
+	rpID := fl.String("rp-id")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	policy := rp.DefaultPolicy(rpID)
+	if v := fl.String("rp-name"); v != "" {
+		policy.RPName = v
+	}
+	if v := fl.String("origin"); v != "" {
+		policy.Origins = splitList(v)
+	}
+	if v := fl.String("uv"); v != "" {
+		policy.UserVerification = v
+	}
+	if v := fl.String("resident"); v != "" {
+		policy.ResidentKey = v
+	}
+	if v := fl.String("attestation"); v != "" {
+		policy.Attestation = v
+	}
+	if v := fl.String("algs"); v != "" {
+		policy.Algorithms = nil
+		for _, name := range splitList(v) {
+			alg, err := rp.ParseAlg(name)
+			if err != nil {
+				usageError(fmt.Errorf("--algs: %w", err), "rp")
+			}
+			policy.Algorithms = append(policy.Algorithms, alg)
+		}
+	}
+	policy.Timeout = fl.Duration("timeout", policy.Timeout)
+	if err := policy.Validate(); err != nil {
+		usageError(err, "rp")
+	}
+
+	store := rp.NewMemoryStore()
+	if path := fl.String("store"); path != "" {
+		s, err := rp.OpenFileStore(path)
+		if err != nil {
+			log.Fatalf("Failed to open store: %v", err)
+		}
+		store = s
+	}
+
+	srv := rp.NewServer(policy, store)
+	srv.Logf = log.Printf
+
+	algs := make([]string, 0, len(policy.Algorithms))
+	for _, a := range policy.Algorithms {
+		algs = append(algs, rp.AlgName(a))
+	}
+	fmt.Printf("Relying party listening on http://%s\n", addr)
+	fmt.Printf("  RP ID:        %s\n", policy.RPID)
+	fmt.Printf("  Origins:      %s\n", strings.Join(policy.Origins, ", "))
+	fmt.Printf("  UV:           %s  ResidentKey: %s\n", policy.UserVerification, policy.ResidentKey)
+	fmt.Printf("  Algorithms:   %s\n", strings.Join(algs, ", "))
+	fmt.Printf("  Attestation:  %s\n", policy.Attestation)
+	fmt.Println("  Endpoints:    POST /register/begin, /register/finish, /login/begin, /login/finish; GET /users")
+	if err := http.ListenAndServe(addr, srv); err != nil {
+		log.Fatalf("rp serve: %v", err)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
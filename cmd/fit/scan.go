@@ -0,0 +1,250 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/mds"
+	"fit/internal/output"
+	"fit/internal/pinentry"
+	"fit/internal/policy"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// cmdInventoryScan reads every key inserted while it runs (and, with
+// --existing, those already attached) and prints one report of the keys with
+// a summary of policy violations. A key with a HID serial number is listed
+// once however often it is inserted; one without has only its model's
+// fingerprint, so every insertion gets an entry, marked as such. It
+// stops after --count keys or on Ctrl-C, and exits with POLICY_FAILED when
+// any key fails the policy. The device polling is that of `fit watch`.
+func cmdInventoryScan(fl *cli.Values) {
+	opTimeout = fl.Duration("timeout", 0)
+	interval := fl.Duration("interval", defaultWatchInterval)
+	if interval <= 0 {
+		usageError(fmt.Errorf("--interval must be positive"), "inventory")
+	}
+	limit, _ := fl.Int("count")
+	var p *policy.Policy
+	if path := fl.String("policy"); path != "" {
+		var err error
+		if p, err = policy.Load(path); err != nil {
+			usageError(err, "inventory")
+		}
+	}
+	blob := loadMDS(fl, p)
+	pinSecret := readPIN(fl, false)
+	defer pinSecret.Zero()
+
+	report := output.InventoryScan{
+		Header: output.NewHeader("inventory", output.BackendLibfido2),
+		Policy: fl.String("policy"),
+		MDS:    fl.String("mds"),
+		Keys:   []output.ScannedKey{},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintln(os.Stderr, "Insert the keys one at a time; press Ctrl-C when done.")
+
+	attached := map[string]bool{}
+	scanned := map[string]bool{} // fingerprints of keys with a serial number
+	first := true
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+scan:
+	for {
+		locs, err := libfido2.DeviceLocations()
+		if err != nil {
+			fatal("enumerate devices", err)
+		}
+		current := map[string]bool{}
+		for i, loc := range locs {
+			current[watchKey(listEntry(i, loc))] = true
+		}
+		for key := range attached {
+			if !current[key] {
+				delete(attached, key)
+			}
+		}
+		for i, loc := range locs {
+			key := watchKey(listEntry(i, loc))
+			if attached[key] {
+				continue
+			}
+			attached[key] = true
+			if first && !fl.Bool("existing") {
+				continue
+			}
+			k := scanKey(loc, pinSecret, p, blob)
+			if k.Fingerprint != "" && !k.SharedFingerprint && scanned[k.Fingerprint] {
+				report.Duplicates++
+				fmt.Fprintf(os.Stderr, "%s (fingerprint %s) was already scanned; skipping.\n", k.Device.Label, k.Fingerprint)
+				continue
+			}
+			scanned[k.Fingerprint] = true
+			if k.SharedFingerprint {
+				fmt.Fprintf(os.Stderr, "Warning: %s has no serial number, so fit cannot tell whether it was scanned before; insert each key only once.\n", k.Device.Label)
+			}
+			report.Keys = append(report.Keys, k)
+			status := "ok"
+			switch {
+			case k.Error != nil:
+				status = "error: " + k.Error.Error()
+			case len(k.Violations) > 0:
+				status = "violates " + strings.Join(k.Violations, ", ")
+			}
+			fmt.Fprintf(os.Stderr, "[%d] %s (fingerprint %s): %s\n", len(report.Keys), k.Device.Label, k.Fingerprint, status)
+			if limit > 0 && len(report.Keys) >= limit {
+				break scan
+			}
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			break scan
+		case <-tick.C:
+		}
+	}
+
+	report.Violations = violationSummary(report.Keys)
+	if outOpts.Structured() {
+		emit(report)
+	} else {
+		printInventoryScan(report)
+	}
+	if len(report.Violations) > 0 {
+		exit(ctaperr.ExitCode(ctaperr.PolicyFailed))
+	}
+}
+
+// scanKey collects the report entry of one newly attached key. The PIN, if
+// any, is used for the resident key counts; a wrong PIN costs each key a
+// retry, so it is only worth giving for a fleet that shares one.
+func scanKey(loc *libfido2.DeviceLocation, pin *pinentry.Secret, p *policy.Policy, blob *mds.BLOB) output.ScannedKey {
+	k := output.ScannedKey{
+		Scanned: time.Now().UTC().Format(time.RFC3339),
+		Device:  deviceEntry(loc),
+		VIDPID:  fmt.Sprintf("%04x:%04x", loc.VendorID, loc.ProductID),
+	}
+	info, ierr := attachedInfo(loc, pin)
+	if ierr != nil {
+		k.Error = ierr
+		return k
+	}
+	d := describe(loc)
+	if err := identify(&d); err == nil {
+		k.Fingerprint = d.Fingerprint
+	}
+	k.Serial = d.Serial
+	k.SharedFingerprint = d.Serial == ""
+	k.AAGUID = info.AAGUID
+	k.FirmwareVersion = info.FirmwareVersion
+	k.CTAPHIDVersion = firmwareVersion(*info)
+	k.PINRetryCount = info.PINRetryCount
+	k.ResidentKeys = info.ResidentKeys
+	switch v, ok := info.Options["clientPin"]; {
+	case !ok:
+		k.PIN = "unsupported"
+	case v == "true":
+		k.PIN = "set"
+	default:
+		k.PIN = "not-set"
+	}
+	if blob != nil {
+		if e, ok := blob.Lookup(info.AAGUID); ok {
+			k.Certification, k.Compromised = e.Level, e.Compromised
+		} else {
+			k.Certification = "unlisted"
+		}
+	}
+	if p != nil {
+		for _, r := range lintReport(p, blob, *info, false).Results {
+			if r.Status == policy.Fail {
+				k.Violations = append(k.Violations, r.Rule)
+			}
+		}
+	}
+	return k
+}
+
+// violationSummary counts the keys failing each rule, in the order the
+// rules were first failed.
+func violationSummary(keys []output.ScannedKey) []output.Violation {
+	out := []output.Violation{}
+	index := map[string]int{}
+	for _, k := range keys {
+		for _, rule := range k.Violations {
+			i, ok := index[rule]
+			if !ok {
+				i = len(out)
+				index[rule] = i
+				out = append(out, output.Violation{Rule: rule, Fingerprints: []string{}})
+			}
+			out[i].Keys++
+			out[i].Fingerprints = append(out[i].Fingerprints, k.Fingerprint)
+		}
+	}
+	return out
+}
+
+// printInventoryScan is the text output of `fit inventory scan`.
+func printInventoryScan(r output.InventoryScan) {
+	for _, k := range r.Keys {
+		if k.Error != nil {
+			fmt.Printf("  %-32s %s  %s  error: %s\n", k.Fingerprint, k.VIDPID, k.Device.Label, k.Error.Error())
+			continue
+		}
+		line := fmt.Sprintf("  %-32s %s  %s  aaguid=%s", k.Fingerprint, k.VIDPID, k.Device.Label, k.AAGUID)
+		if k.Serial != "" {
+			line += " serial=" + k.Serial
+		}
+		if k.FirmwareVersion != nil {
+			line += fmt.Sprintf(" fw=%d", *k.FirmwareVersion)
+		}
+		line += fmt.Sprintf(" ctaphid=%s pin=%s", k.CTAPHIDVersion, k.PIN)
+		if k.PINRetryCount != nil {
+			line += fmt.Sprintf(" retries=%d", *k.PINRetryCount)
+		}
+		if rk := k.ResidentKeys; rk != nil {
+			line += fmt.Sprintf(" rk=%d/%d", rk.Existing, rk.Existing+rk.Remaining)
+		}
+		if k.Certification != "" {
+			line += " cert=" + k.Certification
+		}
+		if len(k.Compromised) > 0 {
+			line += " COMPROMISED=" + strings.Join(k.Compromised, ",")
+		}
+		if len(k.Violations) > 0 {
+			line += "  violates: " + strings.Join(k.Violations, ", ")
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d key(s) scanned", len(r.Keys))
+	if r.Duplicates > 0 {
+		fmt.Printf(", %d repeated insertion(s) skipped", r.Duplicates)
+	}
+	fmt.Println(".")
+	if r.Policy == "" {
+		return
+	}
+	if len(r.Violations) == 0 {
+		fmt.Printf("No violations of %s.\n", r.Policy)
+		return
+	}
+	fmt.Printf("Violations of %s:\n", r.Policy)
+	for _, v := range r.Violations {
+		fmt.Printf("  %-24s %d key(s)\n", v.Rule, v.Keys)
+	}
+}
@@ -0,0 +1,381 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/getinfo"
+	"fit/internal/hidraw"
+	"fit/internal/output"
+
+	"github.com/keys-pub/go-libfido2"
+	"golang.org/x/term"
+)
+
+// selectDevice picks a device using the parsed selectors:
+//
+//	--device N          : index from `fit list`
+//	--path PATH         : device path
+//	--vidpid VVVV:PPPP  : USB vendor and product ID
+//	--aaguid UUID       : authenticator model (getInfo AAGUID)
+//	--product-match RE  : manufacturer/product string, case-insensitive
+//	--alias NAME        : key registered with `fit alias set`
+//	--select touch      : the key the user touches
+//
+// The stable selectors (vidpid, aaguid, product-match, alias) narrow the
+// attached keys; if more than one remains, or none was given and several keys
+// are attached, --select decides, prompting on a terminal and failing in
+// scripts. With --replay it is the virtual key; with --record, the choice is
+// the session's device. It also returns --timeout, which bounds the touch
+// race and which the caller applies to its device operations (opTimeout).
+func selectDevice(fl *cli.Values) (*libfido2.DeviceLocation, time.Duration) {
+	timeout := fl.Duration("timeout", 0)
+	if replayKey != nil {
+		return replayLoc(), timeout
+	}
+	loc := chooseDevice(fl, timeout)
+	recordDevice(loc)
+	return loc, timeout
+}
+
+// chooseDevice applies the selectors to the attached keys.
+func chooseDevice(fl *cli.Values, timeout time.Duration) *libfido2.DeviceLocation {
+	mode := fl.String("select")
+	if mode != "" && mode != "touch" && mode != "prompt" {
+		usageError(fmt.Errorf("--select must be touch or prompt, not %q", mode), fl.Command.Name)
+	}
+	crit := parseCriteria(fl)
+	if !crit.Empty() && (fl.Has("device") || fl.Has("path")) {
+		usageError(fmt.Errorf("--device and --path cannot be combined with --vidpid, --aaguid, --product-match or --alias"), fl.Command.Name)
+	}
+
+	locs, err := libfido2.DeviceLocations()
+	if err != nil {
+		fatal("enumerate devices", err)
+	}
+
+	// Attempt non-interactive selectors first.
+	if path := fl.String("path"); path != "" {
+		for _, loc := range locs {
+			if loc.Path == path {
+				return loc
+			}
+		}
+		return &libfido2.DeviceLocation{Path: path}
+	}
+	if len(locs) == 0 {
+		exitWith(ctaperr.Newf(ctaperr.NoDevice, "", "no FIDO2 devices found"))
+	}
+	if idx, ok := fl.Int("device"); ok {
+		if idx < 0 || idx >= len(locs) {
+			exitWith(ctaperr.Newf(ctaperr.NoDevice, "", "invalid device index %d (have %d)", idx, len(locs)))
+		}
+		return locs[idx]
+	}
+	if !crit.Empty() {
+		locs = matchDevices(locs, crit)
+		if len(locs) == 0 {
+			exitWith(ctaperr.Newf(ctaperr.NoDevice, "", "no attached device matches %s", crit))
+		}
+	}
+
+	// Auto-select if there is exactly one device.
+	if len(locs) == 1 {
+		return locs[0]
+	}
+
+	if mode == "touch" {
+		return selectByTouch(locs, timeout)
+	}
+	if mode == "" && !term.IsTerminal(int(os.Stdin.Fd())) {
+		exitWith(ctaperr.Newf(ctaperr.Usage, "", "%d FIDO2 devices match; choose one with --device, --path, --vidpid, --aaguid, --product-match, --alias or --select touch", len(locs)))
+	}
+
+	// Fallback to interactive selection.
+	fmt.Println("Found FIDO2 devices:")
+	for i, loc := range locs {
+		fmt.Printf("  [%d] %s (Path: %s)\n", i, deviceLabel(loc), loc.Path)
+	}
+	fmt.Print("Select a device (enter number): ")
+	var index int
+	_, err = fmt.Scanln(&index)
+	if err != nil || index < 0 || index >= len(locs) {
+		exitWith(ctaperr.Newf(ctaperr.Usage, "", "invalid device selection"))
+	}
+	return locs[index]
+}
+
+// parseCriteria builds the stable selectors from the flags, resolving --alias
+// through the local config.
+func parseCriteria(fl *cli.Values) devsel.Criteria {
+	var c devsel.Criteria
+	bad := func(err error) { usageError(err, fl.Command.Name) }
+	if v := fl.String("vidpid"); v != "" {
+		vp, err := devsel.ParseVIDPID(v)
+		if err != nil {
+			bad(err)
+		}
+		c.VIDPID = &vp
+	}
+	if v := fl.String("aaguid"); v != "" {
+		a, err := devsel.ParseAAGUID(v)
+		if err != nil {
+			bad(err)
+		}
+		c.AAGUID = a
+	}
+	if v := fl.String("product-match"); v != "" {
+		re, err := devsel.ParseProduct(v)
+		if err != nil {
+			bad(err)
+		}
+		c.Product = re
+	}
+	if name := fl.String("alias"); name != "" {
+		cfg, path := loadDeviceConfig()
+		a, ok := cfg.Lookup(name)
+		if !ok {
+			bad(fmt.Errorf("no alias %q in %s (see `fit alias list`)", name, path))
+		}
+		c.Fingerprint = a.Fingerprint
+	}
+	return c
+}
+
+// matchDevices returns the locations matching crit, querying getInfo only
+// when an AAGUID or alias selector needs it.
+func matchDevices(locs []*libfido2.DeviceLocation, crit devsel.Criteria) []*libfido2.DeviceLocation {
+	var out []*libfido2.DeviceLocation
+	for _, loc := range locs {
+		d := describe(loc)
+		// Rule out on VID:PID and product first so getInfo only reaches candidates.
+		if !(devsel.Criteria{VIDPID: crit.VIDPID, Product: crit.Product}).Match(&d) {
+			continue
+		}
+		if crit.NeedsInfo() {
+			if err := identify(&d); err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", loc.Path, err)
+				continue
+			}
+		}
+		if crit.Match(&d) {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// describe returns what the selectors can see of loc without talking to it.
+func describe(loc *libfido2.DeviceLocation) devsel.Device {
+	return devsel.Device{
+		Path:   loc.Path,
+		VID:    uint16(loc.VendorID),
+		PID:    uint16(loc.ProductID),
+		Label:  deviceLabel(loc),
+		Serial: hidSerial(loc.Path),
+	}
+}
+
+// identify runs getInfo on d to fill its AAGUID and fingerprint.
+func identify(d *devsel.Device) error {
+	dev, err := libfido2.NewDevice(d.Path)
+	if err != nil {
+		return err
+	}
+	info, err := deviceCall(dev, "info", dev.Info)
+	if err != nil {
+		return err
+	}
+	d.Identify(info.AAGUID)
+	return nil
+}
+
+// openLocs remembers the location each device was opened from, so the audit
+// log and inventory can name and fingerprint a key given only its
+// *libfido2.Device.
+var openLocs sync.Map // *libfido2.Device -> *libfido2.DeviceLocation
+
+// openDevice opens loc and remembers where the device came from.
+func openDevice(loc *libfido2.DeviceLocation) (*libfido2.Device, error) {
+	dev, err := libfido2.NewDevice(loc.Path)
+	if err != nil {
+		return nil, err
+	}
+	openLocs.Store(dev, loc)
+	return dev, nil
+}
+
+// closeDevice forgets a device opened with openDevice once fit is done with
+// it. libfido2 holds no handle between calls, so this only drops the
+// openLocs entry, which would otherwise grow with every key a long-running
+// command (watch, --all-devices) opens.
+func closeDevice(dev *libfido2.Device) {
+	openLocs.Delete(dev)
+}
+
+// openedDevice identifies a device opened with openDevice. It costs a getInfo
+// round trip; a key that cannot be identified has no AAGUID or fingerprint.
+func openedDevice(dev *libfido2.Device) devsel.Device {
+	v, ok := openLocs.Load(dev)
+	if !ok {
+		return devsel.Device{}
+	}
+	d := describe(v.(*libfido2.DeviceLocation))
+	if info, err := deviceCall(dev, "info", dev.Info); err == nil {
+		d.Identify(info.AAGUID)
+	}
+	return d
+}
+
+// extendedInfo reads the getInfo fields go-libfido2 leaves out from the key
+// dev was opened from (see openDevice). The exchange goes to --trace and
+// --record like libfido2's own.
+func extendedInfo(dev *libfido2.Device) (*getinfo.Info, error) {
+	c, err := rawConn(dev)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return getinfo.Query(c, rawTimeout())
+}
+
+// rawConn opens a CTAPHID channel of fit's own to the key dev was opened
+// from, for the requests go-libfido2 does not expose. The exchange goes to
+// --trace and --record like libfido2's own.
+func rawConn(dev *libfido2.Device) (*hidraw.Conn, error) {
+	v, ok := openLocs.Load(dev)
+	if !ok {
+		return nil, errors.New("the device was not opened by path")
+	}
+	var observe hidraw.Observer
+	if tracer != nil {
+		observe = tracer.Add
+	}
+	return hidraw.Open(v.(*libfido2.DeviceLocation).Path, rawTimeout(), observe)
+}
+
+// rawTimeout is --timeout, or 5s, for requests on a rawConn.
+func rawTimeout() time.Duration {
+	if opTimeout <= 0 {
+		return 5 * time.Second
+	}
+	return opTimeout
+}
+
+// hidSerial reads the HID serial number (HID_UNIQ) of a hidraw node from
+// sysfs, or "" when the device has none.
+func hidSerial(path string) string {
+	f, err := os.Open(filepath.Join("/sys/class/hidraw", filepath.Base(path), "device", "uevent"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "HID_UNIQ="); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func loadDeviceConfig() (*devsel.Config, string) {
+	path, err := devsel.ConfigPath()
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.Other, 0, "config", err))
+	}
+	cfg, err := devsel.Load(path)
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.Other, 0, "config", err))
+	}
+	return cfg, path
+}
+
+// cmdAlias manages device aliases in the local config.
+func cmdAlias(fl *cli.Values) {
+	cfg, path := loadDeviceConfig()
+	name := fl.String("name")
+	if fl.Arg != "list" && name == "" {
+		usageError(fmt.Errorf("alias %s needs --name", fl.Arg), "alias")
+	}
+	switch fl.Arg {
+	case "set":
+		if fl.Has("alias") {
+			usageError(fmt.Errorf("select the key to name with --device, --path, --vidpid, --aaguid, --product-match or --select"), "alias")
+		}
+		loc, timeout := selectDevice(fl)
+		opTimeout = timeout
+		d := describe(loc)
+		if err := identify(&d); err != nil {
+			fatal("info", err)
+		}
+		a := devsel.Alias{
+			Name:        name,
+			Fingerprint: d.Fingerprint,
+			Label:       d.Label,
+			VIDPID:      fmt.Sprintf("%04x:%04x", d.VID, d.PID),
+			AAGUID:      devsel.FormatAAGUID(d.AAGUID),
+			Serial:      d.Serial,
+			Created:     time.Now().UTC().Format(time.RFC3339),
+		}
+		if twins := sameFingerprint(d); twins > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %d other attached key(s) have the same fingerprint (same model, no HID serial); --alias %s will match them too.\n", twins, name)
+		}
+		cfg.Set(a)
+		if err := cfg.Save(path); err != nil {
+			exitWith(ctaperr.New(ctaperr.Other, 0, "config", err))
+		}
+		fmt.Printf("Alias %s -> %s (%s, fingerprint %s)\n", name, loc.Path, d.Label, d.Fingerprint)
+	case "remove":
+		if !cfg.Remove(name) {
+			usageError(fmt.Errorf("no alias %q in %s", name, path), "alias")
+		}
+		if err := cfg.Save(path); err != nil {
+			exitWith(ctaperr.New(ctaperr.Other, 0, "config", err))
+		}
+		fmt.Printf("Removed alias %s\n", name)
+	case "list":
+		if outOpts.Structured() {
+			emit(output.AliasList{Header: output.NewHeader("alias", output.BackendLibfido2), Config: path, Aliases: append([]devsel.Alias{}, cfg.Aliases...)})
+			return
+		}
+		if len(cfg.Aliases) == 0 {
+			fmt.Printf("No aliases in %s.\n", path)
+			return
+		}
+		for _, a := range cfg.Aliases {
+			fmt.Printf("  %-16s %s  VID:PID=%s  AAGUID=%s  fingerprint=%s\n", a.Name, a.Label, a.VIDPID, a.AAGUID, a.Fingerprint)
+		}
+	}
+}
+
+// sameFingerprint counts the other attached keys that share d's fingerprint.
+func sameFingerprint(d devsel.Device) int {
+	locs, err := libfido2.DeviceLocations()
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, loc := range locs {
+		if loc.Path == d.Path {
+			continue
+		}
+		o := describe(loc)
+		if identify(&o) == nil && o.Fingerprint == d.Fingerprint {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,198 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/output"
+	"fit/internal/pinentry"
+	"fit/internal/snapshot"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// cmdSnapshot records everything fit can read from the selected key: the
+// info document and, with a PIN, every resident credential. The file written
+// by --save is always JSON, whatever --output says, so that `fit diff` can
+// read it back.
+func cmdSnapshot(fl *cli.Values) {
+	pinSecret := readPIN(fl, false)
+	defer pinSecret.Zero()
+
+	snap := takeSnapshot(fl, pinSecret)
+	path := fl.String("save")
+	if path == "" {
+		if outOpts.Structured() {
+			emit(snap)
+		} else {
+			if err := writeSnapshot(os.Stdout, snap); err != nil {
+				exitWith(ctaperr.New(ctaperr.Other, 0, "snapshot", err))
+			}
+		}
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err == nil {
+		err = writeSnapshot(f, snap)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.Other, 0, "snapshot", err))
+	}
+	if outOpts.Structured() {
+		emit(snap)
+		return
+	}
+	fmt.Printf("Saved a snapshot of %s (fingerprint %s) to %s", snap.Device.Label, snap.Fingerprint, path)
+	if snap.RelyingParties != nil {
+		n := 0
+		for _, rp := range snap.RelyingParties {
+			n += len(rp.Credentials)
+		}
+		fmt.Printf(" with %d resident credential(s)", n)
+	} else {
+		fmt.Print(" without credentials (give a PIN source to include them)")
+	}
+	fmt.Println(".")
+}
+
+// writeSnapshot writes snap as indented JSON.
+func writeSnapshot(f *os.File, snap output.Snapshot) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// takeSnapshot snapshots the key chosen by the selector flags. A PIN that
+// fails to list credentials is recorded in the snapshot, not fatal: the
+// rest of the picture is still worth keeping.
+func takeSnapshot(fl *cli.Values, pin *pinentry.Secret) output.Snapshot {
+	loc, timeout := selectDevice(fl)
+	opTimeout = timeout
+	dev, err := openDevice(loc)
+	if err != nil {
+		exitWith(ctaperr.New(ctaperr.NoDevice, 0, "open", err))
+	}
+	// stdout may carry the snapshot itself.
+	fmt.Fprintf(os.Stderr, "Reading %s...\n", deviceLabel(loc))
+	info, err := runInfo(dev, pin)
+	if err != nil {
+		exitWith(classify("info", err))
+	}
+	snap := output.Snapshot{
+		Header:      output.NewHeader("snapshot", output.BackendLibfido2),
+		Taken:       time.Now().UTC().Format(time.RFC3339),
+		Device:      deviceEntry(loc),
+		Fingerprint: openedDevice(dev).Fingerprint,
+		Info:        info,
+	}
+	if !pin.Empty() {
+		rps, err := residentCredentials(dev, pin)
+		if err != nil {
+			snap.CredentialsError = classify("credentials", err)
+		} else {
+			snap.RelyingParties = rps
+		}
+	}
+	return snap
+}
+
+// residentCredentials lists every resident credential on dev by RP.
+func residentCredentials(dev *libfido2.Device, pin *pinentry.Secret) ([]output.SnapshotRP, error) {
+	rps, err := deviceCall(dev, "relyingParties", func() ([]*libfido2.RelyingParty, error) { return dev.RelyingParties(pin.String()) })
+	if errors.Is(err, libfido2.ErrNoCredentials) {
+		return []output.SnapshotRP{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []output.SnapshotRP{}
+	for _, rp := range rps {
+		creds, err := deviceCall(dev, "credentials", func() ([]*libfido2.Credential, error) { return dev.Credentials(rp.ID, pin.String()) })
+		if err != nil && !errors.Is(err, libfido2.ErrNoCredentials) {
+			return nil, err
+		}
+		entry := output.SnapshotRP{ID: rp.ID, Name: rp.Name, Credentials: []output.SnapshotCredential{}}
+		for _, c := range creds {
+			entry.Credentials = append(entry.Credentials, output.SnapshotCredential{
+				ID:          output.B64(c.ID),
+				IDHex:       output.Hex(c.ID),
+				User:        c.User.Name,
+				UserID:      output.B64(c.User.ID),
+				DisplayName: c.User.DisplayName,
+			})
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// cmdDiff compares snapshot A with snapshot B or, without B, with a fresh
+// snapshot of the selected key. With --exit-code it exits with
+// SNAPSHOT_DIFFERS when anything changed, for use in scripts.
+func cmdDiff(fl *cli.Values) {
+	a, err := snapshot.Load(fl.Operands[0])
+	if err != nil {
+		usageError(err, "diff")
+	}
+	report := output.Diff{Header: output.NewHeader("diff", output.BackendLibfido2), A: fl.Operands[0]}
+	var b output.Snapshot
+	if len(fl.Operands) > 1 {
+		report.B = fl.Operands[1]
+		if b, err = snapshot.Load(report.B); err != nil {
+			usageError(err, "diff")
+		}
+	} else {
+		report.B = "live"
+		pinSecret := readPIN(fl, false)
+		defer pinSecret.Zero()
+		b = takeSnapshot(fl, pinSecret)
+	}
+	report.Changes, report.Notes = snapshot.Diff(a, b)
+	report.Same = len(report.Changes) == 0
+
+	if outOpts.Structured() {
+		emit(report)
+	} else {
+		printDiff(report)
+	}
+	if !report.Same && fl.Bool("exit-code") {
+		exit(ctaperr.ExitCode(ctaperr.SnapshotDiffers))
+	}
+}
+
+// printDiff is the text output of `fit diff`.
+func printDiff(r output.Diff) {
+	fmt.Printf("--- %s\n+++ %s\n", r.A, r.B)
+	for _, c := range r.Changes {
+		switch c.Kind {
+		case "added":
+			fmt.Printf("+ %s: %s\n", c.Path, c.New)
+		case "removed":
+			fmt.Printf("- %s: %s\n", c.Path, c.Old)
+		default:
+			fmt.Printf("~ %s: %s -> %s\n", c.Path, c.Old, c.New)
+		}
+	}
+	for _, n := range r.Notes {
+		fmt.Printf("Note: %s\n", n)
+	}
+	if r.Same {
+		fmt.Println("No differences.")
+	} else {
+		fmt.Printf("%d difference(s).\n", len(r.Changes))
+	}
+}
@@ -0,0 +1,193 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"fit/internal/chal"
+	"fit/internal/ctaperr"
+	"fit/internal/getinfo"
+	"fit/internal/hidraw"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/keys-pub/go-libfido2"
+)
+
+// touchRPID is the RP ID of the makeCredential probe used to wait for a
+// touch on CTAP 2.0 keys, the same dummy browsers send.
+const touchRPID = ".dummy"
+
+// touchedCodes are replies a key gives only after it was touched: the 2.0
+// probe carries an empty pinUvAuthParam, which the key answers with a PIN
+// error once user presence has been collected.
+var touchedCodes = []ctaperr.Code{
+	ctaperr.PINInvalid,
+	ctaperr.PINNotSet,
+	ctaperr.PINAuthBlocked,
+	ctaperr.OperationDenied,
+}
+
+// touchProbe is the makeCredential of the 2.0 probe (CTAP 2.0, 5.5.8.1:
+// a zero-length pinAuth makes the key wait for a touch). Nothing is created.
+type touchProbe struct {
+	ClientDataHash   []byte         `cbor:"1,keyasint"`
+	RP               map[string]any `cbor:"2,keyasint"`
+	User             map[string]any `cbor:"3,keyasint"`
+	PubKeyCredParams []any          `cbor:"4,keyasint"`
+	PinUvAuthParam   []byte         `cbor:"8,keyasint"`
+	PinUvAuthProto   int            `cbor:"9,keyasint"`
+}
+
+type touchResult struct {
+	loc *libfido2.DeviceLocation
+	err error
+}
+
+// selectByTouch asks every key in locs for a touch at once and returns the
+// first one touched, waiting at most timeout (--timeout) when it is set.
+// The requests still pending on the other keys are cancelled, and
+// selectByTouch waits for them so every device is released.
+//
+// go-libfido2 has no authenticatorSelection call and cannot send an empty
+// pinUvAuthParam, so fit talks to the keys itself (internal/hidraw): CTAP 2.1
+// keys get authenticatorSelection, 2.0 keys the empty-pinUvAuthParam
+// makeCredential probe, which PIN-protected keys also answer only after a
+// touch. Keys that answer without waiting for one are dropped from the race
+// with a warning.
+func selectByTouch(locs []*libfido2.DeviceLocation, timeout time.Duration) *libfido2.DeviceLocation {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var observe hidraw.Observer
+	if tracer != nil {
+		observe = tracer.Add
+	}
+
+	conns := make([]*hidraw.Conn, 0, len(locs))
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	results := make(chan touchResult, len(locs))
+	for _, loc := range locs {
+		c, err := hidraw.Open(loc.Path, 5*time.Second, observe)
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.NoDevice, 0, "open", err))
+		}
+		conns = append(conns, c)
+		req, err := touchRequest(c)
+		if err != nil {
+			exitWith(ctaperr.New(ctaperr.Transport, 0, "select", err))
+		}
+		// The request is written here rather than in the goroutine, so
+		// that releaseAll cannot cancel a key before its request is sent.
+		if err := c.Send(req); err != nil {
+			results <- touchResult{loc, err}
+			continue
+		}
+		go func() {
+			st, _, err := c.Receive(0)
+			if err == nil && st != 0 {
+				err = ctaperr.FromStatus(int(st), "select", nil)
+			}
+			results <- touchResult{loc, err}
+		}()
+	}
+	progressf("Touch the key you want to use (%d attached)...\n", len(locs))
+
+	var chosen *libfido2.DeviceLocation
+	pending := len(locs)
+	for chosen == nil && pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil || isTouched(r.err) {
+				chosen = r.loc
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", r.loc.Path, r.err)
+		case <-ctx.Done():
+			releaseAll(conns, results, pending)
+			exitWith(stopped(ctx, "select", nil))
+		}
+	}
+	if chosen == nil {
+		exitWith(ctaperr.Newf(ctaperr.NoDevice, "select", "no attached key can be selected by touch"))
+	}
+	releaseAll(conns, results, pending)
+	progressf("Selected %s (Path: %s)\n", deviceLabel(chosen), chosen.Path)
+	return chosen
+}
+
+// touchRequest is the request that waits for a touch on the key behind c:
+// authenticatorSelection when it speaks CTAP 2.1, the makeCredential probe
+// otherwise.
+func touchRequest(c *hidraw.Conn) ([]byte, error) {
+	if info, err := getinfo.Query(c, 5*time.Second); err == nil && slices.Contains(info.Versions, "FIDO_2_1") {
+		return []byte{0x0b}, nil
+	}
+	enc, err := cbor.CTAP2EncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	params, err := enc.Marshal(touchProbe{
+		ClientDataHash:   chal.Bytes(32),
+		RP:               map[string]any{"id": touchRPID},
+		User:             map[string]any{"id": chal.Bytes(16), "name": "fit-select"},
+		PubKeyCredParams: []any{map[string]any{"alg": -7, "type": "public-key"}},
+		PinUvAuthParam:   []byte{},
+		PinUvAuthProto:   1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{0x01}, params...), nil
+}
+
+func isTouched(err error) bool {
+	if e, ok := ctaperr.As(err); ok {
+		return slices.Contains(touchedCodes, e.Code)
+	}
+	return false
+}
+
+// releaseAll cancels the requests still running on conns and waits (up to
+// cancelGrace) for the pending ones to return. Every request was sent before
+// selectByTouch started waiting, so a single cancel reaches it; a key whose
+// request already returned ignores its cancel.
+func releaseAll(conns []*hidraw.Conn, results <-chan touchResult, pending int) {
+	for _, c := range conns {
+		c.Cancel()
+	}
+	grace := time.After(cancelGrace)
+	for ; pending > 0; pending-- {
+		select {
+		case <-results:
+		case <-grace:
+			return
+		}
+	}
+}
+
+// deviceLabel is the manufacturer and product string of loc.
+func deviceLabel(loc *libfido2.DeviceLocation) string {
+	label := strings.TrimSpace(strings.TrimSpace(loc.Manufacturer) + " " + strings.TrimSpace(loc.Product))
+	if label == "" {
+		return "Unknown device"
+	}
+	return label
+}
@@ -0,0 +1,62 @@
+//go:build linux
+// +build linux
+
+package main
+
+/*
+#cgo linux LDFLAGS: -lfido2
+#include <fido.h>
+
+void fitTraceLog(char *);
+
+static void fit_trace_handler(const char *s) { fitTraceLog((char *)s); }
+
+// fit_trace_start turns on libfido2's debug log and routes it to Go.
+static void fit_trace_start(void) {
+	fido_init(FIDO_DEBUG);
+	fido_set_log_handler(fit_trace_handler);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"fit/internal/cli"
+	"fit/internal/ctaptrace"
+)
+
+// tracer receives libfido2's debug log with --trace; nil otherwise.
+var tracer *ctaptrace.Tracer
+
+// startTrace enables --trace: every CTAP request and response is written to
+// stderr or --trace-file, with secrets redacted unless --trace-secrets.
+// --record uses the same log, without writing a trace.
+func startTrace(fl *cli.Values) {
+	trace := fl.Bool("trace") || fl.String("trace-file") != ""
+	if !trace && fl.String("record") == "" {
+		return
+	}
+	var w io.Writer
+	if trace {
+		w = os.Stderr
+	}
+	if path := fl.String("trace-file"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			usageError(fmt.Errorf("--trace-file: %v", err), fl.Command.Name)
+		}
+		w = f
+	}
+	tracer = ctaptrace.New(w, fl.Bool("trace-secrets"))
+	C.fit_trace_start()
+}
+
+// stopTrace writes out a response still being collected before exit.
+func stopTrace() {
+	if tracer != nil {
+		tracer.Flush()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+package main
+
+// A file with //export may only declare C functions, so the log handler that
+// calls fitTraceLog is defined in trace.go.
+
+import "C"
+
+//export fitTraceLog
+func fitTraceLog(s *C.char) {
+	if tracer != nil {
+		tracer.Log(C.GoString(s))
+	}
+}
@@ -0,0 +1,143 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"fit/internal/cli"
+	"fit/internal/ctaperr"
+	"fit/internal/format"
+	"fit/internal/output"
+	"fit/internal/pinentry"
+
+	"github.com/keys-pub/go-libfido2"
+)
+
+// defaultWatchInterval is the --interval default for `fit watch`.
+const defaultWatchInterval = 500 * time.Millisecond
+
+// cmdWatch polls the attached devices and prints an NDJSON event for every
+// arrival and removal until interrupted (or --count events were printed).
+// Polling DeviceLocations keeps it to what libfido2 enumerates, and a half
+// second interval is well under how long a person takes to insert a key.
+func cmdWatch(fl *cli.Values) {
+	opTimeout = fl.Duration("timeout", 0)
+	interval := fl.Duration("interval", defaultWatchInterval)
+	if interval <= 0 {
+		usageError(fmt.Errorf("--interval must be positive"), "watch")
+	}
+	limit, _ := fl.Int("count")
+	withInfo := fl.Bool("info")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	seen := map[string]output.Device{}
+	first := true
+	printed := 0
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		locs, err := libfido2.DeviceLocations()
+		if err != nil {
+			fatal("enumerate devices", err)
+		}
+		current := map[string]output.Device{}
+		for i, loc := range locs {
+			d := listEntry(i, loc)
+			current[watchKey(d)] = d
+		}
+		// Removals first, so a key replugged into another path reads as
+		// remove then add.
+		for key, d := range seen {
+			if _, ok := current[key]; ok {
+				continue
+			}
+			delete(seen, key)
+			writeEvent(output.WatchEvent{
+				Header: output.NewHeader("watch", output.BackendLibfido2),
+				Event:  "remove",
+				Time:   time.Now().UTC().Format(time.RFC3339Nano),
+				Device: d,
+			})
+			if printed++; limit > 0 && printed >= limit {
+				return
+			}
+		}
+		for i, loc := range locs {
+			d := listEntry(i, loc)
+			key := watchKey(d)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = d
+			if first && !fl.Bool("existing") {
+				continue
+			}
+			ev := output.WatchEvent{
+				Header:  output.NewHeader("watch", output.BackendLibfido2),
+				Event:   "add",
+				Time:    time.Now().UTC().Format(time.RFC3339Nano),
+				Initial: first,
+				Device:  d,
+			}
+			if withInfo {
+				ev.Info, ev.InfoError = attachedInfo(loc, nil)
+			}
+			writeEvent(ev)
+			if printed++; limit > 0 && printed >= limit {
+				return
+			}
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// watchKey identifies a device between polls. The index is left out because
+// it shifts when another key is removed.
+func watchKey(d output.Device) string {
+	return fmt.Sprintf("%s|%04x:%04x", d.Path, d.VID, d.PID)
+}
+
+// attachedInfo runs the `fit info` collection on a newly attached key. A key
+// that was just plugged in may not be readable until udev has applied its
+// rules, so a failed open is retried once.
+func attachedInfo(loc *libfido2.DeviceLocation, pin *pinentry.Secret) (*output.Info, *ctaperr.Error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			time.Sleep(250 * time.Millisecond)
+		}
+		var dev *libfido2.Device
+		dev, err = openDevice(loc)
+		if err != nil {
+			continue
+		}
+		var info output.Info
+		info, err = runInfo(dev, pin)
+		closeDevice(dev)
+		if err == nil {
+			return &info, nil
+		}
+	}
+	return nil, classify("info", err)
+}
+
+func writeEvent(ev output.WatchEvent) {
+	if err := format.Write(os.Stdout, format.Options{Format: format.NDJSON}, ev); err != nil {
+		fatal("watch", err)
+	}
+}
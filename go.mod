@@ -5,15 +5,18 @@ go 1.24.4
 toolchain go1.24.7
 
 require (
+	github.com/fxamacker/cbor/v2 v2.8.0
 	github.com/go-ctap/ctaphid v0.7.0
 	github.com/go-ctap/winhello v0.1.0
 	github.com/keys-pub/go-libfido2 v1.5.3
+	golang.org/x/net v0.41.0
+	golang.org/x/term v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
 	honnef.co/go/tools v0.6.1
 )
 
 require (
 	github.com/BurntSushi/toml v1.4.1-0.20240526193622-a339e1f7089c // indirect
-	github.com/fxamacker/cbor/v2 v2.8.0 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/ldclabs/cose v1.3.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
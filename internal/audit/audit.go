@@ -0,0 +1,199 @@
+// Package audit keeps an append-only, hash-chained JSONL log of operations
+// that change an authenticator (set-pin, reset, add-passkey, ceremony
+// register, and fit-hello delete-passkey). Each entry carries the SHA-256 of
+// the previous one, so editing, reordering or deleting an entry breaks the
+// chain from that point on. Entries never hold PINs or other secrets.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+
+	"fit/internal/filelock"
+)
+
+// Entry is one audited operation.
+type Entry struct {
+	Seq          int    `json:"seq" doc:"Position in the log, from 1."`
+	Time         string `json:"time" doc:"RFC 3339 time the operation finished."`
+	Operator     string `json:"operator" doc:"$FIT_OPERATOR, or the local user name."`
+	Host         string `json:"host,omitempty"`
+	Command      string `json:"command" doc:"fit command, e.g. set-pin, reset, add-passkey, ceremony register, or delete-passkey (fit-hello)."`
+	Device       string `json:"device,omitempty" doc:"Manufacturer and product string."`
+	Path         string `json:"path,omitempty" doc:"Device path at the time."`
+	Fingerprint  string `json:"fingerprint,omitempty" doc:"Device fingerprint, as used by --alias."`
+	RP           string `json:"rp,omitempty"`
+	User         string `json:"user,omitempty" doc:"User name of a created credential."`
+	CredentialID string `json:"credentialID,omitempty" doc:"Created or deleted credential ID (base64url)."`
+	Result       string `json:"result" doc:"ok, or the error code of a failed attempt."`
+	Prev         string `json:"prev" doc:"Hash of the previous entry; empty for the first."`
+	Hash         string `json:"hash" doc:"SHA-256 (hex) of this entry's JSON with hash empty."`
+}
+
+// Sum returns the chain hash of e: SHA-256 over its JSON encoding with Hash
+// cleared. Field order is fixed by the struct, so the encoding is stable.
+func (e Entry) Sum() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Path returns $FIT_AUDIT_LOG, or audit.jsonl in the fit directory under the
+// user configuration directory, next to devices.json.
+func Path() (string, error) {
+	if p := os.Getenv("FIT_AUDIT_LOG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fit", "audit.jsonl"), nil
+}
+
+// Operator names who ran the command: $FIT_OPERATOR, else the local user.
+func Operator() string {
+	if op := os.Getenv("FIT_OPERATOR"); op != "" {
+		return op
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// mu serialises appends within the process (--all-devices runs in parallel);
+// the file lock covers other fit processes.
+var mu sync.Mutex
+
+// Append fills e's Seq, Prev and Hash from the last entry of the log at path
+// and appends it, creating the log if needed. It returns the completed entry.
+func Append(path string, e Entry) (Entry, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return e, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return e, err
+	}
+	defer f.Close()
+	unlock, err := filelock.Lock(f)
+	if err != nil {
+		return e, err
+	}
+	defer unlock()
+
+	last, err := lastEntry(f)
+	if err != nil {
+		return e, fmt.Errorf("%s: %v", path, err)
+	}
+	e.Seq = last.Seq + 1
+	e.Prev = last.Hash
+	e.Hash = e.Sum()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return e, err
+	}
+	return e, f.Sync()
+}
+
+// lastEntry returns the final entry in r, or the zero Entry for an empty log.
+func lastEntry(r io.Reader) (Entry, error) {
+	var last []byte
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := sc.Err(); err != nil || last == nil {
+		return Entry{}, err
+	}
+	var e Entry
+	if err := json.Unmarshal(last, &e); err != nil {
+		return Entry{}, fmt.Errorf("last entry is not valid JSON: %v", err)
+	}
+	return e, nil
+}
+
+// Problem is one break in the chain found by Verify.
+type Problem struct {
+	Line    int    `json:"line"`
+	Seq     int    `json:"seq,omitempty"`
+	Message string `json:"message"`
+}
+
+// Read returns the entries of the log at path along with the problems found
+// while checking its chain: unparsable lines, gaps or repeats in seq, prev not
+// matching the previous entry's hash, and hashes not matching the content.
+// After a break the check resumes from the recorded hash, so one edited entry
+// is reported once rather than for every entry after it. A missing log has no
+// entries.
+func Read(path string) ([]Entry, []Problem, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	entries, problems, err := Verify(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entries, problems, nil
+}
+
+// Verify reads and checks a log; see Read.
+func Verify(r io.Reader) ([]Entry, []Problem, error) {
+	var entries []Entry
+	var problems []Problem
+	var prev Entry
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(text, &e); err != nil {
+			problems = append(problems, Problem{Line: line, Message: fmt.Sprintf("not a valid entry: %v", err)})
+			continue
+		}
+		bad := func(format string, args ...any) {
+			problems = append(problems, Problem{Line: line, Seq: e.Seq, Message: fmt.Sprintf(format, args...)})
+		}
+		if e.Seq != prev.Seq+1 {
+			bad("seq %d follows %d", e.Seq, prev.Seq)
+		}
+		if e.Prev != prev.Hash {
+			bad("prev does not match the hash of the entry before it (entries removed, inserted or reordered)")
+		}
+		if sum := e.Sum(); e.Hash != sum {
+			bad("hash does not match the entry's content (entry modified)")
+		}
+		entries = append(entries, e)
+		prev = e
+	}
+	return entries, problems, sc.Err()
+}
@@ -0,0 +1,295 @@
+// Package authnrcfg changes a key's settings with authenticatorConfig
+// (CTAP 2.1): setMinPINLength and toggleAlwaysUv, which go-libfido2 does not
+// expose. The requests go over a raw CTAPHID channel (see internal/hidraw)
+// and are authorized with a pinUvAuthToken that has the acfg permission,
+// obtained from the PIN with PIN/UV auth protocol 1 or 2.
+package authnrcfg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"fit/internal/ctaperr"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CTAP2 commands and subcommands.
+const (
+	cmdClientPIN    = 0x06
+	cmdConfig       = 0x0d
+	subKeyAgreement = 0x02
+	subTokenWithPIN = 0x09 // getPinUvAuthTokenUsingPinWithPermissions
+
+	subToggleAlwaysUv  = 0x02
+	subSetMinPINLength = 0x03
+)
+
+// permAuthenticatorConfig is the acfg pinUvAuthToken permission.
+const permAuthenticatorConfig = 0x20
+
+// Conn sends a CTAP2 request and returns the status byte and the response;
+// *hidraw.Conn is one.
+type Conn interface {
+	CBOR(req []byte, timeout time.Duration) (byte, []byte, error)
+}
+
+// Token is a pinUvAuthToken with the acfg permission.
+type Token struct {
+	proto protocol
+	key   []byte
+}
+
+// GetToken obtains a token for authenticatorConfig from pin using PIN/UV
+// auth protocol 1 or 2.
+func GetToken(c Conn, proto int, pin []byte, timeout time.Duration) (*Token, error) {
+	const op = "clientPIN"
+	var p protocol
+	switch proto {
+	case 1:
+		p = protocolOne{}
+	case 2:
+		p = protocolTwo{}
+	default:
+		return nil, fmt.Errorf("%s: unsupported PIN/UV auth protocol %d", op, proto)
+	}
+	var ka struct {
+		Key coseKey `cbor:"1,keyasint"`
+	}
+	if err := call(c, op, cmdClientPIN, map[int]any{1: proto, 2: subKeyAgreement}, &ka, timeout); err != nil {
+		return nil, err
+	}
+	plat, shared, err := agree(p, ka.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: key agreement: %w", op, err)
+	}
+	h := sha256.Sum256(pin)
+	pinHashEnc, err := p.encrypt(shared, h[:16])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	var tok struct {
+		Token []byte `cbor:"2,keyasint"`
+	}
+	req := map[int]any{1: proto, 2: subTokenWithPIN, 3: plat, 6: pinHashEnc, 9: permAuthenticatorConfig}
+	if err := call(c, op, cmdClientPIN, req, &tok, timeout); err != nil {
+		return nil, err
+	}
+	key, err := p.decrypt(shared, tok.Token)
+	if err != nil {
+		return nil, fmt.Errorf("%s: pinUvAuthToken: %w", op, err)
+	}
+	return &Token{proto: p, key: key}, nil
+}
+
+// SetMinPINLength raises the key's minimum PIN length to n. t may be nil on
+// a key without a PIN.
+func SetMinPINLength(c Conn, t *Token, n int, timeout time.Duration) error {
+	return config(c, t, subSetMinPINLength, map[int]any{1: n}, timeout)
+}
+
+// ToggleAlwaysUv flips the key's alwaysUv option. t may be nil on a key
+// without a PIN whose alwaysUv is off.
+func ToggleAlwaysUv(c Conn, t *Token, timeout time.Duration) error {
+	return config(c, t, subToggleAlwaysUv, nil, timeout)
+}
+
+// config sends an authenticatorConfig subcommand. The pinUvAuthParam covers
+// 32 bytes of 0xff, the command, the subcommand and its encoded parameters.
+func config(c Conn, t *Token, sub byte, params map[int]any, timeout time.Duration) error {
+	const op = "authenticatorConfig"
+	req := map[int]any{1: sub}
+	msg := append(bytes.Repeat([]byte{0xff}, 32), cmdConfig, sub)
+	if params != nil {
+		enc, err := encode(params)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		req[2] = cbor.RawMessage(enc)
+		msg = append(msg, enc...)
+	}
+	if t != nil {
+		req[3] = t.proto.version()
+		req[4] = t.proto.authenticate(t.key, msg)
+	}
+	return call(c, op, cmdConfig, req, nil, timeout)
+}
+
+// call sends cmd with its parameters and decodes the response into resp,
+// when not nil. A non-zero status is a *ctaperr.Error.
+func call(c Conn, op string, cmd byte, req map[int]any, resp any, timeout time.Duration) error {
+	enc, err := encode(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	st, body, err := c.CBOR(append([]byte{cmd}, enc...), timeout)
+	if err != nil {
+		return ctaperr.New(ctaperr.Transport, 0, op, err)
+	}
+	if st != 0 {
+		return ctaperr.FromStatus(int(st), op, nil)
+	}
+	if resp == nil {
+		return nil
+	}
+	if len(body) == 0 {
+		return fmt.Errorf("%s: empty response", op)
+	}
+	if err := cbor.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// encode is CTAP2 canonical CBOR.
+func encode(v any) ([]byte, error) {
+	em, err := cbor.CTAP2EncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	return em.Marshal(v)
+}
+
+// coseKey is a key agreement key (COSE_Key, EC2 P-256).
+type coseKey struct {
+	Kty int    `cbor:"1,keyasint"`
+	Alg int    `cbor:"3,keyasint"`
+	Crv int    `cbor:"-1,keyasint"`
+	X   []byte `cbor:"-2,keyasint"`
+	Y   []byte `cbor:"-3,keyasint"`
+}
+
+// agree makes a platform key, and returns it with the shared secret derived
+// from its ECDH with the key's.
+func agree(p protocol, peer coseKey) (coseKey, []byte, error) {
+	if peer.Kty != 2 || peer.Crv != 1 || len(peer.X) != 32 || len(peer.Y) != 32 {
+		return coseKey{}, nil, errors.New("not a P-256 key")
+	}
+	pub, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, peer.X...), peer.Y...))
+	if err != nil {
+		return coseKey{}, nil, err
+	}
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return coseKey{}, nil, err
+	}
+	z, err := priv.ECDH(pub)
+	if err != nil {
+		return coseKey{}, nil, err
+	}
+	shared, err := p.kdf(z)
+	if err != nil {
+		return coseKey{}, nil, err
+	}
+	b := priv.PublicKey().Bytes()
+	return coseKey{Kty: 2, Alg: -25, Crv: 1, X: b[1:33], Y: b[33:]}, shared, nil
+}
+
+// protocol is a PIN/UV auth protocol.
+type protocol interface {
+	version() int
+	kdf(z []byte) ([]byte, error)
+	encrypt(key, b []byte) ([]byte, error)
+	decrypt(key, b []byte) ([]byte, error)
+	authenticate(key, msg []byte) []byte
+}
+
+// protocolOne: SHA-256 of the ECDH x coordinate, AES-256-CBC with a zero IV
+// and HMAC-SHA-256 truncated to 16 bytes.
+type protocolOne struct{}
+
+func (protocolOne) version() int { return 1 }
+
+func (protocolOne) kdf(z []byte) ([]byte, error) {
+	h := sha256.Sum256(z)
+	return h[:], nil
+}
+
+func (protocolOne) encrypt(key, b []byte) ([]byte, error) {
+	return cbcCrypt(key, make([]byte, aes.BlockSize), b, true)
+}
+
+func (protocolOne) decrypt(key, b []byte) ([]byte, error) {
+	return cbcCrypt(key, make([]byte, aes.BlockSize), b, false)
+}
+
+func (protocolOne) authenticate(key, msg []byte) []byte {
+	return hmacSHA256(key, msg)[:16]
+}
+
+// protocolTwo: HKDF-SHA-256 derived HMAC and AES keys, AES-256-CBC with a
+// random IV prepended to the ciphertext and the full HMAC-SHA-256.
+type protocolTwo struct{}
+
+func (protocolTwo) version() int { return 2 }
+
+func (protocolTwo) kdf(z []byte) ([]byte, error) {
+	salt := make([]byte, 32)
+	hmacKey, err := hkdf.Key(sha256.New, z, salt, "CTAP2 HMAC key", 32)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := hkdf.Key(sha256.New, z, salt, "CTAP2 AES key", 32)
+	if err != nil {
+		return nil, err
+	}
+	return append(hmacKey, aesKey...), nil
+}
+
+func (protocolTwo) encrypt(key, b []byte) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	ct, err := cbcCrypt(aesKeyOf(key), iv, b, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ct...), nil
+}
+
+func (protocolTwo) decrypt(key, b []byte) ([]byte, error) {
+	if len(b) < aes.BlockSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	return cbcCrypt(aesKeyOf(key), b[:aes.BlockSize], b[aes.BlockSize:], false)
+}
+
+func (protocolTwo) authenticate(key, msg []byte) []byte {
+	return hmacSHA256(key, msg)
+}
+
+// aesKeyOf is the AES half of a protocol 2 shared secret.
+func aesKeyOf(shared []byte) []byte { return shared[32:] }
+
+func cbcCrypt(key, iv, b []byte, encrypt bool) ([]byte, error) {
+	if len(b)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("length %d is not a multiple of the AES block size", len(b))
+	}
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(b))
+	if encrypt {
+		cipher.NewCBCEncrypter(blk, iv).CryptBlocks(out, b)
+	} else {
+		cipher.NewCBCDecrypter(blk, iv).CryptBlocks(out, b)
+	}
+	return out, nil
+}
+
+func hmacSHA256(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
@@ -0,0 +1,120 @@
+package authnrcfg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"fit/internal/ctaperr"
+	"fit/internal/sim"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// simConn sends requests to a simulated key.
+type simConn struct{ a *sim.Authenticator }
+
+func (c simConn) CBOR(req []byte, _ time.Duration) (byte, []byte, error) {
+	out := c.a.CBOR(req, nil)
+	return out[0], out[1:], nil
+}
+
+func newKey(t *testing.T, pin string) simConn {
+	t.Helper()
+	sc, err := sim.Parse([]byte(`version: 1
+info:
+  versions: [FIDO_2_0, FIDO_2_1]
+  options: {rk: true, authnrCfg: true, setMinPINLength: true, alwaysUv: false}
+pin:
+  current: "` + pin + `"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return simConn{sim.New(sc)}
+}
+
+// settings reads minPINLength and alwaysUv from getInfo.
+func settings(t *testing.T, c simConn) (int, bool) {
+	t.Helper()
+	st, body, _ := c.CBOR([]byte{0x04}, time.Second)
+	if st != 0 {
+		t.Fatalf("getInfo: status 0x%02x", st)
+	}
+	var r struct {
+		Options      map[string]bool `cbor:"4,keyasint"`
+		MinPINLength int             `cbor:"13,keyasint"`
+	}
+	if err := cbor.Unmarshal(body, &r); err != nil {
+		t.Fatal(err)
+	}
+	return r.MinPINLength, r.Options["alwaysUv"]
+}
+
+func wantCode(t *testing.T, err error, code ctaperr.Code) {
+	t.Helper()
+	if e, ok := ctaperr.As(err); !ok || e.Code != code {
+		t.Fatalf("err = %v, want %s", err, code)
+	}
+}
+
+func TestConfigWithPIN(t *testing.T) {
+	c := newKey(t, "123456")
+	tok, err := GetToken(c, 1, []byte("123456"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMinPINLength(c, tok, 6, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := ToggleAlwaysUv(c, tok, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n, uv := settings(t, c); n != 6 || !uv {
+		t.Errorf("minPINLength %d, alwaysUv %v; want 6, true", n, uv)
+	}
+	wantCode(t, SetMinPINLength(c, tok, 4, time.Second), ctaperr.PINPolicyViolation)
+}
+
+func TestConfigNeedsToken(t *testing.T) {
+	c := newKey(t, "123456")
+	wantCode(t, SetMinPINLength(c, nil, 8, time.Second), ctaperr.PINRequired)
+	_, err := GetToken(c, 1, []byte("654321"), time.Second)
+	wantCode(t, err, ctaperr.PINInvalid)
+	if n, _ := settings(t, c); n != 4 {
+		t.Errorf("minPINLength = %d after refused requests", n)
+	}
+}
+
+func TestConfigWithoutPIN(t *testing.T) {
+	c := newKey(t, "")
+	if err := SetMinPINLength(c, nil, 8, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := settings(t, c); n != 8 {
+		t.Errorf("minPINLength = %d, want 8", n)
+	}
+}
+
+func TestProtocolTwo(t *testing.T) {
+	p := protocolTwo{}
+	shared, err := p.kdf(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := bytes.Repeat([]byte{1}, 32)
+	enc, err := p.encrypt(shared, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enc) != 48 {
+		t.Fatalf("ciphertext length %d, want the IV and two blocks", len(enc))
+	}
+	dec, err := p.decrypt(shared, enc)
+	if err != nil || !bytes.Equal(dec, msg) {
+		t.Errorf("decrypt = %x, %v", dec, err)
+	}
+	if tag := p.authenticate(msg, msg); len(tag) != 32 {
+		t.Errorf("tag length %d, want 32", len(tag))
+	}
+}
@@ -1,21 +1,92 @@
 package chal
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"io"
+	"sync"
 )
 
-// Bytes returns a cryptographically secure random challenge of n bytes.
+var (
+	source io.Reader = rand.Reader
+	seed   string
+)
+
+// Bytes returns a cryptographically secure random challenge of n bytes, or
+// the generator's next n bytes after Seed.
 func Bytes(n int) []byte {
 	b := make([]byte, n)
-	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+	if _, err := io.ReadFull(source, b); err != nil {
 		panic(err)
 	}
 	return b
 }
 
+// Reader is the source of Bytes, for callers that need an io.Reader, such as
+// key generation in simulated authenticators.
+func Reader() io.Reader { return source }
+
+// Seed makes Bytes and Reader deterministic: they return the output of an
+// HMAC-DRBG instantiated from s alone, the same on every run and platform.
+// Anyone who knows s can predict every value, so this is for test vectors
+// only. It must be called before any random value is drawn.
+func Seed(s string) {
+	if s == "" {
+		return
+	}
+	seed = s
+	source = newDRBG([]byte(s))
+}
+
+// SeedValue returns the seed given to Seed, or "" when values are random.
+func SeedValue() string { return seed }
+
+// drbg is HMAC_DRBG with SHA-256 (NIST SP 800-90A) without nonce,
+// personalization string or reseeding.
+type drbg struct {
+	mu   sync.Mutex
+	k, v []byte
+}
+
+func newDRBG(entropy []byte) *drbg {
+	d := &drbg{k: make([]byte, sha256.Size), v: bytes.Repeat([]byte{1}, sha256.Size)}
+	d.update(entropy)
+	return d
+}
+
+func (d *drbg) mac(parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, d.k)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func (d *drbg) update(data []byte) {
+	d.k = d.mac(d.v, []byte{0}, data)
+	d.v = d.mac(d.v)
+	if len(data) > 0 {
+		d.k = d.mac(d.v, []byte{1}, data)
+		d.v = d.mac(d.v)
+	}
+}
+
+// Read generates len(b) bytes.
+func (d *drbg) Read(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for n := 0; n < len(b); {
+		d.v = d.mac(d.v)
+		n += copy(b[n:], d.v)
+	}
+	d.update(nil)
+	return len(b), nil
+}
+
 // B64 returns base64url (no padding) encoding of b.
 func B64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
 
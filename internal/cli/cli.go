@@ -0,0 +1,408 @@
+// Package cli is the flag parser behind fit's subcommands: per-command flag
+// sets with `--flag value` and `--flag=value` syntax, typed validation,
+// required and mutually exclusive flags, generated help and typo suggestions.
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the value type of a flag.
+type Kind int
+
+const (
+	String   Kind = iota // single value
+	Bool                 // present or absent; --flag=false is accepted
+	Int                  // single integer value
+	Duration             // single time.Duration value
+	List                 // repeatable value
+)
+
+// Flag describes one flag. Name is given without the leading dashes.
+type Flag struct {
+	Name     string
+	Kind     Kind
+	Arg      string // value placeholder in help, e.g. "RP_ID"
+	Usage    string
+	Default  string // shown in help only
+	Required bool
+	Secret   bool // value is replaced by "..." in Values.Args
+}
+
+// Command describes a subcommand.
+type Command struct {
+	Name    string
+	Summary string
+	// Args lists the accepted positional words (e.g. "register", "login").
+	// When set exactly one is required; otherwise positionals are rejected.
+	Args []string
+	// Operands names free-form positional arguments (e.g. "MANIFEST"), used
+	// instead of Args. A name in brackets, such as "[FILE]", is optional and
+	// may only be followed by optional names.
+	Operands []string
+	Flags    []Flag
+	// Exclusive lists groups of flags of which at most one may be given.
+	Exclusive [][]string
+	Run       func(*Values)
+}
+
+// Values is the result of parsing a command line.
+type Values struct {
+	Command  *Command
+	Arg      string   // the positional word, when Command.Args is set
+	Operands []string // the positional arguments, when Command.Operands is set
+	set      map[string][]string
+}
+
+// UsageError reports command-line misuse.
+type UsageError struct {
+	Command string
+	Msg     string
+}
+
+func (e *UsageError) Error() string {
+	if e.Command == "" {
+		return e.Msg
+	}
+	return e.Command + ": " + e.Msg
+}
+
+// ErrHelp is returned by Parse when -h or --help is given.
+var ErrHelp = &UsageError{Msg: "help requested"}
+
+func (c *Command) flag(name string) *Flag {
+	for i := range c.Flags {
+		if c.Flags[i].Name == name {
+			return &c.Flags[i]
+		}
+	}
+	return nil
+}
+
+func (c *Command) usageErr(format string, args ...any) error {
+	return &UsageError{Command: c.Name, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Parse parses args against the command's flag set. Flags are spelled with
+// exactly two dashes (-h is the one exception). Flags and the positional
+// word may be interleaved; "--" ends flag parsing. A value flag followed by
+// another known flag is an error rather than consuming it, so `--pin --json`
+// is reported instead of using "--json" as the PIN; use --pin=VALUE for values
+// that start with "--".
+func (c *Command) Parse(args []string) (*Values, error) {
+	v := &Values{Command: c, set: map[string][]string{}}
+	var pos []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			pos = append(pos, args[i+1:]...)
+			break
+		}
+		if a == "-h" || a == "--help" {
+			return nil, ErrHelp
+		}
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			pos = append(pos, a)
+			continue
+		}
+		if !strings.HasPrefix(a, "--") || strings.HasPrefix(a, "---") {
+			name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+			return nil, c.usageErr("invalid flag %s: flags take two dashes (--%s); use -- before arguments that start with a dash", a, name)
+		}
+		name, val, hasVal := strings.Cut(a[2:], "=")
+		f := c.flag(name)
+		if f == nil {
+			msg := fmt.Sprintf("unknown flag --%s", name)
+			if s := Suggest(name, c.flagNames()); s != "" {
+				msg += fmt.Sprintf(" (did you mean --%s?)", s)
+			}
+			return nil, c.usageErr("%s", msg)
+		}
+		if f.Kind == Bool {
+			if hasVal {
+				b, err := strconv.ParseBool(val)
+				if err != nil {
+					return nil, c.usageErr("invalid value %q for --%s: want true or false", val, name)
+				}
+				if !b {
+					delete(v.set, name)
+					continue
+				}
+			}
+			v.set[name] = []string{"true"}
+			continue
+		}
+		if !hasVal {
+			if i+1 >= len(args) || c.looksLikeFlag(args[i+1]) {
+				return nil, c.usageErr("--%s needs a value (%s)", name, f.placeholder())
+			}
+			i++
+			val = args[i]
+		}
+		switch f.Kind {
+		case Int:
+			if _, err := strconv.Atoi(val); err != nil {
+				return nil, c.usageErr("invalid value %q for --%s: want an integer", val, name)
+			}
+		case Duration:
+			if _, err := time.ParseDuration(val); err != nil {
+				return nil, c.usageErr("invalid value %q for --%s: want a duration such as 30s or 2m", val, name)
+			}
+		}
+		if f.Kind == List {
+			v.set[name] = append(v.set[name], val)
+		} else {
+			if _, dup := v.set[name]; dup {
+				return nil, c.usageErr("--%s given more than once", name)
+			}
+			v.set[name] = []string{val}
+		}
+	}
+
+	if len(c.Operands) > 0 {
+		required := 0
+		for _, o := range c.Operands {
+			if !strings.HasPrefix(o, "[") {
+				required++
+			}
+		}
+		if len(pos) < required || len(pos) > len(c.Operands) {
+			return nil, c.usageErr("expected %s", strings.Join(c.Operands, " "))
+		}
+		v.Operands = pos
+		pos = nil
+	}
+	if len(c.Args) == 0 && len(pos) > 0 {
+		return nil, c.usageErr("unexpected argument %q", pos[0])
+	}
+	if len(c.Args) > 0 {
+		if len(pos) != 1 {
+			return nil, c.usageErr("expected one of: %s", strings.Join(c.Args, ", "))
+		}
+		if !contains(c.Args, pos[0]) {
+			msg := fmt.Sprintf("unknown argument %q; expected one of: %s", pos[0], strings.Join(c.Args, ", "))
+			if s := Suggest(pos[0], c.Args); s != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", s)
+			}
+			return nil, c.usageErr("%s", msg)
+		}
+		v.Arg = pos[0]
+	}
+	for _, group := range c.Exclusive {
+		var given []string
+		for _, name := range group {
+			if v.Has(name) {
+				given = append(given, "--"+name)
+			}
+		}
+		if len(given) > 1 {
+			return nil, c.usageErr("%s are mutually exclusive", strings.Join(given, " and "))
+		}
+	}
+	for _, f := range c.Flags {
+		if f.Required && !v.Has(f.Name) {
+			return nil, c.usageErr("--%s is required", f.Name)
+		}
+	}
+	return v, nil
+}
+
+// looksLikeFlag reports whether s would be parsed as one of c's flags.
+func (c *Command) looksLikeFlag(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	name, _, _ := strings.Cut(s[2:], "=")
+	return c.flag(name) != nil || name == "help"
+}
+
+func (c *Command) flagNames() []string {
+	names := make([]string, 0, len(c.Flags))
+	for _, f := range c.Flags {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func (f *Flag) placeholder() string {
+	if f.Arg != "" {
+		return f.Arg
+	}
+	switch f.Kind {
+	case Int:
+		return "N"
+	case Duration:
+		return "DURATION"
+	}
+	return "VALUE"
+}
+
+// Has reports whether the flag was given.
+func (v *Values) Has(name string) bool {
+	_, ok := v.set[name]
+	return ok
+}
+
+// Args returns the parsed command line in canonical form: the positional
+// word or operands, then each given flag as --name or --name=value in the
+// order the command defines them. Secret flags have their value replaced by
+// "...", however they were spelled on the command line.
+func (v *Values) Args() []string {
+	var out []string
+	if v.Arg != "" {
+		out = append(out, v.Arg)
+	}
+	out = append(out, v.Operands...)
+	for _, f := range v.Command.Flags {
+		for _, val := range v.set[f.Name] {
+			switch {
+			case f.Kind == Bool:
+				out = append(out, "--"+f.Name)
+			case f.Secret:
+				out = append(out, "--"+f.Name+"=...")
+			default:
+				out = append(out, "--"+f.Name+"="+val)
+			}
+		}
+	}
+	return out
+}
+
+// Bool reports whether a boolean flag was given.
+func (v *Values) Bool(name string) bool { return v.Has(name) }
+
+// String returns a flag's value, or "" when absent.
+func (v *Values) String(name string) string {
+	if s := v.set[name]; len(s) > 0 {
+		return s[len(s)-1]
+	}
+	return ""
+}
+
+// Strings returns every value of a repeatable flag.
+func (v *Values) Strings(name string) []string { return v.set[name] }
+
+// Int returns an integer flag's value and whether it was given. Values were
+// validated by Parse.
+func (v *Values) Int(name string) (int, bool) {
+	if !v.Has(name) {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(v.String(name))
+	return n, true
+}
+
+// Duration returns a duration flag's value, or def when absent.
+func (v *Values) Duration(name string, def time.Duration) time.Duration {
+	if !v.Has(name) {
+		return def
+	}
+	d, _ := time.ParseDuration(v.String(name))
+	return d
+}
+
+// Synopsis is the one-line usage of the command.
+func (c *Command) Synopsis() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	if len(c.Args) > 0 {
+		b.WriteString(" " + strings.Join(c.Args, "|"))
+	}
+	for _, o := range c.Operands {
+		b.WriteString(" " + o)
+	}
+	for _, f := range c.Flags {
+		if f.Required {
+			fmt.Fprintf(&b, " --%s %s", f.Name, f.placeholder())
+		}
+	}
+	if len(c.Flags) > 0 {
+		b.WriteString(" [flags]")
+	}
+	return b.String()
+}
+
+// Help writes the command's full help text.
+func (c *Command) Help(w io.Writer, exe string) {
+	fmt.Fprintf(w, "Usage: %s %s\n\n%s\n", exe, c.Synopsis(), c.Summary)
+	if len(c.Flags) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	for _, f := range c.Flags {
+		left := "--" + f.Name
+		if f.Kind != Bool {
+			left += " " + f.placeholder()
+		}
+		usage := f.Usage
+		if f.Kind == List {
+			usage += " (repeatable)"
+		}
+		if f.Required {
+			usage += " (required)"
+		}
+		if f.Default != "" {
+			usage += fmt.Sprintf(" (default %s)", f.Default)
+		}
+		fmt.Fprintf(w, "  %-28s %s\n", left, usage)
+	}
+	for _, group := range c.Exclusive {
+		names := make([]string, len(group))
+		for i, n := range group {
+			names[i] = "--" + n
+		}
+		fmt.Fprintf(w, "\nMutually exclusive: %s\n", strings.Join(names, ", "))
+	}
+}
+
+// Suggest returns the candidate closest to s by edit distance, or "" when
+// nothing is close enough to be a plausible typo.
+func Suggest(s string, candidates []string) string {
+	best, bestDist := "", -1
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+	for _, c := range sorted {
+		d := levenshtein(s, c)
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if bestDist < 0 || bestDist > max(2, len(s)/3) {
+		return ""
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
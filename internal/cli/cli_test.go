@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCmd has one flag of each kind and a secret.
+var testCmd = &Command{
+	Name: "test",
+	Flags: []Flag{
+		{Name: "rp"},
+		{Name: "json", Kind: Bool},
+		{Name: "count", Kind: Int},
+		{Name: "timeout", Kind: Duration},
+		{Name: "allow", Kind: List},
+		{Name: "pin", Secret: true},
+		{Name: "pin-file"},
+	},
+	Exclusive: [][]string{{"pin", "pin-file"}},
+}
+
+func TestParseValues(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		flag string
+		want string
+	}{
+		{"separate", []string{"--rp", "example.com"}, "rp", "example.com"},
+		{"equals", []string{"--rp=example.com"}, "rp", "example.com"},
+		{"equals empty", []string{"--rp="}, "rp", ""},
+		{"equals in value", []string{"--rp=a=b"}, "rp", "a=b"},
+		{"dash value", []string{"--rp", "-"}, "rp", "-"},
+		{"negative int", []string{"--count", "-1"}, "count", "-1"},
+		{"value like unknown flag", []string{"--rp", "--nope"}, "rp", "--nope"},
+		{"flag-like value with equals", []string{"--rp=--json"}, "rp", "--json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := testCmd.Parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v.Has(tt.flag) || v.String(tt.flag) != tt.want {
+				t.Errorf("--%s = %q (given %v), want %q", tt.flag, v.String(tt.flag), v.Has(tt.flag), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTyped(t *testing.T) {
+	v, err := testCmd.Parse([]string{"--count", "3", "--timeout=90s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := v.Int("count"); !ok || n != 3 {
+		t.Errorf("Int = %d, %v", n, ok)
+	}
+	if d := v.Duration("timeout", 0); d != 90*time.Second {
+		t.Errorf("Duration = %s", d)
+	}
+	if n, ok := v.Int("missing"); ok || n != 0 {
+		t.Errorf("absent Int = %d, %v", n, ok)
+	}
+	if d := v.Duration("missing", time.Minute); d != time.Minute {
+		t.Errorf("absent Duration = %s, want the default", d)
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"--json"}, true},
+		{[]string{"--json=true"}, true},
+		{[]string{"--json=false"}, false},
+		{[]string{"--json", "--json=false"}, false},
+		{[]string{"--json=0", "--json=1"}, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		v, err := testCmd.Parse(tt.args)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if v.Bool("json") != tt.want {
+			t.Errorf("%v: json = %v, want %v", tt.args, v.Bool("json"), tt.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	v, err := testCmd.Parse([]string{"--allow", "a", "--allow=b", "--allow", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Strings("allow"); !slices.Equal(got, []string{"a", "b", "a"}) {
+		t.Errorf("Strings = %q", got)
+	}
+	if got := v.String("allow"); got != "a" {
+		t.Errorf("String = %q, want the last value", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"single dash", []string{"-rp", "x"}, "invalid flag -rp: flags take two dashes (--rp)"},
+		{"single dash equals", []string{"-pin=1234"}, "invalid flag -pin=1234"},
+		{"three dashes", []string{"---json"}, "invalid flag ---json"},
+		{"dash positional", []string{"-5"}, "invalid flag -5"},
+		{"unknown", []string{"--nope"}, "unknown flag --nope"},
+		{"typo", []string{"--jsno"}, "unknown flag --jsno (did you mean --json?)"},
+		{"no suggestion", []string{"--zzzzzzzz"}, "unknown flag --zzzzzzzz"},
+		{"missing value", []string{"--rp"}, "--rp needs a value (VALUE)"},
+		{"value is a flag", []string{"--rp", "--json"}, "--rp needs a value"},
+		{"value is help", []string{"--rp", "--help"}, "--rp needs a value"},
+		{"bad int", []string{"--count", "three"}, `invalid value "three" for --count: want an integer`},
+		{"bad duration", []string{"--timeout=5"}, `invalid value "5" for --timeout`},
+		{"bad bool", []string{"--json=maybe"}, `invalid value "maybe" for --json: want true or false`},
+		{"repeated", []string{"--rp", "a", "--rp", "b"}, "--rp given more than once"},
+		{"exclusive", []string{"--pin-file", "f", "--pin", "1"}, "--pin and --pin-file are mutually exclusive"},
+		{"positional", []string{"extra"}, `unexpected argument "extra"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testCmd.Parse(tt.args)
+			var ue *UsageError
+			if !errors.As(err, &ue) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse(%q) = %v, want a usage error containing %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHelp(t *testing.T) {
+	for _, a := range []string{"-h", "--help"} {
+		if _, err := testCmd.Parse([]string{"--json", a}); err != ErrHelp {
+			t.Errorf("%s: err = %v, want ErrHelp", a, err)
+		}
+	}
+	if _, err := testCmd.Parse([]string{"--", "-h"}); err == nil || err == ErrHelp {
+		t.Errorf("-h after --: err = %v, want an unexpected argument", err)
+	}
+}
+
+func TestParseRequired(t *testing.T) {
+	c := &Command{Name: "req", Flags: []Flag{{Name: "rp", Required: true}, {Name: "json", Kind: Bool}}}
+	if _, err := c.Parse([]string{"--json"}); err == nil || !strings.Contains(err.Error(), "--rp is required") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := c.Parse([]string{"--rp", "x"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	c := &Command{Name: "ceremony", Args: []string{"register", "login"}, Flags: []Flag{{Name: "json", Kind: Bool}}}
+	v, err := c.Parse([]string{"--json", "login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Arg != "login" || !v.Bool("json") {
+		t.Errorf("Arg = %q, json = %v", v.Arg, v.Bool("json"))
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "expected one of: register, login"},
+		{[]string{"register", "login"}, "expected one of"},
+		{[]string{"regster"}, `unknown argument "regster"; expected one of: register, login (did you mean register?)`},
+	} {
+		if _, err := c.Parse(tt.args); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestParseOperands(t *testing.T) {
+	c := &Command{Name: "apply", Operands: []string{"MANIFEST", "[OUT]"}, Flags: []Flag{{Name: "dry-run", Kind: Bool}}}
+	tests := []struct {
+		args []string
+		want []string
+		err  string
+	}{
+		{[]string{"m.yaml"}, []string{"m.yaml"}, ""},
+		{[]string{"m.yaml", "--dry-run", "out.json"}, []string{"m.yaml", "out.json"}, ""},
+		{[]string{"--dry-run", "--", "-m.yaml"}, []string{"-m.yaml"}, ""},
+		{[]string{"--", "m.yaml", "--dry-run"}, []string{"m.yaml", "--dry-run"}, ""},
+		{nil, nil, "expected MANIFEST [OUT]"},
+		{[]string{"a", "b", "c"}, nil, "expected MANIFEST [OUT]"},
+	}
+	for _, tt := range tests {
+		v, err := c.Parse(tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse(%q) = %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.args, err)
+			continue
+		}
+		if !slices.Equal(v.Operands, tt.want) {
+			t.Errorf("Parse(%q) operands = %q, want %q", tt.args, v.Operands, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	names := []string{"pin", "pin-file", "pin-stdin", "timeout"}
+	for s, want := range map[string]string{"pni": "pin", "pin-fil": "pin-file", "timout": "timeout", "xyz": "", "pinstdin": "pin-stdin"} {
+		if got := Suggest(s, names); got != want {
+			t.Errorf("Suggest(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	v, err := testCmd.Parse([]string{"--pin", "1234", "--allow=a", "--json", "--rp", "example.com", "--allow", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--rp=example.com", "--json", "--allow=a", "--allow=b", "--pin=..."}
+	if got := v.Args(); !slices.Equal(got, want) {
+		t.Errorf("Args = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+// Package clientpolicy applies the WebAuthn client rules a browser enforces
+// before it will run a ceremony: the origin must be a secure context and the
+// RP ID must be a registrable suffix of the origin's host, or the RP must list
+// the origin in its Related Origin Requests document.
+package clientpolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxRelatedOriginLabels is the number of distinct registrable-domain labels a
+// client must honour from a /.well-known/webauthn document.
+const maxRelatedOriginLabels = 5
+
+// Policy controls how strictly origins and RP IDs are validated.
+type Policy struct {
+	// AllowInsecureOrigin permits http:// and IP-literal origins.
+	AllowInsecureOrigin bool
+	// RelatedOriginsURL is the base URL used to fetch /.well-known/webauthn.
+	// Empty means https://<rpID>. Set to "-" to disable Related Origin Requests.
+	RelatedOriginsURL string
+	// HTTP is used for Related Origin Requests; nil means a client with a short timeout.
+	HTTP *http.Client
+}
+
+// Result describes how an origin was accepted.
+type Result struct {
+	Origin        string `json:"origin"`
+	RPID          string `json:"rpId"`
+	SecureContext bool   `json:"secureContext"`
+	// RelatedOrigin is true when the match came from /.well-known/webauthn.
+	RelatedOrigin bool `json:"relatedOrigin"`
+}
+
+// Error is a client-rule violation.
+type Error struct {
+	Origin string
+	RPID   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("origin %q not valid for RP ID %q: %s", e.Origin, e.RPID, e.Reason)
+}
+
+// DefaultOrigin returns the origin a browser on the RP's own site would use.
+func DefaultOrigin(rpID string) string {
+	if strings.Contains(rpID, "://") {
+		return rpID
+	}
+	return "https://" + rpID
+}
+
+// Check validates that origin may run a ceremony for rpID.
+func (p Policy) Check(rpID, origin string) (*Result, error) {
+	rpID = strings.ToLower(strings.TrimSuffix(rpID, "."))
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, &Error{origin, rpID, "not an absolute origin (want scheme://host[:port])"}
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, &Error{origin, rpID, "origin must not include a path, query or fragment"}
+	}
+	host := strings.ToLower(u.Hostname())
+	res := &Result{Origin: origin, RPID: rpID}
+
+	secure, reason := secureContext(u.Scheme, host)
+	res.SecureContext = secure
+	if !secure && !p.AllowInsecureOrigin {
+		return nil, &Error{origin, rpID, reason + " (use --allow-insecure-origin to override)"}
+	}
+	if net.ParseIP(rpID) != nil && !p.AllowInsecureOrigin {
+		return nil, &Error{origin, rpID, "RP ID must be a domain, not an IP address"}
+	}
+
+	if err := registrableSuffix(host, rpID); err == nil {
+		return res, nil
+	} else if p.RelatedOriginsURL == "-" {
+		return nil, &Error{origin, rpID, err.Error()}
+	} else if rerr := p.checkRelated(rpID, origin); rerr != nil {
+		return nil, &Error{origin, rpID, err.Error() + "; related origins: " + rerr.Error()}
+	}
+	res.RelatedOrigin = true
+	return res, nil
+}
+
+// secureContext reports whether scheme/host form a potentially trustworthy origin.
+func secureContext(scheme, host string) (bool, string) {
+	if net.ParseIP(host) != nil {
+		return false, "IP-address origins are not valid for WebAuthn"
+	}
+	switch scheme {
+	case "https":
+		return true, ""
+	case "http":
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return true, ""
+		}
+		return false, "insecure http origin"
+	}
+	return false, fmt.Sprintf("unsupported scheme %q", scheme)
+}
+
+// registrableSuffix checks that rpID equals host or is a parent domain of it,
+// and that rpID is not itself a public suffix.
+func registrableSuffix(host, rpID string) error {
+	if rpID == "" {
+		return errors.New("empty RP ID")
+	}
+	if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+		return errors.New("RP ID is not a registrable suffix of the origin host")
+	}
+	if rpID == "localhost" || net.ParseIP(rpID) != nil {
+		return nil
+	}
+	etld1, err := publicsuffix.EffectiveTLDPlusOne(rpID)
+	if err != nil {
+		return fmt.Errorf("RP ID is a public suffix: %v", err)
+	}
+	if rpID != etld1 && !strings.HasSuffix(rpID, "."+etld1) {
+		return errors.New("RP ID is a public suffix")
+	}
+	return nil
+}
+
+// relatedOrigins is the /.well-known/webauthn document.
+type relatedOrigins struct {
+	Origins []string `json:"origins"`
+}
+
+// checkRelated fetches the RP's Related Origin Requests document and applies
+// the label-limited matching algorithm from WebAuthn Level 3.
+func (p Policy) checkRelated(rpID, origin string) error {
+	base := p.RelatedOriginsURL
+	if base == "" {
+		base = "https://" + rpID
+	}
+	doc, err := p.fetchRelated(strings.TrimRight(base, "/") + "/.well-known/webauthn")
+	if err != nil {
+		return err
+	}
+	labels := map[string]bool{}
+	for _, o := range doc.Origins {
+		ou, err := url.Parse(o)
+		if err != nil || ou.Host == "" {
+			continue
+		}
+		label := originLabel(ou.Hostname())
+		if label == "" {
+			continue
+		}
+		if len(labels) >= maxRelatedOriginLabels && !labels[label] {
+			continue
+		}
+		labels[label] = true
+		if sameOrigin(o, origin) {
+			return nil
+		}
+	}
+	return errors.New("origin not listed in /.well-known/webauthn")
+}
+
+func (p Policy) fetchRelated(target string) (*relatedOrigins, error) {
+	client := p.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Get(target)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		return nil, fmt.Errorf("GET %s: content type %q, want application/json", target, ct)
+	}
+	var doc relatedOrigins
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("GET %s: %w", target, err)
+	}
+	return &doc, nil
+}
+
+// originLabel returns the registrable domain minus its public suffix
+// (e.g. "example" for login.example.co.uk).
+func originLabel(host string) string {
+	etld1, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return ""
+	}
+	suffix, _ := publicsuffix.PublicSuffix(etld1)
+	return strings.TrimSuffix(strings.TrimSuffix(etld1, suffix), ".")
+}
+
+// sameOrigin compares scheme, host and port, treating default ports as equal.
+func sameOrigin(a, b string) bool {
+	ua, err1 := url.Parse(a)
+	ub, err2 := url.Parse(b)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Hostname(), ub.Hostname()) &&
+		effectivePort(ua) == effectivePort(ub)
+}
+
+func effectivePort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	switch u.Scheme {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return ""
+}
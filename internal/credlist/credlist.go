@@ -0,0 +1,143 @@
+// Package credlist parses credential ID lists (allowCredentials /
+// excludeCredentials) from the command line and splits them into batches an
+// authenticator will accept.
+package credlist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultMaxCount is used when the authenticator's maxCredentialCountInList is
+// unknown; one ID per request is accepted by every CTAP2 authenticator.
+const DefaultMaxCount = 1
+
+// Limits are the authenticator's list limits from getInfo. Zero means unknown.
+type Limits struct {
+	MaxCount int // maxCredentialCountInList
+	MaxIDLen int // maxCredentialIdLength
+}
+
+// Parse decodes credential IDs from flag values. Each value is one of:
+//
+//	hex:<hex>     explicit hex
+//	b64:<b64url>  explicit base64url (padded or not; standard alphabet accepted)
+//	@<file>       file with one ID per line (blank lines and # comments ignored)
+//	<id>          hex if it is valid even-length hex, otherwise base64url
+func Parse(values []string) ([][]byte, error) {
+	var out [][]byte
+	for _, v := range values {
+		if path, ok := strings.CutPrefix(v, "@"); ok {
+			ids, err := parseFile(path)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ids...)
+			continue
+		}
+		id, err := ParseID(v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return dedupe(out), nil
+}
+
+// ParseID decodes a single credential ID (see Parse for accepted forms).
+func ParseID(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if v, ok := strings.CutPrefix(s, "hex:"); ok {
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("credential ID %q: %w", s, err)
+		}
+		return b, nil
+	}
+	if v, ok := strings.CutPrefix(s, "b64:"); ok {
+		return decodeB64(s, v)
+	}
+	if b, err := hex.DecodeString(s); err == nil && len(b) > 0 {
+		return b, nil
+	}
+	return decodeB64(s, s)
+}
+
+func decodeB64(orig, v string) ([]byte, error) {
+	v = strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(v, "="))
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("credential ID %q: not valid hex or base64url", orig)
+	}
+	return b, nil
+}
+
+func parseFile(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out [][]byte
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		t := strings.TrimSpace(sc.Text())
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		id, err := ParseID(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		out = append(out, id)
+	}
+	return out, sc.Err()
+}
+
+// Batch splits ids into lists of at most lim.MaxCount entries (DefaultMaxCount
+// when unknown). IDs longer than lim.MaxIDLen are returned in skipped, since the
+// authenticator cannot have issued them.
+func Batch(ids [][]byte, lim Limits) (batches [][][]byte, skipped [][]byte) {
+	n := lim.MaxCount
+	if n <= 0 {
+		n = DefaultMaxCount
+	}
+	var cur [][]byte
+	for _, id := range ids {
+		if lim.MaxIDLen > 0 && len(id) > lim.MaxIDLen {
+			skipped = append(skipped, id)
+			continue
+		}
+		cur = append(cur, id)
+		if len(cur) == n {
+			batches = append(batches, cur)
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		batches = append(batches, cur)
+	}
+	return batches, skipped
+}
+
+func dedupe(ids [][]byte) [][]byte {
+	var out [][]byte
+	for _, id := range ids {
+		dup := false
+		for _, prev := range out {
+			if bytes.Equal(prev, id) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			out = append(out, id)
+		}
+	}
+	return out
+}
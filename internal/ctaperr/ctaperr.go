@@ -0,0 +1,216 @@
+// Package ctaperr is the CLI's error taxonomy: CTAP2 status codes and local
+// failures mapped to stable names, documented process exit codes and a JSON
+// error object scripts can branch on.
+package ctaperr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is a stable, script-facing error name.
+type Code string
+
+// Error codes. Names follow the CTAP2 status names where one exists.
+const (
+	Other                Code = "OTHER"
+	Usage                Code = "USAGE"
+	NoDevice             Code = "NO_DEVICE"
+	Transport            Code = "TRANSPORT"
+	NotFIDO2             Code = "NOT_FIDO2"
+	PINInvalid           Code = "PIN_INVALID"
+	PINBlocked           Code = "PIN_BLOCKED"
+	PINAuthInvalid       Code = "PIN_AUTH_INVALID"
+	PINAuthBlocked       Code = "PIN_AUTH_BLOCKED"
+	PINNotSet            Code = "PIN_NOT_SET"
+	PINRequired          Code = "PIN_REQUIRED"
+	PINPolicyViolation   Code = "PIN_POLICY_VIOLATION"
+	UVBlocked            Code = "UV_BLOCKED"
+	UVInvalid            Code = "UV_INVALID"
+	NoCredentials        Code = "NO_CREDENTIALS"
+	CredentialExcluded   Code = "CREDENTIAL_EXCLUDED"
+	KeyStoreFull         Code = "KEY_STORE_FULL"
+	InvalidCredential    Code = "INVALID_CREDENTIAL"
+	OperationDenied      Code = "OPERATION_DENIED"
+	NotAllowed           Code = "NOT_ALLOWED"
+	KeepaliveCancel      Code = "KEEPALIVE_CANCEL"
+	ActionTimeout        Code = "ACTION_TIMEOUT"
+	UPRequired           Code = "UP_REQUIRED"
+	UnsupportedOption    Code = "UNSUPPORTED_OPTION"
+	UnsupportedAlgorithm Code = "UNSUPPORTED_ALGORITHM"
+	InvalidParameter     Code = "INVALID_PARAMETER"
+	RPError              Code = "RP_ERROR"
+	OriginRejected       Code = "ORIGIN_REJECTED"
+	AuditBroken          Code = "AUDIT_BROKEN"
+	PolicyFailed         Code = "POLICY_FAILED"
+	SnapshotDiffers      Code = "SNAPSHOT_DIFFERS"
+	ReplayMismatch       Code = "REPLAY_MISMATCH"
+	Cancelled            Code = "CANCELLED"
+	TimedOut             Code = "TIMED_OUT"
+)
+
+// exitCodes is the documented, stable exit code for each Code.
+var exitCodes = map[Code]int{
+	Other:                1,
+	Usage:                2,
+	NoDevice:             3,
+	Transport:            4,
+	NotFIDO2:             5,
+	PINInvalid:           10,
+	PINBlocked:           11,
+	PINAuthBlocked:       12,
+	PINNotSet:            13,
+	PINRequired:          14,
+	PINPolicyViolation:   15,
+	UVBlocked:            16,
+	UVInvalid:            17,
+	PINAuthInvalid:       18,
+	NoCredentials:        20,
+	CredentialExcluded:   21,
+	KeyStoreFull:         22,
+	InvalidCredential:    23,
+	OperationDenied:      30,
+	NotAllowed:           31,
+	KeepaliveCancel:      32,
+	ActionTimeout:        33,
+	UPRequired:           34,
+	UnsupportedOption:    40,
+	UnsupportedAlgorithm: 41,
+	InvalidParameter:     42,
+	RPError:              50,
+	OriginRejected:       51,
+	AuditBroken:          60,
+	PolicyFailed:         61,
+	SnapshotDiffers:      62,
+	ReplayMismatch:       63,
+	// Local cancellation uses the shell conventions: 124 as timeout(1),
+	// 130 as a process killed by SIGINT.
+	TimedOut:  124,
+	Cancelled: 130,
+}
+
+// statusCodes maps CTAP2 status bytes to codes.
+var statusCodes = map[int]Code{
+	0x01: InvalidParameter, // CTAP1_ERR_INVALID_COMMAND
+	0x02: InvalidParameter, // CTAP1_ERR_INVALID_PARAMETER
+	0x03: InvalidParameter, // CTAP1_ERR_INVALID_LENGTH
+	0x14: InvalidParameter, // CTAP2_ERR_MISSING_PARAMETER
+	0x19: CredentialExcluded,
+	0x22: InvalidCredential,
+	0x26: UnsupportedAlgorithm,
+	0x27: OperationDenied,
+	0x28: KeyStoreFull,
+	0x2B: UnsupportedOption,
+	0x2C: InvalidParameter, // CTAP2_ERR_INVALID_OPTION
+	0x2D: KeepaliveCancel,
+	0x2E: NoCredentials,
+	0x2F: ActionTimeout,
+	0x30: NotAllowed,
+	0x31: PINInvalid,
+	0x32: PINBlocked,
+	0x33: PINAuthInvalid,
+	0x34: PINAuthBlocked,
+	0x35: PINNotSet,
+	0x36: PINRequired,
+	0x37: PINPolicyViolation,
+	0x3B: UPRequired,
+	0x3C: UVBlocked,
+	0x3F: UVInvalid,
+}
+
+// hints are the default remediation text per code.
+var hints = map[Code]string{
+	NoDevice:             "Check the key is plugged in and readable (udev rules / permissions); run `fit list`.",
+	Transport:            "Communication with the key failed; re-insert it and retry.",
+	NotFIDO2:             "The device does not speak CTAP2; only FIDO2 keys are supported.",
+	PINInvalid:           "Wrong PIN; each failure decrements the retry counter (see `fit info`).",
+	PINBlocked:           "PIN retries exhausted; the key must be factory reset (`fit reset`), which deletes all credentials.",
+	PINAuthInvalid:       "PIN/UV auth token rejected; retry the operation.",
+	PINAuthBlocked:       "Too many consecutive PIN failures; remove and re-insert the key before retrying.",
+	PINNotSet:            "The key has no PIN yet; set one with `fit set-pin`.",
+	PINRequired:          "This operation requires the PIN (--pin-stdin, --pin-file, --pin-fd, FIT_PIN or prompt).",
+	PINPolicyViolation:   "PIN rejected by policy (length/complexity). Try a longer PIN (>=4, preferably 6+ digits).",
+	UVBlocked:            "Built-in user verification is blocked; use the PIN instead.",
+	UVInvalid:            "User verification failed (e.g. fingerprint not recognised); retry.",
+	NoCredentials:        "No matching credential on this key for the RP; create one with `fit add-passkey`.",
+	CredentialExcluded:   "The key already holds a credential the RP excluded (already registered).",
+	KeyStoreFull:         "No space for more resident credentials; delete some or use a non-resident credential.",
+	InvalidCredential:    "The credential ID was not issued by this key for this RP.",
+	OperationDenied:      "The user or authenticator declined the operation.",
+	NotAllowed:           "Operation not allowed in the current state (e.g. reset outside the power-up window).",
+	KeepaliveCancel:      "The operation was cancelled.",
+	ActionTimeout:        "Timed out waiting for user presence; touch the key sooner.",
+	UPRequired:           "User presence (touch) is required.",
+	UnsupportedOption:    "The key does not support a requested option.",
+	UnsupportedAlgorithm: "The key does not support the requested algorithm.",
+	InvalidParameter:     "The key rejected the request parameters.",
+	RPError:              "The relying party rejected the request or returned unusable options; see the step log.",
+	TimedOut:             "No answer (or touch) within --timeout; the request was cancelled on the key. Retry with a longer --timeout.",
+	Cancelled:            "Interrupted; the pending request was cancelled on the key.",
+	OriginRejected:       "The origin is not valid for this RP ID; see --origin, --allow-insecure-origin and --related-origins-url.",
+	PolicyFailed:         "The key does not meet the policy; see the failed rules.",
+	SnapshotDiffers:      "The key changed since the snapshot; see the listed changes.",
+	ReplayMismatch:       "The run did not send the recorded requests: fit, libfido2 or the command line changed since the session was recorded.",
+	AuditBroken:          "The audit log's hash chain is broken: entries were edited, removed or reordered. Compare it with a backup or an earlier recorded head hash.",
+}
+
+// Error is a classified failure.
+type Error struct {
+	Code       Code   `json:"code" doc:"Stable error code, e.g. PIN_INVALID."`
+	CTAPStatus int    `json:"ctapStatus,omitempty" doc:"CTAP2 status byte; absent when not reported by the authenticator."`
+	Op         string `json:"op,omitempty" doc:"Operation that failed."`
+	Message    string `json:"message"`
+	Hint       string `json:"hint,omitempty" doc:"Suggested remediation."`
+	ExitCode   int    `json:"exitCode" doc:"Process exit status."`
+	Err        error  `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Op != "" {
+		return e.Op + ": " + e.Message
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// New classifies err under code for operation op, filling the exit code and default hint.
+func New(code Code, status int, op string, err error) *Error {
+	e := &Error{Code: code, CTAPStatus: status, Op: op, Err: err, Hint: hints[code], ExitCode: ExitCode(code)}
+	if err != nil {
+		e.Message = err.Error()
+	} else {
+		e.Message = string(code)
+	}
+	return e
+}
+
+// Newf is New with a formatted message and no underlying error.
+func Newf(code Code, op, format string, args ...any) *Error {
+	return New(code, 0, op, fmt.Errorf(format, args...))
+}
+
+// FromStatus classifies a CTAP2 status byte.
+func FromStatus(status int, op string, err error) *Error {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = Other
+	}
+	return New(code, status, op, err)
+}
+
+// ExitCode returns the documented process exit code for code.
+func ExitCode(code Code) int {
+	if n, ok := exitCodes[code]; ok {
+		return n
+	}
+	return 1
+}
+
+// As returns err as an *Error if it is (or wraps) one.
+func As(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
@@ -0,0 +1,322 @@
+package ctaptrace
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fit/internal/ctaperr"
+	"fit/internal/rp"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CTAPHID commands (without the frame-init bit).
+var hidCommands = map[byte]string{
+	0x01: "PING",
+	0x03: "MSG",
+	0x04: "LOCK",
+	0x06: "INIT",
+	0x08: "WINK",
+	0x10: "CBOR",
+	0x11: "CANCEL",
+	0x3b: "KEEPALIVE",
+	0x3f: "ERROR",
+}
+
+const hidCBOR = 0x10
+
+// command is a CTAP2 command with the names of its parameter and response
+// map keys.
+type command struct {
+	name       string
+	req, resp  map[uint64]string
+	authData   uint64 // response key holding authenticator data, or 0
+	extensions uint64 // request key holding extension inputs, or 0
+}
+
+var (
+	getAssertionResp = map[uint64]string{1: "credential", 2: "authData", 3: "signature", 4: "user", 5: "numberOfCredentials", 6: "userSelected", 7: "largeBlobKey"}
+	credMgmtReq      = map[uint64]string{1: "subCommand", 2: "subCommandParams", 3: "pinUvAuthProtocol", 4: "pinUvAuthParam"}
+	credMgmtResp     = map[uint64]string{1: "existingResidentCredentialsCount", 2: "maxPossibleRemainingResidentCredentialsCount", 3: "rp", 4: "rpIDHash", 5: "totalRPs", 6: "user", 7: "credentialID", 8: "publicKey", 9: "totalCredentials", 10: "credProtect", 11: "largeBlobKey"}
+	bioReq           = map[uint64]string{1: "modality", 2: "subCommand", 3: "subCommandParams", 4: "pinUvAuthProtocol", 5: "pinUvAuthParam", 6: "getModality"}
+	bioResp          = map[uint64]string{1: "modality", 2: "fingerprintKind", 3: "maxCaptureSamplesRequiredForEnroll", 4: "templateId", 5: "lastEnrollSampleStatus", 6: "remainingSamples", 7: "templateInfos", 8: "maxTemplateFriendlyName"}
+)
+
+var commands = map[byte]command{
+	0x01: {
+		name:       "authenticatorMakeCredential",
+		req:        map[uint64]string{1: "clientDataHash", 2: "rp", 3: "user", 4: "pubKeyCredParams", 5: "excludeList", 6: "extensions", 7: "options", 8: "pinUvAuthParam", 9: "pinUvAuthProtocol", 10: "enterpriseAttestation"},
+		resp:       map[uint64]string{1: "fmt", 2: "authData", 3: "attStmt", 4: "epAtt", 5: "largeBlobKey"},
+		authData:   2,
+		extensions: 6,
+	},
+	0x02: {
+		name:       "authenticatorGetAssertion",
+		req:        map[uint64]string{1: "rpId", 2: "clientDataHash", 3: "allowList", 4: "extensions", 5: "options", 6: "pinUvAuthParam", 7: "pinUvAuthProtocol"},
+		resp:       getAssertionResp,
+		authData:   2,
+		extensions: 4,
+	},
+	0x04: {
+		name: "authenticatorGetInfo",
+		resp: map[uint64]string{1: "versions", 2: "extensions", 3: "aaguid", 4: "options", 5: "maxMsgSize", 6: "pinUvAuthProtocols", 7: "maxCredentialCountInList", 8: "maxCredentialIdLength", 9: "transports", 10: "algorithms", 11: "maxSerializedLargeBlobArray", 12: "forcePINChange", 13: "minPINLength", 14: "firmwareVersion", 15: "maxCredBlobLength", 16: "maxRPIDsForSetMinPINLength", 17: "preferredPlatformUvAttempts", 18: "uvModality", 19: "certifications", 20: "remainingDiscoverableCredentials", 21: "vendorPrototypeConfigCommands"},
+	},
+	0x06: {
+		name: "authenticatorClientPIN",
+		req:  map[uint64]string{1: "pinUvAuthProtocol", 2: "subCommand", 3: "keyAgreement", 4: "pinUvAuthParam", 5: "newPinEnc", 6: "pinHashEnc", 9: "permissions", 10: "rpId"},
+		resp: map[uint64]string{1: "keyAgreement", 2: "pinUvAuthToken", 3: "pinRetries", 4: "powerCycleState", 5: "uvRetries"},
+	},
+	0x07: {name: "authenticatorReset"},
+	0x08: {name: "authenticatorGetNextAssertion", resp: getAssertionResp, authData: 2},
+	0x09: {name: "authenticatorBioEnrollment", req: bioReq, resp: bioResp},
+	0x0a: {name: "authenticatorCredentialManagement", req: credMgmtReq, resp: credMgmtResp},
+	0x0b: {name: "authenticatorSelection"},
+	0x0c: {
+		name: "authenticatorLargeBlobs",
+		req:  map[uint64]string{1: "get", 2: "set", 3: "offset", 4: "length", 5: "pinUvAuthParam", 6: "pinUvAuthProtocol"},
+		resp: map[uint64]string{1: "config"},
+	},
+	0x0d: {name: "authenticatorConfig", req: map[uint64]string{1: "subCommand", 2: "subCommandParams", 3: "pinUvAuthProtocol", 4: "pinUvAuthParam"}},
+	0x40: {name: "authenticatorBioEnrollmentPreview", req: bioReq, resp: bioResp},
+	0x41: {name: "authenticatorCredentialManagementPreview", req: credMgmtReq, resp: credMgmtResp},
+}
+
+// secretFields are the map keys whose values are redacted: PIN material,
+// the tokens and MACs derived from it, and large-blob keys.
+var secretFields = map[string]bool{
+	"pinUvAuthParam": true,
+	"newPinEnc":      true,
+	"pinHashEnc":     true,
+	"pinUvAuthToken": true,
+	"largeBlobKey":   true,
+}
+
+// secretExtensions are the extensions whose inputs and outputs are redacted:
+// hmac-secret salts and outputs are as sensitive as the secrets they derive.
+var secretExtensions = map[string]bool{
+	"hmac-secret":    true,
+	"hmac-secret-mc": true,
+}
+
+func hidName(cmd byte) string {
+	if n, ok := hidCommands[cmd]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%02x", cmd)
+}
+
+// Request describes a CTAPHID request payload as a trace line would,
+// without redaction.
+func Request(hid byte, b []byte) string {
+	return (&Tracer{secrets: true}).request(hid, b)
+}
+
+// request describes a CTAPHID request payload.
+func (t *Tracer) request(hid byte, b []byte) string {
+	if hid != hidCBOR || len(b) == 0 {
+		return fmt.Sprintf("%s %s", hidName(hid), rawBytes(b))
+	}
+	t.lastCmd = b[0]
+	c, ok := commands[b[0]]
+	name := c.name
+	if !ok {
+		name = "command"
+	}
+	s := fmt.Sprintf("CBOR %s(0x%02x)", name, b[0])
+	if len(b) > 1 {
+		s += " " + t.body(b[1:], c.req, c, false)
+	}
+	return s
+}
+
+// response describes a CTAPHID response payload to the last request; note
+// (the latency) follows the command and status.
+func (t *Tracer) response(hid byte, b []byte, note string) string {
+	if hid != hidCBOR || len(b) == 0 {
+		return fmt.Sprintf("%s%s %s", hidName(hid), note, rawBytes(b))
+	}
+	s := "CBOR " + statusName(b[0]) + note
+	if len(b) > 1 {
+		c := commands[t.lastCmd]
+		s += " " + t.body(b[1:], c.resp, c, true)
+	}
+	return s
+}
+
+// statusName names a CTAP2 status byte by its fit error code.
+func statusName(st byte) string {
+	if st == 0 {
+		return "0x00 OK"
+	}
+	code := ctaperr.FromStatus(int(st), "", nil).Code
+	if code == ctaperr.Other {
+		return fmt.Sprintf("0x%02x", st)
+	}
+	return fmt.Sprintf("0x%02x %s", st, code)
+}
+
+// body decodes a CBOR parameter or response map and formats it with field
+// names, redacting secrets unless t.secrets is set.
+func (t *Tracer) body(b []byte, names map[uint64]string, c command, resp bool) string {
+	var v any
+	if err := cbor.Unmarshal(b, &v); err != nil {
+		return fmt.Sprintf("(not CBOR: %v) %s", err, rawBytes(b))
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return t.value(v)
+	}
+	var parts []string
+	for _, k := range sortedKeys(m) {
+		val := m[k]
+		n, isInt := k.(uint64)
+		name := names[n]
+		label := t.value(k)
+		if isInt && name != "" {
+			label = fmt.Sprintf("%s(%d)", name, n)
+		}
+		var s string
+		switch {
+		case !t.secrets && secretFields[name]:
+			s = redacted(val)
+		case resp && isInt && n == c.authData && c.authData != 0:
+			s = t.authData(val)
+		case !resp && isInt && n == c.extensions && c.extensions != 0:
+			s = t.extensions(val)
+		default:
+			s = t.value(val)
+		}
+		parts = append(parts, label+": "+s)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// authData formats authenticator data by its fields.
+func (t *Tracer) authData(v any) string {
+	b, ok := v.([]byte)
+	if !ok {
+		return t.value(v)
+	}
+	ad, err := rp.ParseAuthenticatorData(b)
+	if err != nil {
+		return rawBytes(b)
+	}
+	var flags []string
+	for _, f := range []struct {
+		bit  byte
+		name string
+	}{{rp.FlagUP, "UP"}, {rp.FlagUV, "UV"}, {rp.FlagBE, "BE"}, {rp.FlagBS, "BS"}, {rp.FlagAT, "AT"}, {rp.FlagED, "ED"}} {
+		if ad.Flags&f.bit != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	parts := []string{
+		"rpIdHash: " + rawBytes(ad.RPIDHash),
+		"flags: " + strings.Join(flags, "|"),
+		"signCount: " + strconv.FormatUint(uint64(ad.SignCount), 10),
+	}
+	if ad.Flags&rp.FlagAT != 0 {
+		parts = append(parts, "aaguid: "+rawBytes(ad.AAGUID), "credentialId: "+rawBytes(ad.CredID), "publicKey: "+rawBytes(ad.PublicKey))
+	}
+	if ad.Extensions != nil {
+		m := map[any]any{}
+		for k, v := range ad.Extensions {
+			m[k] = v
+		}
+		parts = append(parts, "extensions: "+t.extensions(m))
+	}
+	return "authData{" + strings.Join(parts, ", ") + "}"
+}
+
+// extensions formats an extensions map, redacting hmac-secret values.
+func (t *Tracer) extensions(v any) string {
+	m, ok := v.(map[any]any)
+	if !ok || t.secrets {
+		return t.value(v)
+	}
+	var parts []string
+	for _, k := range sortedKeys(m) {
+		s := t.value(m[k])
+		if name, _ := k.(string); secretExtensions[name] {
+			if _, isBool := m[k].(bool); !isBool {
+				s = redacted(m[k])
+			}
+		}
+		parts = append(parts, t.value(k)+": "+s)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// value formats a decoded CBOR value in diagnostic notation.
+func (t *Tracer) value(v any) string {
+	switch v := v.(type) {
+	case []byte:
+		return rawBytes(v)
+	case string:
+		return strconv.Quote(v)
+	case []any:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = t.value(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[any]any:
+		var parts []string
+		for _, k := range sortedKeys(v) {
+			s := t.value(v[k])
+			if name, _ := k.(string); !t.secrets && secretFields[name] {
+				s = redacted(v[k])
+			}
+			parts = append(parts, t.value(k)+": "+s)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func rawBytes(b []byte) string { return "h'" + hex.EncodeToString(b) + "'" }
+
+func redacted(v any) string {
+	if b, ok := v.([]byte); ok {
+		return fmt.Sprintf("<redacted %d bytes>", len(b))
+	}
+	return "<redacted>"
+}
+
+// sortedKeys orders map keys as CTAP's canonical CBOR does for the keys it
+// uses: integers first in numeric order, then strings by length and bytes.
+func sortedKeys(m map[any]any) []any {
+	keys := make([]any, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	rank := func(k any) (int, int64, string) {
+		switch k := k.(type) {
+		case uint64:
+			return 0, int64(k), ""
+		case int64:
+			return 0, k, ""
+		case string:
+			return 1, int64(len(k)), k
+		}
+		return 2, 0, fmt.Sprint(k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, ni, si := rank(keys[i])
+		cj, nj, sj := rank(keys[j])
+		if ci != cj {
+			return ci < cj
+		}
+		if ni != nj {
+			return ni < nj
+		}
+		return si < sj
+	})
+	return keys
+}
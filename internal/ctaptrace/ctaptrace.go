@@ -0,0 +1,181 @@
+// Package ctaptrace turns libfido2's debug log into a trace of the CTAP
+// traffic: one line per request and response with the CTAPHID and CTAP2
+// command, the CBOR parameters by field name, the status byte and the time
+// the authenticator took to answer.
+//
+// libfido2 logs each message it sends (fido_tx) and receives (fido_rx) as a
+// header line naming the CTAPHID command followed by a hex dump of the
+// payload. Everything else it logs, including the per-frame dumps of the HID
+// transport, is ignored. PINs never appear in the traffic in the clear, but
+// the values derived from them (pinUvAuthParam, the encrypted PIN hashes,
+// the PIN token), hmac-secret salts and outputs and large-blob keys are
+// redacted unless secrets are asked for.
+package ctaptrace
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// headerRE matches "fido_tx: dev=0x..., cmd=0x10" and the fido_rx form.
+	headerRE = regexp.MustCompile(`^fido_(tx|rx): .*cmd=0x([0-9a-fA-F]{2})`)
+	// dumpRE matches the line that opens fido_tx's or fido_rx's hex dump.
+	dumpRE = regexp.MustCompile(`^fido_(tx|rx)$`)
+	// hexRE matches one hex dump line: an offset and up to 16 bytes.
+	hexRE = regexp.MustCompile(`^[0-9a-fA-F]{4}: ((?:[0-9a-fA-F]{2} ?)+)$`)
+)
+
+// dumpWidth is the number of bytes per hex dump line; a shorter line ends
+// the dump.
+const dumpWidth = 16
+
+// settle is how long after a full-width dump line a message is assumed to
+// be complete when no further log line arrives.
+const settle = 20 * time.Millisecond
+
+// Message is one CTAPHID message as libfido2 sent or received it.
+type Message struct {
+	Dir     string // "tx" or "rx"
+	HID     byte   // CTAPHID command, without the frame-init bit
+	Data    []byte // payload: for CBOR, the CTAP2 command or status byte and the CBOR map
+	Time    time.Time
+	Latency time.Duration // rx: time since the last tx
+}
+
+// Tracer writes the trace of the log lines passed to Log.
+type Tracer struct {
+	// Observe, when set before the first Log, receives every message
+	// unredacted, e.g. to record a session.
+	Observe func(Message)
+
+	mu      sync.Mutex
+	w       io.Writer
+	secrets bool
+
+	dir     string // "tx" or "rx" after a header, "" otherwise
+	hid     byte   // CTAPHID command of the message
+	dumping bool   // inside the message's hex dump
+	buf     []byte
+	at      time.Time
+	sent    time.Time // when the last request was sent
+	lastCmd byte      // CTAP2 command of the last request, to name the response
+	timer   *time.Timer
+}
+
+// New returns a Tracer writing to w, or only observing when w is nil. With
+// secrets, nothing is redacted.
+func New(w io.Writer, secrets bool) *Tracer {
+	return &Tracer{w: w, secrets: secrets}
+}
+
+// Log consumes one line of libfido2 debug output.
+func (t *Tracer) Log(line string) {
+	line = strings.TrimRight(line, "\r\n ")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dumping {
+		if m := hexRE.FindStringSubmatch(line); m != nil {
+			b, err := hex.DecodeString(strings.ReplaceAll(m[1], " ", ""))
+			if err == nil {
+				t.buf = append(t.buf, b...)
+				if len(b) < dumpWidth {
+					t.emit()
+				} else {
+					t.schedule()
+				}
+				return
+			}
+		}
+		t.emit()
+	}
+	isDump := dumpRE.MatchString(line)
+	if t.dir == "tx" && !isDump {
+		// libfido2 logs no dump for an empty payload (CTAPHID_CANCEL).
+		t.buf, t.at = nil, time.Now()
+		t.emit()
+	}
+	switch {
+	case headerRE.MatchString(line):
+		m := headerRE.FindStringSubmatch(line)
+		n, _ := strconv.ParseUint(m[2], 16, 8)
+		t.dir, t.hid = m[1], byte(n)&0x7f
+	case t.dir != "" && isDump && dumpRE.FindStringSubmatch(line)[1] == t.dir:
+		t.dumping, t.buf, t.at = true, nil, time.Now()
+	}
+}
+
+// Add traces a message fit exchanged with the key itself, outside
+// libfido2, as if it had come from the log.
+func (t *Tracer) Add(dir string, hid byte, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dumping {
+		t.emit()
+	}
+	t.dir, t.hid, t.buf, t.at = dir, hid, data, time.Now()
+	t.emit()
+}
+
+// Flush writes out a message whose dump may not have ended yet.
+func (t *Tracer) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dumping {
+		t.emit()
+	}
+}
+
+// schedule emits the current message if no other line follows it soon: a
+// dump whose length is a multiple of the line width has no short last line.
+func (t *Tracer) schedule() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	buf := t.buf
+	t.timer = time.AfterFunc(settle, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.dumping && len(t.buf) == len(buf) {
+			t.emit()
+		}
+	})
+}
+
+// emit writes the collected message. The caller holds t.mu.
+func (t *Tracer) emit() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	m := Message{Dir: t.dir, HID: t.hid, Data: t.buf, Time: t.at}
+	if m.Dir == "tx" {
+		t.sent = t.at
+	} else if !t.sent.IsZero() {
+		m.Latency = t.at.Sub(t.sent)
+	}
+	t.dir, t.dumping, t.buf = "", false, nil
+	if t.Observe != nil {
+		t.Observe(m)
+	}
+	if t.w == nil {
+		return
+	}
+	ts := m.Time.Format("15:04:05.000")
+	if m.Dir == "tx" {
+		fmt.Fprintf(t.w, "%s > %s\n", ts, t.request(m.HID, m.Data))
+		return
+	}
+	latency := ""
+	if m.Latency > 0 {
+		latency = fmt.Sprintf(" (%s)", m.Latency.Round(time.Millisecond))
+	}
+	fmt.Fprintf(t.w, "%s < %s\n", ts, t.response(m.HID, m.Data, latency))
+}
@@ -0,0 +1,101 @@
+package devsel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Alias is a user-assigned name for a key, stored with the fingerprint it
+// resolves to and a few descriptive fields for `fit alias list`.
+type Alias struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Label       string `json:"label,omitempty"`
+	VIDPID      string `json:"vidpid,omitempty"`
+	AAGUID      string `json:"aaguid,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+	Created     string `json:"created" doc:"RFC 3339 time the alias was assigned."`
+}
+
+// Config is the local device configuration file.
+type Config struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+// ConfigPath returns $FIT_CONFIG, or devices.json in the fit directory under
+// the user configuration directory ($XDG_CONFIG_HOME or ~/.config).
+func ConfigPath() (string, error) {
+	if p := os.Getenv("FIT_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fit", "devices.json"), nil
+}
+
+// Load reads the configuration at path; a missing file is an empty config.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the configuration to path atomically.
+func (c *Config) Save(path string) error {
+	sort.Slice(c.Aliases, func(i, j int) bool { return c.Aliases[i].Name < c.Aliases[j].Name })
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Lookup returns the alias called name.
+func (c *Config) Lookup(name string) (Alias, bool) {
+	for _, a := range c.Aliases {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Alias{}, false
+}
+
+// Set adds a, replacing any alias of the same name.
+func (c *Config) Set(a Alias) {
+	c.Remove(a.Name)
+	c.Aliases = append(c.Aliases, a)
+}
+
+// Remove deletes the alias called name and reports whether it existed.
+func (c *Config) Remove(name string) bool {
+	for i, a := range c.Aliases {
+		if a.Name == name {
+			c.Aliases = append(c.Aliases[:i], c.Aliases[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Package devsel matches attached authenticators against stable selectors
+// (USB VID:PID, AAGUID, product string, or a user-assigned alias) instead of
+// enumeration indexes and hidraw paths, which change when keys are replugged.
+package devsel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Device is what the selectors can see of one attached authenticator. AAGUID
+// and Fingerprint need a getInfo round trip and are empty until Identify.
+type Device struct {
+	Path        string
+	VID, PID    uint16
+	Label       string // manufacturer and product string
+	Serial      string // USB/HID serial number; often empty
+	AAGUID      []byte
+	Fingerprint string
+}
+
+// Identify fills AAGUID and Fingerprint from the getInfo AAGUID.
+func (d *Device) Identify(aaguid []byte) {
+	d.AAGUID = aaguid
+	d.Fingerprint = Fingerprint(d.VID, d.PID, d.Serial, aaguid)
+}
+
+// Fingerprint identifies a key by its VID:PID, HID serial and getInfo
+// AAGUID. Versions, extensions and options are left out: a firmware update
+// or a configuration change may alter them, and the key stays the same. Two
+// keys of the same model without a HID serial share a fingerprint.
+func Fingerprint(vid, pid uint16, serial string, aaguid []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%04x:%04x\n%s\n%x\n", vid, pid, serial, aaguid)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Criteria are the selectors given on the command line. Zero fields match
+// everything.
+type Criteria struct {
+	VIDPID      *[2]uint16
+	AAGUID      []byte
+	Product     *regexp.Regexp
+	Fingerprint string // from an alias
+}
+
+// NeedsInfo reports whether matching requires Identify.
+func (c Criteria) NeedsInfo() bool {
+	return c.AAGUID != nil || c.Fingerprint != ""
+}
+
+// Empty reports whether no selector was given.
+func (c Criteria) Empty() bool {
+	return c.VIDPID == nil && c.Product == nil && !c.NeedsInfo()
+}
+
+// Match reports whether d satisfies every selector in c.
+func (c Criteria) Match(d *Device) bool {
+	if c.VIDPID != nil && (d.VID != c.VIDPID[0] || d.PID != c.VIDPID[1]) {
+		return false
+	}
+	if c.Product != nil && !c.Product.MatchString(d.Label) {
+		return false
+	}
+	if c.AAGUID != nil && !bytes.Equal(c.AAGUID, d.AAGUID) {
+		return false
+	}
+	if c.Fingerprint != "" && c.Fingerprint != d.Fingerprint {
+		return false
+	}
+	return true
+}
+
+// String describes c for error messages.
+func (c Criteria) String() string {
+	var parts []string
+	if c.VIDPID != nil {
+		parts = append(parts, fmt.Sprintf("vidpid=%04x:%04x", c.VIDPID[0], c.VIDPID[1]))
+	}
+	if c.AAGUID != nil {
+		parts = append(parts, "aaguid="+FormatAAGUID(c.AAGUID))
+	}
+	if c.Product != nil {
+		parts = append(parts, fmt.Sprintf("product~%q", strings.TrimPrefix(c.Product.String(), "(?i)")))
+	}
+	if c.Fingerprint != "" {
+		parts = append(parts, "fingerprint="+c.Fingerprint)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseVIDPID parses "1050:0407" (hex, as printed by lsusb and `fit list`).
+func ParseVIDPID(s string) ([2]uint16, error) {
+	v, p, ok := strings.Cut(s, ":")
+	if !ok {
+		return [2]uint16{}, fmt.Errorf("invalid VID:PID %q: want hex VVVV:PPPP", s)
+	}
+	vid, err := strconv.ParseUint(strings.TrimPrefix(v, "0x"), 16, 16)
+	if err != nil {
+		return [2]uint16{}, fmt.Errorf("invalid vendor ID %q: %v", v, err)
+	}
+	pid, err := strconv.ParseUint(strings.TrimPrefix(p, "0x"), 16, 16)
+	if err != nil {
+		return [2]uint16{}, fmt.Errorf("invalid product ID %q: %v", p, err)
+	}
+	return [2]uint16{uint16(vid), uint16(pid)}, nil
+}
+
+// ParseAAGUID parses a 16-byte AAGUID as hex, with or without UUID dashes.
+func ParseAAGUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("invalid AAGUID %q: want 32 hex digits, e.g. cb69481e-8ff7-4039-93ec-0a2729a154a8", s)
+	}
+	return b, nil
+}
+
+// FormatAAGUID renders an AAGUID in UUID form.
+func FormatAAGUID(b []byte) string {
+	if len(b) != 16 {
+		return hex.EncodeToString(b)
+	}
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// ParseProduct compiles a --product-match pattern, matched case-insensitively
+// against the manufacturer and product string.
+func ParseProduct(s string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?i)" + s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid product pattern: %v", err)
+	}
+	return re, nil
+}
@@ -0,0 +1,3 @@
+// Package filelock serialises updates to fit's local files (audit log,
+// inventory) across concurrent fit processes.
+package filelock
@@ -0,0 +1,374 @@
+// Package format renders output documents as JSON, NDJSON, YAML, CSV, an
+// aligned table or a Go text/template. Documents are rendered through their
+// JSON encoding, so every format uses the JSON field names and order of the
+// schema in internal/output.
+package format
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is an output format name.
+type Format string
+
+// Formats. Text is the command's own human-readable output.
+const (
+	Text     Format = "text"
+	JSON     Format = "json"
+	NDJSON   Format = "ndjson"
+	YAML     Format = "yaml"
+	CSV      Format = "csv"
+	Table    Format = "table"
+	Template Format = "template"
+)
+
+// Names lists the accepted --output values.
+var Names = []string{string(Text), string(JSON), string(NDJSON), string(YAML), string(CSV), string(Table), string(Template)}
+
+// Options selects a format and, for Template, its text.
+type Options struct {
+	Format   Format
+	Template string
+}
+
+// Parse validates an --output value. "template=TEXT" sets the template
+// inline; otherwise tmpl (from --template) is used.
+func Parse(spec, tmpl string) (Options, error) {
+	if spec == "" {
+		spec = string(Text)
+	}
+	if t, ok := strings.CutPrefix(spec, "template="); ok {
+		spec, tmpl = string(Template), t
+	}
+	o := Options{Format: Format(spec), Template: tmpl}
+	switch o.Format {
+	case Text, JSON, NDJSON, YAML, CSV, Table:
+		if tmpl != "" {
+			return o, fmt.Errorf("--template needs --output template")
+		}
+	case Template:
+		if tmpl == "" {
+			return o, fmt.Errorf("--output template needs --template TEXT (or --output template=TEXT)")
+		}
+		if _, err := newTemplate(tmpl); err != nil {
+			return o, fmt.Errorf("--template: %w", err)
+		}
+	default:
+		return o, fmt.Errorf("unknown output format %q (want %s)", spec, strings.Join(Names, ", "))
+	}
+	return o, nil
+}
+
+// Structured reports whether the format is machine-readable, i.e. progress
+// messages must stay off stdout.
+func (o Options) Structured() bool { return o.Format != Text }
+
+// Lister is implemented by documents that hold a list of records (such as the
+// devices of `fit list`). NDJSON, CSV and table output render one line per
+// record; the other formats render the whole document.
+type Lister interface {
+	Records() any
+}
+
+// Write renders doc to w. Text is not handled here; callers print their own
+// human output for it.
+func Write(w io.Writer, o Options, doc any) error {
+	switch o.Format {
+	case JSON:
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case NDJSON:
+		recs, err := records(doc)
+		if err != nil {
+			return err
+		}
+		for _, r := range recs {
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+				return err
+			}
+		}
+		return nil
+	case YAML:
+		v, err := decode(doc)
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(yamlNode(v)); err != nil {
+			return err
+		}
+		return enc.Close()
+	case CSV, Table:
+		recs, err := records(doc)
+		if err != nil {
+			return err
+		}
+		cols, rows := tabulate(recs)
+		if o.Format == CSV {
+			cw := csv.NewWriter(w)
+			cw.Write(cols)
+			cw.WriteAll(rows)
+			return cw.Error()
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(cols, "\t")))
+		for _, r := range rows {
+			fmt.Fprintln(tw, strings.Join(r, "\t"))
+		}
+		return tw.Flush()
+	case Template:
+		t, err := newTemplate(o.Template)
+		if err != nil {
+			return err
+		}
+		v, err := decode(doc)
+		if err != nil {
+			return err
+		}
+		return t.Execute(w, plain(v))
+	}
+	return fmt.Errorf("format %q cannot be rendered", o.Format)
+}
+
+func newTemplate(text string) (*template.Template, error) {
+	return template.New("output").Option("missingkey=zero").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"join": func(sep string, v []any) string {
+			parts := make([]string, len(v))
+			for i, x := range v {
+				parts[i] = fmt.Sprint(x)
+			}
+			return strings.Join(parts, sep)
+		},
+	}).Parse(text)
+}
+
+// object is a JSON object that keeps its key order.
+type object []field
+
+type field struct {
+	Key   string
+	Value any
+}
+
+// MarshalJSON encodes o as a JSON object in key order.
+func (o object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// decode round-trips v through JSON into objects, []any and scalars,
+// preserving field order.
+func decode(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		var obj object
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{k.(string), val})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			val, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// records returns the documents to render one per line.
+func records(doc any) ([]any, error) {
+	if l, ok := doc.(Lister); ok {
+		v, err := decode(l.Records())
+		if err != nil {
+			return nil, err
+		}
+		if arr, ok := v.([]any); ok {
+			return arr, nil
+		}
+		return []any{v}, nil
+	}
+	v, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	return []any{v}, nil
+}
+
+// tabulate flattens records into columns (union of keys, in first-seen
+// order; nested keys joined with ".") and string cells.
+func tabulate(recs []any) ([]string, [][]string) {
+	var cols []string
+	seen := map[string]bool{}
+	flat := make([]map[string]string, len(recs))
+	for i, r := range recs {
+		flat[i] = map[string]string{}
+		flatten("", r, func(k, v string) {
+			if !seen[k] {
+				seen[k] = true
+				cols = append(cols, k)
+			}
+			flat[i][k] = v
+		})
+	}
+	rows := make([][]string, len(flat))
+	for i, m := range flat {
+		row := make([]string, len(cols))
+		for j, c := range cols {
+			row[j] = m[c]
+		}
+		rows[i] = row
+	}
+	return cols, rows
+}
+
+func flatten(prefix string, v any, emit func(k, v string)) {
+	key := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch x := v.(type) {
+	case object:
+		for _, f := range x {
+			flatten(key(f.Key), f.Value, emit)
+		}
+	case []any:
+		parts := make([]string, 0, len(x))
+		for _, e := range x {
+			if _, ok := e.(object); ok {
+				b, _ := json.Marshal(e)
+				parts = append(parts, string(b))
+				continue
+			}
+			parts = append(parts, scalar(e))
+		}
+		emit(prefix, strings.Join(parts, ";"))
+	default:
+		emit(prefix, scalar(x))
+	}
+}
+
+func scalar(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// plain converts objects to maps for template access and JSON encoding.
+func plain(v any) any {
+	switch x := v.(type) {
+	case object:
+		m := make(map[string]any, len(x))
+		for _, f := range x {
+			m[f.Key] = plain(f.Value)
+		}
+		return m
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = plain(e)
+		}
+		return out
+	}
+	return v
+}
+
+// yamlNode builds a YAML node tree that keeps JSON key order.
+func yamlNode(v any) *yaml.Node {
+	switch x := v.(type) {
+	case object:
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		for _, f := range x {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.Key}, yamlNode(f.Value))
+		}
+		return n
+	case []any:
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, e := range x {
+			n.Content = append(n.Content, yamlNode(e))
+		}
+		return n
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(x)}
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(x.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: x.String()}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(v)}
+}
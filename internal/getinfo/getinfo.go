@@ -0,0 +1,81 @@
+// Package getinfo reads the authenticatorGetInfo fields that go-libfido2
+// does not expose (maxCredentialCountInList, maxCredentialIdLength,
+// minPINLength, firmwareVersion). It sends getInfo itself over the key's
+// hidraw node (see internal/hidraw).
+package getinfo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fit/internal/hidraw"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// defaultMinPINLength is the minimum PIN length a key that does not report
+// minPINLength enforces (CTAP 2.1, authenticatorGetInfo).
+const defaultMinPINLength = 4
+
+// Info holds the fields of a getInfo response. A nil pointer is a field the
+// key did not report.
+type Info struct {
+	Versions                 []string
+	MaxCredentialCountInList *int
+	MaxCredentialIDLength    *int
+	// MinPINLength is the reported minPINLength, or 4 when it is absent.
+	MinPINLength    int
+	FirmwareVersion *uint64
+}
+
+type response struct {
+	Versions                 []string `cbor:"1,keyasint"`
+	MaxCredentialCountInList *int     `cbor:"7,keyasint"`
+	MaxCredentialIDLength    *int     `cbor:"8,keyasint"`
+	MinPINLength             *int     `cbor:"13,keyasint"`
+	FirmwareVersion          *uint64  `cbor:"14,keyasint"`
+}
+
+// Observer receives each message exchanged with the key; see hidraw.Observer.
+type Observer = hidraw.Observer
+
+// Read sends getInfo to the key at path and decodes the response. observe
+// may be nil.
+func Read(path string, timeout time.Duration, observe Observer) (*Info, error) {
+	c, err := hidraw.Open(path, timeout, observe)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return Query(c, timeout)
+}
+
+// Query sends getInfo on an open channel.
+func Query(c *hidraw.Conn, timeout time.Duration) (*Info, error) {
+	st, body, err := c.CBOR([]byte{0x04}, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("getInfo: %w", err)
+	}
+	if st != 0 {
+		return nil, fmt.Errorf("getInfo: CTAP2 status 0x%02x", st)
+	}
+	if len(body) == 0 {
+		return nil, errors.New("getInfo: empty response")
+	}
+	var r response
+	if err := cbor.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("getInfo: %w", err)
+	}
+	info := &Info{
+		Versions:                 r.Versions,
+		MaxCredentialCountInList: r.MaxCredentialCountInList,
+		MaxCredentialIDLength:    r.MaxCredentialIDLength,
+		MinPINLength:             defaultMinPINLength,
+		FirmwareVersion:          r.FirmwareVersion,
+	}
+	if r.MinPINLength != nil {
+		info.MinPINLength = *r.MinPINLength
+	}
+	return info, nil
+}
@@ -0,0 +1,223 @@
+// Package hidraw speaks CTAPHID to a key's hidraw node directly, for the
+// CTAP2 requests and parameters go-libfido2 does not expose. It frames
+// messages into 64-byte reports, allocates a channel with CTAPHID_INIT and
+// skips keepalives.
+package hidraw
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CTAPHID commands, without the frame-init bit.
+const (
+	cmdInit      = 0x06
+	cmdCBOR      = 0x10
+	cmdCancel    = 0x11
+	cmdKeepalive = 0x3b
+	cmdError     = 0x3f
+)
+
+// reportLen is the size of a CTAPHID packet.
+const reportLen = 64
+
+// broadcast is the CTAPHID broadcast channel.
+const broadcast = 0xffffffff
+
+// Observer receives each message exchanged with the key, e.g. to trace or
+// record it alongside libfido2's traffic. dir is "tx" or "rx".
+type Observer func(dir string, hid byte, data []byte)
+
+// Conn is a CTAPHID channel on a hidraw node.
+type Conn struct {
+	f       *os.File
+	cid     uint32
+	observe Observer
+}
+
+// Open opens the hidraw node at path and allocates a channel on it, waiting
+// at most timeout for the key. observe may be nil.
+func Open(path string, timeout time.Duration, observe Observer) (*Conn, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewConn(f, timeout, observe)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewConn allocates a channel on an open hidraw node.
+func NewConn(f *os.File, timeout time.Duration, observe Observer) (*Conn, error) {
+	if observe == nil {
+		observe = func(string, byte, []byte) {}
+	}
+	c := &Conn{f: f, cid: broadcast, observe: observe}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(cmdInit, nonce, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("CTAPHID_INIT: %w", err)
+	}
+	if len(resp) < 17 || !bytes.Equal(resp[:8], nonce) {
+		return nil, errors.New("CTAPHID_INIT: malformed response")
+	}
+	c.cid = binary.BigEndian.Uint32(resp[8:12])
+	return c, nil
+}
+
+// CBOR sends a CTAP2 request, the command byte followed by its encoded
+// parameters, and returns the status byte and the response body. A zero
+// timeout waits until the key answers, Cancel is called or the Conn is
+// closed.
+func (c *Conn) CBOR(req []byte, timeout time.Duration) (byte, []byte, error) {
+	if err := c.Send(req); err != nil {
+		return 0, nil, err
+	}
+	return c.Receive(timeout)
+}
+
+// Send writes a CTAP2 request without waiting for the answer, which Receive
+// then reads. A caller that may Cancel the request from another goroutine
+// sends it first, so that the cancel cannot reach the key before it.
+func (c *Conn) Send(req []byte) error {
+	if err := c.write(cmdCBOR, req); err != nil {
+		return err
+	}
+	c.observe("tx", cmdCBOR, req)
+	return nil
+}
+
+// Receive waits for the answer to the request Send wrote; see CBOR.
+func (c *Conn) Receive(timeout time.Duration) (byte, []byte, error) {
+	resp, err := c.receive(cmdCBOR, timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) == 0 {
+		return 0, nil, errors.New("empty CTAP2 response")
+	}
+	return resp[0], resp[1:], nil
+}
+
+// Cancel asks the key to abandon the request in progress, which then
+// returns CTAP2_ERR_KEEPALIVE_CANCEL. It may be called from another
+// goroutine.
+func (c *Conn) Cancel() error {
+	c.observe("tx", cmdCancel, nil)
+	return c.write(cmdCancel, nil)
+}
+
+// Close closes the node; a CBOR call still waiting returns an error.
+func (c *Conn) Close() error {
+	return c.f.Close()
+}
+
+// exchange sends one request and returns the response payload.
+func (c *Conn) exchange(cmd byte, data []byte, timeout time.Duration) ([]byte, error) {
+	if err := c.write(cmd, data); err != nil {
+		return nil, err
+	}
+	c.observe("tx", cmd, data)
+	return c.receive(cmd, timeout)
+}
+
+// receive returns the payload of the answer to cmd, skipping keepalives and
+// packets for other channels.
+func (c *Conn) receive(cmd byte, timeout time.Duration) ([]byte, error) {
+	// hidraw nodes are pollable, so the deadline bounds every read; a key
+	// that stops answering cannot hang the caller.
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	_ = c.f.SetReadDeadline(deadline)
+	for {
+		rcmd, resp, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		switch rcmd {
+		case cmdKeepalive:
+			continue
+		case cmdError:
+			c.observe("rx", rcmd, resp)
+			if len(resp) > 0 {
+				return nil, fmt.Errorf("CTAPHID error 0x%02x", resp[0])
+			}
+			return nil, errors.New("CTAPHID error")
+		case cmd:
+			c.observe("rx", rcmd, resp)
+			return resp, nil
+		}
+		return nil, fmt.Errorf("unexpected CTAPHID command 0x%02x", rcmd)
+	}
+}
+
+// write frames data into an init packet and continuation packets, each
+// preceded by report ID 0.
+func (c *Conn) write(cmd byte, data []byte) error {
+	pkt := make([]byte, 1+reportLen)
+	binary.BigEndian.PutUint32(pkt[1:], c.cid)
+	pkt[5] = cmd | 0x80
+	binary.BigEndian.PutUint16(pkt[6:], uint16(len(data)))
+	n := copy(pkt[8:], data)
+	if _, err := c.f.Write(pkt); err != nil {
+		return err
+	}
+	for seq := byte(0); n < len(data); seq++ {
+		pkt = make([]byte, 1+reportLen)
+		binary.BigEndian.PutUint32(pkt[1:], c.cid)
+		pkt[5] = seq
+		n += copy(pkt[6:], data[n:])
+		if _, err := c.f.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reassembles the next message on the channel.
+func (c *Conn) read() (byte, []byte, error) {
+	pkt := make([]byte, reportLen)
+	var (
+		cmd  byte
+		want int
+		data []byte
+		seq  byte
+	)
+	for {
+		n, err := c.f.Read(pkt)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 7 || binary.BigEndian.Uint32(pkt) != c.cid {
+			continue
+		}
+		if pkt[4]&0x80 != 0 {
+			cmd = pkt[4] &^ 0x80
+			want = int(binary.BigEndian.Uint16(pkt[5:]))
+			data = append([]byte{}, pkt[7:min(n, 7+want)]...)
+			seq = 0
+		} else {
+			if data == nil || pkt[4] != seq {
+				return 0, nil, errors.New("CTAPHID continuation packet out of sequence")
+			}
+			seq++
+			data = append(data, pkt[5:min(n, 5+want-len(data))]...)
+		}
+		if len(data) >= want {
+			return cmd, data, nil
+		}
+	}
+}
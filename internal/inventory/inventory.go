@@ -0,0 +1,201 @@
+// Package inventory is the local record of credentials fit has created or
+// used: which key holds them, their public key, and the highest signature
+// counter seen. A counter that goes backwards suggests the credential's key
+// was cloned, so every such observation is kept on the credential.
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"fit/internal/filelock"
+)
+
+// Credential is one inventoried credential.
+type Credential struct {
+	ID          string       `json:"id" doc:"Credential ID (base64url)."`
+	IDHex       string       `json:"idHex" doc:"Credential ID (hex)."`
+	RP          string       `json:"rp"`
+	User        string       `json:"user,omitempty"`
+	UserID      string       `json:"userID,omitempty" doc:"User handle (base64url)."`
+	PublicKey   string       `json:"publicKey,omitempty" doc:"COSE_Key (base64url)."`
+	Alg         string       `json:"alg,omitempty" doc:"Signature algorithm, e.g. ES256."`
+	Resident    bool         `json:"resident"`
+	Device      string       `json:"device,omitempty" doc:"Manufacturer and product string of the key."`
+	Fingerprint string       `json:"fingerprint,omitempty" doc:"Device fingerprint, as used by --alias."`
+	AAGUID      string       `json:"aaguid,omitempty"`
+	Source      string       `json:"source" enum:"add-passkey,ceremony,auth" doc:"Command that first recorded the credential."`
+	Created     string       `json:"created" doc:"RFC 3339 time the credential was recorded."`
+	LastUsed    string       `json:"lastUsed,omitempty" doc:"RFC 3339 time of the last fit auth."`
+	Uses        int          `json:"uses" doc:"Assertions seen by fit auth."`
+	SignCount   uint32       `json:"signCount" doc:"Highest signature counter seen."`
+	Regressions []Regression `json:"regressions,omitempty" doc:"Assertions whose counter did not exceed the highest seen."`
+}
+
+// Regression is one assertion whose signature counter did not advance.
+type Regression struct {
+	Time     string `json:"time"`
+	Previous uint32 `json:"previous" doc:"Highest counter seen before."`
+	Reported uint32 `json:"reported" doc:"Counter in the assertion."`
+}
+
+// Flagged reports whether the credential has shown a counter regression.
+func (c *Credential) Flagged() bool { return len(c.Regressions) > 0 }
+
+// Observe records an assertion with signature counter count made at time
+// now, and returns the regression when the counter did not advance. A
+// counter that stays at zero means the authenticator does not implement one
+// and is not a regression.
+func (c *Credential) Observe(count uint32, now string) *Regression {
+	c.Uses++
+	c.LastUsed = now
+	if count > c.SignCount {
+		c.SignCount = count
+		return nil
+	}
+	if count == 0 && c.SignCount == 0 {
+		return nil
+	}
+	r := Regression{Time: now, Previous: c.SignCount, Reported: count}
+	c.Regressions = append(c.Regressions, r)
+	return &r
+}
+
+// DB is the inventory file.
+type DB struct {
+	Credentials []Credential `json:"credentials"`
+}
+
+// Path returns $FIT_INVENTORY, or inventory.json in the fit directory under
+// the user configuration directory.
+func Path() (string, error) {
+	if p := os.Getenv("FIT_INVENTORY"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fit", "inventory.json"), nil
+}
+
+// Load reads the inventory at path; a missing file is an empty inventory.
+func Load(path string) (*DB, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &DB{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var db DB
+	if err := json.Unmarshal(b, &db); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &db, nil
+}
+
+// mu serialises updates within the process (--all-devices runs in parallel);
+// the lock file covers other fit processes.
+var mu sync.Mutex
+
+// Update loads the inventory at path, applies fn and writes the result back
+// atomically while holding a lock, so concurrent fit runs do not lose each
+// other's changes. Nothing is written when fn fails.
+func Update(path string, fn func(*DB) error) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	lf, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	unlock, err := filelock.Lock(lf)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(db); err != nil {
+		return err
+	}
+	return db.save(path)
+}
+
+func (db *DB) save(path string) error {
+	sort.SliceStable(db.Credentials, func(i, j int) bool {
+		a, b := db.Credentials[i], db.Credentials[j]
+		if a.RP != b.RP {
+			return a.RP < b.RP
+		}
+		return a.Created < b.Created
+	})
+	b, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Find returns the credential whose ID (base64url or hex) is id.
+func (db *DB) Find(id string) *Credential {
+	for i := range db.Credentials {
+		c := &db.Credentials[i]
+		if c.ID == id || strings.EqualFold(c.IDHex, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Put adds c, replacing any credential with the same ID, and returns the
+// stored copy. A replaced credential's use history (uses, highest counter,
+// regressions, last use) carries over.
+func (db *DB) Put(c Credential) *Credential {
+	if old := db.Find(c.ID); old != nil {
+		c.Uses += old.Uses
+		c.SignCount = max(c.SignCount, old.SignCount)
+		c.Regressions = append(old.Regressions, c.Regressions...)
+		if c.LastUsed < old.LastUsed {
+			c.LastUsed = old.LastUsed
+		}
+		*old = c
+		return old
+	}
+	db.Credentials = append(db.Credentials, c)
+	return &db.Credentials[len(db.Credentials)-1]
+}
+
+// Remove deletes the credentials for which drop returns true and returns
+// them.
+func (db *DB) Remove(drop func(*Credential) bool) []Credential {
+	kept := []Credential{}
+	var removed []Credential
+	for _, c := range db.Credentials {
+		if drop(&c) {
+			removed = append(removed, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	db.Credentials = kept
+	return removed
+}
@@ -0,0 +1,55 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestObserve(t *testing.T) {
+	c := &Credential{}
+	for _, n := range []uint32{0, 0, 3, 5} {
+		if r := c.Observe(n, "t"); r != nil {
+			t.Fatalf("Observe(%d) = %+v", n, r)
+		}
+	}
+	if r := c.Observe(5, "t2"); r == nil || r.Previous != 5 || r.Reported != 5 {
+		t.Fatalf("repeated counter: %+v", r)
+	}
+	if c.Uses != 5 || c.SignCount != 5 || !c.Flagged() || c.LastUsed != "t2" {
+		t.Errorf("credential = %+v", c)
+	}
+}
+
+func TestPutKeepsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	err := Update(path, func(db *DB) error {
+		c := db.Put(Credential{ID: "AQ", IDHex: "01", RP: "example.com", Source: "auth", Created: "2026-01-01T00:00:00Z"})
+		c.Observe(7, "2026-01-02T00:00:00Z")
+		c.Observe(2, "2026-01-03T00:00:00Z")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Update(path, func(db *DB) error {
+		db.Put(Credential{ID: "AQ", IDHex: "01", RP: "example.com", User: "alice", Source: "add-passkey", Created: "2026-01-04T00:00:00Z", SignCount: 1})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Credentials) != 1 {
+		t.Fatalf("%d credentials, want 1", len(db.Credentials))
+	}
+	c := db.Credentials[0]
+	if c.User != "alice" || c.Source != "add-passkey" {
+		t.Errorf("Put did not replace the record: %+v", c)
+	}
+	if c.Uses != 2 || c.SignCount != 7 || len(c.Regressions) != 1 || c.LastUsed != "2026-01-03T00:00:00Z" {
+		t.Errorf("use history lost: uses %d, signCount %d, regressions %+v, lastUsed %q", c.Uses, c.SignCount, c.Regressions, c.LastUsed)
+	}
+}
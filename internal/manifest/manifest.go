@@ -0,0 +1,78 @@
+// Package manifest reads the provisioning manifests of `fit apply`: the PIN
+// policy a key should meet and the resident credentials it should hold.
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is the desired state of one key.
+type Manifest struct {
+	Version     int          `yaml:"version"`
+	PIN         PINPolicy    `yaml:"pin"`
+	AlwaysUV    *bool        `yaml:"alwaysUv"`
+	Credentials []Credential `yaml:"credentials"`
+}
+
+// PINPolicy is the manifest's pin section.
+type PINPolicy struct {
+	// Required sets a PIN on a key that has none.
+	Required bool `yaml:"required"`
+	// MinLength is the shortest PIN fit will set. It is not written to the
+	// key: setMinPINLength needs authenticatorConfig, which the libfido2
+	// binding does not expose.
+	MinLength int `yaml:"minLength"`
+}
+
+// Credential is a resident credential that should exist on the key.
+type Credential struct {
+	RP      string `yaml:"rp"`
+	User    string `yaml:"user"`
+	Display string `yaml:"display"`
+}
+
+// Load reads and validates the manifest at path.
+func Load(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+// Parse decodes and validates a manifest. Unknown fields are errors, so a
+// misspelt key does not silently leave a setting unapplied.
+func Parse(b []byte) (*Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	if m.Version != 1 {
+		return nil, fmt.Errorf("unsupported manifest version %d (want version: 1)", m.Version)
+	}
+	if m.PIN.MinLength != 0 && (m.PIN.MinLength < 4 || m.PIN.MinLength > 63) {
+		return nil, fmt.Errorf("pin.minLength %d out of range 4..63", m.PIN.MinLength)
+	}
+	seen := map[[2]string]bool{}
+	for i, c := range m.Credentials {
+		if c.RP == "" || c.User == "" {
+			return nil, fmt.Errorf("credentials[%d]: rp and user are required", i)
+		}
+		k := [2]string{c.RP, c.User}
+		if seen[k] {
+			return nil, fmt.Errorf("credentials[%d]: %s/%s listed twice", i, c.RP, c.User)
+		}
+		seen[k] = true
+	}
+	return &m, nil
+}
@@ -0,0 +1,133 @@
+// Package mds reads a locally stored FIDO Metadata Service (MDS3) BLOB and
+// answers what it says about an authenticator model: its description and
+// FIDO certification level, and whether it has been reported compromised.
+//
+// The BLOB's JWT signature is not verified here; check it when downloading
+// the BLOB (it is signed by the FIDO Alliance root).
+package mds
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Levels are the FIDO certification statuses in increasing order.
+var Levels = []string{
+	"FIDO_CERTIFIED_L1",
+	"FIDO_CERTIFIED_L1plus",
+	"FIDO_CERTIFIED_L2",
+	"FIDO_CERTIFIED_L2plus",
+	"FIDO_CERTIFIED_L3",
+	"FIDO_CERTIFIED_L3plus",
+}
+
+// compromised are the status reports that disqualify an authenticator.
+var compromised = map[string]bool{
+	"REVOKED":                      true,
+	"USER_VERIFICATION_BYPASS":     true,
+	"ATTESTATION_KEY_COMPROMISE":   true,
+	"USER_KEY_REMOTE_COMPROMISE":   true,
+	"USER_KEY_PHYSICAL_COMPROMISE": true,
+}
+
+// Entry is what the BLOB says about one AAGUID.
+type Entry struct {
+	AAGUID      string   `json:"aaguid"`
+	Description string   `json:"description,omitempty"`
+	Level       string   `json:"level,omitempty" doc:"Highest FIDO certification status, e.g. FIDO_CERTIFIED_L2."`
+	Compromised []string `json:"compromised,omitempty" doc:"Status reports that revoke or compromise the model."`
+}
+
+// Rank returns the position of level in Levels, or -1. The legacy status
+// FIDO_CERTIFIED counts as L1.
+func Rank(level string) int {
+	if level == "FIDO_CERTIFIED" {
+		return 0
+	}
+	for i, l := range Levels {
+		if strings.EqualFold(l, level) {
+			return i
+		}
+	}
+	return -1
+}
+
+// BLOB is a parsed metadata BLOB, indexed by AAGUID (lower-case UUID form).
+type BLOB struct {
+	No      int
+	Entries map[string]Entry
+}
+
+type rawBLOB struct {
+	No      int `json:"no"`
+	Entries []struct {
+		AAGUID            string `json:"aaguid"`
+		MetadataStatement struct {
+			Description string `json:"description"`
+		} `json:"metadataStatement"`
+		StatusReports []struct {
+			Status string `json:"status"`
+		} `json:"statusReports"`
+	} `json:"entries"`
+}
+
+// Load reads a BLOB from path: the JWT as served by mds3.fidoalliance.org,
+// or its decoded JSON payload.
+func Load(path string) (*BLOB, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return blob, nil
+}
+
+// Parse decodes a BLOB; see Load.
+func Parse(b []byte) (*BLOB, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] != '{' {
+		parts := strings.Split(string(b), ".")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("not a metadata BLOB: want a JWT or its JSON payload")
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("JWT payload: %v", err)
+		}
+		b = payload
+	}
+	var raw rawBLOB
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	blob := &BLOB{No: raw.No, Entries: map[string]Entry{}}
+	for _, e := range raw.Entries {
+		if e.AAGUID == "" {
+			continue // U2F and UAF entries are keyed differently
+		}
+		entry := Entry{AAGUID: strings.ToLower(e.AAGUID), Description: e.MetadataStatement.Description}
+		for _, r := range e.StatusReports {
+			if Rank(r.Status) > Rank(entry.Level) {
+				entry.Level = r.Status
+			}
+			if compromised[r.Status] {
+				entry.Compromised = append(entry.Compromised, r.Status)
+			}
+		}
+		blob.Entries[entry.AAGUID] = entry
+	}
+	return blob, nil
+}
+
+// Lookup returns the entry for an AAGUID in UUID form.
+func (b *BLOB) Lookup(aaguid string) (Entry, bool) {
+	e, ok := b.Entries[strings.ToLower(aaguid)]
+	return e, ok
+}
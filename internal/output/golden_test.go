@@ -0,0 +1,162 @@
+package output_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fit/internal/ctaperr"
+	"fit/internal/format"
+	"fit/internal/output"
+	"fit/internal/policy"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func intPtr(n int) *int          { return &n }
+func uint32Ptr(n uint32) *uint32 { return &n }
+func int64Ptr(n int64) *int64    { return &n }
+
+// device is how fit list reports a YubiKey 5 NFC.
+var device = output.Device{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw3"}
+
+// golden lists the documents as the commands fill them, including the
+// fields they leave empty, and the formats each one is checked in.
+var golden = []struct {
+	name    string
+	doc     any
+	formats []format.Format
+}{
+	{"list", output.List{
+		Header:  output.NewHeader("list", output.BackendLibfido2),
+		Devices: []output.Device{device, {Index: 1, Label: "SoloKeys Solo 2", VID: 0x1209, PID: 0xbeee, Path: "/dev/hidraw5"}},
+	}, []format.Format{format.JSON, format.NDJSON, format.CSV, format.Table}},
+	{"list-empty", output.List{
+		Header:  output.NewHeader("list", output.BackendLibfido2),
+		Devices: []output.Device{},
+	}, []format.Format{format.JSON}},
+	{"auth", output.Assertion{
+		Header:            output.NewHeader("auth", output.BackendLibfido2),
+		RP:                "example.com",
+		CredentialID:      output.B64([]byte{1, 2, 3, 4}),
+		CredentialIDHex:   output.Hex([]byte{1, 2, 3, 4}),
+		Signature:         output.B64([]byte{0x30, 0x45, 0x02, 0x21}),
+		ChallengeB64:      output.B64([]byte{0, 1, 2, 3}),
+		ChallengeHex:      output.Hex([]byte{0, 1, 2, 3}),
+		AuthenticatorData: output.B64(make([]byte, 37)),
+		SignCount:         uint32Ptr(0),
+		CounterRegression: true,
+	}, []format.Format{format.JSON, format.YAML}},
+	{"info", output.Info{
+		Header:                   output.NewHeader("info", output.BackendLibfido2),
+		Type:                     "fido2",
+		IsFIDO2:                  true,
+		Versions:                 []string{"U2F_V2", "FIDO_2_0", "FIDO_2_1"},
+		Extensions:               []string{"credProtect", "hmac-secret"},
+		AAGUID:                   "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+		Options:                  map[string]string{"rk": "true", "up": "true", "clientPin": "true", "credMgmt": "true"},
+		CTAPHID:                  &output.CTAPHID{Major: 5, Minor: 4, Build: 3, Flags: 5},
+		FirmwareVersion:          int64Ptr(328707),
+		MinPINLength:             intPtr(4),
+		MaxCredentialCountInList: intPtr(8),
+		MaxCredentialIDLength:    intPtr(128),
+		PINRetryCount:            intPtr(8),
+		ResidentKeys:             &output.ResidentKeys{Existing: 2, Remaining: 23},
+	}, []format.Format{format.JSON, format.YAML}},
+	{"info-ctap20", output.Info{
+		Header:     output.NewHeader("info", output.BackendLibfido2),
+		Type:       "fido2",
+		IsFIDO2:    true,
+		Versions:   []string{"U2F_V2", "FIDO_2_0"},
+		Extensions: []string{},
+		Options:    map[string]string{"rk": "false", "up": "true", "clientPin": "false"},
+	}, []format.Format{format.JSON}},
+	{"error", output.Error{
+		Header: output.NewHeader("auth", output.BackendLibfido2),
+		Error:  ctaperr.New(ctaperr.PINInvalid, 0x31, "getAssertion", errors.New("FIDO_ERR_PIN_INVALID")),
+	}, []format.Format{format.JSON, format.YAML}},
+	{"fleet", output.Fleet{
+		Header:   output.NewHeader("info", output.BackendLibfido2),
+		Parallel: 4,
+		OK:       1,
+		Failed:   1,
+		Devices: []output.DeviceResult{
+			{Device: device, OK: true, Millis: 120, Result: output.Info{
+				Header:     output.NewHeader("info", output.BackendLibfido2),
+				Type:       "fido2",
+				IsFIDO2:    true,
+				Versions:   []string{"FIDO_2_0"},
+				Extensions: []string{},
+				Options:    map[string]string{"clientPin": "true"},
+			}},
+			{Device: output.Device{Index: 1, Label: "SoloKeys Solo 2", VID: 0x1209, PID: 0xbeee, Path: "/dev/hidraw5"}, Millis: 30000,
+				Error: ctaperr.Newf(ctaperr.ActionTimeout, "info", "no answer within 30s")},
+		},
+	}, []format.Format{format.JSON, format.CSV}},
+	{"lint", output.Lint{
+		Header:      output.NewHeader("lint", output.BackendLibfido2),
+		Policy:      "policy.yaml",
+		Device:      device,
+		Fingerprint: "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+		Passed:      1,
+		Failed:      1,
+		Unknown:     1,
+		Results: []policy.Result{
+			{Rule: "requireOptions.clientPin", Status: policy.Pass, Want: "true", Have: "true"},
+			{Rule: "minPinRetries", Status: policy.Fail, Want: ">= 8", Have: "3"},
+			{Rule: "certification", Status: policy.Unknown, Want: "FIDO_CERTIFIED_L1", Detail: "no metadata BLOB given (--mds)"},
+		},
+	}, []format.Format{format.JSON, format.Table}},
+	{"inventory-scan", output.InventoryScan{
+		Header:     output.NewHeader("inventory-scan", output.BackendLibfido2),
+		Duplicates: 0,
+		Keys: []output.ScannedKey{{
+			Scanned:           "2026-10-19T09:30:00Z",
+			Device:            device,
+			VIDPID:            "1050:0407",
+			Fingerprint:       "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+			SharedFingerprint: true,
+			AAGUID:            "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+			CTAPHIDVersion:    "5.4.3",
+			PIN:               "not-set",
+			Violations:        []string{"requireOptions.clientPin"},
+		}},
+		Violations: []output.Violation{{Rule: "requireOptions.clientPin", Keys: 1, Fingerprints: []string{"3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b"}}},
+	}, []format.Format{format.JSON, format.CSV}},
+}
+
+// TestGolden renders the documents and compares them with the files in
+// testdata/golden; run with -update after an intended change.
+func TestGolden(t *testing.T) {
+	for _, g := range golden {
+		for _, f := range g.formats {
+			name := g.name + "." + string(f)
+			t.Run(name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := format.Write(&buf, format.Options{Format: f}, g.doc); err != nil {
+					t.Fatal(err)
+				}
+				path := filepath.Join("testdata", "golden", name)
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("%v (run go test -update to create it)", err)
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					t.Errorf("%s differs from the rendered document:\n--- want\n%s\n--- got\n%s", path, want, buf.Bytes())
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,415 @@
+// Package output defines the JSON documents fit and fit-hello print with
+// --json. Every document starts with schemaVersion, command and backend; the
+// field set of a given schemaVersion only grows (new optional fields), and any
+// rename, removal or type change bumps SchemaVersion.
+//
+// Binary values are base64url without padding, as in WebAuthn JSON, on both
+// backends. Credential IDs and challenges also carry a hex copy because fit's
+// flags take hex.
+//
+// JSON Schema files and example documents for every command are generated
+// into schema/ at the repository root; `make generate-check` (and `make
+// schema-check`) fail when a struct changes without regenerating them.
+package output
+
+//go:generate go run ./schemagen -dir ../../schema
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+
+	"fit/internal/audit"
+	"fit/internal/chal"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/inventory"
+	"fit/internal/mds"
+	"fit/internal/policy"
+	"fit/internal/rp"
+)
+
+// SchemaVersion is the version of every document in this package.
+const SchemaVersion = 1
+
+// Backend names.
+const (
+	BackendLibfido2 = "libfido2"
+	BackendHello    = "hello"
+)
+
+// Header is embedded first in every document.
+type Header struct {
+	SchemaVersion int    `json:"schemaVersion" doc:"Output schema version."`
+	Command       string `json:"command" doc:"Command that produced the document."`
+	Backend       string `json:"backend" doc:"libfido2 (fit) or hello (fit-hello)." enum:"libfido2,hello"`
+	InsecureSeed  string `json:"insecureSeed,omitempty" doc:"Seed given with --seed or FIT_SEED: challenges, user IDs and simulated keys are predictable from it. NOT SECURE; for test vectors only."`
+}
+
+// NewHeader returns the header for command on backend, flagging seeded
+// (non-secure) random values.
+func NewHeader(command, backend string) Header {
+	return Header{SchemaVersion: SchemaVersion, Command: command, Backend: backend, InsecureSeed: chal.SeedValue()}
+}
+
+// B64 encodes b as unpadded base64url, or "" for empty input.
+func B64(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Hex encodes b as lowercase hex, or "" for empty input.
+func Hex(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Device is one entry of `fit list`.
+type Device struct {
+	Index int    `json:"index" doc:"Index for --device."`
+	Label string `json:"label" doc:"Manufacturer and product string."`
+	VID   uint16 `json:"vid" doc:"USB vendor ID."`
+	PID   uint16 `json:"pid" doc:"USB product ID."`
+	Path  string `json:"path" doc:"Device path for --path."`
+}
+
+// List is the output of `fit list`.
+type List struct {
+	Header
+	Devices []Device `json:"devices"`
+}
+
+// Assertion is the output of `fit auth` and `fit-hello auth`.
+type Assertion struct {
+	Header
+	RP                string  `json:"rp" doc:"Relying party ID."`
+	CredentialID      string  `json:"credentialID" doc:"Credential ID (base64url)."`
+	CredentialIDHex   string  `json:"credentialIDHex" doc:"Credential ID (hex)."`
+	Signature         string  `json:"signature" doc:"Assertion signature (base64url)."`
+	ChallengeB64      string  `json:"challengeB64" doc:"Random challenge (base64url)."`
+	ChallengeHex      string  `json:"challengeHex" doc:"Random challenge (hex)."`
+	AuthenticatorData string  `json:"authenticatorData,omitempty" doc:"Raw authenticator data (base64url)."`
+	HMACSecret        string  `json:"hmacSecret,omitempty" doc:"hmac-secret extension output (base64url)."`
+	PRFFirst          string  `json:"prfFirst,omitempty" doc:"PRF extension first output (base64url, fit-hello)."`
+	SignCount         *uint32 `json:"signCount,omitempty" doc:"Signature counter from the authenticator data (fit)."`
+	CounterRegression bool    `json:"counterRegression,omitempty" doc:"The counter did not exceed the highest one in the local inventory: possible cloned authenticator."`
+}
+
+// Passkey is the output of `fit add-passkey` and `fit-hello add-passkey`.
+type Passkey struct {
+	Header
+	RP              string `json:"rp" doc:"Relying party ID."`
+	User            string `json:"user" doc:"User name."`
+	Resident        bool   `json:"resident" doc:"Whether the credential is discoverable."`
+	CredentialID    string `json:"credentialID" doc:"Credential ID (base64url)."`
+	CredentialIDHex string `json:"credentialIDHex" doc:"Credential ID (hex)."`
+	ChallengeB64    string `json:"challengeB64" doc:"Random challenge (base64url)."`
+	ChallengeHex    string `json:"challengeHex" doc:"Random challenge (hex)."`
+}
+
+// CTAPHID is the CTAPHID_INIT version information.
+type CTAPHID struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Build int `json:"build"`
+	Flags int `json:"flags"`
+}
+
+// ResidentKeys counts discoverable credential slots.
+type ResidentKeys struct {
+	Existing  int64 `json:"existing"`
+	Remaining int64 `json:"remaining"`
+}
+
+// Info is the output of `fit info`.
+type Info struct {
+	Header
+	Type       string            `json:"type" doc:"Device type reported by libfido2."`
+	IsFIDO2    bool              `json:"isFIDO2"`
+	Versions   []string          `json:"versions" doc:"getInfo versions."`
+	Extensions []string          `json:"extensions" doc:"getInfo extensions."`
+	AAGUID     string            `json:"aaguid,omitempty" doc:"getInfo AAGUID (UUID form)."`
+	Options    map[string]string `json:"options" doc:"getInfo options: true, false or default."`
+	CTAPHID    *CTAPHID          `json:"ctapHID,omitempty"`
+	// Read with a getInfo of fit's own; absent when that failed.
+	FirmwareVersion          *int64        `json:"firmwareVersion,omitempty" doc:"getInfo firmwareVersion (0x0E), when the key reports one."`
+	MinPINLength             *int          `json:"minPinLength,omitempty" doc:"getInfo minPINLength (0x0D); 4 when the key does not report one."`
+	MaxCredentialCountInList *int          `json:"maxCredentialCountInList,omitempty" doc:"getInfo maxCredentialCountInList (0x07)."`
+	MaxCredentialIDLength    *int          `json:"maxCredentialIdLength,omitempty" doc:"getInfo maxCredentialIdLength (0x08)."`
+	PINRetryCount            *int          `json:"pinRetryCount,omitempty" doc:"Remaining PIN attempts."`
+	ResidentKeys             *ResidentKeys `json:"residentKeys,omitempty" doc:"Present when a PIN was supplied."`
+}
+
+// Ceremony is the output of `fit ceremony register|login`.
+type Ceremony struct {
+	Header
+	Ceremony      string         `json:"ceremony" enum:"register,login"`
+	RP            string         `json:"rp" doc:"RP ID from the server options."`
+	Origin        string         `json:"origin" doc:"Origin placed in clientDataJSON."`
+	RelatedOrigin bool           `json:"relatedOrigin,omitempty" doc:"Origin was accepted via the related origins document."`
+	CredentialID  string         `json:"credentialID,omitempty" doc:"Credential ID (base64url)."`
+	Steps         []*rp.Step     `json:"steps" doc:"HTTP exchanges in order."`
+	OK            bool           `json:"ok"`
+	Error         *ctaperr.Error `json:"error,omitempty"`
+}
+
+// Error is printed instead of a command's document when it fails under --json.
+type Error struct {
+	Header
+	Error *ctaperr.Error `json:"error"`
+}
+
+// DeviceResult is one device's outcome in a --all-devices report.
+type DeviceResult struct {
+	Device Device         `json:"device"`
+	OK     bool           `json:"ok"`
+	Millis int64          `json:"millis" doc:"Time spent on this device."`
+	Result any            `json:"result,omitempty" doc:"The command's document (info, auth or add-passkey) for this device."`
+	Error  *ctaperr.Error `json:"error,omitempty"`
+}
+
+// Fleet is the output of list, info, auth and add-passkey with --all-devices.
+type Fleet struct {
+	Header
+	Parallel int            `json:"parallel" doc:"Devices handled at once."`
+	OK       int            `json:"ok" doc:"Number of devices that succeeded."`
+	Failed   int            `json:"failed" doc:"Number of devices that failed."`
+	Devices  []DeviceResult `json:"devices" doc:"One entry per device, in enumeration order."`
+}
+
+// WatchEvent is one line of `fit watch`.
+type WatchEvent struct {
+	Header
+	Event     string         `json:"event" enum:"add,remove"`
+	Time      string         `json:"time" doc:"RFC 3339 time the change was seen."`
+	Initial   bool           `json:"initial,omitempty" doc:"Device was already attached when watch started (--existing)."`
+	Device    Device         `json:"device" doc:"The device; index is its position in the enumeration when the event was seen."`
+	Info      *Info          `json:"info,omitempty" doc:"getInfo summary of an added device (--info)."`
+	InfoError *ctaperr.Error `json:"infoError,omitempty" doc:"Why --info could not be collected."`
+}
+
+// HelloCredential is one Windows Hello platform credential.
+type HelloCredential struct {
+	Index           int    `json:"index"`
+	RP              string `json:"rp"`
+	User            string `json:"user"`
+	Removable       bool   `json:"removable"`
+	BackedUp        bool   `json:"backedUp"`
+	CredentialID    string `json:"credentialID" doc:"Credential ID (base64url)."`
+	CredentialIDHex string `json:"credentialIDHex" doc:"Credential ID (hex)."`
+}
+
+// HelloList is the output of `fit-hello list` and `fit-hello test`.
+type HelloList struct {
+	Header
+	RP          string            `json:"rp" doc:"RP filter; empty for all."`
+	APIVersion  int               `json:"apiVersion,omitempty" doc:"WebAuthn API version (test only)."`
+	Credentials []HelloCredential `json:"credentials"`
+}
+
+// HelloDelete is the output of `fit-hello delete-passkey`.
+type HelloDelete struct {
+	Header
+	Deleted         bool   `json:"deleted"`
+	CredentialID    string `json:"credentialID" doc:"Credential ID (base64url)."`
+	CredentialIDHex string `json:"credentialIDHex" doc:"Credential ID (hex)."`
+}
+
+// AliasList is the output of `fit alias list`.
+type AliasList struct {
+	Header
+	Config  string         `json:"config" doc:"Path of the device config file."`
+	Aliases []devsel.Alias `json:"aliases"`
+}
+
+// AuditLog is the output of `fit audit show`.
+type AuditLog struct {
+	Header
+	Log     string        `json:"log" doc:"Path of the audit log."`
+	Entries []audit.Entry `json:"entries"`
+}
+
+// AuditVerify is the output of `fit audit verify`.
+type AuditVerify struct {
+	Header
+	Log      string          `json:"log" doc:"Path of the audit log."`
+	OK       bool            `json:"ok" doc:"The chain is intact (and contains --anchor, if given)."`
+	Entries  int             `json:"entries"`
+	Head     string          `json:"head,omitempty" doc:"Hash of the last entry; record it to detect later truncation with --anchor."`
+	Anchor   string          `json:"anchor,omitempty" doc:"Hash given with --anchor."`
+	Problems []audit.Problem `json:"problems"`
+}
+
+// Inventory is the output of `fit inventory list` and `fit inventory show`.
+type Inventory struct {
+	Header
+	DB          string                 `json:"db" doc:"Path of the inventory file."`
+	Credentials []inventory.Credential `json:"credentials"`
+}
+
+// InventoryPrune is the output of `fit inventory prune`.
+type InventoryPrune struct {
+	Header
+	DB        string                 `json:"db" doc:"Path of the inventory file."`
+	DryRun    bool                   `json:"dryRun,omitempty" doc:"Nothing was removed (--dry-run)."`
+	Removed   []inventory.Credential `json:"removed"`
+	Remaining int                    `json:"remaining" doc:"Credentials left in the inventory."`
+}
+
+// ScannedKey is one key in a `fit inventory scan` report.
+type ScannedKey struct {
+	Scanned           string         `json:"scanned" doc:"RFC 3339 time the key was read."`
+	Device            Device         `json:"device"`
+	VIDPID            string         `json:"vidpid" doc:"USB vendor:product ID (hex)."`
+	Fingerprint       string         `json:"fingerprint" doc:"Device fingerprint; keys with a HID serial number have one entry each."`
+	Serial            string         `json:"serial,omitempty" doc:"HID serial number (HID_UNIQ), when the key has one."`
+	SharedFingerprint bool           `json:"sharedFingerprint,omitempty" doc:"No HID serial number: the fingerprint is the model's, and the entry may repeat an earlier key."`
+	AAGUID            string         `json:"aaguid,omitempty"`
+	FirmwareVersion   *int64         `json:"firmwareVersion,omitempty" doc:"getInfo firmwareVersion (0x0E), when the key reports one."`
+	CTAPHIDVersion    string         `json:"ctapHIDVersion,omitempty" doc:"CTAPHID device version, major.minor.build."`
+	PIN               string         `json:"pin,omitempty" enum:"set,not-set,unsupported"`
+	PINRetryCount     *int           `json:"pinRetryCount,omitempty" doc:"Remaining PIN attempts."`
+	ResidentKeys      *ResidentKeys  `json:"residentKeys,omitempty" doc:"Present when a PIN was supplied and accepted."`
+	Certification     string         `json:"certification,omitempty" doc:"Highest FIDO certification status in the metadata BLOB, or \"unlisted\" (--mds)."`
+	Compromised       []string       `json:"compromised,omitempty" doc:"Revocation or compromise reports in the metadata BLOB."`
+	Violations        []string       `json:"violations,omitempty" doc:"Policy rules the key fails (--policy)."`
+	Error             *ctaperr.Error `json:"error,omitempty" doc:"Why the key could not be read."`
+}
+
+// Violation counts the keys failing one policy rule.
+type Violation struct {
+	Rule         string   `json:"rule"`
+	Keys         int      `json:"keys" doc:"Number of keys failing the rule."`
+	Fingerprints []string `json:"fingerprints"`
+}
+
+// InventoryScan is the output of `fit inventory scan`.
+type InventoryScan struct {
+	Header
+	Policy     string       `json:"policy,omitempty" doc:"Policy file the keys were checked against."`
+	MDS        string       `json:"mds,omitempty" doc:"Metadata BLOB used for certification status."`
+	Duplicates int          `json:"duplicates" doc:"Insertions of a key already in the report (keys with a HID serial number only)."`
+	Keys       []ScannedKey `json:"keys" doc:"One entry per distinct key, in scan order."`
+	Violations []Violation  `json:"violations" doc:"Per-rule summary of policy failures."`
+}
+
+// ApplyStep is one item of a `fit apply` plan.
+type ApplyStep struct {
+	Action       string         `json:"action" enum:"set-pin,min-pin-length,always-uv,create-credential"`
+	Target       string         `json:"target" doc:"What the step is about, e.g. example.com/alice for a credential."`
+	Status       string         `json:"status" enum:"ok,planned,done,failed,manual,skipped" doc:"ok: already as declared; planned: would change (--dry-run); manual: fit cannot make this change."`
+	Detail       string         `json:"detail,omitempty"`
+	CredentialID string         `json:"credentialID,omitempty" doc:"Created credential ID (base64url)."`
+	Error        *ctaperr.Error `json:"error,omitempty"`
+}
+
+// Apply is the output of `fit apply`.
+type Apply struct {
+	Header
+	Manifest string      `json:"manifest" doc:"Path of the manifest."`
+	Device   Device      `json:"device"`
+	DryRun   bool        `json:"dryRun,omitempty" doc:"The plan was shown but not applied."`
+	Steps    []ApplyStep `json:"steps"`
+	Created  int         `json:"created" doc:"Credentials created."`
+	Failed   int         `json:"failed" doc:"Steps that failed."`
+	Manual   int         `json:"manual" doc:"Differences fit cannot change."`
+}
+
+// Lint is the output of `fit lint`.
+type Lint struct {
+	Header
+	Policy      string          `json:"policy" doc:"Path of the policy file."`
+	Device      Device          `json:"device"`
+	Fingerprint string          `json:"fingerprint,omitempty" doc:"Device fingerprint, as used by --alias."`
+	MDS         *mds.Entry      `json:"mds,omitempty" doc:"Metadata BLOB entry for the key's AAGUID (--mds)."`
+	Pass        bool            `json:"pass" doc:"No rule failed (and, with --strict, none was unknown)."`
+	Passed      int             `json:"passed"`
+	Failed      int             `json:"failed"`
+	Unknown     int             `json:"unknown" doc:"Rules the key did not report enough to check."`
+	Results     []policy.Result `json:"results" doc:"One entry per rule, in policy order."`
+}
+
+// Snapshot is the output of `fit snapshot`: everything fit can read from a
+// key, for later comparison with `fit diff`.
+type Snapshot struct {
+	Header
+	Taken            string         `json:"taken" doc:"RFC 3339 time the snapshot was taken."`
+	Device           Device         `json:"device"`
+	Fingerprint      string         `json:"fingerprint,omitempty" doc:"Device fingerprint, as used by --alias."`
+	Info             Info           `json:"info" doc:"The fit info document."`
+	RelyingParties   []SnapshotRP   `json:"relyingParties" doc:"Resident credentials by RP; null when no PIN was supplied."`
+	CredentialsError *ctaperr.Error `json:"credentialsError,omitempty" doc:"Why the credentials could not be listed."`
+}
+
+// SnapshotRP is one relying party with resident credentials on the key.
+type SnapshotRP struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name,omitempty"`
+	Credentials []SnapshotCredential `json:"credentials"`
+}
+
+// SnapshotCredential is one resident credential.
+type SnapshotCredential struct {
+	ID          string `json:"id" doc:"Credential ID (base64url)."`
+	IDHex       string `json:"idHex" doc:"Credential ID (hex)."`
+	User        string `json:"user"`
+	UserID      string `json:"userID,omitempty" doc:"User handle (base64url)."`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// Change is one difference found by `fit diff`.
+type Change struct {
+	Path string `json:"path" doc:"What changed, e.g. info.options.clientPin or rp[example.com].credential[0102]."`
+	Kind string `json:"kind" enum:"added,removed,changed"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// Diff is the output of `fit diff`.
+type Diff struct {
+	Header
+	A       string   `json:"a" doc:"First snapshot: file path."`
+	B       string   `json:"b" doc:"Second snapshot: file path, or \"live\" for the attached key."`
+	Same    bool     `json:"same"`
+	Changes []Change `json:"changes"`
+	Notes   []string `json:"notes,omitempty" doc:"Parts that could not be compared."`
+}
+
+// Records returns the devices, for one-line-per-record formats.
+func (l List) Records() any { return l.Devices }
+
+// Records returns the credentials, for one-line-per-record formats.
+func (l HelloList) Records() any { return l.Credentials }
+
+// Records returns the aliases, for one-line-per-record formats.
+func (l AliasList) Records() any { return l.Aliases }
+
+// Records returns the entries, for one-line-per-record formats.
+func (l AuditLog) Records() any { return l.Entries }
+
+// Records returns the problems found, for one-line-per-record formats.
+func (v AuditVerify) Records() any { return v.Problems }
+
+// Records returns the credentials, for one-line-per-record formats.
+func (l Inventory) Records() any { return l.Credentials }
+
+// Records returns the removed credentials, for one-line-per-record formats.
+func (p InventoryPrune) Records() any { return p.Removed }
+
+// Records returns the scanned keys, for one-line-per-record formats.
+func (s InventoryScan) Records() any { return s.Keys }
+
+// Records returns the plan steps, for one-line-per-record formats.
+func (a Apply) Records() any { return a.Steps }
+
+// Records returns the rule results, for one-line-per-record formats.
+func (l Lint) Records() any { return l.Results }
+
+// Records returns the changes, for one-line-per-record formats.
+func (d Diff) Records() any { return d.Changes }
+
+// Records returns the per-device results, for one-line-per-record formats.
+func (f Fleet) Records() any { return f.Devices }
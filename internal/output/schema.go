@@ -0,0 +1,439 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"fit/internal/audit"
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/inventory"
+	"fit/internal/mds"
+	"fit/internal/policy"
+	"fit/internal/rp"
+)
+
+// Doc describes one command's output document.
+type Doc struct {
+	Name    string // schema name, e.g. "auth" or "hello-list"
+	Title   string
+	Example any // fully populated example; its type defines the schema
+}
+
+// Docs lists every output document, in help order.
+var Docs = []Doc{
+	{"list", "fit list", List{
+		Header:  NewHeader("list", BackendLibfido2),
+		Devices: []Device{{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw3"}},
+	}},
+	{"auth", "fit auth / fit-hello auth", Assertion{
+		Header:            NewHeader("auth", BackendLibfido2),
+		RP:                "example.com",
+		CredentialID:      "AQIDBA",
+		CredentialIDHex:   "01020304",
+		Signature:         "MEUCIQ",
+		ChallengeB64:      "AAECAw",
+		ChallengeHex:      "00010203",
+		AuthenticatorData: "o3mm9u6vuaVeN4wRgDTidR5oL6ufLTCrE9ISVYbOGUMFAAAAAQ",
+		HMACSecret:        "qrvM3Q",
+		PRFFirst:          "qrvM3Q",
+		SignCount:         uint32Ptr(1),
+	}},
+	{"add-passkey", "fit add-passkey / fit-hello add-passkey", Passkey{
+		Header:          NewHeader("add-passkey", BackendLibfido2),
+		RP:              "example.com",
+		User:            "fit-user",
+		Resident:        true,
+		CredentialID:    "AQIDBA",
+		CredentialIDHex: "01020304",
+		ChallengeB64:    "AAECAw",
+		ChallengeHex:    "00010203",
+	}},
+	{"info", "fit info", Info{
+		Header:                   NewHeader("info", BackendLibfido2),
+		Type:                     "fido2",
+		IsFIDO2:                  true,
+		Versions:                 []string{"U2F_V2", "FIDO_2_0", "FIDO_2_1"},
+		Extensions:               []string{"credProtect", "hmac-secret"},
+		AAGUID:                   "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+		Options:                  map[string]string{"clientPin": "true", "rk": "true", "up": "true"},
+		CTAPHID:                  &CTAPHID{Major: 5, Minor: 4, Build: 3, Flags: 5},
+		FirmwareVersion:          int64Ptr(328707),
+		MinPINLength:             intPtr(4),
+		MaxCredentialCountInList: intPtr(8),
+		MaxCredentialIDLength:    intPtr(128),
+		PINRetryCount:            intPtr(8),
+		ResidentKeys:             &ResidentKeys{Existing: 2, Remaining: 23},
+	}},
+	{"ceremony", "fit ceremony", Ceremony{
+		Header:        NewHeader("ceremony", BackendLibfido2),
+		Ceremony:      "register",
+		RP:            "localhost",
+		Origin:        "http://localhost:8787",
+		RelatedOrigin: true,
+		CredentialID:  "AQIDBA",
+		Steps:         []*rp.Step{{Name: "begin", URL: "http://localhost:8787/register/begin", Status: 200, Millis: 3, Body: json.RawMessage(`{"status":"ok"}`)}},
+		OK:            false,
+		Error:         ctaperr.New(ctaperr.PINInvalid, 0x31, "makeCredential", fmt.Errorf("pin invalid")),
+	}},
+	{"alias", "fit alias list", AliasList{
+		Header: NewHeader("alias", BackendLibfido2),
+		Config: "/home/user/.config/fit/devices.json",
+		Aliases: []devsel.Alias{{
+			Name:        "ci-key",
+			Fingerprint: "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+			Label:       "Yubico YubiKey OTP+FIDO+CCID",
+			VIDPID:      "1050:0407",
+			AAGUID:      "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+			Serial:      "",
+			Created:     "2025-10-01T12:00:00Z",
+		}},
+	}},
+	{"fleet", "fit list|info|auth|add-passkey --all-devices", Fleet{
+		Header:   NewHeader("info", BackendLibfido2),
+		Parallel: 4,
+		OK:       1,
+		Failed:   1,
+		Devices: []DeviceResult{
+			{
+				Device: Device{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw3"},
+				OK:     true,
+				Millis: 41,
+				Result: map[string]any{"type": "fido2", "isFIDO2": true},
+			},
+			{
+				Device: Device{Index: 1, Label: "SoloKeys Solo 2", VID: 0x1209, PID: 0xbeee, Path: "/dev/hidraw5"},
+				Millis: 5000,
+				Error:  ctaperr.Newf(ctaperr.TimedOut, "info", "timed out after 5s"),
+			},
+		},
+	}},
+	{"watch", "fit watch (one document per line)", WatchEvent{
+		Header:  NewHeader("watch", BackendLibfido2),
+		Event:   "add",
+		Time:    "2025-10-01T12:00:00.123456789Z",
+		Initial: false,
+		Device:  Device{Index: 1, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw4"},
+		Info: &Info{
+			Header:     NewHeader("info", BackendLibfido2),
+			Type:       "fido2",
+			IsFIDO2:    true,
+			Versions:   []string{"U2F_V2", "FIDO_2_0"},
+			Extensions: []string{"hmac-secret"},
+			Options:    map[string]string{"clientPin": "false", "rk": "true", "up": "true"},
+		},
+		InfoError: nil,
+	}},
+	{"audit", "fit audit show", AuditLog{
+		Header: NewHeader("audit", BackendLibfido2),
+		Log:    "/home/user/.config/fit/audit.jsonl",
+		Entries: []audit.Entry{{
+			Seq:          1,
+			Time:         "2025-10-01T12:00:00Z",
+			Operator:     "alice",
+			Host:         "provisioning-01",
+			Command:      "add-passkey",
+			Device:       "Yubico YubiKey OTP+FIDO+CCID",
+			Path:         "/dev/hidraw4",
+			Fingerprint:  "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+			RP:           "example.com",
+			User:         "alice",
+			CredentialID: "AQIDBA",
+			Result:       "ok",
+			Prev:         "",
+			Hash:         "9c1185a5c5e9fc54612808977ee8f548b2258d31f0f2b7b4e8f4a1b2c3d4e5f6",
+		}},
+	}},
+	{"audit-verify", "fit audit verify", AuditVerify{
+		Header:   NewHeader("audit", BackendLibfido2),
+		Log:      "/home/user/.config/fit/audit.jsonl",
+		OK:       false,
+		Entries:  3,
+		Head:     "5d41402abc4b2a76b9719d911017c592ae0b5c1f3e6d7a8b9c0d1e2f3a4b5c6d",
+		Problems: []audit.Problem{{Line: 2, Seq: 2, Message: "hash does not match the entry's content (entry modified)"}},
+	}},
+	{"inventory", "fit inventory list|show", Inventory{
+		Header: NewHeader("inventory", BackendLibfido2),
+		DB:     "/home/user/.config/fit/inventory.json",
+		Credentials: []inventory.Credential{inventory.Credential{
+			ID:          "AQIDBA",
+			IDHex:       "01020304",
+			RP:          "example.com",
+			User:        "alice",
+			UserID:      "q83vEjRWeJA",
+			PublicKey:   "pQECAyYgASFYIA",
+			Alg:         "ES256",
+			Resident:    true,
+			Device:      "Yubico YubiKey OTP+FIDO+CCID",
+			Fingerprint: "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+			AAGUID:      "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+			Source:      "add-passkey",
+			Created:     "2025-10-01T12:00:00Z",
+			LastUsed:    "2025-10-02T09:30:00Z",
+			Uses:        3,
+			SignCount:   5,
+			Regressions: []inventory.Regression{{Time: "2025-10-02T09:30:00Z", Previous: 5, Reported: 2}},
+		}},
+	}},
+	{"inventory-prune", "fit inventory prune", InventoryPrune{
+		Header: NewHeader("inventory", BackendLibfido2),
+		DB:     "/home/user/.config/fit/inventory.json",
+		DryRun: true,
+		Removed: []inventory.Credential{inventory.Credential{
+			ID:          "AQIDBA",
+			IDHex:       "01020304",
+			RP:          "example.com",
+			User:        "alice",
+			UserID:      "q83vEjRWeJA",
+			PublicKey:   "pQECAyYgASFYIA",
+			Alg:         "ES256",
+			Resident:    true,
+			Device:      "Yubico YubiKey OTP+FIDO+CCID",
+			Fingerprint: "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+			AAGUID:      "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+			Source:      "add-passkey",
+			Created:     "2025-10-01T12:00:00Z",
+			LastUsed:    "2025-10-02T09:30:00Z",
+			Uses:        3,
+			SignCount:   5,
+			Regressions: []inventory.Regression{{Time: "2025-10-02T09:30:00Z", Previous: 5, Reported: 2}},
+		}},
+		Remaining: 12,
+	}},
+	{"inventory-scan", "fit inventory scan", InventoryScan{
+		Header: NewHeader("inventory", BackendLibfido2),
+		Policy: "policy.yaml",
+		MDS:    "blob.jwt",
+		Keys: []ScannedKey{{
+			Scanned:         "2025-10-01T12:00:00Z",
+			Device:          Device{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw4"},
+			VIDPID:          "1050:0407",
+			Fingerprint:     "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+			Serial:          "0012345678",
+			AAGUID:          "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+			FirmwareVersion: int64Ptr(328707),
+			CTAPHIDVersion:  "5.4.3",
+			PIN:             "not-set",
+			PINRetryCount:   intPtr(8),
+			ResidentKeys:    &ResidentKeys{Existing: 2, Remaining: 23},
+			Certification:   "FIDO_CERTIFIED_L2",
+			Violations:      []string{"clientPin"},
+		}},
+		Duplicates: 1,
+		Violations: []Violation{{Rule: "clientPin", Keys: 1, Fingerprints: []string{"3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b"}}},
+	}},
+	{"apply", "fit apply", Apply{
+		Header:   NewHeader("apply", BackendLibfido2),
+		Manifest: "alice.yaml",
+		Device:   Device{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw4"},
+		Steps: []ApplyStep{
+			{Action: "set-pin", Target: "pin", Status: "done", Detail: "PIN set (minimum length 6)"},
+			{Action: "min-pin-length", Target: "minPINLength", Status: "done", Detail: "minPINLength set to 6"},
+			{Action: "always-uv", Target: "alwaysUv", Status: "manual", Detail: "key reports alwaysUv=false, manifest wants true; the key does not support toggleAlwaysUv"},
+			{Action: "create-credential", Target: "example.com/alice", Status: "done", CredentialID: "AQIDBA"},
+			{Action: "create-credential", Target: "login.example.org/alice", Status: "ok", Detail: "already present"},
+		},
+		Created: 1,
+		Failed:  0,
+		Manual:  1,
+	}},
+	{"lint", "fit lint", Lint{
+		Header:      NewHeader("lint", BackendLibfido2),
+		Policy:      "policy.yaml",
+		Device:      Device{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw4"},
+		Fingerprint: "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+		MDS:         &mds.Entry{AAGUID: "cb69481e-8ff7-4039-93ec-0a2729a154a8", Description: "YubiKey 5 Series", Level: "FIDO_CERTIFIED_L2"},
+		Pass:        false,
+		Passed:      3,
+		Failed:      1,
+		Unknown:     1,
+		Results: []policy.Result{
+			{Rule: "aaguids", Status: "pass", Want: "cb69481e-8ff7-4039-93ec-0a2729a154a8", Have: "cb69481e-8ff7-4039-93ec-0a2729a154a8"},
+			{Rule: "minFirmware", Status: "pass", Want: ">= 5.2.7", Have: "5.4.3", Detail: "compared with the CTAPHID device version"},
+			{Rule: "clientPin", Status: "fail", Want: "true", Have: "false", Detail: "no PIN is set; run `fit set-pin`"},
+			{Rule: "minPinLength", Status: "unknown", Want: ">= 6", Detail: "the key's minPINLength is not available through libfido2's getInfo binding"},
+			{Rule: "certification", Status: "pass", Want: ">= FIDO_CERTIFIED_L1", Have: "FIDO_CERTIFIED_L2", Detail: "YubiKey 5 Series"},
+		},
+	}},
+	{"snapshot", "fit snapshot", Snapshot{
+		Header:      NewHeader("snapshot", BackendLibfido2),
+		Taken:       "2026-03-02T14:05:00Z",
+		Device:      Device{Index: 0, Label: "Yubico YubiKey OTP+FIDO+CCID", VID: 0x1050, PID: 0x0407, Path: "/dev/hidraw4"},
+		Fingerprint: "3f8e2c1a9b7d4e6f0a1b2c3d4e5f6a7b",
+		Info: Info{
+			Header:        NewHeader("info", BackendLibfido2),
+			Type:          "fido2",
+			IsFIDO2:       true,
+			Versions:      []string{"U2F_V2", "FIDO_2_0", "FIDO_2_1"},
+			Extensions:    []string{"credProtect", "hmac-secret"},
+			AAGUID:        "cb69481e-8ff7-4039-93ec-0a2729a154a8",
+			Options:       map[string]string{"clientPin": "true", "rk": "true", "up": "true"},
+			CTAPHID:       &CTAPHID{Major: 5, Minor: 4, Build: 3, Flags: 5},
+			PINRetryCount: intPtr(8),
+			ResidentKeys:  &ResidentKeys{Existing: 1, Remaining: 24},
+		},
+		RelyingParties: []SnapshotRP{{
+			ID:          "example.com",
+			Name:        "example.com",
+			Credentials: []SnapshotCredential{{ID: "AQIDBA", IDHex: "01020304", User: "alice", UserID: "YWxpY2U", DisplayName: "Alice"}},
+		}},
+	}},
+	{"diff", "fit diff", Diff{
+		Header: NewHeader("diff", BackendLibfido2),
+		A:      "before.json",
+		B:      "live",
+		Same:   false,
+		Changes: []Change{
+			{Path: "info.options.clientPin", Kind: "changed", Old: "false", New: "true"},
+			{Path: "info.pinRetryCount", Kind: "changed", Old: "8", New: "7"},
+			{Path: "rp[example.com].credential[01020304]", Kind: "added", New: "alice"},
+		},
+	}},
+	{"error", "any fit command failing under --json", Error{
+		Header: NewHeader("auth", BackendLibfido2),
+		Error:  ctaperr.New(ctaperr.NoCredentials, 0x2E, "getAssertion", fmt.Errorf("no credentials")),
+	}},
+	{"hello-list", "fit-hello list / test", HelloList{
+		Header:      NewHeader("list", BackendHello),
+		RP:          "example.com",
+		APIVersion:  4,
+		Credentials: []HelloCredential{{Index: 0, RP: "example.com", User: "alice", Removable: true, BackedUp: false, CredentialID: "AQIDBA", CredentialIDHex: "01020304"}},
+	}},
+	{"delete-passkey", "fit-hello delete-passkey", HelloDelete{
+		Header:          NewHeader("delete-passkey", BackendHello),
+		Deleted:         true,
+		CredentialID:    "AQIDBA",
+		CredentialIDHex: "01020304",
+	}},
+}
+
+func intPtr(n int) *int { return &n }
+
+func uint32Ptr(n uint32) *uint32 { return &n }
+
+func int64Ptr(n int64) *int64 { return &n }
+
+// Names returns the schema names in help order.
+func Names() []string {
+	names := make([]string, len(Docs))
+	for i, d := range Docs {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// Lookup returns the document named name.
+func Lookup(name string) (Doc, bool) {
+	for _, d := range Docs {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Doc{}, false
+}
+
+// Schema returns the JSON Schema (draft 2020-12) for the named document.
+func Schema(name string) ([]byte, error) {
+	d, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("no schema %q (have %s)", name, strings.Join(Names(), ", "))
+	}
+	s := typeSchema(reflect.TypeOf(d.Example))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = d.Title
+	props := s["properties"].(map[string]any)
+	props["schemaVersion"] = map[string]any{"const": SchemaVersion, "description": "Output schema version."}
+	return marshal(s)
+}
+
+// Example returns the indented example document for name.
+func Example(name string) ([]byte, error) {
+	d, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("no schema %q", name)
+	}
+	return marshal(d.Example)
+}
+
+func marshal(v any) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+var rawMessage = reflect.TypeOf(json.RawMessage(nil))
+
+// typeSchema derives a schema from a Go type using its json tags. Fields
+// without omitempty are required; `doc` and `enum` tags add a description and
+// allowed values.
+func typeSchema(t reflect.Type) map[string]any {
+	if t == rawMessage {
+		return map[string]any{"description": "Arbitrary JSON."}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		addStructFields(t, props, &required)
+		sort.Strings(required)
+		s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
+
+func addStructFields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			addStructFields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := typeSchema(f.Type)
+		if d := f.Tag.Get("doc"); d != "" {
+			s["description"] = d
+		}
+		if e := f.Tag.Get("enum"); e != "" {
+			s["enum"] = strings.Split(e, ",")
+		}
+		props[name] = s
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Command schemagen writes the JSON Schema and example document for every
+// output.Docs entry:
+//
+//	DIR/<name>.schema.json
+//	DIR/examples/<name>.json
+//
+// With -check it writes nothing and exits non-zero when a file is missing or
+// differs, so a change to an output struct fails the build until the schema
+// files are regenerated and committed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"fit/internal/output"
+)
+
+func main() {
+	dir := flag.String("dir", "schema", "output directory")
+	check := flag.Bool("check", false, "compare instead of writing")
+	flag.Parse()
+
+	stale := 0
+	for _, name := range output.Names() {
+		schema, err := output.Schema(name)
+		if err != nil {
+			fail(err)
+		}
+		example, err := output.Example(name)
+		if err != nil {
+			fail(err)
+		}
+		files := map[string][]byte{
+			filepath.Join(*dir, name+".schema.json"):      schema,
+			filepath.Join(*dir, "examples", name+".json"): example,
+		}
+		for path, want := range files {
+			if *check {
+				got, err := os.ReadFile(path)
+				if err != nil || !bytes.Equal(got, want) {
+					fmt.Fprintf(os.Stderr, "stale: %s\n", path)
+					stale++
+				}
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				fail(err)
+			}
+			if err := os.WriteFile(path, want, 0o644); err != nil {
+				fail(err)
+			}
+		}
+	}
+	if stale > 0 {
+		fmt.Fprintf(os.Stderr, "%d schema file(s) out of date; run: go generate ./internal/output\n", stale)
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "schemagen:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,248 @@
+// Package pinentry reads authenticator PINs from sources that do not leak them
+// to shell history or process listings: stdin, a file, an inherited file
+// descriptor, an environment variable, a no-echo terminal prompt, or an
+// SSH_ASKPASS-style helper program.
+package pinentry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"golang.org/x/term"
+)
+
+// ErrUnavailable is returned when a PIN is required but no source is configured
+// and interactive entry is impossible (no terminal and no askpass helper).
+var ErrUnavailable = errors.New("PIN required: use --pin-stdin, --pin-file, --pin-fd, FIT_PIN, a terminal, or FIT_ASKPASS/SSH_ASKPASS")
+
+// Options lists where a PIN may come from. Sources are tried in field order;
+// the first one configured wins.
+type Options struct {
+	Value  string // command-line value (visible in ps; discouraged)
+	Stdin  bool   // read the first line of stdin
+	File   string // read the first line of a file
+	FD     int    // read the first line of an inherited descriptor; <0 when unset
+	EnvVar string // environment variable name (e.g. FIT_PIN)
+	// Prompt enables interactive entry when no other source is set. Empty means
+	// the PIN is optional and an empty Secret is returned instead.
+	Prompt string
+	// Confirm asks twice on interactive entry and requires both to match.
+	Confirm bool
+}
+
+// Secret holds a PIN in a byte slice that can be wiped after use. Converting it
+// to a string for a library call makes an immutable copy Go cannot zero; keep
+// that copy's lifetime to the call.
+type Secret struct {
+	b      []byte
+	Source string // where the PIN came from, for diagnostics
+}
+
+// String returns the PIN for passing to APIs that take a string.
+func (s *Secret) String() string {
+	if s == nil {
+		return ""
+	}
+	return string(s.b)
+}
+
+// Bytes returns the PIN itself, for APIs that take bytes. The caller must
+// not keep or modify it.
+func (s *Secret) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	return s.b
+}
+
+// Empty reports whether no PIN was provided.
+func (s *Secret) Empty() bool { return s == nil || len(s.b) == 0 }
+
+// Len returns the PIN length in bytes.
+func (s *Secret) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.b)
+}
+
+// Zero overwrites the PIN bytes.
+func (s *Secret) Zero() {
+	if s == nil {
+		return
+	}
+	for i := range s.b {
+		s.b[i] = 0
+	}
+	s.b = nil
+}
+
+// Read resolves a PIN from the configured sources.
+func Read(o Options) (*Secret, error) {
+	switch {
+	case o.Value != "":
+		return &Secret{b: []byte(o.Value), Source: "flag"}, nil
+	case o.Stdin:
+		b, err := firstLine(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read PIN from stdin: %w", err)
+		}
+		return &Secret{b: b, Source: "stdin"}, nil
+	case o.File != "":
+		f, err := os.Open(o.File)
+		if err != nil {
+			return nil, fmt.Errorf("read PIN file: %w", err)
+		}
+		defer f.Close()
+		b, err := firstLine(f)
+		if err != nil {
+			return nil, fmt.Errorf("read PIN file: %w", err)
+		}
+		return &Secret{b: b, Source: "file"}, nil
+	case o.FD >= 0:
+		f := os.NewFile(uintptr(o.FD), fmt.Sprintf("fd%d", o.FD))
+		if f == nil {
+			return nil, fmt.Errorf("read PIN from fd %d: invalid descriptor", o.FD)
+		}
+		defer f.Close()
+		b, err := firstLine(f)
+		if err != nil {
+			return nil, fmt.Errorf("read PIN from fd %d: %w", o.FD, err)
+		}
+		return &Secret{b: b, Source: "fd"}, nil
+	}
+	if o.EnvVar != "" {
+		if v, ok := os.LookupEnv(o.EnvVar); ok && v != "" {
+			return &Secret{b: []byte(v), Source: "env"}, nil
+		}
+	}
+	if o.Prompt == "" {
+		return &Secret{}, nil
+	}
+	return interactive(o.Prompt, o.Confirm)
+}
+
+// interactive prompts on the controlling terminal, falling back to an askpass helper.
+func interactive(prompt string, confirm bool) (*Secret, error) {
+	read := promptTTY
+	source := "prompt"
+	if helper := askpassHelper(); helper != "" && (os.Getenv("SSH_ASKPASS_REQUIRE") == "force" || !haveTTY()) {
+		read = func(p string) ([]byte, error) { return runAskpass(helper, p) }
+		source = "askpass"
+	} else if !haveTTY() {
+		return nil, ErrUnavailable
+	}
+	b, err := read(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := read("Confirm " + prompt)
+		if err != nil {
+			zero(b)
+			return nil, err
+		}
+		match := bytes.Equal(b, again)
+		zero(again)
+		if !match {
+			zero(b)
+			return nil, errors.New("PINs do not match")
+		}
+	}
+	return &Secret{b: b, Source: source}, nil
+}
+
+func askpassHelper() string {
+	if h := os.Getenv("FIT_ASKPASS"); h != "" {
+		return h
+	}
+	return os.Getenv("SSH_ASKPASS")
+}
+
+func haveTTY() bool {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return term.IsTerminal(int(os.Stdin.Fd()))
+	}
+	f.Close()
+	return true
+}
+
+// promptTTY reads without echo from /dev/tty (or stdin when it is a terminal),
+// so the prompt works even when stdin is redirected.
+func promptTTY(prompt string) ([]byte, error) {
+	in, out := os.Stdin, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	fmt.Fprint(out, prompt)
+	b, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	if err != nil {
+		return nil, fmt.Errorf("read PIN: %w", err)
+	}
+	return b, nil
+}
+
+// runAskpass runs helper with the prompt as its argument and returns the first
+// line of its output.
+func runAskpass(helper, prompt string) ([]byte, error) {
+	cmd := exec.Command(helper, prompt)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		zero(out)
+		return nil, fmt.Errorf("askpass helper %s: %w", helper, err)
+	}
+	b, err := firstLine(bytes.NewReader(out))
+	zero(out)
+	return b, err
+}
+
+// firstLine reads up to the first newline, stripping the line terminator.
+// It reads a byte at a time into a buffer it owns, so no reader buffer is
+// left holding the PIN, and it grows the buffer by copying and wiping the
+// old one. Nothing past the newline is consumed.
+func firstLine(r io.Reader) ([]byte, error) {
+	line := make([]byte, 0, 64)
+	var c [1]byte
+	defer func() { c[0] = 0 }()
+	for {
+		n, err := r.Read(c[:])
+		if n == 1 {
+			if c[0] == '\n' {
+				break
+			}
+			if len(line) == cap(line) {
+				grown := make([]byte, len(line), 2*cap(line))
+				copy(grown, line)
+				zero(line)
+				line = grown
+			}
+			line = append(line, c[0])
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			zero(line)
+			return nil, err
+		}
+	}
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return nil, errors.New("empty PIN")
+	}
+	return line, nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,233 @@
+// Package policy evaluates an authenticator against an organization's key
+// policy (allowed models, firmware, extensions, PIN and UV settings, FIDO
+// certification), rule by rule.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fit/internal/mds"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a policy file. Zero fields are not checked.
+type Policy struct {
+	Version       int      `yaml:"version"`
+	AAGUIDs       []string `yaml:"aaguids"`
+	MinFirmware   string   `yaml:"minFirmware"`
+	Extensions    []string `yaml:"extensions"`
+	ClientPIN     bool     `yaml:"clientPin"`
+	AlwaysUV      bool     `yaml:"alwaysUv"`
+	MinPINLength  int      `yaml:"minPinLength"`
+	MinPINRetries int      `yaml:"minPinRetries"`
+	Certification string   `yaml:"certification"`
+}
+
+// NeedsMDS reports whether the policy needs a metadata BLOB.
+func (p *Policy) NeedsMDS() bool { return p.Certification != "" }
+
+// Load reads and validates the policy at path.
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Parse decodes and validates a policy. Unknown fields are errors, so a
+// misspelt rule is not silently skipped.
+func Parse(b []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Version != 1 {
+		return nil, fmt.Errorf("unsupported policy version %d (want version: 1)", p.Version)
+	}
+	for i, a := range p.AAGUIDs {
+		p.AAGUIDs[i] = strings.ToLower(a)
+	}
+	if p.MinFirmware != "" {
+		if _, err := parseVersion(p.MinFirmware); err != nil {
+			return nil, fmt.Errorf("minFirmware: %v", err)
+		}
+	}
+	if p.Certification != "" && mds.Rank(p.Certification) < 0 {
+		return nil, fmt.Errorf("certification %q: want one of %s", p.Certification, strings.Join(mds.Levels, ", "))
+	}
+	return &p, nil
+}
+
+// Facts are what is known about the key being checked. Nil pointers are
+// facts that could not be read.
+type Facts struct {
+	AAGUID         string            // UUID form
+	Firmware       string            // version checked by minFirmware, from FirmwareSource
+	FirmwareSource string            // FirmwareGetInfo or FirmwareCTAPHID
+	Extensions     []string          // getInfo extensions
+	Options        map[string]string // getInfo options
+	PINRetries     *int
+	MinPINLength   *int
+	MDS            *mds.Entry // nil when the BLOB has no entry for the AAGUID
+	MDSLoaded      bool
+}
+
+// Where Facts.Firmware was read.
+const (
+	FirmwareGetInfo = "getInfo firmwareVersion" // a single number, e.g. 328707
+	FirmwareCTAPHID = "CTAPHID device version"  // major.minor.build
+)
+
+// Status of one rule.
+const (
+	Pass    = "pass"
+	Fail    = "fail"
+	Unknown = "unknown" // the key did not report what the rule needs
+)
+
+// Result is the outcome of one rule.
+type Result struct {
+	Rule   string `json:"rule"`
+	Status string `json:"status" enum:"pass,fail,unknown"`
+	Want   string `json:"want"`
+	Have   string `json:"have,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Evaluate checks f against every rule set in p, in policy-file order.
+func Evaluate(p *Policy, f Facts) []Result {
+	var out []Result
+	add := func(rule, want, have string, ok bool, detail string) {
+		st := Fail
+		if ok {
+			st = Pass
+		}
+		out = append(out, Result{Rule: rule, Status: st, Want: want, Have: have, Detail: detail})
+	}
+	unknown := func(rule, want, detail string) {
+		out = append(out, Result{Rule: rule, Status: Unknown, Want: want, Detail: detail})
+	}
+
+	if len(p.AAGUIDs) > 0 {
+		add("aaguids", strings.Join(p.AAGUIDs, ", "), f.AAGUID, slices.Contains(p.AAGUIDs, strings.ToLower(f.AAGUID)), "")
+	}
+	if p.MinFirmware != "" {
+		want := ">= " + p.MinFirmware
+		switch {
+		case f.Firmware == "":
+			unknown("minFirmware", want, "the key reported neither a getInfo firmwareVersion nor a CTAPHID device version")
+		case f.FirmwareSource == FirmwareGetInfo && strings.Contains(p.MinFirmware, "."):
+			// getInfo firmwareVersion is one vendor-defined number; a dotted
+			// minimum cannot be compared with it.
+			unknown("minFirmware", want, fmt.Sprintf("the key reports %s %s, a single number; write minFirmware in that form to check it", FirmwareGetInfo, f.Firmware))
+		default:
+			add("minFirmware", want, f.Firmware, compareVersions(f.Firmware, p.MinFirmware) >= 0, "compared with the "+f.FirmwareSource)
+		}
+	}
+	for _, ext := range p.Extensions {
+		add("extensions."+ext, "present", strings.Join(f.Extensions, ", "), slices.Contains(f.Extensions, ext), "")
+	}
+	if p.ClientPIN {
+		v, ok := f.Options["clientPin"]
+		detail := ""
+		if !ok {
+			detail = "the key does not support a PIN"
+		} else if v != "true" {
+			detail = "no PIN is set; run `fit set-pin`"
+		}
+		add("clientPin", "true", optionValue(v, ok), v == "true", detail)
+	}
+	if p.AlwaysUV {
+		v, ok := f.Options["alwaysUv"]
+		add("alwaysUv", "true", optionValue(v, ok), v == "true", "")
+	}
+	if p.MinPINLength > 0 {
+		want := ">= " + strconv.Itoa(p.MinPINLength)
+		if f.MinPINLength == nil {
+			unknown("minPinLength", want, "fit could not read the key's getInfo")
+		} else {
+			add("minPinLength", want, strconv.Itoa(*f.MinPINLength), *f.MinPINLength >= p.MinPINLength, "")
+		}
+	}
+	if p.MinPINRetries > 0 {
+		want := ">= " + strconv.Itoa(p.MinPINRetries)
+		if f.PINRetries == nil {
+			unknown("minPinRetries", want, "the key did not report its PIN retry counter")
+		} else {
+			add("minPinRetries", want, strconv.Itoa(*f.PINRetries), *f.PINRetries >= p.MinPINRetries, "")
+		}
+	}
+	if p.Certification != "" {
+		want := ">= " + p.Certification
+		switch {
+		case !f.MDSLoaded:
+			unknown("certification", want, "no metadata BLOB given (--mds)")
+		case f.MDS == nil:
+			add("certification", want, "", false, "the AAGUID is not in the metadata BLOB")
+		case len(f.MDS.Compromised) > 0:
+			add("certification", want, f.MDS.Level, false, "metadata reports "+strings.Join(f.MDS.Compromised, ", "))
+		default:
+			add("certification", want, f.MDS.Level, mds.Rank(f.MDS.Level) >= mds.Rank(p.Certification), f.MDS.Description)
+		}
+	}
+	return out
+}
+
+func optionValue(v string, ok bool) string {
+	if !ok {
+		return "absent"
+	}
+	return v
+}
+
+// parseVersion splits a dotted version into its numbers.
+func parseVersion(s string) ([]int, error) {
+	var out []int
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q: want numbers separated by dots, e.g. 5.2.7", s)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// compareVersions compares dotted versions numerically; missing parts are 0.
+// An unparsable a sorts first.
+func compareVersions(a, b string) int {
+	va, err := parseVersion(a)
+	if err != nil {
+		return -1
+	}
+	vb, _ := parseVersion(b)
+	for i := 0; i < max(len(va), len(vb)); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"bytes"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CTAPHID and CTAP2 commands whose requests carry random values.
+const (
+	hidMsg  = 0x03
+	hidInit = 0x06
+	hidCBOR = 0x10
+
+	cmdMakeCredential = 0x01
+	cmdGetAssertion   = 0x02
+	cmdClientPIN      = 0x06
+	cmdBioEnrollment  = 0x09
+	cmdCredMgmt       = 0x0a
+	cmdLargeBlobs     = 0x0c
+	cmdConfig         = 0x0d
+	cmdBioPreview     = 0x40
+	cmdCredMgmtPre    = 0x41
+)
+
+// randomKeys lists, per CTAP2 command, the parameters that differ between
+// runs: client data hashes over fresh challenges, and everything derived
+// from the PIN protocol's ephemeral key.
+var randomKeys = map[byte][]uint64{
+	cmdMakeCredential: {1, 8},       // clientDataHash, pinUvAuthParam
+	cmdGetAssertion:   {2, 6},       // clientDataHash, pinUvAuthParam
+	cmdClientPIN:      {3, 4, 5, 6}, // keyAgreement, pinUvAuthParam, newPinEnc, pinHashEnc
+	cmdBioEnrollment:  {5},
+	cmdBioPreview:     {5},
+	cmdCredMgmt:       {4},
+	cmdCredMgmtPre:    {4},
+	cmdLargeBlobs:     {5},
+	cmdConfig:         {4},
+}
+
+// extensionsKey is the parameter holding extension inputs, whose
+// hmac-secret salts are encrypted with the ephemeral key.
+var extensionsKey = map[byte]uint64{
+	cmdMakeCredential: 6,
+	cmdGetAssertion:   4,
+}
+
+// masked replaces a random value.
+const masked = "(random)"
+
+// mask returns the comparable form of a request: its payload with the
+// values that differ between runs replaced.
+func mask(hid byte, b []byte) []byte {
+	switch {
+	case hid == hidInit:
+		return nil // only a nonce
+	case hid == hidMsg && len(b) >= 7+32 && (b[1] == 0x01 || b[1] == 0x02):
+		// U2F register and authenticate: the challenge parameter follows
+		// the extended-length APDU header.
+		out := bytes.Clone(b)
+		clear(out[7 : 7+32])
+		return out
+	case hid != hidCBOR || len(b) < 2:
+		return b
+	}
+	var m map[uint64]any
+	if cbor.Unmarshal(b[1:], &m) != nil {
+		return b
+	}
+	for _, k := range randomKeys[b[0]] {
+		if _, ok := m[k]; ok {
+			m[k] = masked
+		}
+	}
+	if b[0] == cmdMakeCredential {
+		// fit draws the user ID of a new credential at random.
+		if u, ok := m[3].(map[any]any); ok {
+			if _, ok := u["id"]; ok {
+				u["id"] = masked
+			}
+		}
+	}
+	if k, ok := extensionsKey[b[0]]; ok {
+		if ext, ok := m[k].(map[any]any); ok {
+			for _, name := range []string{"hmac-secret", "hmac-secret-mc"} {
+				if _, isBool := ext[name].(bool); ext[name] != nil && !isBool {
+					ext[name] = masked
+				}
+			}
+		}
+	}
+	enc, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return b
+	}
+	out, err := enc.Marshal(m)
+	if err != nil {
+		return b
+	}
+	return append([]byte{b[0]}, out...)
+}
@@ -0,0 +1,123 @@
+//go:build linux
+
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"fit/internal/ctaptrace"
+	"fit/internal/vkey"
+)
+
+const hidCancel = 0x11
+
+// cancelGrace is how much longer than in the recording the player waits for
+// the run to cancel a request the recorded run cancelled.
+const cancelGrace = 10 * time.Second
+
+// Player answers a run's requests from a session, as a vkey.Handler. Each
+// request must match the next recorded one; the answer is the recorded
+// response. After the first mismatch every request fails with a CTAPHID
+// error, so the run stops soon, and Err reports the mismatch.
+type Player struct {
+	mu   sync.Mutex
+	msgs []Message
+	next int
+	n    int // requests answered
+	err  error
+}
+
+// NewPlayer returns a player for s.
+func NewPlayer(s *Session) *Player {
+	return &Player{msgs: s.Messages}
+}
+
+// Handle implements vkey.Handler.
+func (p *Player) Handle(req vkey.Request) vkey.Response {
+	if req.Cmd == hidCancel {
+		// Recorded cancels are matched while the cancelled request waits.
+		return vkey.Response{None: true}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return vkey.ErrorResponse(vkey.ErrOther)
+	}
+	p.n++
+	for p.next < len(p.msgs) && p.msgs[p.next].Dir == "rx" {
+		p.next++ // a response to a request libfido2 gave up on
+	}
+	if p.next == len(p.msgs) {
+		p.err = fmt.Errorf("request %d was not recorded: %s", p.n, ctaptrace.Request(req.Cmd, req.Data))
+		return vkey.ErrorResponse(vkey.ErrOther)
+	}
+	want := p.msgs[p.next]
+	if want.HID != req.Cmd || !bytes.Equal(mask(req.Cmd, req.Data), mask(want.HID, want.Data)) {
+		p.err = fmt.Errorf("request %d differs from the recording:\n  sent:     %s\n  recorded: %s",
+			p.n, ctaptrace.Request(req.Cmd, req.Data), ctaptrace.Request(want.HID, want.Data))
+		return vkey.ErrorResponse(vkey.ErrOther)
+	}
+	p.next++
+
+	if p.next < len(p.msgs) && p.msgs[p.next].Dir == "tx" && p.msgs[p.next].HID == hidCancel {
+		c := p.msgs[p.next]
+		p.next++
+		if !p.awaitCancel(req, time.Duration(c.Millis-want.Millis)*time.Millisecond+cancelGrace) {
+			p.err = fmt.Errorf("request %d was cancelled in the recording but not by this run: %s", p.n, ctaptrace.Request(req.Cmd, req.Data))
+			return vkey.ErrorResponse(vkey.ErrOther)
+		}
+	}
+	if p.next == len(p.msgs) || p.msgs[p.next].Dir != "rx" {
+		// libfido2 logs no response it rejects, such as a CTAPHID error.
+		return vkey.ErrorResponse(vkey.ErrOther)
+	}
+	resp := p.msgs[p.next]
+	p.next++
+	data := resp.Data
+	if req.Cmd == hidInit && len(data) >= 8 && len(req.Data) == 8 {
+		data = bytes.Clone(data)
+		copy(data, req.Data) // the nonce is echoed
+	}
+	return vkey.Response{Cmd: resp.HID, Data: data}
+}
+
+// awaitCancel waits up to d for the run to cancel req, sending keepalives
+// as a key waiting for a touch does.
+func (p *Player) awaitCancel(req vkey.Request, d time.Duration) bool {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	deadline := time.After(d)
+	for {
+		select {
+		case <-req.Cancelled:
+			return true
+		case <-deadline:
+			return false
+		case <-tick.C:
+			req.Keepalive(vkey.StatusUPNeeded)
+		}
+	}
+}
+
+// Err returns the first mismatch, or an error when the run ended before
+// sending every recorded request.
+func (p *Player) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	left := 0
+	for _, m := range p.msgs[p.next:] {
+		if m.Dir == "tx" && m.HID != hidCancel {
+			left++
+		}
+	}
+	if left > 0 {
+		return fmt.Errorf("the run sent %d request(s); the recording has %d more", p.n, left)
+	}
+	return nil
+}
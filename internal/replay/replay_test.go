@@ -0,0 +1,123 @@
+//go:build linux
+
+package replay
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"fit/internal/getinfo"
+	"fit/internal/hidraw"
+	"fit/internal/vkey"
+)
+
+// testdata/info.cbor is a fit info session against a fit-sim key with a PIN
+// set: CTAPHID_INIT, getInfo and clientPIN getPINRetries.
+const fixture = "testdata/info.cbor"
+
+// getRetries is clientPIN getPINRetries with PIN protocol 1.
+var getRetries = []byte{0x06, 0xa2, 0x01, 0x01, 0x02, 0x01}
+
+func loadFixture(t *testing.T) *Session {
+	t.Helper()
+	s, err := Load(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLoad(t *testing.T) {
+	s := loadFixture(t)
+	if s.Header.Version != Version || s.Header.Args[1] != "info" {
+		t.Errorf("header = %+v", s.Header)
+	}
+	if s.Device.VID != 0x1209 || s.Device.PID != 0xf1d0 || s.Device.Serial != "0001" {
+		t.Errorf("device = %+v", s.Device)
+	}
+	if len(s.Messages) != 6 {
+		t.Fatalf("%d messages, want 6", len(s.Messages))
+	}
+}
+
+// request is a vkey request nobody cancels.
+func request(cmd byte, data []byte) vkey.Request {
+	return vkey.Request{CID: 1, Cmd: cmd, Data: data, Keepalive: func(byte) {}, Cancelled: make(chan struct{})}
+}
+
+func TestPlayer(t *testing.T) {
+	p := NewPlayer(loadFixture(t))
+	nonce := []byte("newnonce")
+	resp := p.Handle(request(vkey.CmdInit, nonce))
+	if resp.Cmd != vkey.CmdInit || !bytes.Equal(resp.Data[:8], nonce) {
+		t.Fatalf("INIT answered %+v; want the run's nonce echoed", resp)
+	}
+	if resp := p.Handle(request(vkey.CmdCBOR, []byte{0x04})); resp.Cmd != vkey.CmdCBOR || resp.Data[0] != 0 {
+		t.Fatalf("getInfo answered %+v", resp)
+	}
+	if err := p.Err(); err == nil || !strings.Contains(err.Error(), "1 more") {
+		t.Errorf("Err before the last request = %v", err)
+	}
+	if resp := p.Handle(request(vkey.CmdCBOR, getRetries)); resp.Cmd != vkey.CmdCBOR || resp.Data[0] != 0 {
+		t.Fatalf("getPINRetries answered %+v", resp)
+	}
+	if err := p.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlayerMismatch(t *testing.T) {
+	p := NewPlayer(loadFixture(t))
+	p.Handle(request(vkey.CmdInit, []byte("newnonce")))
+	if resp := p.Handle(request(vkey.CmdCBOR, []byte{0x07})); resp.Cmd != vkey.CmdError {
+		t.Errorf("reset answered %+v; want a CTAPHID error", resp)
+	}
+	if err := p.Err(); err == nil || !strings.Contains(err.Error(), "differs from the recording") {
+		t.Errorf("Err = %v", err)
+	}
+	if resp := p.Handle(request(vkey.CmdCBOR, []byte{0x04})); resp.Cmd != vkey.CmdError {
+		t.Errorf("request after a mismatch answered %+v", resp)
+	}
+}
+
+// TestReplay plays the fixture behind a virtual HID device and runs the
+// recorded exchange against its hidraw node, as fit --replay does.
+func TestReplay(t *testing.T) {
+	f, err := os.OpenFile("/dev/uhid", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no /dev/uhid: %v", err)
+	}
+	f.Close()
+
+	s := loadFixture(t)
+	p := NewPlayer(s)
+	key, err := vkey.Start(vkey.Info{Name: s.Device.Product, Serial: s.Device.Serial, VID: s.Device.VID, PID: s.Device.PID}, p)
+	if err != nil {
+		t.Skipf("cannot create a virtual device: %v", err)
+	}
+	defer key.Close()
+
+	c, err := hidraw.Open(key.Path, 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	info, err := getinfo.Query(c, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(info.Versions, "FIDO_2_1") {
+		t.Errorf("versions = %v", info.Versions)
+	}
+	st, body, err := c.CBOR(getRetries, 5*time.Second)
+	if err != nil || st != 0 || len(body) == 0 {
+		t.Fatalf("getPINRetries: status 0x%02x, %v", st, err)
+	}
+	if err := p.Err(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package replay records the CTAP traffic of a fit run against a real key
+// (--record) and plays it back to a later run (--replay), so a problem seen
+// on one key becomes a test that runs without it.
+//
+// A session file is a CBOR sequence: a header, the selected device, then
+// every CTAPHID request and response in order, as libfido2 logged them.
+// Requests carry the values fit and libfido2 draw at random (challenges,
+// client data hashes, user IDs, CTAPHID_INIT nonces, ephemeral PIN protocol
+// keys and what is derived from them); the player ignores those when it
+// compares a run's requests with the recorded ones.
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"fit/internal/ctaptrace"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Version is the session file format version.
+const Version = 1
+
+// Header opens a session file.
+type Header struct {
+	Version int      `cbor:"version"`
+	Fit     string   `cbor:"fit"`  // fit version that recorded the session
+	Args    []string `cbor:"args"` // command line, with PIN values removed
+	Time    string   `cbor:"time"` // RFC 3339
+}
+
+// Device is the key the session was recorded with.
+type Device struct {
+	Path         string `cbor:"path"`
+	Manufacturer string `cbor:"manufacturer,omitempty"`
+	Product      string `cbor:"product,omitempty"`
+	Serial       string `cbor:"serial,omitempty"` // HID serial number
+	VID          uint16 `cbor:"vid"`
+	PID          uint16 `cbor:"pid"`
+}
+
+// Message is one CTAPHID request ("tx") or response ("rx").
+type Message struct {
+	Dir    string `cbor:"dir"`
+	HID    byte   `cbor:"hid"` // CTAPHID command
+	Data   []byte `cbor:"data"`
+	Millis int64  `cbor:"ms"` // since the device was selected
+}
+
+// record is one item of the sequence; exactly one field is set.
+type record struct {
+	Header  *Header  `cbor:"header,omitempty"`
+	Device  *Device  `cbor:"device,omitempty"`
+	Message *Message `cbor:"message,omitempty"`
+}
+
+// Session is a loaded session file.
+type Session struct {
+	Header   Header
+	Device   Device
+	Messages []Message
+}
+
+// Load reads the session file at path.
+func Load(path string) (*Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := cbor.NewDecoder(f)
+	var s Session
+	for i := 0; ; i++ {
+		var r record
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: item %d: %v", path, i, err)
+		}
+		switch {
+		case i == 0:
+			if r.Header == nil {
+				return nil, fmt.Errorf("%s: not a fit session file", path)
+			}
+			if r.Header.Version != Version {
+				return nil, fmt.Errorf("%s: session format version %d, want %d", path, r.Header.Version, Version)
+			}
+			s.Header = *r.Header
+		case i == 1:
+			if r.Device == nil {
+				return nil, fmt.Errorf("%s: item 1 is not the device", path)
+			}
+			s.Device = *r.Device
+		case r.Message != nil:
+			s.Messages = append(s.Messages, *r.Message)
+		default:
+			return nil, fmt.Errorf("%s: item %d is not a message", path, i)
+		}
+	}
+	if s.Header.Version == 0 {
+		return nil, fmt.Errorf("%s: empty session file", path)
+	}
+	if s.Device.Path == "" {
+		return nil, fmt.Errorf("%s: the recorded run selected no device", path)
+	}
+	return &s, nil
+}
+
+// Recorder writes a session file as the run goes, so a crash or Ctrl-C
+// keeps what was exchanged so far.
+type Recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	enc     *cbor.Encoder
+	started time.Time // when the device was selected; zero before
+	err     error
+}
+
+// Create creates the session file at path (mode 0600: sessions hold
+// credential IDs and user names) and writes h.
+func Create(path string, h Header) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	h.Version = Version
+	r := &Recorder{f: f, enc: cbor.NewEncoder(f)}
+	if err := r.enc.Encode(record{Header: &h}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Device records the selected key. Messages are recorded from then on;
+// those exchanged while choosing a key are not part of the session.
+func (r *Recorder) Device(d Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started.IsZero() {
+		return
+	}
+	r.started = time.Now()
+	r.write(record{Device: &d})
+}
+
+// Observe records m; it is a ctaptrace.Tracer's Observe function.
+func (r *Recorder) Observe(m ctaptrace.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started.IsZero() {
+		return
+	}
+	r.write(record{Message: &Message{
+		Dir:    m.Dir,
+		HID:    m.HID,
+		Data:   m.Data,
+		Millis: m.Time.Sub(r.started).Milliseconds(),
+	}})
+}
+
+// write appends one item. The caller holds r.mu.
+func (r *Recorder) write(rec record) {
+	if r.err == nil {
+		r.err = r.enc.Encode(rec)
+	}
+}
+
+// Close closes the file and returns the first write error.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
@@ -0,0 +1,112 @@
+package rp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// AttestationObject is the decoded CBOR attestationObject.
+type AttestationObject struct {
+	Format   string         `cbor:"fmt"`
+	AttStmt  map[string]any `cbor:"attStmt"`
+	AuthData []byte         `cbor:"authData"`
+}
+
+// ParseAttestationObject decodes a CBOR attestationObject.
+func ParseAttestationObject(b []byte) (*AttestationObject, error) {
+	var obj AttestationObject
+	if err := cbor.Unmarshal(b, &obj); err != nil {
+		return nil, fmt.Errorf("attestationObject: %w", err)
+	}
+	return &obj, nil
+}
+
+// EncodeAttestationObject builds a CBOR attestationObject from its parts.
+func EncodeAttestationObject(format string, authData []byte, attStmt map[string]any) ([]byte, error) {
+	if attStmt == nil {
+		attStmt = map[string]any{}
+	}
+	return cbor.Marshal(AttestationObject{Format: format, AttStmt: attStmt, AuthData: authData})
+}
+
+// PackedAttStmt builds a packed (or fido-u2f) attestation statement from a signature
+// and optional DER certificate, as returned by libfido2.
+func PackedAttStmt(format string, alg int64, sig, cert []byte) map[string]any {
+	stmt := map[string]any{"sig": sig}
+	if format != "fido-u2f" {
+		stmt["alg"] = alg
+	}
+	if len(cert) > 0 {
+		stmt["x5c"] = [][]byte{cert}
+	}
+	return stmt
+}
+
+// verifyAttestation checks the attestation statement against the parsed authenticator data.
+func verifyAttestation(obj *AttestationObject, ad *AuthenticatorData, cred *PublicKey, clientDataHash []byte) error {
+	switch obj.Format {
+	case "none":
+		if len(obj.AttStmt) != 0 {
+			return errors.New("attestation: none format with non-empty attStmt")
+		}
+		return nil
+	case "packed":
+		return verifyPacked(obj, cred, clientDataHash)
+	case "fido-u2f":
+		return verifyU2F(obj, ad, cred, clientDataHash)
+	}
+	return fmt.Errorf("attestation: unsupported format %q", obj.Format)
+}
+
+func verifyPacked(obj *AttestationObject, cred *PublicKey, clientDataHash []byte) error {
+	alg, ok := coseInt(obj.AttStmt["alg"])
+	if !ok {
+		return errors.New("attestation: packed attStmt missing alg")
+	}
+	sig, _ := obj.AttStmt["sig"].([]byte)
+	msg := append(append([]byte{}, obj.AuthData...), clientDataHash...)
+	if x5c, ok := obj.AttStmt["x5c"].([]any); ok && len(x5c) > 0 {
+		der, _ := x5c[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("attestation: x5c: %w", err)
+		}
+		return verifySignature(alg, cert.PublicKey, msg, sig)
+	}
+	if alg != cred.Alg {
+		return fmt.Errorf("attestation: self attestation alg %d does not match credential alg %d", alg, cred.Alg)
+	}
+	return cred.Verify(msg, sig)
+}
+
+func verifyU2F(obj *AttestationObject, ad *AuthenticatorData, cred *PublicKey, clientDataHash []byte) error {
+	sig, _ := obj.AttStmt["sig"].([]byte)
+	x5c, _ := obj.AttStmt["x5c"].([]any)
+	if len(x5c) != 1 {
+		return errors.New("attestation: fido-u2f requires exactly one certificate")
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("attestation: x5c: %w", err)
+	}
+	pub, ok := cred.Key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return errors.New("attestation: fido-u2f requires a P-256 credential key")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(0x00)
+	buf.Write(ad.RPIDHash)
+	buf.Write(clientDataHash)
+	buf.Write(ad.CredID)
+	buf.WriteByte(0x04)
+	buf.Write(pub.X.FillBytes(make([]byte, 32)))
+	buf.Write(pub.Y.FillBytes(make([]byte, 32)))
+	return verifySignature(AlgES256, cert.PublicKey, buf.Bytes(), sig)
+}
@@ -0,0 +1,92 @@
+package rp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Authenticator data flag bits.
+const (
+	FlagUP = 0x01 // user present
+	FlagUV = 0x04 // user verified
+	FlagBE = 0x08 // backup eligible
+	FlagBS = 0x10 // backed up
+	FlagAT = 0x40 // attested credential data included
+	FlagED = 0x80 // extension data included
+)
+
+// AuthenticatorData is the parsed authenticatorData structure.
+type AuthenticatorData struct {
+	RPIDHash   []byte
+	Flags      byte
+	SignCount  uint32
+	AAGUID     []byte
+	CredID     []byte
+	PublicKey  []byte // COSE_Key bytes when FlagAT is set
+	Extensions map[string]any
+	Raw        []byte
+}
+
+// UserPresent reports whether the UP flag is set.
+func (a *AuthenticatorData) UserPresent() bool { return a.Flags&FlagUP != 0 }
+
+// UserVerified reports whether the UV flag is set.
+func (a *AuthenticatorData) UserVerified() bool { return a.Flags&FlagUV != 0 }
+
+// ParseAuthenticatorData decodes raw authenticator data bytes.
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("authData: too short (%d bytes)", len(b))
+	}
+	ad := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+		Raw:       b,
+	}
+	rest := b[37:]
+	if ad.Flags&FlagAT != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("authData: truncated attested credential data")
+		}
+		ad.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, errors.New("authData: truncated credential ID")
+		}
+		ad.CredID = rest[:n]
+		rest = rest[n:]
+		var key cbor.RawMessage
+		tail, err := cbor.UnmarshalFirst(rest, &key)
+		if err != nil {
+			return nil, fmt.Errorf("authData: credential public key: %w", err)
+		}
+		ad.PublicKey = []byte(key)
+		rest = tail
+	}
+	if ad.Flags&FlagED != 0 {
+		tail, err := cbor.UnmarshalFirst(rest, &ad.Extensions)
+		if err != nil {
+			return nil, fmt.Errorf("authData: extensions: %w", err)
+		}
+		rest = tail
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("authData: %d trailing bytes", len(rest))
+	}
+	return ad, nil
+}
+
+// UnwrapCBORBytes returns the payload of a CBOR byte string, or b unchanged when
+// it is not one. libfido2 reports authenticator data CBOR-wrapped.
+func UnwrapCBORBytes(b []byte) []byte {
+	var inner []byte
+	if rest, err := cbor.UnmarshalFirst(b, &inner); err == nil && len(rest) == 0 {
+		return inner
+	}
+	return b
+}
@@ -0,0 +1,201 @@
+package rp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client drives a remote relying party that follows the common begin/finish
+// REST shape (POST JSON to begin, receive options, POST the credential to finish).
+type Client struct {
+	HTTP   *http.Client
+	Header http.Header
+}
+
+// NewClient returns a client with a cookie jar so session cookies set by the
+// begin endpoint are replayed to finish. cookies ("name=value") are preloaded
+// for base.
+func NewClient(base string, cookies []string, timeout time.Duration) (*Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(cookies) > 0 {
+		u, err := url.Parse(base)
+		if err != nil {
+			return nil, err
+		}
+		var cs []*http.Cookie
+		for _, c := range cookies {
+			name, value, ok := strings.Cut(c, "=")
+			if !ok {
+				return nil, fmt.Errorf("cookie %q: want name=value", c)
+			}
+			cs = append(cs, &http.Cookie{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+		}
+		jar.SetCookies(u, cs)
+	}
+	return &Client{HTTP: &http.Client{Jar: jar, Timeout: timeout}, Header: http.Header{}}, nil
+}
+
+// Step describes one HTTP exchange with the relying party.
+type Step struct {
+	Name   string          `json:"step"`
+	URL    string          `json:"url"`
+	Status int             `json:"status"`
+	Millis int64           `json:"ms"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// ServerError is returned when the relying party answers with a non-2xx status
+// or a "failed" status envelope.
+type ServerError struct {
+	Step    string
+	Status  int
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s: server returned %d: %s", e.Step, e.Status, e.Message)
+}
+
+// Post sends body as JSON to target and returns the exchange; the response body
+// is kept on the returned Step.
+func (c *Client) Post(name, target string, body any) (*Step, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	start := time.Now()
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	st := &Step{Name: name, URL: target, Status: resp.StatusCode, Millis: time.Since(start).Milliseconds()}
+	if json.Valid(raw) {
+		st.Body = raw
+	}
+	if resp.StatusCode/100 != 2 {
+		return st, &ServerError{Step: name, Status: resp.StatusCode, Message: errorMessage(raw)}
+	}
+	var env ServerResponse
+	if json.Unmarshal(raw, &env) == nil && strings.EqualFold(env.Status, "failed") {
+		return st, &ServerError{Step: name, Status: resp.StatusCode, Message: env.ErrorMessage}
+	}
+	return st, nil
+}
+
+// BeginRegistration posts body to the begin endpoint and decodes the creation
+// options it returns. Options without an RP ID get the host of beginURL, as a
+// browser uses the page's host.
+func (c *Client) BeginRegistration(beginURL string, body any) (*Step, *CreationOptions, error) {
+	st, err := c.Post("begin", beginURL, body)
+	if err != nil {
+		return st, nil, err
+	}
+	opts, err := DecodeCreationOptions(st.Body)
+	if err != nil {
+		return st, nil, err
+	}
+	if opts.RP.ID == "" {
+		opts.RP.ID = hostOf(beginURL)
+	}
+	return st, opts, nil
+}
+
+// BeginLogin is BeginRegistration for the request options of a login.
+func (c *Client) BeginLogin(beginURL string, body any) (*Step, *RequestOptions, error) {
+	st, err := c.Post("begin", beginURL, body)
+	if err != nil {
+		return st, nil, err
+	}
+	opts, err := DecodeRequestOptions(st.Body)
+	if err != nil {
+		return st, nil, err
+	}
+	if opts.RPID == "" {
+		opts.RPID = hostOf(beginURL)
+	}
+	return st, opts, nil
+}
+
+// hostOf returns the hostname of u, or "" if it does not parse.
+func hostOf(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return p.Hostname()
+}
+
+// DecodeCreationOptions accepts either {"publicKey": {...}} or bare creation options.
+func DecodeCreationOptions(raw []byte) (*CreationOptions, error) {
+	var wrapped CredentialCreation
+	if err := json.Unmarshal(raw, &wrapped); err == nil && len(wrapped.PublicKey.Challenge) > 0 {
+		return &wrapped.PublicKey, nil
+	}
+	var opts CreationOptions
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return nil, fmt.Errorf("creation options: %w", err)
+	}
+	if len(opts.Challenge) == 0 {
+		return nil, fmt.Errorf("creation options: missing challenge")
+	}
+	return &opts, nil
+}
+
+// DecodeRequestOptions accepts either {"publicKey": {...}} or bare request options.
+func DecodeRequestOptions(raw []byte) (*RequestOptions, error) {
+	var wrapped CredentialAssertion
+	if err := json.Unmarshal(raw, &wrapped); err == nil && len(wrapped.PublicKey.Challenge) > 0 {
+		return &wrapped.PublicKey, nil
+	}
+	var opts RequestOptions
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return nil, fmt.Errorf("request options: %w", err)
+	}
+	if len(opts.Challenge) == 0 {
+		return nil, fmt.Errorf("request options: missing challenge")
+	}
+	return &opts, nil
+}
+
+// errorMessage extracts a human-readable message from an error response body.
+func errorMessage(raw []byte) string {
+	var m map[string]any
+	if json.Unmarshal(raw, &m) == nil {
+		for _, k := range []string{"errorMessage", "error", "message", "detail"} {
+			if s, ok := m[k].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	s := strings.TrimSpace(string(raw))
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return s
+}
@@ -0,0 +1,149 @@
+package rp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fit/internal/clientpolicy"
+)
+
+// withoutRPID serves h, deleting the RP ID from the options its begin
+// endpoints return, as relying parties that rely on the client's default do.
+func withoutRPID(t *testing.T, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		if strings.HasSuffix(r.URL.Path, "/begin") && rec.Code == http.StatusOK {
+			var doc struct {
+				PublicKey map[string]any `json:"publicKey"`
+			}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				t.Errorf("begin response: %v", err)
+			}
+			delete(doc.PublicKey, "rpId")
+			if rp, ok := doc.PublicKey["rp"].(map[string]any); ok {
+				delete(rp, "id")
+			}
+			body, _ = json.Marshal(doc)
+		}
+		for k, vs := range rec.Header() {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	})
+}
+
+func TestCeremonyDefaultRPID(t *testing.T) {
+	ts := httptest.NewServer(withoutRPID(t, NewServer(DefaultPolicy("localhost"), NewMemoryStore())))
+	defer ts.Close()
+	// Reach the server by name, so that the begin URL host is an RP ID.
+	base := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	c := testClient(t, ts)
+	k := newTestKey(t)
+
+	_, opts, err := c.BeginRegistration(base+"/register/begin", beginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.RP.ID != "localhost" {
+		t.Fatalf("RP ID = %q, want the begin URL host", opts.RP.ID)
+	}
+	if _, err := (clientpolicy.Policy{RelatedOriginsURL: "-"}).Check(opts.RP.ID, clientpolicy.DefaultOrigin(opts.RP.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Post("finish", base+"/register/finish", k.create(t, *opts)); err != nil {
+		t.Fatal(err)
+	}
+
+	k.count = 1
+	_, ropts, err := c.BeginLogin(base+"/login/begin", beginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ropts.RPID != "localhost" {
+		t.Fatalf("login RP ID = %q, want the begin URL host", ropts.RPID)
+	}
+	if _, err := c.Post("finish", base+"/login/finish", k.get(t, *ropts)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCeremonyBeginErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/refused/begin":
+			http.Error(w, `{"errorMessage":"registration closed"}`, http.StatusForbidden)
+		case "/empty/begin":
+			w.Write([]byte(`{"publicKey":{"rp":{"name":"x"}}}`))
+		}
+	}))
+	defer ts.Close()
+	c := testClient(t, ts)
+
+	st, _, err := c.BeginRegistration(ts.URL+"/refused/begin", nil)
+	wantServerError(t, err, "registration closed")
+	if st == nil || st.Status != http.StatusForbidden {
+		t.Errorf("step = %+v, want the 403 exchange", st)
+	}
+	if _, _, err := c.BeginLogin(ts.URL+"/empty/begin", nil); err == nil || !strings.Contains(err.Error(), "missing challenge") {
+		t.Errorf("err = %v, want missing challenge", err)
+	}
+}
+
+func TestCeremonyOriginRejected(t *testing.T) {
+	related := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/webauthn" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"origins":["https://login.example.org"]}`))
+	}))
+	defer related.Close()
+	ts := httptest.NewServer(NewServer(DefaultPolicy("example.com"), NewMemoryStore()))
+	defer ts.Close()
+	c := testClient(t, ts)
+
+	_, opts, err := c.BeginRegistration(ts.URL+"/register/begin", beginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := clientpolicy.Policy{RelatedOriginsURL: related.URL, HTTP: related.Client()}
+	tests := []struct {
+		origin string
+		want   string // "" when accepted
+	}{
+		{"https://example.com", ""},
+		{"https://login.example.org", ""},
+		{"https://evil.example.net", "origin not listed in /.well-known/webauthn"},
+		{"http://example.com", "--allow-insecure-origin"},
+	}
+	for _, tt := range tests {
+		_, err := p.Check(opts.RP.ID, tt.origin)
+		var ce *clientpolicy.Error
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.origin, err)
+		case tt.want != "" && (!errors.As(err, &ce) || !strings.Contains(ce.Reason, tt.want)):
+			t.Errorf("%s: err = %v, want a rejection containing %q", tt.origin, err, tt.want)
+		}
+	}
+
+	// An IP begin URL without an RP ID in the options defaults to the
+	// address, which the client rules reject.
+	ip := httptest.NewServer(withoutRPID(t, NewServer(DefaultPolicy("127.0.0.1"), NewMemoryStore())))
+	defer ip.Close()
+	_, opts, err = c.BeginRegistration(ip.URL+"/register/begin", beginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (clientpolicy.Policy{}).Check(opts.RP.ID, clientpolicy.DefaultOrigin(opts.RP.ID)); err == nil {
+		t.Errorf("origin for RP ID %q accepted", opts.RP.ID)
+	}
+}
@@ -0,0 +1,168 @@
+package rp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithm identifiers supported by the relying party.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgES384 int64 = -35
+	AlgRS256 int64 = -257
+)
+
+// AlgName returns the short JOSE-style name for a COSE algorithm identifier.
+func AlgName(alg int64) string {
+	switch alg {
+	case AlgES256:
+		return "ES256"
+	case AlgEdDSA:
+		return "EdDSA"
+	case AlgES384:
+		return "ES384"
+	case AlgRS256:
+		return "RS256"
+	}
+	return fmt.Sprintf("alg(%d)", alg)
+}
+
+// ParseAlg accepts a JOSE name (ES256, EdDSA, ...) or a numeric COSE identifier.
+func ParseAlg(s string) (int64, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "ES256", "-7":
+		return AlgES256, nil
+	case "EDDSA", "ED25519", "-8":
+		return AlgEdDSA, nil
+	case "ES384", "-35":
+		return AlgES384, nil
+	case "RS256", "-257":
+		return AlgRS256, nil
+	}
+	return 0, fmt.Errorf("unsupported algorithm %q", s)
+}
+
+// PublicKey is a credential public key decoded from its COSE_Key encoding.
+type PublicKey struct {
+	Alg int64
+	Key crypto.PublicKey
+}
+
+// ParseCOSEKey decodes a COSE_Key (EC2, OKP or RSA) into a Go public key.
+func ParseCOSEKey(b []byte) (*PublicKey, error) {
+	var m map[int64]any
+	if err := cbor.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("cose key: %w", err)
+	}
+	kty, _ := coseInt(m[1])
+	alg, ok := coseInt(m[3])
+	if !ok {
+		return nil, errors.New("cose key: missing alg")
+	}
+	switch kty {
+	case 2: // EC2
+		crv, _ := coseInt(m[-1])
+		x, _ := m[-2].([]byte)
+		y, _ := m[-3].([]byte)
+		var curve elliptic.Curve
+		switch crv {
+		case 1:
+			curve = elliptic.P256()
+		case 2:
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("cose key: unsupported EC2 curve %d", crv)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("cose key: EC point not on curve")
+		}
+		return &PublicKey{Alg: alg, Key: pub}, nil
+	case 1: // OKP
+		crv, _ := coseInt(m[-1])
+		x, _ := m[-2].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("cose key: unsupported OKP curve %d", crv)
+		}
+		return &PublicKey{Alg: alg, Key: ed25519.PublicKey(x)}, nil
+	case 3: // RSA
+		n, _ := m[-1].([]byte)
+		e, _ := m[-2].([]byte)
+		if len(n) == 0 || len(e) == 0 {
+			return nil, errors.New("cose key: malformed RSA key")
+		}
+		return &PublicKey{Alg: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+	return nil, fmt.Errorf("cose key: unsupported kty %d", kty)
+}
+
+// Verify checks sig over msg using the key's algorithm.
+func (k *PublicKey) Verify(msg, sig []byte) error {
+	return verifySignature(k.Alg, k.Key, msg, sig)
+}
+
+// verifySignature checks a WebAuthn signature for the given COSE algorithm.
+func verifySignature(alg int64, key crypto.PublicKey, msg, sig []byte) error {
+	switch alg {
+	case AlgES256, AlgES384:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("signature: key is not ECDSA")
+		}
+		var digest []byte
+		if alg == AlgES256 {
+			h := sha256.Sum256(msg)
+			digest = h[:]
+		} else {
+			h := sha512.Sum384(msg)
+			digest = h[:]
+		}
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return errors.New("signature: ECDSA verification failed")
+		}
+		return nil
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("signature: key is not Ed25519")
+		}
+		if !ed25519.Verify(pub, msg, sig) {
+			return errors.New("signature: Ed25519 verification failed")
+		}
+		return nil
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("signature: key is not RSA")
+		}
+		h := sha256.Sum256(msg)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+			return fmt.Errorf("signature: RSA verification failed: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("signature: unsupported algorithm %d", alg)
+}
+
+// coseInt normalizes CBOR integers (decoded as int64 or uint64) to int64.
+func coseInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,449 @@
+// Package rp implements a small WebAuthn relying party used to exercise the
+// CLI's registration and authentication ceremonies end to end without a real
+// web service.
+package rp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"fit/internal/chal"
+)
+
+// Policy configures what the relying party asks for and accepts.
+type Policy struct {
+	RPID             string
+	RPName           string
+	Origins          []string // accepted clientData origins
+	UserVerification string   // required | preferred | discouraged
+	ResidentKey      string   // required | preferred | discouraged
+	Algorithms       []int64  // accepted COSE algorithms, in preference order
+	Attestation      string   // none | indirect | direct | enterprise
+	Timeout          time.Duration
+}
+
+// DefaultPolicy returns a permissive policy for rpID with origin https://rpID.
+func DefaultPolicy(rpID string) Policy {
+	return Policy{
+		RPID:             rpID,
+		RPName:           rpID,
+		Origins:          []string{"https://" + rpID},
+		UserVerification: "preferred",
+		ResidentKey:      "preferred",
+		Algorithms:       []int64{AlgES256, AlgEdDSA, AlgRS256},
+		Attestation:      "none",
+		Timeout:          2 * time.Minute,
+	}
+}
+
+// Validate checks the policy for unsupported values.
+func (p Policy) Validate() error {
+	if p.RPID == "" {
+		return errors.New("policy: rp id required")
+	}
+	if len(p.Origins) == 0 {
+		return errors.New("policy: at least one origin required")
+	}
+	for _, v := range []string{p.UserVerification, p.ResidentKey} {
+		switch v {
+		case "required", "preferred", "discouraged":
+		default:
+			return fmt.Errorf("policy: invalid requirement %q (want required|preferred|discouraged)", v)
+		}
+	}
+	switch p.Attestation {
+	case "none", "indirect", "direct", "enterprise":
+	default:
+		return fmt.Errorf("policy: invalid attestation conveyance %q", p.Attestation)
+	}
+	if len(p.Algorithms) == 0 {
+		return errors.New("policy: at least one algorithm required")
+	}
+	return nil
+}
+
+// session is the server-side state between a begin and finish call.
+type session struct {
+	kind      string // "register" | "login"
+	challenge []byte
+	user      string
+	account   *User // the user registering; stored by registerFinish if new
+	expires   time.Time
+}
+
+// SessionCookie is the cookie carrying the ceremony session between begin and finish.
+const SessionCookie = "fit-rp-session"
+
+// Server is an http.Handler exposing begin/finish endpoints for registration
+// and authentication:
+//
+//	POST /register/begin   {"username": "...", "displayName": "..."}
+//	POST /register/finish  PublicKeyCredential (attestation)
+//	POST /login/begin      {"username": "..."} (empty for discoverable)
+//	POST /login/finish     PublicKeyCredential (assertion)
+//	GET  /users            registered users and credentials
+type Server struct {
+	Policy Policy
+	Store  *Store
+	// Logf, when set, receives one line per ceremony step.
+	Logf func(format string, args ...any)
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	mux      *http.ServeMux
+}
+
+// NewServer returns a relying party server for policy backed by store.
+func NewServer(policy Policy, store *Store) *Server {
+	s := &Server{Policy: policy, Store: store, sessions: map[string]*session{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register/begin", s.registerBegin)
+	mux.HandleFunc("POST /register/finish", s.registerFinish)
+	mux.HandleFunc("POST /login/begin", s.loginBegin)
+	mux.HandleFunc("POST /login/finish", s.loginFinish)
+	mux.HandleFunc("GET /users", s.users)
+	s.mux = mux
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+type beginRequest struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+}
+
+func (s *Server) registerBegin(w http.ResponseWriter, r *http.Request) {
+	var req beginRequest
+	if err := decodeBody(r, &req); err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Username == "" {
+		s.fail(w, http.StatusBadRequest, errors.New("username required"))
+		return
+	}
+	if req.DisplayName == "" {
+		req.DisplayName = req.Username
+	}
+	// A new user is only stored once a credential is registered for it, so
+	// abandoned ceremonies leave nothing behind.
+	u, ok := s.Store.User(req.Username)
+	if !ok {
+		u = &User{ID: chal.Bytes(32), Name: req.Username, DisplayName: req.DisplayName}
+	}
+	challenge := chal.Bytes(32)
+	opts := CreationOptions{
+		RP:        RelyingPartyEntity{ID: s.Policy.RPID, Name: s.Policy.RPName},
+		User:      UserEntity{ID: u.ID, Name: u.Name, DisplayName: u.DisplayName},
+		Challenge: challenge,
+		Timeout:   s.Policy.Timeout.Milliseconds(),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        s.Policy.ResidentKey,
+			RequireResidentKey: s.Policy.ResidentKey == "required",
+			UserVerification:   s.Policy.UserVerification,
+		},
+		Attestation: s.Policy.Attestation,
+	}
+	for _, alg := range s.Policy.Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	for _, c := range u.Credentials {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, CredentialDescriptor{Type: "public-key", ID: c.ID, Transports: c.Transports})
+	}
+	s.startSession(w, &session{kind: "register", challenge: challenge, user: u.Name, account: u})
+	s.logf("register/begin user=%s exclude=%d", u.Name, len(opts.ExcludeCredentials))
+	writeJSON(w, http.StatusOK, CredentialCreation{PublicKey: opts})
+}
+
+func (s *Server) registerFinish(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.takeSession(r, "register")
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	var cred RegistrationCredential
+	if err := decodeBody(r, &cred); err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	c, err := s.verifyRegistration(sess, &cred)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.Store.AddCredential(sess.account, c); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrCredentialExists) {
+			code = http.StatusBadRequest
+		}
+		s.fail(w, code, err)
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(c.ID)
+	s.logf("register/finish user=%s cred=%s alg=%s fmt=%s", sess.user, id, AlgName(c.Alg), c.Format)
+	writeJSON(w, http.StatusOK, ServerResponse{Status: "ok", CredentialID: id, User: sess.user, SignCount: c.SignCount})
+}
+
+func (s *Server) verifyRegistration(sess *session, cred *RegistrationCredential) (*Credential, error) {
+	cdh, err := s.verifyClientData(cred.Response.ClientDataJSON, "webauthn.create", sess.challenge)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ParseAttestationObject(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := ParseAuthenticatorData(obj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyAuthData(ad); err != nil {
+		return nil, err
+	}
+	if ad.Flags&FlagAT == 0 {
+		return nil, errors.New("authData: attested credential data missing")
+	}
+	if len(cred.RawID) > 0 && !bytes.Equal(cred.RawID, ad.CredID) {
+		return nil, errors.New("rawId does not match attested credential ID")
+	}
+	key, err := ParseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(s.Policy.Algorithms, key.Alg) {
+		return nil, fmt.Errorf("credential algorithm %s not allowed by policy", AlgName(key.Alg))
+	}
+	if err := verifyAttestation(obj, ad, key, cdh); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:         ad.CredID,
+		PublicKey:  ad.PublicKey,
+		Alg:        key.Alg,
+		AAGUID:     ad.AAGUID,
+		Format:     obj.Format,
+		SignCount:  ad.SignCount,
+		UV:         ad.UserVerified(),
+		Transports: cred.Response.Transports,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+func (s *Server) loginBegin(w http.ResponseWriter, r *http.Request) {
+	var req beginRequest
+	if err := decodeBody(r, &req); err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	challenge := chal.Bytes(32)
+	opts := RequestOptions{
+		Challenge:        challenge,
+		Timeout:          s.Policy.Timeout.Milliseconds(),
+		RPID:             s.Policy.RPID,
+		UserVerification: s.Policy.UserVerification,
+	}
+	if req.Username != "" {
+		u, ok := s.Store.User(req.Username)
+		if !ok || len(u.Credentials) == 0 {
+			s.fail(w, http.StatusNotFound, fmt.Errorf("no credentials registered for %q", req.Username))
+			return
+		}
+		for _, c := range u.Credentials {
+			opts.AllowCredentials = append(opts.AllowCredentials, CredentialDescriptor{Type: "public-key", ID: c.ID, Transports: c.Transports})
+		}
+	}
+	s.startSession(w, &session{kind: "login", challenge: challenge, user: req.Username})
+	s.logf("login/begin user=%q allow=%d", req.Username, len(opts.AllowCredentials))
+	writeJSON(w, http.StatusOK, CredentialAssertion{PublicKey: opts})
+}
+
+func (s *Server) loginFinish(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.takeSession(r, "login")
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	var cred AuthenticationCredential
+	if err := decodeBody(r, &cred); err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	u, c, err := s.verifyAssertion(sess, &cred)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(c.ID)
+	s.logf("login/finish user=%s cred=%s signCount=%d", u.Name, id, c.SignCount)
+	writeJSON(w, http.StatusOK, ServerResponse{Status: "ok", CredentialID: id, User: u.Name, SignCount: c.SignCount})
+}
+
+func (s *Server) verifyAssertion(sess *session, cred *AuthenticationCredential) (*User, *Credential, error) {
+	id := []byte(cred.RawID)
+	if len(id) == 0 {
+		b, err := DecodeBase64(cred.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("credential id: %w", err)
+		}
+		id = b
+	}
+	u, c, ok := s.Store.FindCredential(id)
+	if !ok {
+		return nil, nil, errors.New("unknown credential")
+	}
+	if sess.user != "" && u.Name != sess.user {
+		return nil, nil, errors.New("credential does not belong to the requested user")
+	}
+	if len(cred.Response.UserHandle) > 0 && !bytes.Equal(cred.Response.UserHandle, u.ID) {
+		return nil, nil, errors.New("userHandle does not match credential owner")
+	}
+	cdh, err := s.verifyClientData(cred.Response.ClientDataJSON, "webauthn.get", sess.challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	ad, err := ParseAuthenticatorData(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.verifyAuthData(ad); err != nil {
+		return nil, nil, err
+	}
+	key, err := ParseCOSEKey(c.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := append(append([]byte{}, cred.Response.AuthenticatorData...), cdh...)
+	if err := key.Verify(msg, cred.Response.Signature); err != nil {
+		return nil, nil, err
+	}
+	if err := s.Store.UseCredential(c.ID, ad.SignCount, time.Now().UTC()); err != nil {
+		return nil, nil, err
+	}
+	c.SignCount = ad.SignCount
+	return u, c, nil
+}
+
+// verifyClientData checks type, challenge and origin and returns the clientDataHash.
+func (s *Server) verifyClientData(raw []byte, typ string, challenge []byte) ([]byte, error) {
+	var cd CollectedClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("clientDataJSON: %w", err)
+	}
+	if cd.Type != typ {
+		return nil, fmt.Errorf("clientData type %q, want %q", cd.Type, typ)
+	}
+	got, err := DecodeBase64(cd.Challenge)
+	if err != nil || !bytes.Equal(got, challenge) {
+		return nil, errors.New("clientData challenge mismatch")
+	}
+	if !slices.Contains(s.Policy.Origins, cd.Origin) {
+		return nil, fmt.Errorf("clientData origin %q not allowed", cd.Origin)
+	}
+	h := sha256.Sum256(raw)
+	return h[:], nil
+}
+
+// verifyAuthData checks the RP ID hash and the UP/UV flags against policy.
+func (s *Server) verifyAuthData(ad *AuthenticatorData) error {
+	want := sha256.Sum256([]byte(s.Policy.RPID))
+	if !bytes.Equal(ad.RPIDHash, want[:]) {
+		return errors.New("authData rpIdHash does not match RP ID")
+	}
+	if !ad.UserPresent() {
+		return errors.New("user presence flag not set")
+	}
+	if s.Policy.UserVerification == "required" && !ad.UserVerified() {
+		return errors.New("user verification required by policy but UV flag not set")
+	}
+	return nil
+}
+
+func (s *Server) users(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Store.Users())
+}
+
+func (s *Server) startSession(w http.ResponseWriter, sess *session) {
+	sess.expires = time.Now().Add(s.Policy.Timeout)
+	// Session IDs are bearer secrets: they come from crypto/rand even when
+	// chal is seeded for test vectors.
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	s.mu.Lock()
+	now := time.Now()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[id] = sess
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: id, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+}
+
+// takeSession removes and returns the caller's pending session of the given kind.
+func (s *Server) takeSession(r *http.Request, kind string) (*session, error) {
+	ck, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, errors.New("no ceremony session (call begin first)")
+	}
+	s.mu.Lock()
+	sess, ok := s.sessions[ck.Value]
+	delete(s.sessions, ck.Value)
+	s.mu.Unlock()
+	if !ok || sess.kind != kind {
+		return nil, errors.New("unknown or mismatched ceremony session")
+	}
+	if time.Now().After(sess.expires) {
+		return nil, errors.New("ceremony session expired")
+	}
+	return sess, nil
+}
+
+func (s *Server) fail(w http.ResponseWriter, code int, err error) {
+	s.logf("error: %v", err)
+	writeJSON(w, code, ServerResponse{Status: "failed", ErrorMessage: err.Error()})
+}
+
+func decodeBody(r *http.Request, v any) error {
+	defer r.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("request body: %w", err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,285 @@
+package rp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const testOrigin = "https://localhost"
+
+// testKey is a software authenticator holding one Ed25519 credential.
+type testKey struct {
+	id    []byte
+	priv  ed25519.PrivateKey
+	count uint32
+}
+
+func newTestKey(t *testing.T) *testKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testKey{id: id, priv: priv}
+}
+
+// authData builds authenticator data for rpID, with the attested credential
+// data when attest is set.
+func (k *testKey) authData(t *testing.T, rpID string, attest bool) []byte {
+	t.Helper()
+	h := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, h[:]...)
+	flags := byte(FlagUP)
+	if attest {
+		flags |= FlagAT
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, k.count)
+	if attest {
+		b = append(b, make([]byte, 16)...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(k.id)))
+		b = append(b, k.id...)
+		key, err := cbor.Marshal(map[int]any{1: 1, 3: AlgEdDSA, -1: 6, -2: []byte(k.priv.Public().(ed25519.PublicKey))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, key...)
+	}
+	return b
+}
+
+// create answers creation options with a "none" attestation.
+func (k *testKey) create(t *testing.T, opts CreationOptions) RegistrationCredential {
+	t.Helper()
+	obj, err := EncodeAttestationObject("none", k.authData(t, opts.RP.ID, true), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RegistrationCredential{
+		RawID: k.id,
+		Type:  "public-key",
+		Response: AttestationResponse{
+			ClientDataJSON:    ClientDataJSON("webauthn.create", opts.Challenge, testOrigin),
+			AttestationObject: obj,
+		},
+	}
+}
+
+// get answers request options with a signed assertion.
+func (k *testKey) get(t *testing.T, opts RequestOptions) AuthenticationCredential {
+	t.Helper()
+	ad := k.authData(t, opts.RPID, false)
+	cd := ClientDataJSON("webauthn.get", opts.Challenge, testOrigin)
+	cdh := sha256.Sum256(cd)
+	return AuthenticationCredential{
+		RawID: k.id,
+		Type:  "public-key",
+		Response: AssertionResponse{
+			ClientDataJSON:    cd,
+			AuthenticatorData: ad,
+			Signature:         ed25519.Sign(k.priv, append(append([]byte{}, ad...), cdh[:]...)),
+		},
+	}
+}
+
+// testServer starts a relying party for localhost with an in-memory store.
+func testServer(t *testing.T, policy func(*Policy)) (*httptest.Server, *Store) {
+	t.Helper()
+	p := DefaultPolicy("localhost")
+	if policy != nil {
+		policy(&p)
+	}
+	store := NewMemoryStore()
+	ts := httptest.NewServer(NewServer(p, store))
+	t.Cleanup(ts.Close)
+	return ts, store
+}
+
+func testClient(t *testing.T, ts *httptest.Server) *Client {
+	t.Helper()
+	c, err := NewClient(ts.URL, nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func register(t *testing.T, ts *httptest.Server, c *Client, k *testKey, user string) error {
+	t.Helper()
+	st, err := c.Post("begin", ts.URL+"/register/begin", beginRequest{Username: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := DecodeCreationOptions(st.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Post("finish", ts.URL+"/register/finish", k.create(t, *opts))
+	return err
+}
+
+func login(t *testing.T, ts *httptest.Server, c *Client, k *testKey, user string, tamper func(*AuthenticationCredential)) error {
+	t.Helper()
+	st, err := c.Post("begin", ts.URL+"/login/begin", beginRequest{Username: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := DecodeRequestOptions(st.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := k.get(t, *opts)
+	if tamper != nil {
+		tamper(&cred)
+	}
+	_, err = c.Post("finish", ts.URL+"/login/finish", cred)
+	return err
+}
+
+// wantServerError checks that err is a ServerError whose message contains msg.
+func wantServerError(t *testing.T, err error, msg string) {
+	t.Helper()
+	var se *ServerError
+	if !errors.As(err, &se) || !strings.Contains(se.Message, msg) {
+		t.Fatalf("err = %v, want a server error containing %q", err, msg)
+	}
+}
+
+func TestRegisterLogin(t *testing.T) {
+	ts, store := testServer(t, nil)
+	c := testClient(t, ts)
+	k := newTestKey(t)
+
+	if _, ok := store.User("alice"); ok {
+		t.Fatal("user exists before registration")
+	}
+	if err := register(t, ts, c, k, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	k.count = 1
+	if err := login(t, ts, c, k, "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	k.count = 2
+	if err := login(t, ts, c, k, "", nil); err != nil {
+		t.Fatalf("discoverable login: %v", err)
+	}
+
+	u, ok := store.User("alice")
+	if !ok || len(u.Credentials) != 1 || u.Credentials[0].SignCount != 2 {
+		t.Fatalf("stored user = %+v", u)
+	}
+	resp, err := c.HTTP.Get(ts.URL + "/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var users []*User
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "alice" {
+		t.Errorf("GET /users = %+v", users)
+	}
+}
+
+func TestAbandonedRegistration(t *testing.T) {
+	ts, store := testServer(t, nil)
+	c := testClient(t, ts)
+	if _, err := c.Post("begin", ts.URL+"/register/begin", beginRequest{Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if users := store.Users(); len(users) != 0 {
+		t.Errorf("begin stored %d user(s)", len(users))
+	}
+}
+
+func TestLoginBadSignature(t *testing.T) {
+	ts, store := testServer(t, nil)
+	c := testClient(t, ts)
+	k := newTestKey(t)
+	if err := register(t, ts, c, k, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	k.count = 1
+	err := login(t, ts, c, k, "alice", func(cred *AuthenticationCredential) {
+		cred.Response.Signature[0] ^= 0xff
+	})
+	wantServerError(t, err, "signature")
+	if u, _ := store.User("alice"); u.Credentials[0].SignCount != 0 {
+		t.Errorf("signCount = %d after a failed login", u.Credentials[0].SignCount)
+	}
+}
+
+func TestLoginCounterRegression(t *testing.T) {
+	ts, store := testServer(t, nil)
+	c := testClient(t, ts)
+	k := newTestKey(t)
+	if err := register(t, ts, c, k, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	k.count = 5
+	if err := login(t, ts, c, k, "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []uint32{5, 3} {
+		k.count = n
+		wantServerError(t, login(t, ts, c, k, "alice", nil), "sign counter regression")
+	}
+	if u, _ := store.User("alice"); u.Credentials[0].SignCount != 5 {
+		t.Errorf("signCount = %d, want 5", u.Credentials[0].SignCount)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	ts, store := testServer(t, nil)
+	c := testClient(t, ts)
+	k := newTestKey(t)
+	if err := register(t, ts, c, k, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	wantServerError(t, register(t, ts, c, k, "carol"), ErrCredentialExists.Error())
+	if _, ok := store.User("carol"); ok {
+		t.Error("the refused registration stored its user")
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	ts, _ := testServer(t, func(p *Policy) { p.Timeout = time.Millisecond })
+	c := testClient(t, ts)
+	k := newTestKey(t)
+	st, err := c.Post("begin", ts.URL+"/register/begin", beginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := DecodeCreationOptions(st.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, err = c.Post("finish", ts.URL+"/register/finish", k.create(t, *opts))
+	wantServerError(t, err, "ceremony session expired")
+}
+
+func TestStoreRollback(t *testing.T) {
+	s := &Store{path: t.TempDir() + "/missing/store.json", users: map[string]*User{}}
+	u := &User{ID: []byte{1}, Name: "alice"}
+	if err := s.AddCredential(u, &Credential{ID: []byte{2}}); err == nil {
+		t.Fatal("AddCredential succeeded without a writable store")
+	}
+	if len(s.Users()) != 0 {
+		t.Errorf("failed AddCredential left %+v", s.Users())
+	}
+}
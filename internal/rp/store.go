@@ -0,0 +1,211 @@
+package rp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID         Base64URL `json:"id"`
+	PublicKey  Base64URL `json:"publicKey"` // COSE_Key
+	Alg        int64     `json:"alg"`
+	AAGUID     Base64URL `json:"aaguid,omitempty"`
+	Format     string    `json:"fmt"`
+	SignCount  uint32    `json:"signCount"`
+	UV         bool      `json:"uv"`
+	Transports []string  `json:"transports,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// User is an account known to the relying party.
+type User struct {
+	ID          Base64URL     `json:"id"`
+	Name        string        `json:"name"`
+	DisplayName string        `json:"displayName"`
+	Credentials []*Credential `json:"credentials"`
+}
+
+// Store holds users and their credentials in memory, optionally persisted to a
+// JSON file after every change.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	users map[string]*User
+}
+
+// NewMemoryStore returns an empty, non-persistent store.
+func NewMemoryStore() *Store {
+	return &Store{users: map[string]*User{}}
+}
+
+// OpenFileStore loads the store at path, creating an empty one if the file is missing.
+func OpenFileStore(path string) (*Store, error) {
+	s := &Store{path: path, users: map[string]*User{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var users []*User
+	if err := json.Unmarshal(b, &users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		s.users[u.Name] = u
+	}
+	return s, nil
+}
+
+// clone copies u and its credentials, so callers can read them without
+// holding the store's lock.
+func (u *User) clone() *User {
+	c := *u
+	c.Credentials = make([]*Credential, len(u.Credentials))
+	for i, cred := range u.Credentials {
+		cc := *cred
+		c.Credentials[i] = &cc
+	}
+	return &c
+}
+
+// User returns a copy of the named user, if present.
+func (s *Store) User(name string) (*User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[name]
+	if !ok {
+		return nil, false
+	}
+	return u.clone(), true
+}
+
+// Users returns copies of all users sorted by name.
+func (s *Store) Users() []*User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		out = append(out, u.clone())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ErrCredentialExists is returned by AddCredential for a credential ID that
+// is already registered.
+var ErrCredentialExists = errors.New("credential already registered")
+
+// ErrCounterRegression is returned by UseCredential when the sign counter did
+// not go past the stored one.
+var ErrCounterRegression = errors.New("sign counter regression")
+
+// AddCredential appends cred to u, storing u first when it is new, and
+// persists the store. It fails with ErrCredentialExists when cred's ID is
+// already registered, and when another user of the same name was stored
+// meanwhile, since cred was created for u's user ID. Nothing changes when the
+// store cannot be written.
+func (s *Store) AddCredential(u *User, cred *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, _, ok := s.findLocked(cred.ID); ok {
+		return ErrCredentialExists
+	}
+	stored, ok := s.users[u.Name]
+	if !ok {
+		stored = &User{ID: u.ID, Name: u.Name, DisplayName: u.DisplayName}
+		s.users[u.Name] = stored
+	} else if !bytes.Equal(stored.ID, u.ID) {
+		return errors.New("store: user " + u.Name + " was registered with another user ID")
+	}
+	stored.Credentials = append(stored.Credentials, cred)
+	if err := s.saveLocked(); err != nil {
+		stored.Credentials = stored.Credentials[:len(stored.Credentials)-1]
+		if !ok {
+			delete(s.users, u.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// FindCredential looks a credential up by ID across all users and returns
+// copies of it and its owner.
+func (s *Store) FindCredential(id []byte) (*User, *Credential, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, c, ok := s.findLocked(id)
+	if !ok {
+		return nil, nil, false
+	}
+	cc := *c
+	return u.clone(), &cc, true
+}
+
+// findLocked returns the stored credential id and its owner. Caller holds s.mu.
+func (s *Store) findLocked(id []byte) (*User, *Credential, bool) {
+	for _, u := range s.users {
+		for _, c := range u.Credentials {
+			if bytes.Equal(c.ID, id) {
+				return u, c, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// UseCredential records a successful assertion with credential id and
+// persists the store. signCount must go past the stored counter unless both
+// are 0 (authenticators without a counter); otherwise it fails with
+// ErrCounterRegression and the stored counter is kept. The check and the
+// update happen under one lock, so concurrent assertions cannot both pass
+// with the same counter.
+func (s *Store) UseCredential(id []byte, signCount uint32, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, c, ok := s.findLocked(id)
+	if !ok {
+		return errors.New("unknown credential")
+	}
+	if (signCount != 0 || c.SignCount != 0) && signCount <= c.SignCount {
+		return fmt.Errorf("%w (stored %d, received %d): possible cloned authenticator", ErrCounterRegression, c.SignCount, signCount)
+	}
+	prev := *c
+	c.SignCount = signCount
+	c.LastUsedAt = at
+	if err := s.saveLocked(); err != nil {
+		*c = prev
+		return err
+	}
+	return nil
+}
+
+// saveLocked writes the store to disk when file-backed. Caller holds s.mu.
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	users := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	b, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,163 @@
+package rp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Base64URL is a byte slice that marshals to unpadded base64url JSON strings,
+// matching the WebAuthn JSON serialization used by browsers and RP libraries.
+type Base64URL []byte
+
+// MarshalJSON encodes b as an unpadded base64url string.
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON accepts base64url (padded or not) and standard base64.
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := DecodeBase64(s)
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// DecodeBase64 decodes base64url or standard base64, with or without padding.
+func DecodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// RelyingPartyEntity identifies the relying party in creation options.
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity identifies the user account in creation options.
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+// CredentialParameter is one entry of pubKeyCredParams.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialDescriptor references a credential in allow/exclude lists.
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection carries resident key and user verification preferences.
+type AuthenticatorSelection struct {
+	AuthenticatorAttachment string `json:"authenticatorAttachment,omitempty"`
+	ResidentKey             string `json:"residentKey,omitempty"`
+	RequireResidentKey      bool   `json:"requireResidentKey,omitempty"`
+	UserVerification        string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions mirrors PublicKeyCredentialCreationOptions.
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation,omitempty"`
+}
+
+// RequestOptions mirrors PublicKeyCredentialRequestOptions.
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId,omitempty"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// CredentialCreation is the begin-registration response body ({"publicKey": ...}).
+type CredentialCreation struct {
+	PublicKey CreationOptions `json:"publicKey"`
+}
+
+// CredentialAssertion is the begin-login response body ({"publicKey": ...}).
+type CredentialAssertion struct {
+	PublicKey RequestOptions `json:"publicKey"`
+}
+
+// AttestationResponse is the response member of a registration PublicKeyCredential.
+type AttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports,omitempty"`
+}
+
+// AssertionResponse is the response member of an authentication PublicKeyCredential.
+type AssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle,omitempty"`
+}
+
+// RegistrationCredential is the finish-registration request body.
+type RegistrationCredential struct {
+	ID       string              `json:"id"`
+	RawID    Base64URL           `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+// AuthenticationCredential is the finish-login request body.
+type AuthenticationCredential struct {
+	ID       string            `json:"id"`
+	RawID    Base64URL         `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// CollectedClientData is the decoded clientDataJSON.
+type CollectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+	TopOrigin   string `json:"topOrigin,omitempty"`
+}
+
+// ClientDataJSON serializes client data the way a browser would, with the
+// challenge encoded as unpadded base64url.
+func ClientDataJSON(typ string, challenge []byte, origin string) []byte {
+	b, _ := json.Marshal(CollectedClientData{
+		Type:      typ,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	return b
+}
+
+// ServerResponse is the status envelope returned by finish endpoints and on errors.
+type ServerResponse struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+	CredentialID string `json:"credentialId,omitempty"`
+	User         string `json:"user,omitempty"`
+	SignCount    uint32 `json:"signCount,omitempty"`
+}
@@ -0,0 +1,353 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"fit/internal/ctaperr"
+	"fit/internal/ctaptrace"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CTAP2 status codes the simulator answers with.
+const (
+	statusOK                = 0x00
+	errInvalidCommand       = 0x01
+	errInvalidParameter     = 0x02
+	errInvalidLength        = 0x03
+	errInvalidCBOR          = 0x12
+	errMissingParameter     = 0x14
+	errCredentialExcluded   = 0x19
+	errUnsupportedAlgorithm = 0x26
+	errKeyStoreFull         = 0x28
+	errUnsupportedOption    = 0x2b
+	errInvalidOption        = 0x2c
+	errKeepaliveCancel      = 0x2d
+	errNoCredentials        = 0x2e
+	errNotAllowed           = 0x30
+	errPINInvalid           = 0x31
+	errPINBlocked           = 0x32
+	errPINAuthInvalid       = 0x33
+	errPINAuthBlocked       = 0x34
+	errPINNotSet            = 0x35
+	errPUATRequired         = 0x36
+	errPINPolicyViolation   = 0x37
+	errInvalidSubcommand    = 0x3e
+)
+
+// Keepalive statuses.
+const (
+	statusProcessing byte = 1
+	statusUPNeeded   byte = 2
+)
+
+// command is a CTAP2 command the simulator implements.
+type command struct {
+	code   byte
+	name   string
+	subKey uint64            // parameter holding the subcommand, or 0
+	subs   map[string]uint64 // subcommands by name
+	handle func(a *Authenticator, p params) (byte, any)
+}
+
+func (c *command) subcommand(name string) (uint64, bool) {
+	n, ok := c.subs[name]
+	return n, ok
+}
+
+var commands []*command
+
+func init() {
+	credMgmt := map[string]uint64{
+		"getCredsMetadata":                      1,
+		"enumerateRPsBegin":                     2,
+		"enumerateRPsGetNextRP":                 3,
+		"enumerateCredentialsBegin":             4,
+		"enumerateCredentialsGetNextCredential": 5,
+		"deleteCredential":                      6,
+	}
+	commands = []*command{
+		{code: 0x01, name: "makeCredential", handle: (*Authenticator).makeCredential},
+		{code: 0x02, name: "getAssertion", handle: (*Authenticator).getAssertion},
+		{code: 0x04, name: "getInfo", handle: (*Authenticator).getInfo},
+		{code: 0x06, name: "clientPIN", subKey: 2, handle: (*Authenticator).clientPIN, subs: map[string]uint64{
+			"getPINRetries":   1,
+			"getKeyAgreement": 2,
+			"setPIN":          3,
+			"changePIN":       4,
+			"getPinToken":     5,
+			"getPinUvAuthTokenUsingPinWithPermissions": 9,
+		}},
+		{code: 0x07, name: "reset", handle: (*Authenticator).reset},
+		{code: 0x08, name: "getNextAssertion", handle: (*Authenticator).getNextAssertion},
+		{code: 0x0a, name: "credentialManagement", subKey: 1, subs: credMgmt, handle: (*Authenticator).credentialManagement},
+		{code: 0x0b, name: "selection", handle: func(*Authenticator, params) (byte, any) { return statusOK, nil }},
+		{code: 0x0d, name: "authenticatorConfig", subKey: 1, handle: (*Authenticator).authenticatorConfig, subs: map[string]uint64{
+			"toggleAlwaysUv":  2,
+			"setMinPINLength": 3,
+		}},
+		// The CTAP 2.1 preview command, as libfido2 sends it to keys that
+		// report credentialMgmtPreview.
+		{code: 0x41, name: "credentialManagement", subKey: 1, subs: credMgmt, handle: (*Authenticator).credentialManagement},
+	}
+}
+
+func commandByCode(code byte) (*command, bool) {
+	for _, c := range commands {
+		if c.code == code {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func commandByName(name string) (*command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func commandNames() []string {
+	var names []string
+	for _, c := range commands {
+		if !slices.Contains(names, c.name) {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// params are a request's CBOR parameters, still encoded.
+type params map[uint64]cbor.RawMessage
+
+// get decodes parameter k into v and reports whether it was present.
+func (p params) get(k uint64, v any) (bool, error) {
+	raw, ok := p[k]
+	if !ok {
+		return false, nil
+	}
+	return true, cbor.Unmarshal(raw, v)
+}
+
+// Waiter waits d before the answer, sending keepalives with status (if not
+// 0). It returns false if the host cancelled the request meanwhile.
+type Waiter func(d time.Duration, status byte) bool
+
+// Authenticator is a simulated key. Its state (PIN, retries, credentials)
+// lives in memory for the life of the process.
+type Authenticator struct {
+	// Log, when set, receives a line per CTAP2 request and its status.
+	Log io.Writer
+
+	mu  sync.Mutex
+	sc  *Scenario
+	cid uint32 // last allocated CTAPHID channel
+
+	steps []Step
+	step  int // index of the step waiting for its request
+	used  int // requests the current step has answered
+
+	pin      string
+	retries  int
+	minLen   int  // minPINLength, which authenticatorConfig can raise
+	alwaysUv bool // the alwaysUv option, which authenticatorConfig toggles
+	failures int  // wrong PINs in a row since power-up
+	ka       *ecdh.PrivateKey
+	token    []byte
+
+	creds    []*credential
+	next     []*credential // getNextAssertion queue
+	nextResp assertionContext
+	rpIter   []string
+	credIter []*credential
+}
+
+// New returns a key in the scenario's initial state.
+func New(sc *Scenario) *Authenticator {
+	a := &Authenticator{sc: sc, steps: sc.Script, pin: sc.PIN.Current, retries: sc.PIN.Retries}
+	a.minLen, a.alwaysUv = sc.PIN.MinLength, sc.Info.Options["alwaysUv"]
+	a.ka = newECDH()
+	return a
+}
+
+// Unused returns the script steps that never answered a request.
+func (a *Authenticator) Unused() []Step {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.step < len(a.steps) && a.used > 0 {
+		return a.steps[a.step+1:]
+	}
+	return a.steps[a.step:]
+}
+
+// CBOR answers a CTAPHID_CBOR request: the CTAP2 command byte and its
+// parameters in, the status byte and the response map out.
+func (a *Authenticator) CBOR(data []byte, wait Waiter) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st, resp, note := a.dispatch(data, wait)
+	out := []byte{st}
+	if st == statusOK && resp != nil {
+		enc, err := cbor.CTAP2EncOptions().EncMode()
+		if err == nil {
+			var b []byte
+			if b, err = enc.Marshal(resp); err == nil {
+				out = append(out, b...)
+			}
+		}
+		if err != nil {
+			out = []byte{errInvalidCBOR}
+			note = fmt.Sprintf(" (encoding the response: %v)", err)
+		}
+	}
+	if a.Log != nil {
+		fmt.Fprintf(a.Log, "> %s\n< %s%s\n", ctaptrace.Request(0x10, data), statusText(out[0]), note)
+	}
+	return out
+}
+
+func statusText(st byte) string {
+	if st == statusOK {
+		return "0x00 OK"
+	}
+	return fmt.Sprintf("0x%02x %s", st, ctaperr.FromStatus(int(st), "", nil).Code)
+}
+
+// dispatch applies the script and runs the command.
+func (a *Authenticator) dispatch(data []byte, wait Waiter) (byte, any, string) {
+	if len(data) == 0 {
+		return errInvalidLength, nil, ""
+	}
+	c, ok := commandByCode(data[0])
+	if !ok {
+		return errInvalidCommand, nil, ""
+	}
+	p := params{}
+	if len(data) > 1 {
+		if err := cbor.Unmarshal(data[1:], &p); err != nil {
+			return errInvalidCBOR, nil, ""
+		}
+	}
+	var sub uint64
+	if c.subKey != 0 {
+		p.get(c.subKey, &sub)
+	}
+	// Iterations end when another command comes in.
+	if c.name != "getNextAssertion" {
+		a.next = nil
+	}
+	if c.name != "credentialManagement" {
+		a.rpIter, a.credIter = nil, nil
+	}
+
+	if st := a.takeStep(c, sub); st != nil {
+		if st.Delay > 0 && !wait(st.Delay, st.keepalive) {
+			return errKeepaliveCancel, nil, " (cancelled during the scripted delay)"
+		}
+		if st.status != statusOK {
+			return st.status, nil, " (scripted)"
+		}
+	}
+	status, resp := c.handle(a, p)
+	return status, resp, ""
+}
+
+// takeStep returns the script step for this request, if it is the one the
+// current step waits for.
+func (a *Authenticator) takeStep(c *command, sub uint64) *Step {
+	if a.step >= len(a.steps) {
+		return nil
+	}
+	st := &a.steps[a.step]
+	if !st.matches(c, sub) {
+		return nil
+	}
+	a.used++
+	if a.used >= st.Times {
+		a.step++
+		a.used = 0
+	}
+	return st
+}
+
+// getInfo reports the scenario's capabilities and the current PIN state.
+func (a *Authenticator) getInfo(params) (byte, any) {
+	in := a.sc.Info
+	opts := map[string]bool{}
+	for k, v := range in.Options {
+		opts[k] = v
+	}
+	if a.sc.pinSupported() {
+		opts["clientPin"] = a.pin != ""
+	}
+	if _, ok := in.Options["alwaysUv"]; ok || a.alwaysUv {
+		opts["alwaysUv"] = a.alwaysUv
+	}
+	var algs []map[string]any
+	for _, alg := range a.sc.algs {
+		algs = append(algs, map[string]any{"type": "public-key", "alg": alg})
+	}
+	m := map[int]any{
+		1:  in.Versions,
+		3:  a.sc.aaguid,
+		4:  opts,
+		5:  1200, // maxMsgSize
+		7:  in.MaxCredentialCountInList,
+		8:  in.MaxCredentialIDLength,
+		10: algs,
+	}
+	if len(in.Extensions) > 0 {
+		m[2] = in.Extensions
+	}
+	if a.sc.pinSupported() {
+		m[6] = []int{1} // pinUvAuthProtocols
+		m[0x0d] = a.minLen
+	}
+	if slices.Contains(in.Versions, "FIDO_2_1") {
+		m[0x14] = in.MaxCredentials - a.residentCount()
+	}
+	if a.sc.Device.Firmware != "" {
+		m[0x0e] = int(a.sc.fwMajor)<<16 | int(a.sc.fwMinor)<<8 | int(a.sc.fwBuild)
+	}
+	return statusOK, m
+}
+
+// reset erases the credentials and the PIN, and restores the settings
+// authenticatorConfig changed.
+func (a *Authenticator) reset(params) (byte, any) {
+	a.creds, a.next = nil, nil
+	a.pin, a.retries, a.failures, a.token = "", a.sc.PIN.Retries, 0, nil
+	a.minLen, a.alwaysUv = a.sc.PIN.MinLength, a.sc.Info.Options["alwaysUv"]
+	a.ka = newECDH()
+	return statusOK, nil
+}
+
+// residentCount is the number of discoverable credentials stored.
+func (a *Authenticator) residentCount() int {
+	n := 0
+	for _, c := range a.creds {
+		if c.resident {
+			n++
+		}
+	}
+	return n
+}
+
+// findCredential returns the stored credential with id for rpID.
+func (a *Authenticator) findCredential(rpID string, id []byte) *credential {
+	for _, c := range a.creds {
+		if c.rpID == rpID && bytes.Equal(c.id, id) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/hmac"
+)
+
+// authenticatorConfig implements the setMinPINLength and toggleAlwaysUv
+// subcommands for keys whose scenario lists the authnrCfg option. Once a
+// PIN is set or alwaysUv is on, the request needs a pinUvAuthParam over 32
+// bytes of 0xff, the command, the subcommand and its encoded parameters.
+func (a *Authenticator) authenticatorConfig(p params) (byte, any) {
+	if !a.sc.Info.Options["authnrCfg"] {
+		return errInvalidCommand, nil
+	}
+	var sub uint64
+	if ok, err := p.get(1, &sub); !ok || err != nil {
+		return errMissingParameter, nil
+	}
+	if sub != 2 && sub != 3 {
+		return errInvalidSubcommand, nil
+	}
+	var tag []byte
+	if ok, err := p.get(4, &tag); !ok || err != nil {
+		if a.pin != "" || a.alwaysUv {
+			return errPUATRequired, nil
+		}
+	} else {
+		var proto uint64
+		if ok, err := p.get(3, &proto); !ok || err != nil {
+			return errMissingParameter, nil
+		}
+		if proto != 1 {
+			return errInvalidParameter, nil
+		}
+		msg := append(bytes.Repeat([]byte{0xff}, 32), 0x0d, byte(sub))
+		if a.token == nil || !hmac.Equal(authTag(a.token, msg, p[2]), tag) {
+			return errPINAuthInvalid, nil
+		}
+	}
+
+	if sub == 2 { // toggleAlwaysUv
+		a.alwaysUv = !a.alwaysUv
+		return statusOK, nil
+	}
+	// setMinPINLength: the minimum can only grow.
+	var sp struct {
+		NewMinPINLength *int `cbor:"1,keyasint"`
+	}
+	if ok, err := p.get(2, &sp); ok && err != nil {
+		return errInvalidCBOR, nil
+	}
+	if sp.NewMinPINLength != nil {
+		if *sp.NewMinPINLength < a.minLen || *sp.NewMinPINLength > 63 {
+			return errPINPolicyViolation, nil
+		}
+		a.minLen = *sp.NewMinPINLength
+	}
+	return statusOK, nil
+}
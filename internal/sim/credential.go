@@ -0,0 +1,336 @@
+package sim
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"slices"
+
+	"fit/internal/chal"
+	"fit/internal/rp"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// credential is a credential the simulated key created.
+type credential struct {
+	id          []byte
+	rpID        string
+	rpName      string
+	user        userEntity
+	resident    bool
+	alg         int64
+	key         crypto.Signer
+	signCount   uint32
+	credProtect int
+}
+
+type userEntity struct {
+	ID          []byte `cbor:"id"`
+	Name        string `cbor:"name,omitempty"`
+	DisplayName string `cbor:"displayName,omitempty"`
+}
+
+type rpEntity struct {
+	ID   string `cbor:"id"`
+	Name string `cbor:"name,omitempty"`
+}
+
+type credParam struct {
+	Type string `cbor:"type"`
+	Alg  int64  `cbor:"alg"`
+}
+
+type descriptor struct {
+	Type string `cbor:"type"`
+	ID   []byte `cbor:"id"`
+}
+
+// assertionContext is what getNextAssertion needs from getAssertion.
+type assertionContext struct {
+	rpID  string
+	cdh   []byte
+	flags byte
+}
+
+// Keys are drawn from chal.Reader, so a seeded run (--seed, FIT_SEED)
+// creates the same keys every time. The scalars are read directly rather
+// than through ecdsa.GenerateKey, whose use of the reader is not stable
+// across Go releases.
+
+// newP256 returns a P-256 key from the next bytes of chal.Reader.
+func newP256() (*ecdh.PrivateKey, []byte) {
+	for {
+		b := make([]byte, 32)
+		if _, err := io.ReadFull(chal.Reader(), b); err != nil {
+			panic(err)
+		}
+		if k, err := ecdh.P256().NewPrivateKey(b); err == nil {
+			return k, b
+		}
+	}
+}
+
+// newECDH returns a key agreement key for PIN protocol 1.
+func newECDH() *ecdh.PrivateKey {
+	k, _ := newP256()
+	return k
+}
+
+// newSigner returns a credential key for alg.
+func newSigner(alg int64) crypto.Signer {
+	if alg == rp.AlgEdDSA {
+		return ed25519.NewKeyFromSeed(chal.Bytes(ed25519.SeedSize))
+	}
+	k, d := newP256()
+	pub := k.PublicKey().Bytes() // 0x04 || X || Y
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(pub[1:33]), Y: new(big.Int).SetBytes(pub[33:])},
+		D:         new(big.Int).SetBytes(d),
+	}
+}
+
+// sign signs msg; ECDSA signatures are deterministic (RFC 6979).
+func (c *credential) sign(msg []byte) []byte {
+	if c.alg == rp.AlgEdDSA {
+		sig, _ := c.key.Sign(nil, msg, crypto.Hash(0))
+		return sig
+	}
+	h := sha256.Sum256(msg)
+	sig, _ := c.key.Sign(nil, h[:], crypto.SHA256)
+	return sig
+}
+
+// coseKey is the credential public key as a COSE_Key.
+func (c *credential) coseKey() map[int]any {
+	if c.alg == rp.AlgEdDSA {
+		return map[int]any{1: 1, 3: rp.AlgEdDSA, -1: 6, -2: []byte(c.key.Public().(ed25519.PublicKey))}
+	}
+	pub := c.key.Public().(*ecdsa.PublicKey)
+	return map[int]any{1: 2, 3: rp.AlgES256, -1: 1, -2: pub.X.FillBytes(make([]byte, 32)), -3: pub.Y.FillBytes(make([]byte, 32))}
+}
+
+// authData builds authenticator data; attested and ext are optional.
+func authData(rpID string, flags byte, count uint32, attested []byte, ext map[string]any) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, h[:]...)
+	if attested != nil {
+		flags |= rp.FlagAT
+	}
+	var extBytes []byte
+	if len(ext) > 0 {
+		flags |= rp.FlagED
+		extBytes, _ = cbor.Marshal(ext)
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, count)
+	b = append(b, attested...)
+	return append(b, extBytes...)
+}
+
+// makeCredential creates a credential, resident if asked, and attests it
+// with a packed self attestation.
+func (a *Authenticator) makeCredential(p params) (byte, any) {
+	var (
+		cdh     []byte
+		rpEnt   rpEntity
+		user    userEntity
+		algs    []credParam
+		exclude []descriptor
+		ext     map[string]any
+		opts    map[string]bool
+	)
+	for k, v := range map[uint64]any{1: &cdh, 2: &rpEnt, 3: &user, 4: &algs} {
+		if ok, err := p.get(k, v); !ok {
+			return errMissingParameter, nil
+		} else if err != nil {
+			return errInvalidCBOR, nil
+		}
+	}
+	for k, v := range map[uint64]any{5: &exclude, 6: &ext, 7: &opts} {
+		if _, err := p.get(k, v); err != nil {
+			return errInvalidCBOR, nil
+		}
+	}
+	var alg int64
+	for _, c := range algs {
+		if c.Type == "public-key" && slices.Contains(a.sc.algs, c.Alg) {
+			alg = c.Alg
+			break
+		}
+	}
+	if alg == 0 {
+		return errUnsupportedAlgorithm, nil
+	}
+	rk := opts["rk"]
+	if rk && !a.sc.Info.Options["rk"] {
+		return errUnsupportedOption, nil
+	}
+	if up, ok := opts["up"]; ok && !up {
+		return errInvalidOption, nil
+	}
+	if opts["uv"] {
+		return errInvalidOption, nil // no built-in user verification
+	}
+	uv, st := a.checkPinUvAuth(p, 8, 9, cdh)
+	if st != statusOK {
+		return st, nil
+	}
+	if !uv && a.pin != "" && (rk || !a.sc.Info.Options["makeCredUvNotRqd"]) {
+		return errPUATRequired, nil
+	}
+	for _, d := range exclude {
+		if a.findCredential(rpEnt.ID, d.ID) != nil {
+			return errCredentialExcluded, nil
+		}
+	}
+	if rk {
+		// A resident credential replaces the RP's one for the same user.
+		a.creds = slices.DeleteFunc(a.creds, func(c *credential) bool {
+			return c.resident && c.rpID == rpEnt.ID && string(c.user.ID) == string(user.ID)
+		})
+		if a.residentCount() >= a.sc.Info.MaxCredentials {
+			return errKeyStoreFull, nil
+		}
+	}
+
+	c := &credential{
+		id:       chal.Bytes(32),
+		rpID:     rpEnt.ID,
+		rpName:   rpEnt.Name,
+		user:     user,
+		resident: rk,
+		alg:      alg,
+		key:      newSigner(alg),
+	}
+	extOut := map[string]any{}
+	if v, ok := ext["credProtect"].(uint64); ok && a.supports("credProtect") && v >= 1 && v <= 3 {
+		c.credProtect = int(v)
+		extOut["credProtect"] = v
+	}
+	if v, ok := ext["hmac-secret"].(bool); ok && v && a.supports("hmac-secret") {
+		extOut["hmac-secret"] = true
+	}
+	a.creds = append(a.creds, c)
+
+	pub, _ := cbor.Marshal(c.coseKey())
+	attested := append([]byte{}, a.sc.aaguid...)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(c.id)))
+	attested = append(attested, c.id...)
+	attested = append(attested, pub...)
+	flags := byte(rp.FlagUP)
+	if uv {
+		flags |= rp.FlagUV
+	}
+	ad := authData(c.rpID, flags, c.signCount, attested, extOut)
+	return statusOK, map[int]any{
+		1: "packed",
+		2: ad,
+		3: map[string]any{"alg": alg, "sig": c.sign(append(ad, cdh...))},
+	}
+}
+
+// getAssertion signs with the allowed credential, or the RP's resident
+// credentials newest first, queueing the rest for getNextAssertion.
+func (a *Authenticator) getAssertion(p params) (byte, any) {
+	var (
+		rpID  string
+		cdh   []byte
+		allow []descriptor
+		opts  map[string]bool
+	)
+	for k, v := range map[uint64]any{1: &rpID, 2: &cdh} {
+		if ok, err := p.get(k, v); !ok {
+			return errMissingParameter, nil
+		} else if err != nil {
+			return errInvalidCBOR, nil
+		}
+	}
+	for k, v := range map[uint64]any{3: &allow, 5: &opts} {
+		if _, err := p.get(k, v); err != nil {
+			return errInvalidCBOR, nil
+		}
+	}
+	if opts["uv"] {
+		return errInvalidOption, nil
+	}
+	uv, st := a.checkPinUvAuth(p, 6, 7, cdh)
+	if st != statusOK {
+		return st, nil
+	}
+
+	var found []*credential
+	if len(allow) > 0 {
+		for _, d := range allow {
+			if c := a.findCredential(rpID, d.ID); c != nil {
+				found = append(found, c)
+			}
+		}
+	} else {
+		for _, c := range slices.Backward(a.creds) {
+			if c.resident && c.rpID == rpID {
+				found = append(found, c)
+			}
+		}
+	}
+	// credProtect: level 3 always needs UV, level 2 when discovering.
+	found = slices.DeleteFunc(found, func(c *credential) bool {
+		return !uv && (c.credProtect == 3 || (c.credProtect == 2 && len(allow) == 0))
+	})
+	if len(found) == 0 {
+		return errNoCredentials, nil
+	}
+
+	ctx := assertionContext{rpID: rpID, cdh: cdh}
+	if up, ok := opts["up"]; !ok || up {
+		ctx.flags |= rp.FlagUP
+	}
+	if uv {
+		ctx.flags |= rp.FlagUV
+	}
+	resp := a.assertion(found[0], ctx)
+	if len(found) > 1 && len(allow) == 0 {
+		resp[5] = len(found)
+		a.next, a.nextResp = found[1:], ctx
+	}
+	return statusOK, resp
+}
+
+// getNextAssertion answers with the next queued credential.
+func (a *Authenticator) getNextAssertion(params) (byte, any) {
+	if len(a.next) == 0 {
+		return errNotAllowed, nil
+	}
+	c := a.next[0]
+	a.next = a.next[1:]
+	return statusOK, a.assertion(c, a.nextResp)
+}
+
+// assertion is the getAssertion response for c.
+func (a *Authenticator) assertion(c *credential, ctx assertionContext) map[int]any {
+	c.signCount++
+	ad := authData(ctx.rpID, ctx.flags, c.signCount, nil, nil)
+	m := map[int]any{
+		1: descriptor{Type: "public-key", ID: c.id},
+		2: ad,
+		3: c.sign(append(ad, ctx.cdh...)),
+	}
+	if c.resident {
+		u := userEntity{ID: c.user.ID}
+		if ctx.flags&rp.FlagUV != 0 {
+			u.Name, u.DisplayName = c.user.Name, c.user.DisplayName
+		}
+		m[4] = u
+	}
+	return m
+}
+
+func (a *Authenticator) supports(ext string) bool {
+	return slices.Contains(a.sc.Info.Extensions, ext)
+}
@@ -0,0 +1,150 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"slices"
+)
+
+// credMgmtParams are credentialManagement's subCommandParams.
+type credMgmtParams struct {
+	RPIDHash []byte     `cbor:"1,keyasint,omitempty"`
+	CredID   descriptor `cbor:"2,keyasint,omitempty"`
+}
+
+// credentialManagement lists and deletes resident credentials. Every
+// subcommand but the GetNext ones needs a PIN token.
+func (a *Authenticator) credentialManagement(p params) (byte, any) {
+	if !a.sc.Info.Options["credMgmt"] && !a.sc.Info.Options["credentialMgmtPreview"] {
+		return errInvalidCommand, nil
+	}
+	var sub uint64
+	if ok, err := p.get(1, &sub); !ok || err != nil {
+		return errMissingParameter, nil
+	}
+	var sp credMgmtParams
+	if _, err := p.get(2, &sp); err != nil {
+		return errInvalidCBOR, nil
+	}
+	switch sub {
+	case 1, 2, 4, 6:
+		if st := a.checkCredMgmtAuth(p, byte(sub)); st != statusOK {
+			return st, nil
+		}
+	}
+
+	switch sub {
+	case 1: // getCredsMetadata
+		return statusOK, map[int]any{1: a.residentCount(), 2: a.sc.Info.MaxCredentials - a.residentCount()}
+
+	case 2: // enumerateRPsBegin
+		a.rpIter = nil
+		for _, c := range a.creds {
+			if c.resident && !slices.Contains(a.rpIter, c.rpID) {
+				a.rpIter = append(a.rpIter, c.rpID)
+			}
+		}
+		if len(a.rpIter) == 0 {
+			return errNoCredentials, nil
+		}
+		total := len(a.rpIter)
+		resp := a.nextRP()
+		resp[5] = total
+		return statusOK, resp
+
+	case 3: // enumerateRPsGetNextRP
+		if len(a.rpIter) == 0 {
+			return errNotAllowed, nil
+		}
+		return statusOK, a.nextRP()
+
+	case 4: // enumerateCredentialsBegin
+		if sp.RPIDHash == nil {
+			return errMissingParameter, nil
+		}
+		a.credIter = nil
+		for _, c := range a.creds {
+			h := sha256.Sum256([]byte(c.rpID))
+			if c.resident && bytes.Equal(h[:], sp.RPIDHash) {
+				a.credIter = append(a.credIter, c)
+			}
+		}
+		if len(a.credIter) == 0 {
+			return errNoCredentials, nil
+		}
+		total := len(a.credIter)
+		resp := a.nextCred()
+		resp[9] = total
+		return statusOK, resp
+
+	case 5: // enumerateCredentialsGetNextCredential
+		if len(a.credIter) == 0 {
+			return errNotAllowed, nil
+		}
+		return statusOK, a.nextCred()
+
+	case 6: // deleteCredential
+		if sp.CredID.ID == nil {
+			return errMissingParameter, nil
+		}
+		n := len(a.creds)
+		a.creds = slices.DeleteFunc(a.creds, func(c *credential) bool {
+			return c.resident && bytes.Equal(c.id, sp.CredID.ID)
+		})
+		if len(a.creds) == n {
+			return errNoCredentials, nil
+		}
+		return statusOK, nil
+	}
+	return errInvalidSubcommand, nil
+}
+
+// checkCredMgmtAuth verifies pinUvAuthParam over the subcommand and its
+// encoded parameters.
+func (a *Authenticator) checkCredMgmtAuth(p params, sub byte) byte {
+	var tag []byte
+	if ok, err := p.get(4, &tag); !ok || err != nil {
+		return errPUATRequired
+	}
+	var proto uint64
+	if ok, err := p.get(3, &proto); !ok || err != nil {
+		return errMissingParameter
+	}
+	if proto != 1 {
+		return errInvalidParameter
+	}
+	if a.token == nil || !hmac.Equal(authTag(a.token, []byte{sub}, p[2]), tag) {
+		return errPINAuthInvalid
+	}
+	return statusOK
+}
+
+// nextRP takes the next RP off the enumeration.
+func (a *Authenticator) nextRP() map[int]any {
+	id := a.rpIter[0]
+	a.rpIter = a.rpIter[1:]
+	rp := rpEntity{ID: id}
+	for _, c := range a.creds {
+		if c.rpID == id && c.rpName != "" {
+			rp.Name = c.rpName
+		}
+	}
+	h := sha256.Sum256([]byte(id))
+	return map[int]any{3: rp, 4: h[:]}
+}
+
+// nextCred takes the next credential off the enumeration.
+func (a *Authenticator) nextCred() map[int]any {
+	c := a.credIter[0]
+	a.credIter = a.credIter[1:]
+	m := map[int]any{
+		6: c.user,
+		7: descriptor{Type: "public-key", ID: c.id},
+		8: c.coseKey(),
+	}
+	if c.credProtect != 0 {
+		m[10] = c.credProtect
+	}
+	return m
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package sim
+
+import (
+	"time"
+
+	"fit/internal/vkey"
+)
+
+// keepaliveEvery is how often keepalives go out during a scripted delay.
+const keepaliveEvery = 100 * time.Millisecond
+
+// VKeyInfo is how the key appears on the bus, for vkey.Start.
+func (s *Scenario) VKeyInfo() vkey.Info {
+	return vkey.Info{Name: s.Device.Name, Serial: s.Device.Serial, VID: s.vid, PID: s.pid}
+}
+
+// Handle answers CTAPHID requests; it makes an Authenticator a vkey.Handler.
+func (a *Authenticator) Handle(req vkey.Request) vkey.Response {
+	switch req.Cmd {
+	case vkey.CmdInit:
+		if len(req.Data) != 8 {
+			return vkey.ErrorResponse(vkey.ErrOther)
+		}
+		cid := req.CID
+		if cid == 0xffffffff {
+			a.mu.Lock()
+			a.cid++
+			cid = a.cid
+			a.mu.Unlock()
+		}
+		sc := a.sc
+		return vkey.Response{Cmd: vkey.CmdInit, Data: vkey.InitResponse(req.Data, cid,
+			sc.fwMajor, sc.fwMinor, sc.fwBuild, vkey.CapWink|vkey.CapCBOR|vkey.CapNMSG)}
+	case vkey.CmdPing:
+		return vkey.Response{Cmd: vkey.CmdPing, Data: req.Data}
+	case vkey.CmdWink:
+		return vkey.Response{Cmd: vkey.CmdWink}
+	case vkey.CmdCancel:
+		return vkey.Response{None: true}
+	case vkey.CmdCBOR:
+		return vkey.Response{Cmd: vkey.CmdCBOR, Data: a.CBOR(req.Data, waiter(req))}
+	}
+	return vkey.ErrorResponse(vkey.ErrInvalidCmd)
+}
+
+// waiter waits on behalf of req, sending its keepalives and watching for
+// CTAPHID_CANCEL.
+func waiter(req vkey.Request) Waiter {
+	return func(d time.Duration, status byte) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		tick := time.NewTicker(keepaliveEvery)
+		defer tick.Stop()
+		for {
+			select {
+			case <-timer.C:
+				return true
+			case <-req.Cancelled:
+				return false
+			case <-tick.C:
+				if status != 0 {
+					req.Keepalive(status)
+				}
+			}
+		}
+	}
+}
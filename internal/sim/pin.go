@@ -0,0 +1,230 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"unicode/utf8"
+
+	"fit/internal/chal"
+)
+
+// coseECDH is a platform key agreement key (COSE_Key, EC2 P-256).
+type coseECDH struct {
+	Kty int    `cbor:"1,keyasint"`
+	Crv int    `cbor:"-1,keyasint"`
+	X   []byte `cbor:"-2,keyasint"`
+	Y   []byte `cbor:"-3,keyasint"`
+}
+
+// pinAuthLen is the length of a PIN protocol 1 authentication tag.
+const pinAuthLen = 16
+
+// clientPIN implements PIN/UV auth protocol 1: the PIN itself, its retry
+// counter and the token that authorizes makeCredential, getAssertion and
+// credentialManagement.
+func (a *Authenticator) clientPIN(p params) (byte, any) {
+	if !a.sc.pinSupported() {
+		return errInvalidCommand, nil
+	}
+	var proto, sub uint64
+	if ok, err := p.get(1, &proto); !ok || err != nil {
+		return errMissingParameter, nil
+	}
+	if ok, err := p.get(2, &sub); !ok || err != nil {
+		return errMissingParameter, nil
+	}
+	if proto != 1 {
+		return errInvalidParameter, nil
+	}
+
+	switch sub {
+	case 1: // getPINRetries
+		return statusOK, map[int]any{3: a.retries}
+	case 2: // getKeyAgreement
+		pub := a.ka.PublicKey().Bytes()
+		return statusOK, map[int]any{1: map[int]any{1: 2, 3: -25, -1: 1, -2: pub[1:33], -3: pub[33:]}}
+	case 3, 4, 5, 9:
+	default:
+		return errInvalidSubcommand, nil
+	}
+
+	shared, st := a.sharedSecret(p)
+	if st != statusOK {
+		return st, nil
+	}
+	var pinAuth, newPinEnc, pinHashEnc []byte
+	p.get(4, &pinAuth)
+	p.get(5, &newPinEnc)
+	p.get(6, &pinHashEnc)
+
+	switch sub {
+	case 3: // setPIN
+		if pinAuth == nil || newPinEnc == nil {
+			return errMissingParameter, nil
+		}
+		if a.pin != "" {
+			return errPINAuthInvalid, nil
+		}
+		if !hmac.Equal(authTag(shared, newPinEnc), pinAuth) {
+			return errPINAuthInvalid, nil
+		}
+		return a.storePIN(shared, newPinEnc)
+
+	case 4: // changePIN
+		if pinAuth == nil || newPinEnc == nil || pinHashEnc == nil {
+			return errMissingParameter, nil
+		}
+		if a.pin == "" {
+			return errPINNotSet, nil
+		}
+		if a.retries == 0 {
+			return errPINBlocked, nil
+		}
+		if !hmac.Equal(authTag(shared, newPinEnc, pinHashEnc), pinAuth) {
+			return errPINAuthInvalid, nil
+		}
+		if st := a.checkPINHash(shared, pinHashEnc); st != statusOK {
+			return st, nil
+		}
+		a.token = nil
+		return a.storePIN(shared, newPinEnc)
+
+	default: // getPinToken, getPinUvAuthTokenUsingPinWithPermissions
+		if pinHashEnc == nil {
+			return errMissingParameter, nil
+		}
+		if a.pin == "" {
+			return errPINNotSet, nil
+		}
+		if a.retries == 0 {
+			return errPINBlocked, nil
+		}
+		if st := a.checkPINHash(shared, pinHashEnc); st != statusOK {
+			return st, nil
+		}
+		a.token = chal.Bytes(32)
+		return statusOK, map[int]any{2: aesCBC(shared, a.token, true)}
+	}
+}
+
+// sharedSecret derives the protocol 1 shared secret from the platform key
+// agreement key in parameter 3: SHA-256 of the ECDH x coordinate.
+func (a *Authenticator) sharedSecret(p params) ([]byte, byte) {
+	var k coseECDH
+	if ok, err := p.get(3, &k); !ok {
+		return nil, errMissingParameter
+	} else if err != nil || k.Kty != 2 || k.Crv != 1 || len(k.X) != 32 || len(k.Y) != 32 {
+		return nil, errInvalidParameter
+	}
+	pub, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, k.X...), k.Y...))
+	if err != nil {
+		return nil, errInvalidParameter
+	}
+	z, err := a.ka.ECDH(pub)
+	if err != nil {
+		return nil, errInvalidParameter
+	}
+	h := sha256.Sum256(z)
+	return h[:], statusOK
+}
+
+// storePIN decrypts a 64-byte padded new PIN and makes it the PIN.
+func (a *Authenticator) storePIN(shared, newPinEnc []byte) (byte, any) {
+	if len(newPinEnc) != 64 {
+		return errPINPolicyViolation, nil
+	}
+	padded := aesCBC(shared, newPinEnc, false)
+	pin, _, _ := bytes.Cut(padded, []byte{0})
+	if len(pin) > 63 || !utf8.Valid(pin) || utf8.RuneCount(pin) < a.minLen {
+		return errPINPolicyViolation, nil
+	}
+	a.pin = string(pin)
+	a.retries, a.failures = a.sc.PIN.Retries, 0
+	return statusOK, nil
+}
+
+// checkPINHash compares the encrypted left half of SHA-256(PIN) with the
+// PIN, counting a wrong one against the retries. Three wrong PINs in a row
+// block PIN entry until the key is power cycled, here until fit-sim
+// restarts.
+func (a *Authenticator) checkPINHash(shared, pinHashEnc []byte) byte {
+	if a.failures >= 3 {
+		return errPINAuthBlocked
+	}
+	a.retries--
+	want := sha256.Sum256([]byte(a.pin))
+	if len(pinHashEnc) != 16 || !hmac.Equal(aesCBC(shared, pinHashEnc, false), want[:16]) {
+		a.ka = newECDH()
+		a.failures++
+		switch {
+		case a.retries == 0:
+			return errPINBlocked
+		case a.failures >= 3:
+			return errPINAuthBlocked
+		}
+		return errPINInvalid
+	}
+	a.retries, a.failures = a.sc.PIN.Retries, 0
+	return statusOK
+}
+
+// checkPinUvAuth verifies the pinUvAuthParam at key auth (protocol at key
+// proto) over msg, and reports whether the request is user verified. An
+// empty param is the platform's probe for a touch: it answers whether a PIN
+// is set.
+func (a *Authenticator) checkPinUvAuth(p params, auth, proto uint64, msg []byte) (bool, byte) {
+	var tag []byte
+	if ok, err := p.get(auth, &tag); !ok {
+		return false, statusOK
+	} else if err != nil {
+		return false, errInvalidCBOR
+	}
+	if !a.sc.pinSupported() {
+		return false, errUnsupportedOption
+	}
+	if len(tag) == 0 {
+		if a.pin == "" {
+			return false, errPINNotSet
+		}
+		return false, errPINInvalid
+	}
+	var v uint64
+	if ok, err := p.get(proto, &v); !ok || err != nil {
+		return false, errMissingParameter
+	}
+	if v != 1 {
+		return false, errInvalidParameter
+	}
+	if a.token == nil || !hmac.Equal(authTag(a.token, msg), tag) {
+		return false, errPINAuthInvalid
+	}
+	return true, statusOK
+}
+
+// authTag is the protocol 1 authentication tag: HMAC-SHA-256 truncated to
+// 16 bytes.
+func authTag(key []byte, msg ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, m := range msg {
+		h.Write(m)
+	}
+	return h.Sum(nil)[:pinAuthLen]
+}
+
+// aesCBC encrypts or decrypts b with AES-256-CBC and a zero IV, as protocol
+// 1 does. len(b) must be a multiple of the block size.
+func aesCBC(key, b []byte, encrypt bool) []byte {
+	blk, _ := aes.NewCipher(key)
+	out := make([]byte, len(b)/aes.BlockSize*aes.BlockSize)
+	iv := make([]byte, aes.BlockSize)
+	if encrypt {
+		cipher.NewCBCEncrypter(blk, iv).CryptBlocks(out, b[:len(out)])
+	} else {
+		cipher.NewCBCDecrypter(blk, iv).CryptBlocks(out, b[:len(out)])
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+// Package sim is the simulated authenticator behind fit-sim: a software
+// CTAP2 key, described by a scenario file, that fit talks to through a
+// virtual HID device exactly as to a real one. A scenario declares what the
+// key supports and scripts how it answers particular requests (a CTAP
+// error, a delay, keepalives while waiting for a touch), so every error
+// path of fit can be exercised without hardware.
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fit/internal/ctaperr"
+	"fit/internal/devsel"
+	"fit/internal/rp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a scenario file.
+type Scenario struct {
+	Version int      `yaml:"version"`
+	Name    string   `yaml:"name"`
+	Device  Device   `yaml:"device"`
+	Info    InfoSpec `yaml:"info"`
+	PIN     PINSpec  `yaml:"pin"`
+	Script  []Step   `yaml:"script"`
+
+	// Parsed from the fields above by Parse.
+	vid, pid         uint16
+	aaguid           []byte
+	fwMajor, fwMinor byte
+	fwBuild          byte
+	algs             []int64
+}
+
+// Device is how the key appears on the bus.
+type Device struct {
+	Name     string `yaml:"name"`     // HID name; default "fit-sim"
+	Serial   string `yaml:"serial"`   // HID serial number
+	VIDPID   string `yaml:"vidpid"`   // default 1209:f1d0
+	Firmware string `yaml:"firmware"` // CTAPHID device version, major.minor.build
+}
+
+// InfoSpec is what authenticatorGetInfo reports. clientPin is not an
+// option here: it follows the pin section and the PIN state.
+type InfoSpec struct {
+	AAGUID                   string          `yaml:"aaguid"`
+	Versions                 []string        `yaml:"versions"`
+	Extensions               []string        `yaml:"extensions"`
+	Options                  map[string]bool `yaml:"options"`
+	Algorithms               []string        `yaml:"algorithms"`
+	MaxCredentials           int             `yaml:"maxCredentials"` // resident credential slots
+	MaxCredentialCountInList int             `yaml:"maxCredentialCountInList"`
+	MaxCredentialIDLength    int             `yaml:"maxCredentialIdLength"`
+}
+
+// PINSpec is the key's PIN support and initial state.
+type PINSpec struct {
+	Supported *bool  `yaml:"supported"` // default true
+	Current   string `yaml:"current"`   // PIN set at start; "" for none
+	Retries   int    `yaml:"retries"`   // retry counter maximum; default 8
+	MinLength int    `yaml:"minLength"` // default 4
+}
+
+// Step scripts the answer to the next request that matches Request.
+type Step struct {
+	// Request is a CTAP2 command (getAssertion), optionally narrowed to a
+	// subcommand (clientPIN.getPinToken).
+	Request string `yaml:"request"`
+	// Status is OK (the default: handle the request normally) or the status
+	// to answer with instead, as a fit error code (PIN_INVALID) or a CTAP2
+	// status byte (0x31).
+	Status string `yaml:"status"`
+	// Delay is how long to take before answering.
+	Delay time.Duration `yaml:"delay"`
+	// Keepalive is sent every 100ms during the delay: processing or
+	// up-needed (waiting for a touch).
+	Keepalive string `yaml:"keepalive"`
+	// Times is how many consecutive matching requests the step answers;
+	// default 1.
+	Times int `yaml:"times"`
+
+	status    byte
+	keepalive byte
+}
+
+// Default getInfo values of a scenario that leaves them out.
+var (
+	defaultVersions   = []string{"FIDO_2_0", "FIDO_2_1"}
+	defaultOptions    = map[string]bool{"rk": true, "up": true, "credMgmt": true}
+	defaultAlgorithms = []string{"ES256", "EdDSA"}
+	defaultAAGUID     = "f1d0f1d0-f1d0-f1d0-f1d0-f1d0f1d0f1d0"
+)
+
+// Load reads and validates the scenario at path.
+func Load(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Parse decodes and validates a scenario and fills in the defaults.
+// Unknown fields are errors, so a misspelt capability is not silently
+// left at its default.
+func Parse(b []byte) (*Scenario, error) {
+	var s Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version != 1 {
+		return nil, fmt.Errorf("unsupported scenario version %d (want version: 1)", s.Version)
+	}
+
+	if s.Device.Name == "" {
+		s.Device.Name = "fit-sim"
+	}
+	if s.Device.VIDPID == "" {
+		s.Device.VIDPID = "1209:f1d0"
+	}
+	vp, err := devsel.ParseVIDPID(s.Device.VIDPID)
+	if err != nil {
+		return nil, fmt.Errorf("device.vidpid: %v", err)
+	}
+	s.vid, s.pid = vp[0], vp[1]
+	if s.Device.Firmware != "" {
+		parts := strings.Split(s.Device.Firmware, ".")
+		var v [3]byte
+		for i, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 8)
+			if err != nil || i >= len(v) {
+				return nil, fmt.Errorf("device.firmware %q: want major.minor.build, each 0-255", s.Device.Firmware)
+			}
+			v[i] = byte(n)
+		}
+		s.fwMajor, s.fwMinor, s.fwBuild = v[0], v[1], v[2]
+	}
+
+	in := &s.Info
+	if in.AAGUID == "" {
+		in.AAGUID = defaultAAGUID
+	}
+	if s.aaguid, err = devsel.ParseAAGUID(in.AAGUID); err != nil {
+		return nil, fmt.Errorf("info.aaguid: %v", err)
+	}
+	if in.Versions == nil {
+		in.Versions = defaultVersions
+	}
+	if in.Options == nil {
+		in.Options = defaultOptions
+	}
+	if _, ok := in.Options["clientPin"]; ok {
+		return nil, fmt.Errorf("info.options: clientPin follows the pin section; remove it")
+	}
+	if in.Algorithms == nil {
+		in.Algorithms = defaultAlgorithms
+	}
+	for _, a := range in.Algorithms {
+		alg, err := rp.ParseAlg(a)
+		if err != nil || (alg != rp.AlgES256 && alg != rp.AlgEdDSA) {
+			return nil, fmt.Errorf("info.algorithms: %q is not simulated (want ES256 or EdDSA)", a)
+		}
+		s.algs = append(s.algs, alg)
+	}
+	if in.MaxCredentials == 0 {
+		in.MaxCredentials = 25
+	}
+	if in.MaxCredentialCountInList == 0 {
+		in.MaxCredentialCountInList = 8
+	}
+	if in.MaxCredentialIDLength == 0 {
+		in.MaxCredentialIDLength = 128
+	}
+
+	if s.PIN.Retries == 0 {
+		s.PIN.Retries = 8
+	}
+	if s.PIN.MinLength == 0 {
+		s.PIN.MinLength = 4
+	}
+	if !s.pinSupported() && s.PIN.Current != "" {
+		return nil, fmt.Errorf("pin.current is set but pin.supported is false")
+	}
+	if s.PIN.Current != "" && len(s.PIN.Current) < s.PIN.MinLength {
+		return nil, fmt.Errorf("pin.current is shorter than pin.minLength (%d)", s.PIN.MinLength)
+	}
+
+	for i := range s.Script {
+		if err := s.Script[i].parse(); err != nil {
+			return nil, fmt.Errorf("script[%d]: %v", i, err)
+		}
+	}
+	return &s, nil
+}
+
+func (s *Scenario) pinSupported() bool { return s.PIN.Supported == nil || *s.PIN.Supported }
+
+// parse validates a step and resolves its status and keepalive.
+func (st *Step) parse() error {
+	cmd, sub, hasSub := strings.Cut(st.Request, ".")
+	c, ok := commandByName(cmd)
+	if !ok {
+		return fmt.Errorf("unknown request %q; want one of %s", st.Request, strings.Join(commandNames(), ", "))
+	}
+	if hasSub {
+		if _, ok := c.subcommand(sub); !ok {
+			return fmt.Errorf("unknown %s subcommand %q", cmd, sub)
+		}
+	}
+	switch s := strings.TrimSpace(st.Status); {
+	case s == "" || strings.EqualFold(s, "OK"):
+	case strings.HasPrefix(s, "0x"):
+		n, err := strconv.ParseUint(s[2:], 16, 8)
+		if err != nil || n == 0 {
+			return fmt.Errorf("status %q: want a CTAP2 status byte such as 0x31", s)
+		}
+		st.status = byte(n)
+	default:
+		n, ok := statusByCode(ctaperr.Code(s))
+		if !ok {
+			return fmt.Errorf("status %q is not a fit error code for a CTAP2 status (see `fit help`); give the byte, e.g. 0x31", s)
+		}
+		st.status = n
+	}
+	switch st.Keepalive {
+	case "":
+	case "processing":
+		st.keepalive = statusProcessing
+	case "up-needed":
+		st.keepalive = statusUPNeeded
+	default:
+		return fmt.Errorf("keepalive %q: want processing or up-needed", st.Keepalive)
+	}
+	if st.Keepalive != "" && st.Delay == 0 {
+		return fmt.Errorf("keepalive needs a delay to be sent during")
+	}
+	if st.Times < 0 {
+		return fmt.Errorf("times must be positive")
+	}
+	if st.Times == 0 {
+		st.Times = 1
+	}
+	return nil
+}
+
+// statusByCode returns the lowest CTAP2 status fit classifies as code.
+func statusByCode(code ctaperr.Code) (byte, bool) {
+	for st := 1; st < 0x100; st++ {
+		if ctaperr.FromStatus(st, "", nil).Code == code {
+			return byte(st), true
+		}
+	}
+	return 0, false
+}
+
+// matches reports whether the step applies to a request for c with
+// subcommand sub (0 when the command has none).
+func (st *Step) matches(c *command, sub uint64) bool {
+	cmd, subName, hasSub := strings.Cut(st.Request, ".")
+	if cmd != c.name {
+		return false
+	}
+	if !hasSub {
+		return true
+	}
+	n, _ := c.subcommand(subName)
+	return n == sub
+}
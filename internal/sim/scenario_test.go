@@ -0,0 +1,140 @@
+package sim
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDefaults(t *testing.T) {
+	s, err := Parse([]byte("version: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Device.Name != "fit-sim" || s.vid != 0x1209 || s.pid != 0xf1d0 {
+		t.Errorf("device = %+v, %04x:%04x", s.Device, s.vid, s.pid)
+	}
+	if !slices.Equal(s.Info.Versions, defaultVersions) || !slices.Equal(s.algs, []int64{-7, -8}) {
+		t.Errorf("versions %v, algorithms %v", s.Info.Versions, s.algs)
+	}
+	if len(s.aaguid) != 16 {
+		t.Errorf("aaguid = %x", s.aaguid)
+	}
+	if s.Info.MaxCredentials != 25 || s.Info.MaxCredentialCountInList != 8 || s.Info.MaxCredentialIDLength != 128 {
+		t.Errorf("limits = %+v", s.Info)
+	}
+	if s.PIN.Retries != 8 || s.PIN.MinLength != 4 || s.PIN.Current != "" || !s.pinSupported() {
+		t.Errorf("pin = %+v", s.PIN)
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	s, err := Parse([]byte(`version: 1
+device:
+  name: Sim Key
+  serial: "0042"
+  vidpid: "1050:0407"
+  firmware: 5.4.3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.vid != 0x1050 || s.pid != 0x0407 || s.Device.Serial != "0042" {
+		t.Errorf("device = %+v, %04x:%04x", s.Device, s.vid, s.pid)
+	}
+	if s.fwMajor != 5 || s.fwMinor != 4 || s.fwBuild != 3 {
+		t.Errorf("firmware = %d.%d.%d", s.fwMajor, s.fwMinor, s.fwBuild)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	s, err := Parse([]byte(`version: 1
+pin:
+  current: "1234"
+script:
+  - request: clientPIN.getPinToken
+    status: PIN_INVALID
+  - request: getAssertion
+    status: "0x2e"
+    times: 2
+  - request: makeCredential
+    delay: 1500ms
+    keepalive: up-needed
+  - request: getInfo
+    status: OK
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		status    byte
+		delay     time.Duration
+		keepalive byte
+		times     int
+	}{
+		{0x31, 0, 0, 1},
+		{0x2e, 0, 0, 2},
+		{0, 1500 * time.Millisecond, statusUPNeeded, 1},
+		{0, 0, 0, 1},
+	}
+	if len(s.Script) != len(want) {
+		t.Fatalf("%d steps, want %d", len(s.Script), len(want))
+	}
+	for i, w := range want {
+		st := s.Script[i]
+		if st.status != w.status || st.Delay != w.delay || st.keepalive != w.keepalive || st.Times != w.times {
+			t.Errorf("step %d = %+v, want %+v", i, st, w)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"version", "version: 2\n", "unsupported scenario version 2"},
+		{"unknown field", "version: 1\ninfo:\n  option: {rk: true}\n", "field option not found"},
+		{"vidpid", "version: 1\ndevice:\n  vidpid: yubikey\n", "device.vidpid"},
+		{"firmware", "version: 1\ndevice:\n  firmware: 1.2.3.4\n", "device.firmware"},
+		{"aaguid", "version: 1\ninfo:\n  aaguid: nope\n", "info.aaguid"},
+		{"clientPin option", "version: 1\ninfo:\n  options: {clientPin: true}\n", "clientPin follows the pin section"},
+		{"algorithm", "version: 1\ninfo:\n  algorithms: [RS256]\n", "is not simulated"},
+		{"pin unsupported", "version: 1\npin:\n  supported: false\n  current: \"1234\"\n", "pin.supported is false"},
+		{"pin too short", "version: 1\npin:\n  current: \"12\"\n", "shorter than pin.minLength"},
+		{"request", "version: 1\nscript:\n  - request: getAssertions\n", "unknown request"},
+		{"subcommand", "version: 1\nscript:\n  - request: clientPIN.getToken\n", "unknown clientPIN subcommand"},
+		{"status name", "version: 1\nscript:\n  - request: getInfo\n    status: PIN_WRONG\n", "is not a fit error code"},
+		{"status zero", "version: 1\nscript:\n  - request: getInfo\n    status: \"0x00\"\n", "want a CTAP2 status byte"},
+		{"keepalive", "version: 1\nscript:\n  - request: getInfo\n    delay: 1s\n    keepalive: busy\n", "want processing or up-needed"},
+		{"keepalive without delay", "version: 1\nscript:\n  - request: getInfo\n    keepalive: processing\n", "keepalive needs a delay"},
+		{"times", "version: 1\nscript:\n  - request: getInfo\n    times: -1\n", "times must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestTestdata checks that the scenarios the sim-test target runs parse.
+func TestTestdata(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.yaml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no scenarios in testdata: %v", err)
+	}
+	for _, p := range paths {
+		s, err := Load(p)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(s.Script) == 0 {
+			t.Errorf("%s: no script steps", p)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package snapshot reads the documents written by `fit snapshot` and
+// compares two of them field by field for `fit diff`.
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"fit/internal/output"
+)
+
+// Load reads a snapshot document from path.
+func Load(path string) (output.Snapshot, error) {
+	var s output.Snapshot
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("%s: %v", path, err)
+	}
+	if s.Command != "snapshot" {
+		return s, fmt.Errorf("%s: not a fit snapshot (command %q)", path, s.Command)
+	}
+	return s, nil
+}
+
+// Diff returns what changed from a to b, sorted by path, and notes on the
+// parts that could not be compared: credentials are only compared when both
+// snapshots were taken with a PIN.
+func Diff(a, b output.Snapshot) ([]output.Change, []string) {
+	var notes []string
+	withCreds := a.RelyingParties != nil && b.RelyingParties != nil
+	if !withCreds && (a.RelyingParties != nil || b.RelyingParties != nil || a.CredentialsError != nil || b.CredentialsError != nil) {
+		notes = append(notes, "credentials not compared: only one side lists them (take both snapshots with a PIN)")
+	}
+	fa, fb := flatten(a, withCreds), flatten(b, withCreds)
+
+	changes := []output.Change{}
+	for path, old := range fa {
+		nw, ok := fb[path]
+		switch {
+		case !ok:
+			changes = append(changes, output.Change{Path: path, Kind: "removed", Old: old})
+		case nw != old:
+			changes = append(changes, output.Change{Path: path, Kind: "changed", Old: old, New: nw})
+		}
+	}
+	for path, nw := range fb {
+		if _, ok := fa[path]; !ok {
+			changes = append(changes, output.Change{Path: path, Kind: "added", New: nw})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, notes
+}
+
+// flatten maps every compared field of s to a path. List members are keyed
+// by value (versions, extensions) or ID (RPs, credentials) so that a
+// reordering is not reported as a change.
+func flatten(s output.Snapshot, withCreds bool) map[string]string {
+	m := map[string]string{
+		"device.label":  s.Device.Label,
+		"device.vidpid": fmt.Sprintf("%04x:%04x", s.Device.VID, s.Device.PID),
+		"fingerprint":   s.Fingerprint,
+		"info.type":     s.Info.Type,
+		"info.isFIDO2":  strconv.FormatBool(s.Info.IsFIDO2),
+		"info.aaguid":   s.Info.AAGUID,
+	}
+	for _, v := range s.Info.Versions {
+		m["info.versions["+v+"]"] = "present"
+	}
+	for _, e := range s.Info.Extensions {
+		m["info.extensions["+e+"]"] = "present"
+	}
+	for k, v := range s.Info.Options {
+		m["info.options."+k] = v
+	}
+	if hid := s.Info.CTAPHID; hid != nil {
+		m["info.ctapHID.version"] = fmt.Sprintf("%d.%d.%d", hid.Major, hid.Minor, hid.Build)
+		m["info.ctapHID.flags"] = fmt.Sprintf("0x%02x", hid.Flags)
+	}
+	if n := s.Info.PINRetryCount; n != nil {
+		m["info.pinRetryCount"] = strconv.Itoa(*n)
+	}
+	if rk := s.Info.ResidentKeys; rk != nil && withCreds {
+		m["info.residentKeys.existing"] = strconv.FormatInt(rk.Existing, 10)
+		m["info.residentKeys.remaining"] = strconv.FormatInt(rk.Remaining, 10)
+	}
+	for k, v := range m {
+		if v == "" {
+			delete(m, k) // not reported by this key or fit version
+		}
+	}
+	if !withCreds {
+		return m
+	}
+	for _, rp := range s.RelyingParties {
+		key := "rp[" + rp.ID + "]"
+		m[key] = rp.Name
+		if rp.Name == "" {
+			m[key] = "present"
+		}
+		for _, c := range rp.Credentials {
+			m[key+".credential["+c.IDHex+"]"] = c.User
+			if c.DisplayName != "" {
+				m[key+".credential["+c.IDHex+"].displayName"] = c.DisplayName
+			}
+		}
+	}
+	return m
+}
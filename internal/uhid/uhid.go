@@ -0,0 +1,164 @@
+//go:build linux
+
+// Package uhid creates virtual HID devices through the Linux uhid driver
+// (/dev/uhid). The kernel exposes each one as a /dev/hidrawN node, so a
+// program such as libfido2 talks to it exactly as to a physical device.
+//
+// Opening /dev/uhid needs root or a udev rule granting access, and so does
+// the hidraw node it creates.
+package uhid
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Event types and sizes from <linux/uhid.h>.
+const (
+	evDestroy = 1
+	evOutput  = 6
+	evCreate2 = 11
+	evInput2  = 12
+
+	dataMax  = 4096
+	descMax  = 4096
+	eventLen = 4 + 128 + 64 + 64 + 2 + 2 + 4*4 + descMax // type + the largest member, uhid_create2_req
+
+	busUSB = 0x03
+)
+
+// FIDODescriptor is the HID report descriptor of a FIDO authenticator: usage
+// page 0xF1D0, 64-byte input and output reports without report IDs.
+var FIDODescriptor = []byte{
+	0x06, 0xd0, 0xf1, // Usage Page (FIDO Alliance)
+	0x09, 0x01, //       Usage (CTAPHID)
+	0xa1, 0x01, //       Collection (Application)
+	0x09, 0x20, //         Usage (Input Report Data)
+	0x15, 0x00, //         Logical Minimum (0)
+	0x26, 0xff, 0x00, //   Logical Maximum (255)
+	0x75, 0x08, //         Report Size (8)
+	0x95, 0x40, //         Report Count (64)
+	0x81, 0x02, //         Input (Data, Var, Abs)
+	0x09, 0x21, //         Usage (Output Report Data)
+	0x15, 0x00, //         Logical Minimum (0)
+	0x26, 0xff, 0x00, //   Logical Maximum (255)
+	0x75, 0x08, //         Report Size (8)
+	0x95, 0x40, //         Report Count (64)
+	0x91, 0x02, //         Output (Data, Var, Abs)
+	0xc0, //             End Collection
+}
+
+// Config describes the device to create.
+type Config struct {
+	Name       string // HID_NAME, e.g. "Yubico YubiKey OTP+FIDO+CCID"
+	Phys       string // HID_PHYS; identifies the device in sysfs
+	Uniq       string // HID_UNIQ, the serial number
+	Vendor     uint16
+	Product    uint16
+	Descriptor []byte
+}
+
+// Device is a virtual HID device.
+type Device struct {
+	f    *os.File
+	phys string
+}
+
+// Create creates a device. It is removed by Close or when the process exits.
+func Create(cfg Config) (*Device, error) {
+	if len(cfg.Descriptor) > descMax {
+		return nil, fmt.Errorf("uhid: report descriptor too long (%d bytes)", len(cfg.Descriptor))
+	}
+	f, err := os.OpenFile("/dev/uhid", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("uhid: %w (needs root or a udev rule for /dev/uhid)", err)
+	}
+	ev := make([]byte, eventLen)
+	binary.LittleEndian.PutUint32(ev, evCreate2)
+	copy(ev[4:4+127], cfg.Name)
+	copy(ev[132:132+63], cfg.Phys)
+	copy(ev[196:196+63], cfg.Uniq)
+	off := 260
+	binary.LittleEndian.PutUint16(ev[off:], uint16(len(cfg.Descriptor)))
+	binary.LittleEndian.PutUint16(ev[off+2:], busUSB)
+	binary.LittleEndian.PutUint32(ev[off+4:], uint32(cfg.Vendor))
+	binary.LittleEndian.PutUint32(ev[off+8:], uint32(cfg.Product))
+	// version and country stay 0
+	copy(ev[off+20:], cfg.Descriptor)
+	if _, err := f.Write(ev); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("uhid: create: %w", err)
+	}
+	return &Device{f: f, phys: cfg.Phys}, nil
+}
+
+// Hidraw waits up to timeout for the device's /dev/hidrawN node and returns
+// its path.
+func (d *Device) Hidraw(timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		nodes, _ := filepath.Glob("/sys/class/hidraw/hidraw*")
+		for _, n := range nodes {
+			b, err := os.ReadFile(filepath.Join(n, "device", "uevent"))
+			if err != nil || !strings.Contains(string(b), "\nHID_PHYS="+d.phys+"\n") {
+				continue
+			}
+			dev := filepath.Join("/dev", filepath.Base(n))
+			if _, err := os.Stat(dev); err == nil {
+				return dev, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("uhid: no hidraw node for %s after %s", d.phys, timeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// ReadReport returns the next output report written to the device (host to
+// device), without a report ID. Other events are skipped.
+func (d *Device) ReadReport() ([]byte, error) {
+	ev := make([]byte, eventLen)
+	for {
+		n, err := d.f.Read(ev)
+		if err != nil {
+			return nil, err
+		}
+		if n < 4 || binary.LittleEndian.Uint32(ev) != evOutput {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint16(ev[4+dataMax:]))
+		data := append([]byte(nil), ev[4:4+min(size, dataMax)]...)
+		// hidraw passes the report ID byte through, 0 for unnumbered reports.
+		if len(data) == 65 && data[0] == 0 {
+			data = data[1:]
+		}
+		return data, nil
+	}
+}
+
+// WriteReport sends an input report (device to host).
+func (d *Device) WriteReport(b []byte) error {
+	if len(b) > dataMax {
+		return errors.New("uhid: report too long")
+	}
+	ev := make([]byte, 4+2+len(b))
+	binary.LittleEndian.PutUint32(ev, evInput2)
+	binary.LittleEndian.PutUint16(ev[4:], uint16(len(b)))
+	copy(ev[6:], b)
+	_, err := d.f.Write(ev)
+	return err
+}
+
+// Close destroys the device.
+func (d *Device) Close() error {
+	ev := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ev, evDestroy)
+	d.f.Write(ev)
+	return d.f.Close()
+}
@@ -0,0 +1,257 @@
+//go:build linux
+
+// Package vkey puts a software authenticator behind a virtual HID device. It
+// speaks the device side of CTAPHID over uhid: it reassembles the host's
+// packets into requests, hands each one to a Handler, and frames the answer
+// and any keepalives. fit uses it to replay recorded sessions (--replay) and
+// for the fit-sim simulator; libfido2 sees an ordinary /dev/hidrawN key.
+package vkey
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"fit/internal/uhid"
+)
+
+// CTAPHID commands, without the frame-init bit.
+const (
+	CmdPing      = 0x01
+	CmdMsg       = 0x03
+	CmdInit      = 0x06
+	CmdWink      = 0x08
+	CmdCBOR      = 0x10
+	CmdCancel    = 0x11
+	CmdKeepalive = 0x3b
+	CmdError     = 0x3f
+)
+
+// Keepalive statuses.
+const (
+	StatusProcessing = 1
+	StatusUPNeeded   = 2
+)
+
+// CTAPHID error codes sent with CmdError.
+const (
+	ErrInvalidCmd = 0x01
+	ErrInvalidSeq = 0x04
+	ErrOther      = 0x7f
+)
+
+// reportLen is the size of a CTAPHID packet.
+const reportLen = 64
+
+// Request is one reassembled CTAPHID request.
+type Request struct {
+	CID  uint32
+	Cmd  byte // without the frame-init bit
+	Data []byte
+	// Keepalive sends a keepalive with the given status; for handlers that
+	// take time or wait for user presence.
+	Keepalive func(status byte)
+	// Cancelled is closed when the host sends CTAPHID_CANCEL on the channel
+	// while the request is being handled.
+	Cancelled <-chan struct{}
+}
+
+// Response is a Handler's answer. With None, nothing is sent (CANCEL).
+type Response struct {
+	Cmd  byte
+	Data []byte
+	None bool
+}
+
+// ErrorResponse is a CTAPHID_ERROR response with code.
+func ErrorResponse(code byte) Response { return Response{Cmd: CmdError, Data: []byte{code}} }
+
+// Handler answers requests. Requests are handled one at a time, in order.
+type Handler interface {
+	Handle(req Request) Response
+}
+
+// Info describes the virtual device as the host sees it.
+type Info struct {
+	Name   string // HID name, shown as the manufacturer and product
+	Serial string // HID serial number (HID_UNIQ)
+	VID    uint16
+	PID    uint16
+}
+
+// Key is a running virtual authenticator.
+type Key struct {
+	Path string // /dev/hidrawN
+
+	dev  *uhid.Device
+	h    Handler
+	mu   sync.Mutex // serializes writes
+	reqs chan Request
+	done chan struct{}
+
+	cancelMu sync.Mutex
+	cancel   map[uint32]chan struct{} // in-flight request per channel
+}
+
+// Start creates the virtual device, waits for its hidraw node and serves h
+// until Close.
+func Start(info Info, h Handler) (*Key, error) {
+	phys := fmt.Sprintf("fit-vkey-%d-%d", os.Getpid(), time.Now().UnixNano())
+	dev, err := uhid.Create(uhid.Config{
+		Name:       info.Name,
+		Phys:       phys,
+		Uniq:       info.Serial,
+		Vendor:     info.VID,
+		Product:    info.PID,
+		Descriptor: uhid.FIDODescriptor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	path, err := dev.Hidraw(5 * time.Second)
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	k := &Key{
+		Path:   path,
+		dev:    dev,
+		h:      h,
+		reqs:   make(chan Request, 16),
+		done:   make(chan struct{}),
+		cancel: map[uint32]chan struct{}{},
+	}
+	go k.read()
+	go k.serve()
+	return k, nil
+}
+
+// Close removes the device.
+func (k *Key) Close() error {
+	err := k.dev.Close()
+	<-k.done
+	return err
+}
+
+// partial is a request whose continuation packets are still arriving.
+type partial struct {
+	cmd  byte
+	want int
+	data []byte
+	seq  byte
+}
+
+// read reassembles packets into requests.
+func (k *Key) read() {
+	defer close(k.reqs)
+	pending := map[uint32]*partial{}
+	for {
+		pkt, err := k.dev.ReadReport()
+		if err != nil {
+			return
+		}
+		if len(pkt) < 5 {
+			continue
+		}
+		cid := binary.BigEndian.Uint32(pkt)
+		if pkt[4]&0x80 != 0 {
+			if len(pkt) < 7 {
+				continue
+			}
+			p := &partial{cmd: pkt[4] &^ 0x80, want: int(binary.BigEndian.Uint16(pkt[5:]))}
+			p.data = append(p.data, pkt[7:min(len(pkt), 7+p.want)]...)
+			if p.cmd == CmdCancel {
+				k.cancelMu.Lock()
+				if c, ok := k.cancel[cid]; ok {
+					close(c)
+					delete(k.cancel, cid)
+				}
+				k.cancelMu.Unlock()
+			}
+			pending[cid] = p
+		} else {
+			p, ok := pending[cid]
+			if !ok {
+				continue // continuation without an init packet
+			}
+			if pkt[4] != p.seq {
+				delete(pending, cid)
+				k.write(cid, CmdError, []byte{ErrInvalidSeq})
+				continue
+			}
+			p.seq++
+			p.data = append(p.data, pkt[5:min(len(pkt), 5+p.want-len(p.data))]...)
+		}
+		if p := pending[cid]; p != nil && len(p.data) >= p.want {
+			delete(pending, cid)
+			k.reqs <- Request{CID: cid, Cmd: p.cmd, Data: p.data}
+		}
+	}
+}
+
+// serve hands requests to the handler one at a time.
+func (k *Key) serve() {
+	defer close(k.done)
+	for req := range k.reqs {
+		c := make(chan struct{})
+		if req.Cmd != CmdCancel {
+			k.cancelMu.Lock()
+			k.cancel[req.CID] = c
+			k.cancelMu.Unlock()
+		}
+		cid := req.CID
+		req.Cancelled = c
+		req.Keepalive = func(status byte) { k.write(cid, CmdKeepalive, []byte{status}) }
+		resp := k.h.Handle(req)
+		k.cancelMu.Lock()
+		if k.cancel[cid] == c {
+			delete(k.cancel, cid)
+		}
+		k.cancelMu.Unlock()
+		if !resp.None {
+			k.write(cid, resp.Cmd, resp.Data)
+		}
+	}
+}
+
+// write frames a response into an init packet and continuation packets.
+func (k *Key) write(cid uint32, cmd byte, data []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	pkt := make([]byte, reportLen)
+	binary.BigEndian.PutUint32(pkt, cid)
+	pkt[4] = cmd | 0x80
+	binary.BigEndian.PutUint16(pkt[5:], uint16(len(data)))
+	n := copy(pkt[7:], data)
+	if k.dev.WriteReport(pkt) != nil {
+		return
+	}
+	for seq := byte(0); n < len(data); seq++ {
+		pkt = make([]byte, reportLen)
+		binary.BigEndian.PutUint32(pkt, cid)
+		pkt[4] = seq
+		n += copy(pkt[5:], data[n:])
+		if k.dev.WriteReport(pkt) != nil {
+			return
+		}
+	}
+}
+
+// InitResponse builds the CTAPHID_INIT answer to nonce: the nonce, the new
+// channel ID, protocol version 2, the device version and capabilities.
+func InitResponse(nonce []byte, cid uint32, major, minor, build, caps byte) []byte {
+	b := make([]byte, 17)
+	copy(b, nonce)
+	binary.BigEndian.PutUint32(b[8:], cid)
+	b[12], b[13], b[14], b[15], b[16] = 2, major, minor, build, caps
+	return b
+}
+
+// Capability flags for InitResponse.
+const (
+	CapWink = 0x01
+	CapCBOR = 0x04
+	CapNMSG = 0x08
+)
@@ -47,6 +47,9 @@ go build -ldflags "$LDFLAGS" -o "$BIN/fit" ./cmd/fit
 echo "Building fit-hello (Windows Hello CLI)..."
 go build -ldflags "$LDFLAGS" -o "$BIN/fit-hello" ./cmd/fit-hello || echo "(fit-hello build may be skipped on non-Windows)"
 
+echo "Building fit-sim (simulated authenticator, Linux)..."
+go build -o "$BIN/fit-sim" ./cmd/fit-sim
+
 # Copy libraries present (Linux/macOS builds may not need these Windows DLLs)
 if compgen -G "$LIB/*.dll" > /dev/null; then
   echo "Copying DLLs..."
@@ -0,0 +1,44 @@
+#!/usr/bin/env bash
+# Runs fit against fit-sim scenarios and checks the exit statuses. Needs
+# bin/fit and bin/fit-sim (make fit fit-sim) and access to /dev/uhid.
+set -uo pipefail
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+ROOT="${SCRIPT_DIR}/.."
+BIN="${BIN:-${ROOT}/bin}"
+SCENARIOS="${ROOT}/internal/sim/testdata"
+
+if [ ! -w /dev/uhid ]; then
+  echo "sim-test: /dev/uhid is not writable; skipping (run as root or add a udev rule)" >&2
+  exit 0
+fi
+
+TMP="$(mktemp -d)"
+trap 'rm -rf "$TMP"' EXIT
+echo 1234 > "$TMP/pin"
+
+failed=0
+# check SCENARIO WANT ARGS...: runs fit ARGS against SCENARIO, expecting
+# exit status WANT.
+check() {
+  local scenario="$1" want="$2"
+  shift 2
+  "$BIN/fit-sim" "$SCENARIOS/$scenario" -- "$BIN/fit" "$@" --path {} >"$TMP/out" 2>&1
+  local got=$?
+  if [ "$got" -eq "$want" ]; then
+    echo "ok    $scenario: fit $1 exited $got"
+  else
+    echo "FAIL  $scenario: fit $* exited $got, want $want" >&2
+    sed 's/^/      /' "$TMP/out" >&2
+    failed=1
+  fi
+}
+
+check pin-invalid.yaml         10  auth --rp example.com --create --pin-file "$TMP/pin"
+check pin-blocked.yaml         11  auth --rp example.com --create --pin-file "$TMP/pin"
+check keepalive-cancel.yaml    32  auth --rp example.com --create --pin-file "$TMP/pin"
+check up-needed.yaml           0   auth --rp example.com --create --pin-file "$TMP/pin"
+check up-needed.yaml           124 auth --rp example.com --create --pin-file "$TMP/pin" --timeout 1s
+check change-pin-invalid.yaml  10  set-pin --old 1234 --new 5678
+check change-pin-blocked.yaml  11  set-pin --old 1234 --new 5678
+
+exit $failed